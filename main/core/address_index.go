@@ -0,0 +1,230 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// addrIndexBucket 地址索引，每个地址对应一个子bucket，子bucket的键是该地址拥有的未花费输出的OutPoint
+// 索引与chainstate在同一个事务中更新，查询余额时不需要再遍历整个UTXO集合
+const addrIndexBucket = "addrindex"
+
+// OutPoint 指向某笔交易的某个输出
+type OutPoint struct {
+	Txid []byte // 交易ID
+	Vout int    // out在交易Vout中的位置
+}
+
+// Key 返回OutPoint在数据库中的键：txid || 4字节大端Vout
+func (op OutPoint) Key() []byte {
+	key := make([]byte, len(op.Txid)+4)
+	copy(key, op.Txid)
+	binary.BigEndian.PutUint32(key[len(op.Txid):], uint32(op.Vout))
+	return key
+}
+
+// DecodeOutPoint 从数据库中的键还原OutPoint
+func DecodeOutPoint(key []byte) OutPoint {
+	n := len(key) - 4
+	return OutPoint{
+		Txid: append([]byte{}, key[:n]...),
+		Vout: int(binary.BigEndian.Uint32(key[n:])),
+	}
+}
+
+// AddressUTXOs 某个地址拥有的未花费输出与余额
+// 时间锁还没有到期的out与还没有结算的占位交易的out单独统计，不能在下一个区块中花费，构造交易时不会使用它们
+type AddressUTXOs struct {
+	Address        common.Address
+	Balance        Amount                // 可以花费的余额
+	Outputs        map[string]UTXOutputs // 可以花费的out，key是十六进制的txid，value只包含属于该地址的out
+	Locked         Amount                // 锁定中的余额
+	LockedOutputs  map[string]UTXOutputs // 锁定中的out
+	Pending        Amount                // 待定余额，占位交易结算之后才能确定
+	PendingOutputs map[string]UTXOutputs // 占位交易的待定out
+}
+
+// Serialize serializes AddressUTXOs，节点通过gRPC把查询结果发给客户端时使用
+func (au AddressUTXOs) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(au)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeAddressUTXOs deserializes AddressUTXOs
+func DeserializeAddressUTXOs(data []byte) (*AddressUTXOs, error) {
+	var au AddressUTXOs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&au)
+	if err != nil {
+		return nil, err
+	}
+
+	return &au, nil
+}
+
+// indexAddressOutput 将地址拥有的新out加入索引
+func indexAddressOutput(ai *bolt.Bucket, address common.Address, txid []byte, vout int) error {
+	b, err := ai.CreateBucketIfNotExists(address[:])
+	if err != nil {
+		return err
+	}
+	return b.Put(OutPoint{txid, vout}.Key(), []byte{})
+}
+
+// unindexAddressOutput 从索引中删除地址已经花费的out，地址没有剩余out时删除它的子bucket
+func unindexAddressOutput(ai *bolt.Bucket, address common.Address, txid []byte, vout int) error {
+	b := ai.Bucket(address[:])
+	if b == nil {
+		return nil
+	}
+	err := b.Delete(OutPoint{txid, vout}.Key())
+	if err != nil {
+		return err
+	}
+	if k, _ := b.Cursor().First(); k == nil {
+		return ai.DeleteBucket(address[:])
+	}
+	return nil
+}
+
+// FindAddressUTXOs 通过地址索引查询一组地址拥有的未花费输出与余额，所有地址在同一个读事务中查询
+// out是否锁定按接在最新区块之后的下一个区块判断
+func (bc *BlockChain) FindAddressUTXOs(addresses []common.Address) (map[common.Address]*AddressUTXOs, error) {
+	result := make(map[common.Address]*AddressUTXOs, len(addresses))
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		utxo := tx.Bucket([]byte(utxoBucket))
+		ai := tx.Bucket([]byte(addrIndexBucket))
+		lock, err := nextLockContext(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, address := range addresses {
+			au := &AddressUTXOs{
+				Address:        address,
+				Outputs:        make(map[string]UTXOutputs),
+				LockedOutputs:  make(map[string]UTXOutputs),
+				PendingOutputs: make(map[string]UTXOutputs),
+			}
+			result[address] = au
+
+			b := ai.Bucket(address[:])
+			if b == nil {
+				continue
+			}
+
+			// 同一笔交易的多个out在索引中相邻，只需要反序列化一次
+			var lastTxid []byte
+			var entry UTXOutputs
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				op := DecodeOutPoint(k)
+				if string(op.Txid) != string(lastTxid) {
+					v := utxo.Get(op.Txid)
+					if v == nil {
+						continue
+					}
+					entry = DeserializeUTXOutputs(v)
+					lastTxid = op.Txid
+				}
+
+				for _, out := range entry.Outputs {
+					if out.Outid != op.Vout {
+						continue
+					}
+					outputs, balance := au.Outputs, &au.Balance
+					if out.Pending {
+						outputs, balance = au.PendingOutputs, &au.Pending
+					} else if !out.IsSpendable(uint64(entry.Height), lock) {
+						outputs, balance = au.LockedOutputs, &au.Locked
+					}
+					txID := hex.EncodeToString(op.Txid)
+					outs := outputs[txID]
+					outs.Height = entry.Height
+					outs.TxIndex = entry.TxIndex
+					outs.Outputs = append(outs.Outputs, out)
+					outputs[txID] = outs
+					sum, err := balance.Add(out.Value)
+					if err != nil {
+						return fmt.Errorf("! 地址 %x 的余额: %w", address, err)
+					}
+					*balance = sum
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// TXOutputs 按txid返回地址拥有的可以花费的out
+func (au *AddressUTXOs) TXOutputs() map[string]TXOutputs {
+	result := make(map[string]TXOutputs, len(au.Outputs))
+	for txID, outs := range au.Outputs {
+		var a TXOutputs
+		for _, out := range outs.Outputs {
+			a.Outputs = append(a.Outputs, out.TXOutput)
+		}
+		result[txID] = a
+	}
+	return result
+}
+
+// TXOutputs2 按txid返回地址拥有的可以花费的out，带有out在原交易中的位置
+func (au *AddressUTXOs) TXOutputs2() map[string]TXOutputs2 {
+	result := make(map[string]TXOutputs2, len(au.Outputs))
+	for txID, outs := range au.Outputs {
+		var a TXOutputs2
+		for _, out := range outs.Outputs {
+			a.Outputs = append(a.Outputs, TXOutput2{
+				Value:    out.Value,
+				Address:  out.Address,
+				Outid:    out.Outid,
+				IsUse:    out.IsUse,
+				LockTime: out.LockTime,
+				Maturity: out.Maturity,
+				Multisig: out.Multisig,
+				HTLC:     out.HTLC,
+			})
+		}
+		result[txID] = a
+	}
+	return result
+}
+
+// TXOutputsTran 按txid返回地址拥有的可以花费的带交易坐标的out，用于跨区转账
+func (au *AddressUTXOs) TXOutputsTran() map[string][]TXOutputsTran {
+	result := make(map[string][]TXOutputsTran, len(au.Outputs))
+	for txID, outs := range au.Outputs {
+		for _, out := range outs.Outputs {
+			result[txID] = append(result[txID], TXOutputsTran{
+				Out:          out.TXOutput,
+				Outid:        out.Outid,
+				CoordinatesX: outs.Height,
+				CoordinatesY: outs.TxIndex,
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestOutPointKey(t *testing.T) {
+	tests := []OutPoint{
+		{Txid: []byte{1, 2, 3}, Vout: 0},
+		{Txid: make([]byte, 32), Vout: 7},
+		{Txid: []byte{0xff}, Vout: 1 << 20},
+	}
+	for _, op := range tests {
+		if got := DecodeOutPoint(op.Key()); !reflect.DeepEqual(got, op) {
+			t.Errorf("DecodeOutPoint(%x) = %+v，期望 %+v", op.Key(), got, op)
+		}
+	}
+}
+
+func TestFindAddressUTXOs(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5, 7)
+	addTestBlock(t, bc, cb)
+	// A把5转给B，找零给自己；7仍然属于A
+	spend := testSpend(t, cb, 0, *NewTXOutput(3, addrB), *NewTXOutput(2, addrA))
+	addTestBlock(t, bc, spend)
+
+	tests := []struct {
+		address common.Address
+		balance Amount
+		outs    map[string][]int // 十六进制txid -> 属于该地址的out位置
+	}{
+		{addrA, 9, map[string][]int{hexID(cb): {1}, hexID(spend): {1}}},
+		{addrB, 3, map[string][]int{hexID(spend): {0}}},
+		{addrC, 0, map[string][]int{}},
+	}
+	result, err := bc.FindAddressUTXOs([]common.Address{addrA, addrB, addrC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		au := result[tt.address]
+		if au.Balance != tt.balance {
+			t.Errorf("地址 %x 余额 %d，期望 %d", tt.address, au.Balance, tt.balance)
+		}
+		got := make(map[string][]int)
+		for txid, outs := range au.Outputs {
+			got[txid] = outids(outs)
+		}
+		if !reflect.DeepEqual(got, tt.outs) {
+			t.Errorf("地址 %x 的out %v，期望 %v", tt.address, got, tt.outs)
+		}
+		// 节点把查询结果序列化后通过gRPC发给客户端
+		decoded, err := DeserializeAddressUTXOs(au.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Address != au.Address || decoded.Balance != au.Balance || !reflect.DeepEqual(decoded.TXOutputs2(), au.TXOutputs2()) {
+			t.Errorf("地址 %x 反序列化后 %+v，期望 %+v", tt.address, decoded, au)
+		}
+	}
+
+	// 地址的out全部花费后子bucket被删除
+	err = bc.db.View(func(dbTx *bolt.Tx) error {
+		if dbTx.Bucket([]byte(addrIndexBucket)).Bucket(addrC[:]) != nil {
+			t.Error("没有out的地址在索引中")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, testSpend(t, spend, 0, *NewTXOutput(3, addrC)))
+	err = bc.db.View(func(dbTx *bolt.Tx) error {
+		if dbTx.Bucket([]byte(addrIndexBucket)).Bucket(addrB[:]) != nil {
+			t.Error("out全部花费的地址仍在索引中")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// Amount 金额，单位是最小的不可分割单位，金额没有小数部分
+// 交易的output、余额、手续费都使用Amount，加减乘都需要使用带溢出检查的方法
+type Amount uint64
+
+// MaxAmount 最大的合法金额
+// 规范编码中金额按有符号的varint编码，因此不能超过int64的范围，多个金额的和也不能超过它
+const MaxAmount Amount = math.MaxInt64
+
+var (
+	ErrAmountOverflow  = errors.New("金额超过上限")
+	ErrAmountUnderflow = errors.New("金额不足，减法结果为负数")
+	ErrNegativeAmount  = errors.New("金额不能为负数")
+	ErrZeroAmount      = errors.New("金额必须大于0")
+)
+
+// NewAmount 把有符号整数转为金额，负数返回ErrNegativeAmount
+func NewAmount(v int64) (Amount, error) {
+	if v < 0 {
+		return 0, fmt.Errorf("! 金额 %d: %w", v, ErrNegativeAmount)
+	}
+	return Amount(v), nil
+}
+
+// ParseAmount 解析十进制的金额字符串，用于用户输入
+func ParseAmount(s string) (Amount, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("! 金额 %q 格式错误: %v", s, err)
+	}
+	return NewAmount(v)
+}
+
+// Add 带溢出检查的加法
+func (a Amount) Add(b Amount) (Amount, error) {
+	if a > MaxAmount || b > MaxAmount-a {
+		return 0, fmt.Errorf("! %d + %d: %w", a, b, ErrAmountOverflow)
+	}
+	return a + b, nil
+}
+
+// Sub 带检查的减法，结果不能为负数
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b > a {
+		return 0, fmt.Errorf("! %d - %d: %w", a, b, ErrAmountUnderflow)
+	}
+	return a - b, nil
+}
+
+// Mul 带溢出检查的乘法
+func (a Amount) Mul(n uint64) (Amount, error) {
+	hi, lo := bits.Mul64(uint64(a), n)
+	if hi != 0 || Amount(lo) > MaxAmount {
+		return 0, fmt.Errorf("! %d * %d: %w", a, n, ErrAmountOverflow)
+	}
+	return Amount(lo), nil
+}
+
+// SumAmounts 带溢出检查地求和
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var sum Amount
+	for _, v := range amounts {
+		var err error
+		sum, err = sum.Add(v)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return sum, nil
+}
+
+// saturatingAdd 超过MaxAmount时返回MaxAmount，用于只需要比较大小的累加
+func (a Amount) saturatingAdd(b Amount) Amount {
+	sum, err := a.Add(b)
+	if err != nil {
+		return MaxAmount
+	}
+	return sum
+}
+
+// CheckOutputAmount 检查output的金额：必须大于0且不超过MaxAmount
+func CheckOutputAmount(a Amount) error {
+	if a == 0 {
+		return ErrZeroAmount
+	}
+	if a > MaxAmount {
+		return fmt.Errorf("! 金额 %d: %w", a, ErrAmountOverflow)
+	}
+	return nil
+}
+
+// String 十进制表示
+func (a Amount) String() string {
+	return strconv.FormatUint(uint64(a), 10)
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestAmountArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func() (Amount, error)
+		want Amount
+		err  error
+	}{
+		{"add", func() (Amount, error) { return Amount(2).Add(3) }, 5, nil},
+		{"add to max", func() (Amount, error) { return (MaxAmount - 1).Add(1) }, MaxAmount, nil},
+		{"add overflow", func() (Amount, error) { return MaxAmount.Add(1) }, 0, ErrAmountOverflow},
+		// 两个超过上限的金额相加在uint64中会回绕
+		{"add wraps uint64", func() (Amount, error) { return Amount(math.MaxUint64).Add(2) }, 0, ErrAmountOverflow},
+		{"sub", func() (Amount, error) { return Amount(5).Sub(3) }, 2, nil},
+		{"sub to zero", func() (Amount, error) { return Amount(5).Sub(5) }, 0, nil},
+		{"sub underflow", func() (Amount, error) { return Amount(3).Sub(5) }, 0, ErrAmountUnderflow},
+		{"mul", func() (Amount, error) { return Amount(7).Mul(6) }, 42, nil},
+		{"mul by zero", func() (Amount, error) { return MaxAmount.Mul(0) }, 0, nil},
+		{"mul over max", func() (Amount, error) { return (MaxAmount/2 + 1).Mul(2) }, 0, ErrAmountOverflow},
+		{"mul wraps uint64", func() (Amount, error) { return Amount(1 << 40).Mul(1 << 40) }, 0, ErrAmountOverflow},
+		{"sum", func() (Amount, error) { return SumAmounts(1, 2, 3) }, 6, nil},
+		{"sum empty", func() (Amount, error) { return SumAmounts() }, 0, nil},
+		{"sum overflow", func() (Amount, error) { return SumAmounts(MaxAmount, 1, 1) }, 0, ErrAmountOverflow},
+		{"new", func() (Amount, error) { return NewAmount(9) }, 9, nil},
+		{"new negative", func() (Amount, error) { return NewAmount(-1) }, 0, ErrNegativeAmount},
+		{"parse", func() (Amount, error) { return ParseAmount("120") }, 120, nil},
+		{"parse negative", func() (Amount, error) { return ParseAmount("-5") }, 0, ErrNegativeAmount},
+		{"parse max", func() (Amount, error) { return ParseAmount("9223372036854775807") }, MaxAmount, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("错误 %v，期望 %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Fatalf("结果 %d，期望 %d", got, tt.want)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "1.5", "abc", "9223372036854775808"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) 没有返回错误", s)
+		}
+	}
+}
+
+func TestCheckOutputAmount(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		err    error
+	}{
+		{1, nil},
+		{MaxAmount, nil},
+		{0, ErrZeroAmount},
+		{MaxAmount + 1, ErrAmountOverflow},
+	}
+	for _, tt := range tests {
+		if err := CheckOutputAmount(tt.amount); !errors.Is(err, tt.err) {
+			t.Errorf("CheckOutputAmount(%d) = %v，期望 %v", tt.amount, err, tt.err)
+		}
+	}
+}
+
+// TestValidateRejectsInvalidAmount 金额为0的output，或者金额之和溢出的交易不能上链
+func TestValidateRejectsInvalidAmount(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 10)
+	addTestBlock(t, bc, cb)
+
+	tests := []struct {
+		name    string
+		outputs []TXOutput
+	}{
+		{"zero output", []TXOutput{*NewTXOutput(0, addrB), *NewTXOutput(10, addrA)}},
+		{"output over max", []TXOutput{*NewTXOutput(MaxAmount+1, addrB)}},
+		// 两个output的和在uint64中回绕后小于input
+		{"outputs wrap", []TXOutput{*NewTXOutput(MaxAmount, addrB), *NewTXOutput(MaxAmount, addrB), *NewTXOutput(4, addrB)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.ValidateTransaction(testSpend(t, cb, 0, tt.outputs...))
+			if !errors.Is(err, ErrInvalidAmount) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, ErrInvalidAmount)
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/gob"
-	"fmt"
 	"log"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 type Header struct {
@@ -15,7 +17,8 @@ type Header struct {
 	// time when block was created
 	TimeStamp int64
 
-	// Height    uint64 // block height
+	// block height, the genesis block is 0
+	Height uint64
 
 	// hash of the previous block
 	PrevBlock []byte
@@ -23,8 +26,24 @@ type Header struct {
 	// hash of the block state
 	MerkelRoot []byte
 
+	// 区块哈希的计算方式，HashChameleon时持有ChameleonKey陷门的改写机构可以改写区块中的交易而不改变区块哈希，见chameleon.go
+	HashMode HashMode
+
+	// 变色龙公钥，压缩编码的secp256k1公钥，HashSHA256时为空
+	ChameleonKey []byte
+
+	// 变色龙哈希的随机数r || s，出块时随机生成，改写区块中的交易时由改写机构重新计算，HashSHA256时为空
+	Randomness []byte
+
+	// 压缩编码的难度目标，区块哈希不能大于它，见pow.go；创世区块为0
+	Bits uint32
+
+	// 工作量证明的随机数，出块节点通过改变它寻找满足难度目标的区块哈希
+	Nonce uint64
+
 	// state of the block : 0->commit ; 1->valid ; 2->invalid
-	state byte
+	// 状态保存在blockstate bucket中，从数据库读取区块时填写，不参与编码与哈希计算
+	State BlockState
 }
 
 type Body struct {
@@ -37,49 +56,106 @@ type Block struct {
 }
 
 func NewBlock() *Block {
-	return &Block{
+	block := &Block{
 		Header: &Header{},
 		Body:   &Body{},
 	}
+	block.Header.MerkelRoot = block.Body.MerkleRoot()
+	return block
+}
+
+// genesisTimeStamp 创世区块的时间戳，固定不变保证所有节点的创世区块哈希值相同
+const genesisTimeStamp = 1630041600
+
+// NewGenesisBlock 创建创世区块，高度为0，没有前一个区块
+// coordinator不为零地址时创世区块包含设置第一个协调者的协调者交易，见coordinator.go
+func NewGenesisBlock(coordinator common.Address) *Block {
+	txs := []*Transaction{}
+	if coordinator != (common.Address{}) {
+		txs = append(txs, NewCoordinatorTX(coordinator))
+	}
+	block := &Block{
+		Header: &Header{
+			Version:   1,
+			TimeStamp: genesisTimeStamp,
+			Height:    0,
+		},
+		Body: &Body{
+			Transactions: txs,
+		},
+	}
+	block.Header.MerkelRoot = block.Body.MerkleRoot()
+	return block
+}
+
+// IsGenesis 判断是否是创世区块
+func (b *Block) IsGenesis() bool {
+	return b.Header.Height == 0 && len(b.Header.PrevBlock) == 0
+}
+
+// NewBlockWithTransactions 组装一个链接在prevBlock之后、高度为height的新区块，并根据交易计算默克尔根
+func NewBlockWithTransactions(prevBlock []byte, height uint64, txs []*Transaction) *Block {
+	block := &Block{
+		Header: &Header{
+			Version:   1,
+			TimeStamp: time.Now().Unix(),
+			Height:    height,
+			PrevBlock: prevBlock,
+		},
+		Body: &Body{
+			Transactions: txs,
+		},
+	}
+	block.Header.MerkelRoot = block.Body.MerkleRoot()
+	return block
 }
 
 // Serialize serializes the block
+// 使用规范二进制编码，见encoding.go
 func (b *Block) Serialize() []byte {
+	return EncodeBlock(b)
+}
 
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
-
-	err := encoder.Encode(b)
+// DeserializeBlock deserializes a block
+func DeserializeBlock(d []byte) *Block {
+	block, err := DecodeBlock(d)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return result.Bytes()
+	return block
 }
 
-// DeserializeBlock deserializes a block
-func DeserializeBlock(d []byte) *Block {
+// deserializeBlockGob 解码旧版本数据库中使用gob保存的区块，只在迁移数据库时使用
+func deserializeBlockGob(d []byte) (*Block, error) {
 	var block Block
 
 	decoder := gob.NewDecoder(bytes.NewReader(d))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	if block.Body == nil {
+		block.Body = &Body{}
 	}
 
-	return &block
+	return &block, nil
 }
 
 // Hash 返回块的哈希值
+// 哈希值是区块头规范编码的SHA-256，区块状态不参与编码
+// 变色龙模式下MerkelRoot替换为变色龙哈希CH(MerkelRoot, Randomness)，Randomness不参与编码，见chameleon.go
 func (b *Block) Hash() ([]byte, error) {
-	// 连接块头部字段
-	headers := fmt.Sprintf("%d%d%x%x%d", b.Header.Version, b.Header.TimeStamp, b.Header.PrevBlock, b.Header.MerkelRoot, b.Header.state)
+	header, err := b.Header.hashedHeader()
+	if err != nil {
+		return nil, err
+	}
 
 	// 创建 SHA-256 哈希对象
 	hasher := sha256.New()
 
-	// 将连接后的数据写入哈希对象
-	_, err := hasher.Write([]byte(headers))
+	// 将区块头编码写入哈希对象
+	_, err = hasher.Write(EncodeHeader(header))
 	if err != nil {
 		return nil, err
 	}
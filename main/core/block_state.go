@@ -0,0 +1,205 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// blockStateBucket 区块状态 区块哈希值 -> BlockState
+// 状态单独保存，不写进区块数据，也不参与区块哈希计算，改变状态不会改变区块哈希值
+// 无效区块无法从主链重新推导，因此这个bucket不属于derivedBuckets
+const blockStateBucket = "blockstate"
+
+// BlockState 区块的状态
+type BlockState byte
+
+const (
+	BlockCommit  BlockState = iota // 已经保存但还没有经过完整验证，例如侧链区块
+	BlockValid                     // 已经验证通过并应用到过主链
+	BlockInvalid                   // 验证失败，以它为祖先的区块都会被拒绝
+)
+
+func (s BlockState) String() string {
+	switch s {
+	case BlockCommit:
+		return "commit"
+	case BlockValid:
+		return "valid"
+	case BlockInvalid:
+		return "invalid"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(s))
+	}
+}
+
+// ErrBlockStateTransition 不允许的区块状态转换：无效是最终状态，已经验证通过的区块不能回到commit
+var ErrBlockStateTransition = errors.New("不允许的区块状态转换")
+
+// BlockQueryOptions 查询区块时的选项
+type BlockQueryOptions struct {
+	SkipInvalid bool // 跳过被标记为无效的区块
+}
+
+// getBlockState 读取区块状态，没有记录的区块是commit状态
+func getBlockState(dbTx *bolt.Tx, hash []byte) BlockState {
+	sb := dbTx.Bucket([]byte(blockStateBucket))
+	if sb == nil {
+		return BlockCommit
+	}
+	v := sb.Get(hash)
+	if len(v) == 0 {
+		return BlockCommit
+	}
+	return BlockState(v[0])
+}
+
+// setBlockState 修改区块状态，检查状态转换是否允许
+func setBlockState(dbTx *bolt.Tx, hash []byte, state BlockState) error {
+	current := getBlockState(dbTx, hash)
+	if current != state && (current == BlockInvalid || state == BlockCommit && current == BlockValid) {
+		return fmt.Errorf("! 区块 %x 的状态不能从 %v 转换为 %v: %w", hash, current, state, ErrBlockStateTransition)
+	}
+	return dbTx.Bucket([]byte(blockStateBucket)).Put(hash, []byte{byte(state)})
+}
+
+// fillMissingBlockStates 为没有状态记录的区块补上commit状态，打开旧数据库重建索引时调用
+func fillMissingBlockStates(dbTx *bolt.Tx) error {
+	sb := dbTx.Bucket([]byte(blockStateBucket))
+	c := dbTx.Bucket([]byte(blocksBucket)).Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		// blocks bucket中除区块外还保存了tip、高度等短键
+		if len(k) != sha256.Size || sb.Get(k) != nil {
+			continue
+		}
+		err := sb.Put(k, []byte{byte(BlockCommit)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidatesBlock 判断验证错误是否说明区块本身无效
+// 区块哈希只覆盖区块头，默克尔根不符、交易ID不符或重复交易说明收到的区块体被篡改过，同一个哈希值的正确区块仍可能存在，这类错误不标记区块
+// 协调者是链上状态，ErrUnauthorizedMint与ErrNoCoordinator只取决于区块与它的祖先，所有节点的结果相同，因此和其他交易错误一样标记区块
+// 哈希模式不符取决于本节点的设置，不标记区块
+// 不满足工作量证明或难度目标的区块伪造成本很低，不为它们保存数据；时间戳超前的区块之后可能变为有效
+func invalidatesBlock(err error) bool {
+	var verr *BlockValidationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	switch verr.Err {
+	case ErrOrphanBlock, ErrBlockExists, ErrPrevBlockMismatch, ErrMerkleRootMismatch, ErrTxIDMismatch, ErrDuplicateTx, ErrHashMode,
+		ErrInsufficientWork, ErrBadDifficulty, ErrBlockTime:
+		return false
+	}
+	return true
+}
+
+// markBranchInvalid 把验证失败的区块以及从它到hash之间的所有后代区块标记为无效
+// block是本次添加的区块(哈希值为hash)，它可能还没有保存，需要一起保存以便之后直接拒绝
+func markBranchInvalid(dbTx *bolt.Tx, block *Block, hash []byte, failed []byte) error {
+	b := dbTx.Bucket([]byte(blocksBucket))
+	if b.Get(hash) == nil {
+		err := b.Put(hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	for cur := hash; len(cur) != 0; {
+		err := setBlockState(dbTx, cur, BlockInvalid)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(cur, failed) {
+			break
+		}
+		curBlock, err := readBlock(dbTx, cur)
+		if err != nil {
+			return err
+		}
+		cur = curBlock.Header.PrevBlock
+		if isMainChain(dbTx, cur, int(curBlock.Header.Height)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// GetBlockState 查询区块的状态
+func (bc *BlockChain) GetBlockState(hash []byte) (BlockState, error) {
+	var state BlockState
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(blocksBucket)).Get(hash) == nil {
+			return fmt.Errorf("! 区块 %x 不存在", hash)
+		}
+		state = getBlockState(tx, hash)
+		return nil
+	})
+
+	return state, err
+}
+
+// MarkBlockValid 把commit状态的区块标记为验证通过，用于通过其他途径(如轻计算区确认)验证过的侧链区块
+func (bc *BlockChain) MarkBlockValid(hash []byte) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(blocksBucket)).Get(hash) == nil {
+			return fmt.Errorf("! 区块 %x 不存在", hash)
+		}
+		return setBlockState(tx, hash, BlockValid)
+	})
+}
+
+// MarkBlockInvalid 把侧链区块标记为无效，之后接在它后面的区块都会被拒绝，主链也不会再切换到包含它的分支
+// 主链上的区块不能标记为无效
+func (bc *BlockChain) MarkBlockInvalid(hash []byte) error {
+	return bc.db.Update(func(tx *bolt.Tx) error {
+		block, err := readBlock(tx, hash)
+		if err != nil {
+			return err
+		}
+		if isMainChain(tx, hash, int(block.Header.Height)) {
+			return fmt.Errorf("! 区块 %x 在主链上，不能标记为无效: %w", hash, ErrBlockStateTransition)
+		}
+		return setBlockState(tx, hash, BlockInvalid)
+	})
+}
+
+// GetBlockByHashWithOptions 按哈希值获取区块，opts.SkipInvalid为true时无效区块返回ErrInvalidBlock
+func (bc *BlockChain) GetBlockByHashWithOptions(hash []byte, opts BlockQueryOptions) (*Block, error) {
+	block, err := bc.GetBlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SkipInvalid && block.Header.State == BlockInvalid {
+		return nil, &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: ErrInvalidBlock}
+	}
+	return block, nil
+}
+
+// GetBlocksByState 返回所有处于state状态的区块哈希值
+func (bc *BlockChain) GetBlocksByState(state BlockState) ([][]byte, error) {
+	var hashes [][]byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(blockStateBucket)).ForEach(func(k, v []byte) error {
+			if len(v) != 0 && BlockState(v[0]) == state {
+				hashes = append(hashes, append([]byte{}, k...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
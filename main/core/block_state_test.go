@@ -0,0 +1,159 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestSetBlockState(t *testing.T) {
+	tests := []struct {
+		from, to BlockState
+		ok       bool
+	}{
+		{BlockCommit, BlockCommit, true},
+		{BlockCommit, BlockValid, true},
+		{BlockCommit, BlockInvalid, true},
+		{BlockValid, BlockValid, true},
+		{BlockValid, BlockInvalid, true},
+		{BlockValid, BlockCommit, false},
+		{BlockInvalid, BlockInvalid, true},
+		{BlockInvalid, BlockCommit, false},
+		{BlockInvalid, BlockValid, false},
+	}
+	bc := newTestChain(t)
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"->"+tt.to.String(), func(t *testing.T) {
+			hash := bytes.Repeat([]byte{byte(tt.from)}, 32)
+			err := bc.db.Update(func(dbTx *bolt.Tx) error {
+				if err := dbTx.Bucket([]byte(blockStateBucket)).Put(hash, []byte{byte(tt.from)}); err != nil {
+					return err
+				}
+				return setBlockState(dbTx, hash, tt.to)
+			})
+			if (err == nil) != tt.ok {
+				t.Fatalf("setBlockState = %v，期望允许: %v", err, tt.ok)
+			}
+			if !tt.ok && !errors.Is(err, ErrBlockStateTransition) {
+				t.Fatalf("错误 %v 不是 %v", err, ErrBlockStateTransition)
+			}
+		})
+	}
+}
+
+func TestInvalidSideBranch(t *testing.T) {
+	bc := newTestChain(t)
+	genesis := append([]byte{}, bc.tip...)
+	addTestBlock(t, bc, testCoinbase(t, addrA, 1))
+	main2 := addTestBlock(t, bc, testCoinbase(t, addrA, 2))
+	mainTip, _ := main2.Hash()
+
+	// 第二个侧链区块花费不存在的out，保存时不验证
+	s1 := testCoinbase(t, addrB, 1)
+	missing := &Transaction{ID: []byte("missing"), Vout: []TXOutput{{Value: 1, Address: addrB}}}
+	s2 := testSpend(t, missing, 0, *NewTXOutput(1, addrC))
+	side2 := addTestBranch(t, bc, genesis, s1, s2)
+	side1, _ := bc.GetBlockByHash(side2)
+	side1Hash := side1.Header.PrevBlock
+
+	// 侧链变长时验证失败，主链不变，失败的区块与它的后代被标记为无效
+	block3 := mineTestBlock(t, bc, NewBlockWithTransactions(side2, 3, []*Transaction{testCoinbase(t, addrB, 3)}))
+	hash3, _ := block3.Hash()
+	if err := bc.AddBlock(block3); !errors.Is(err, ErrMissingInput) {
+		t.Fatalf("AddBlock = %v，期望 %v", err, ErrMissingInput)
+	}
+	if !bytes.Equal(bc.tip, mainTip) {
+		t.Fatal("验证失败的分支改变了最新区块")
+	}
+
+	tests := []struct {
+		name string
+		hash []byte
+		want BlockState
+	}{
+		{"main chain", mainTip, BlockValid},
+		{"valid side block", side1Hash, BlockCommit},
+		{"failed block", side2, BlockInvalid},
+		{"descendant", hash3, BlockInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, err := bc.GetBlockState(tt.hash)
+			if err != nil || state != tt.want {
+				t.Fatalf("GetBlockState = %v, %v，期望 %v", state, err, tt.want)
+			}
+		})
+	}
+
+	// 再次收到无效区块或它的后代时直接拒绝
+	if err := bc.AddBlock(block3); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("重复的无效区块: %v，期望 %v", err, ErrInvalidBlock)
+	}
+	block4 := mineTestBlock(t, bc, NewBlockWithTransactions(hash3, 4, []*Transaction{testCoinbase(t, addrB, 4)}))
+	if err := bc.AddBlock(block4); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("无效区块的后代: %v，期望 %v", err, ErrInvalidBlock)
+	}
+
+	// 查询时可以跳过无效区块
+	if _, err := bc.GetBlockByHashWithOptions(side2, BlockQueryOptions{SkipInvalid: true}); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("GetBlockByHashWithOptions = %v，期望 %v", err, ErrInvalidBlock)
+	}
+	var heights []uint64
+	for it := bc.IteratorWithOptions(hash3, BlockQueryOptions{SkipInvalid: true}); ; {
+		block := it.Next()
+		if block == nil {
+			break
+		}
+		heights = append(heights, block.Header.Height)
+	}
+	if len(heights) != 2 || heights[0] != 1 || heights[1] != 0 {
+		t.Fatalf("跳过无效区块后遍历的高度 %v，期望 [1 0]", heights)
+	}
+	// 被拒绝的后代也保存为无效
+	invalid, err := bc.GetBlocksByState(BlockInvalid)
+	if err != nil || len(invalid) != 3 {
+		t.Fatalf("GetBlocksByState = %d 个区块, %v，期望 3 个", len(invalid), err)
+	}
+}
+
+// TestTamperedBodyDoesNotInvalidate 区块体被篡改的区块不标记为无效，之后仍然可以接受正确的区块
+func TestTamperedBodyDoesNotInvalidate(t *testing.T) {
+	bc := newTestChain(t)
+	block := newTestBlock(t, bc, testCoinbase(t, addrA, 1))
+	tampered := *block
+	tampered.Body = &Body{Transactions: []*Transaction{testCoinbase(t, addrB, 100)}}
+	if err := bc.AddBlock(&tampered); !errors.Is(err, ErrMerkleRootMismatch) {
+		t.Fatalf("AddBlock = %v，期望 %v", err, ErrMerkleRootMismatch)
+	}
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatalf("篡改的区块体使正确的区块被拒绝: %v", err)
+	}
+}
+
+func TestMarkBlockState(t *testing.T) {
+	bc := newTestChain(t)
+	genesis := append([]byte{}, bc.tip...)
+	addTestBlock(t, bc, testCoinbase(t, addrA, 1))
+	addTestBlock(t, bc, testCoinbase(t, addrA, 2))
+	side := addTestBranch(t, bc, genesis, testCoinbase(t, addrB, 1))
+
+	if err := bc.MarkBlockInvalid(bc.tip); !errors.Is(err, ErrBlockStateTransition) {
+		t.Fatalf("标记主链区块为无效: %v", err)
+	}
+	if err := bc.MarkBlockValid(side); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.MarkBlockInvalid(side); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.MarkBlockValid(side); !errors.Is(err, ErrBlockStateTransition) {
+		t.Fatalf("无效区块标记为验证通过: %v", err)
+	}
+	// 包含无效区块的分支不会成为主链
+	block := mineTestBlock(t, bc, NewBlockWithTransactions(side, 2, []*Transaction{testCoinbase(t, addrB, 2)}))
+	if err := bc.AddBlock(block); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("接在无效区块后面的区块: %v，期望 %v", err, ErrInvalidBlock)
+	}
+}
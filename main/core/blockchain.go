@@ -3,39 +3,55 @@ package core
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"log"
+	"sync"
 
 	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
 )
 
 const dbFile = "blockchain_%s.db"
 const blocksBucket = "blocks"
+const tipHeightKey = "h" // blocks bucket中存储最新区块高度的键
+
+const heightIndexBucket = "heightindex" // 区块高度 -> 区块哈希值
+
+// indexVersion 派生索引的版本，索引的格式或种类改变时加一，打开旧数据库时会重建所有索引
+const indexVersion = 5
+const indexVersionKey = "v" // blocks bucket中存储索引版本的键
+
+// derivedBuckets 由区块数据派生出的bucket，可以随时通过Reindex重建
+// 累计工作量在需要时从区块头重新计算，清空后不需要重建
+var derivedBuckets = []string{utxoBucket, addrIndexBucket, txIndexBucket, txCoordBucket, heightIndexBucket, coordinatorBucket, undoBucket, chainWorkBucket}
+
+// GenesisCoordinator 新建数据库时写入创世区块的协调者地址，需要在第一次调用GetBlockChain之前设置
+// 同一个网络中所有节点的创世区块必须相同，零地址表示不接受从轻计算区转入钱
+var GenesisCoordinator common.Address
 
 type BlockChain struct {
 	tip []byte
 	db  *bolt.DB
+
+	mu       sync.Mutex
+	onReorgs []func(disconnected []*Block) // 主链切换分支后的回调，见OnReorg
 }
 
+// currentChain 当前进程使用的区块链，bolt数据库同一时间只能被打开一次，因此所有调用者共享同一个实例
+var (
+	currentChain   *BlockChain
+	currentChainMu sync.Mutex
+)
+
 // GetBlockChain 获取当前区块链BlockChain结构体的方法
 func GetBlockChain() (error, *BlockChain) {
-	// 模拟获取当前区块链的区块，前两个空块，第三个区块随即填入一些数据
-	block1 := NewBlock()
-	block2 := NewBlock()
-	// 创建一个块
-	block3 := &Block{
-		Header: &Header{
-			Version:    1,
-			TimeStamp:  1630041600,
-			PrevBlock:  []byte("00000000000000000000000000000000"),
-			MerkelRoot: []byte("5fa9f46d4f3d0b775a3b2ea1c59252f2"),
-			state:      0,
-		},
-		Body: &Body{
-			Transactions: []*Transaction{},
-		},
+	currentChainMu.Lock()
+	defer currentChainMu.Unlock()
+
+	if currentChain != nil {
+		return nil, currentChain
 	}
 
 	// 新建区块链
@@ -45,27 +61,36 @@ func GetBlockChain() (error, *BlockChain) {
 		return err, nil
 	}
 
-	// 放入区块
-	err = blockchain.AddBlock(block1)
-	err = blockchain.AddBlock(block2)
-	err = blockchain.AddBlock(block3)
-	if err != nil {
-		fmt.Println("添加区块时出错")
-		return err, nil
+	// 数据库中已经有区块，直接使用
+	if len(blockchain.tip) != 0 {
+		// 旧版本的数据库使用gob保存区块，改写为规范编码后交易ID与区块哈希值都会改变，需要重建所有索引
+		if blockchain.blockEncoding() < blockEncodingVersion {
+			err = blockchain.migrateBlockEncoding()
+			if err != nil {
+				fmt.Println("迁移区块编码时出错")
+				return err, nil
+			}
+			UTXOSet1{blockchain}.Reindex()
+		} else if blockchain.indexVersion() < indexVersion {
+			// 旧版本的数据库没有维护chainstate与各种索引，需要根据已有区块重建
+			UTXOSet1{blockchain}.Reindex()
+		}
+		currentChain = blockchain
+		return nil, blockchain
 	}
 
-	fmt.Println("区块添加完成")
-	h, err := block3.Hash()
+	// 空数据库，写入创世区块
+	err = blockchain.AddBlock(NewGenesisBlock(GenesisCoordinator))
 	if err != nil {
-		fmt.Println("计算区块哈希值出错")
+		fmt.Println("添加创世区块时出错")
 		return err, nil
 	}
 
-	// 设置blockchain.tip为最新区块哈希值
-	blockchain.tip = h
+	fmt.Println("区块添加完成")
 
 	// 返回
-	return err, blockchain
+	currentChain = blockchain
+	return nil, blockchain
 }
 
 // NewBlockChain 创建一个新的区块链
@@ -93,76 +118,308 @@ func NewBlockChain() (*BlockChain, error) {
 	b := tx.Bucket([]byte("blocks"))
 	if b == nil {
 		// 如果不存在，则创建一个新的 bucket
-		_, err := tx.CreateBucket([]byte("blocks"))
+		b, err = tx.CreateBucket([]byte("blocks"))
+		if err != nil {
+			return nil, err
+		}
+		// 新数据库的索引从第一个区块开始维护，不需要重建
+		err = putIndexVersion(b, indexVersion)
+		if err != nil {
+			return nil, err
+		}
+		err = putBlockEncoding(b, blockEncodingVersion)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// chainstate bucket 存储当前的UTXO集合，其余是按地址、交易ID、交易坐标查询的索引
+	for _, name := range derivedBuckets {
+		_, err = tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 区块状态、跨区转账状态与改写日志不是派生数据，只在这里创建
+	for _, name := range []string{blockStateBucket, transferStateBucket, redactionBucket} {
+		_, err = tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 读取已有的最新区块哈希值
+	tip := append([]byte{}, b.Get([]byte("l"))...)
+
 	// 提交事务
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
 	// 返回新的 BlockChain 实例
-	return &BlockChain{db: db}, nil
+	return &BlockChain{tip: tip, db: db}, nil
 }
 
 // AddBlock 添加一个区块到区块链中
+// 接在最新区块之后的区块先经过validateBlock验证，再与tip、chainstate中的UTXO集合及各种索引在同一个事务中更新
+// 接在其他已知区块之后的区块作为侧链区块保存，侧链的累计工作量比主链大时切换主链
+// 除创世区块外，区块头必须满足工作量证明、难度调整规则与时间戳规则，见pow.go
+// 任何一步失败都不会留下部分写入的数据
 func (bc *BlockChain) AddBlock(block *Block) error {
-	return bc.db.Update(func(tx *bolt.Tx) error {
+	// 先获取区块的哈希值
+	h, err := block.Hash()
+	if err != nil {
+		fmt.Println("区块哈希值计算存在错误")
+		return err
+	}
+
+	var tip []byte
+	var disconnected []*Block
+	err = bc.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("blocks"))
 		if b == nil {
 			return fmt.Errorf("Bucket 'blocks' does not exist")
 		}
 
-		// 将区块序列化为字节数组
-		blockData := block.Serialize()
+		if b.Get(h) != nil {
+			if getBlockState(tx, h) == BlockInvalid {
+				return &BlockValidationError{BlockHash: h, TxIndex: -1, Err: ErrInvalidBlock}
+			}
+			return &BlockValidationError{BlockHash: h, TxIndex: -1, Err: ErrBlockExists}
+		}
 
-		// 将区块数据保存到数据库中，以区块哈希值作为键
-		// 先获取区块的哈希值
-		h, err := block.Hash()
-		if err != nil {
-			fmt.Println("区块哈希值计算存在错误")
-			return err
+		// 区块的哈希模式必须符合链上的设置，变色龙模式的区块只能使用链上设置的变色龙公钥
+		if err := checkHashMode(b, block.Header); err != nil {
+			return &BlockValidationError{BlockHash: h, TxIndex: -1, Err: err}
 		}
-		err = b.Put(h, blockData)
-		if err != nil {
+		if err := checkBlockHeader(tx, block.Header, h); err != nil {
 			return err
 		}
 
-		// 更新区块链的 tip
-		err = b.Put([]byte("l"), h) // 在示例代码中使用 []byte("l") 作为键存储最新区块的哈希值，是一种简单的做法，它只是作为一个标识符，用来表示最新区块的哈希值。 // 通常情况下，我们可以选择任何唯一的标识符来表示最新区块的哈希值。在实际应用中，可以根据具体需求选择更具描述性的标识符。例如，可以使用 "latest_block_hash"、"tip"、"current_block_hash" 等等。选择一个合适的标识符可以使代码更易读和易于理解
-		if err != nil {
-			return err
+		if bytes.Equal(block.Header.PrevBlock, b.Get([]byte("l"))) {
+			// 写入之前先验证区块，验证失败返回BlockValidationError，不会写入任何数据
+			err := validateBlock(tx, block, h)
+			if err != nil {
+				return err
+			}
+
+			// 将区块数据保存到数据库中，以区块哈希值作为键
+			err = b.Put(h, block.Serialize())
+			if err != nil {
+				return err
+			}
+
+			// 更新tip、UTXO集合与索引
+			err = connectBlock(tx, block, h, int(block.Header.Height))
+			if err != nil {
+				return err
+			}
+		} else {
+			// 分叉区块
+			var err error
+			disconnected, err = addSideBlock(tx, block, h)
+			if err != nil {
+				return err
+			}
 		}
 
-		// 更新区块链结构体中的 tip
-		bc.tip = h
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		return nil
+	})
+	if err != nil {
+		// 区块本身无效时单独保存为invalid状态，之后收到同一个区块或它的后代时直接拒绝
+		if invalidatesBlock(err) {
+			failed := err.(*BlockValidationError).BlockHash
+			markErr := bc.db.Update(func(tx *bolt.Tx) error {
+				return markBranchInvalid(tx, block, h, failed)
+			})
+			if markErr != nil {
+				return markErr
+			}
+		}
+		return err
+	}
+
+	// 事务提交成功后再更新区块链结构体中的 tip
+	bc.tip = tip
+
+	if len(disconnected) > 0 {
+		bc.mu.Lock()
+		onReorgs := bc.onReorgs
+		bc.mu.Unlock()
+		for _, fn := range onReorgs {
+			fn(disconnected)
+		}
+	}
+	return nil
+}
+
+// OnReorg 注册主链切换分支后的回调，disconnected是从主链上回滚的区块，按高度从低到高排列
+// 回调在AddBlock的事务提交之后、AddBlock返回之前调用，例如交易池把回滚区块中的交易重新放回交易池
+func (bc *BlockChain) OnReorg(fn func(disconnected []*Block)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.onReorgs = append(bc.onReorgs, fn)
+}
 
+// connectBlock 将区块应用到UTXO集合与所有索引，并把它设为最新区块，需要在写入区块的同一个事务中调用
+func connectBlock(dbTx *bolt.Tx, block *Block, hash []byte, height int) error {
+	err := setBlockState(dbTx, hash, BlockValid)
+	if err != nil {
+		return err
+	}
+	undo, err := applyBlockUTXO(dbTx, block, height)
+	if err != nil {
+		return err
+	}
+	err = dbTx.Bucket([]byte(undoBucket)).Put(hash, undo.Serialize())
+	if err != nil {
+		return err
+	}
+	err = indexBlockTransactions(dbTx, block, hash, height)
+	if err != nil {
+		return err
+	}
+	err = connectCoordinator(dbTx, block, height)
+	if err != nil {
+		return err
+	}
+	err = dbTx.Bucket([]byte(heightIndexBucket)).Put(heightKey(height), hash)
+	if err != nil {
+		return err
+	}
+
+	// 更新区块链的 tip
+	b := dbTx.Bucket([]byte(blocksBucket))
+	err = b.Put([]byte("l"), hash) // 在示例代码中使用 []byte("l") 作为键存储最新区块的哈希值，是一种简单的做法，它只是作为一个标识符，用来表示最新区块的哈希值。 // 通常情况下，我们可以选择任何唯一的标识符来表示最新区块的哈希值。在实际应用中，可以根据具体需求选择更具描述性的标识符。例如，可以使用 "latest_block_hash"、"tip"、"current_block_hash" 等等。选择一个合适的标识符可以使代码更易读和易于理解
+	if err != nil {
+		return err
+	}
+	// 更新区块高度
+	return putTipHeight(b, height)
+}
+
+// heightKey 区块高度索引的键：8字节大端高度
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
+
+// GetBlockByHash 按哈希值获取区块
+func (bc *BlockChain) GetBlockByHash(hash []byte) (*Block, error) {
+	var block *Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		var err error
+		block, err = readBlock(tx, hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// GetBlockByHeight 按高度获取主链上的区块
+func (bc *BlockChain) GetBlockByHeight(height int) (*Block, error) {
+	var block *Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket([]byte(heightIndexBucket)).Get(heightKey(height))
+		if hash == nil {
+			return fmt.Errorf("! 高度为 %d 的区块不存在", height)
+		}
+		var err error
+		block, err = readBlock(tx, hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// indexVersion 返回数据库中索引的版本，旧数据库没有记录时为0
+func (bc *BlockChain) indexVersion() int {
+	version := 0
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(blocksBucket)).Get([]byte(indexVersionKey))
+		if v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return version
+}
+
+// putIndexVersion 保存索引的版本
+func putIndexVersion(b *bolt.Bucket, version int) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(version))
+	return b.Put([]byte(indexVersionKey), v)
+}
+
+// getTipHeight 返回最新区块的高度，没有区块时返回-1
+// 与tip一起保存在blocks bucket中，避免每次都反序列化最新区块
+func getTipHeight(b *bolt.Bucket) int {
+	v := b.Get([]byte(tipHeightKey))
+	if v == nil {
+		return -1
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+// putTipHeight 保存最新区块的高度
+func putTipHeight(b *bolt.Bucket, height int) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(height))
+	return b.Put([]byte(tipHeightKey), v)
+}
+
+// TipHeight 返回最新区块的高度，创世区块高度为0，没有区块时返回-1
+func (bc *BlockChain) TipHeight() int {
+	height := -1
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		height = getTipHeight(tx.Bucket([]byte(blocksBucket)))
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return height
 }
 
 // FindTransaction finds a transaction by its ID
+// 通过交易索引直接定位交易所在的区块
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
+	tx, _, err := bc.findIndexedTransaction(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
 
-	for {
-		block := bci.Next()
+	return *tx, nil
+}
 
-		for _, tx := range block.Body.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+// GetMerkleProof 寻找交易所在的区块，返回该交易的默克尔包含证明与区块的默克尔根
+func (bc *BlockChain) GetMerkleProof(txid []byte) (*MerkleProof, []byte, error) {
+	_, block, err := bc.findIndexedTransaction(txid)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if len(block.Header.PrevBlock) == 0 {
-			break
-		}
+	proof, err := block.MerkleProof(txid)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return proof, block.Header.MerkelRoot, nil
 }
 
 //// FindUTXONoBlockchain 所有未花费的交易的记录(曾经是也会记录)，和所有花费的交易的记录，理论上来说这两个集合的差集就是所有现在未花费的交易记录
@@ -218,191 +475,156 @@ func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 //	return UTXO, nil
 //}
 
+// forEachUTXO 遍历chainstate中的每一笔交易剩余的未花费输出
+func (bc *BlockChain) forEachUTXO(fn func(txID string, outs UTXOutputs)) {
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(utxoBucket)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			fn(hex.EncodeToString(k), DeserializeUTXOutputs(v))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
 // FindUTXOutputs 找出所有的还没有使用的UTXO的outputs，不包括曾经是但后来被使用的UTXO，是真正的UTXO集合
-// TODO:为了满足验证的需求，需要新建一个FindUTXOutputs函数，返回带txid和outid的out集合
+// 直接读取chainstate，不再遍历整条区块链，还没有结算的占位交易的out不能花费，不包含在结果中
 func (bc *BlockChain) FindUTXOutputs() map[string]TXOutputs {
 	USet := make(map[string]TXOutputs) // 存储UTXO的集合
-	bci := bc.Iterator()               // 迭代器
-
-	for {
-		block := bci.Next() // 新区块
-		for _, tx := range block.Body.Transactions {
-			txID := hex.EncodeToString(tx.ID) // TXID解码，string结构
-
-			// 先处理out
-			for _, out := range tx.Vout {
-				a := USet[txID].Outputs
-				a = append(a, out)
-				b := USet[txID]
-				b.Outputs = a
-				USet[txID] = b // 这个步骤真的麻烦
-				//USet[TxID].Outputs = append(USet[TxID].Outputs, Out)
-			}
 
-			for _, in := range tx.Vin {
-				// 处理in
-				if tx.IsCoinbase() == true {
-					fmt.Println("> 出块交易，没有in来源")
-				} else {
-					if _, ok := USet[hex.EncodeToString(in.Txid)]; !ok {
-						fmt.Println("> 在UTXO集合中没有找到使用的输出")
-						// 但是这种情况不太可能，coinbase交易除外，普通交易必须使用已有的未使用的交易输出来构造交易
-					} else {
-						for index, value := range USet[hex.EncodeToString(in.Txid)].Outputs {
-							// value.IsUse == true表示该output是特殊类型的UTXO，需要从UTXO集合中去除
-							if value.Address == in.Address || value.IsUse == true {
-								fmt.Println("> 使用了对应的out，out地址是：", value.Address)
-								// 删除对应元素，利用切片
-								c := USet[hex.EncodeToString(in.Txid)].Outputs
-								c = append(c[:index-1], c[index:]...)
-								d := USet[hex.EncodeToString(in.Txid)]
-								d.Outputs = c
-								USet[hex.EncodeToString(in.Txid)] = d
-								//USet[hex.EncodeToString(in.Txid)].Outputs = append(USet[hex.EncodeToString(in.Txid)].Outputs[:index-1], USet[hex.EncodeToString(in.Txid)].Outputs[index:]...)
-							}
-						}
-					}
-				}
+	bc.forEachUTXO(func(txID string, outs UTXOutputs) {
+		var a TXOutputs
+		for _, out := range outs.Outputs {
+			if out.Pending {
+				continue
 			}
+			a.Outputs = append(a.Outputs, out.TXOutput)
 		}
-		if len(block.Header.PrevBlock) == 0 {
-			break
+		if len(a.Outputs) != 0 {
+			USet[txID] = a
 		}
-	}
+	})
+
 	return USet
 }
 
 // FindUTXOutputs2 找出所有的还没有使用的UTXO的outputs，不包括曾经是但后来被使用的UTXO，是真正的UTXO集合
-// TODO:为了满足验证的需求，需要新建一个FindUTXOutputs函数，返回带txid和outid的out集合
+// 返回的out带有Outid，用于验证
 func (bc *BlockChain) FindUTXOutputs2() map[string]TXOutputs2 {
 	USet := make(map[string]TXOutputs2) // 存储UTXO的集合
-	bci := bc.Iterator()                // 迭代器
-
-	for {
-		block := bci.Next() // 新区块
-		for _, tx := range block.Body.Transactions {
-			txID := hex.EncodeToString(tx.ID) // TXID解码，string结构
-
-			// 先处理out
-			for i, out := range tx.Vout {
-				a := USet[txID].Outputs
-				a = append(a, TXOutput2{
-					Value:   out.Value,
-					Address: out.Address,
-					Outid:   i,
-					IsUse:   out.IsUse,
-				})
-				b := USet[txID]
-				b.Outputs = a
-				USet[txID] = b // 这个步骤真的麻烦
-				//USet[TxID].Outputs = append(USet[TxID].Outputs, Out)
-			}
 
-			for _, in := range tx.Vin {
-				// 处理in
-				if tx.IsCoinbase() == true {
-					fmt.Println("> 出块交易，没有in来源")
-				} else {
-					if _, ok := USet[hex.EncodeToString(in.Txid)]; !ok {
-						fmt.Println("> 在UTXO集合中没有找到使用的输出")
-						// 但是这种情况不太可能，coinbase交易除外，普通交易必须使用已有的未使用的交易输出来构造交易
-					} else {
-						for index, value := range USet[hex.EncodeToString(in.Txid)].Outputs {
-							// value.IsUse == true表示该output是特殊类型的UTXO，需要从UTXO集合中去除
-							if value.Address == in.Address || value.IsUse == true {
-								fmt.Println("> 使用了对应的out，out地址是：", value.Address)
-								// 删除对应元素，利用切片
-								c := USet[hex.EncodeToString(in.Txid)].Outputs
-								c = append(c[:index-1], c[index:]...)
-								d := USet[hex.EncodeToString(in.Txid)]
-								d.Outputs = c
-								USet[hex.EncodeToString(in.Txid)] = d
-								//USet[hex.EncodeToString(in.Txid)].Outputs = append(USet[hex.EncodeToString(in.Txid)].Outputs[:index-1], USet[hex.EncodeToString(in.Txid)].Outputs[index:]...)
-							}
-						}
-					}
-				}
+	bc.forEachUTXO(func(txID string, outs UTXOutputs) {
+		var a TXOutputs2
+		for _, out := range outs.Outputs {
+			if out.Pending {
+				continue
 			}
+			a.Outputs = append(a.Outputs, TXOutput2{
+				Value:    out.Value,
+				Address:  out.Address,
+				Outid:    out.Outid,
+				IsUse:    out.IsUse,
+				LockTime: out.LockTime,
+				Maturity: out.Maturity,
+				Multisig: out.Multisig,
+				HTLC:     out.HTLC,
+			})
 		}
-		if len(block.Header.PrevBlock) == 0 {
-			break
+		if len(a.Outputs) != 0 {
+			USet[txID] = a
 		}
-	}
+	})
+
 	return USet
 }
 
 // FindUTXOutputsForTran 跨区转账使用，找出所有的还没有使用的UTXO的outputs，不包括曾经是但后来被使用的UTXO，是真正的UTXO集合
+// 交易坐标CoordinatesX是区块高度，新区块加入后不会改变
 func (bc *BlockChain) FindUTXOutputsForTran() map[string][]TXOutputsTran {
 	USet := make(map[string][]TXOutputsTran) // 存储UTXO的集合
-	bci := bc.Iterator()                     // 迭代器
-
-	var blockNum int = 0 // 区块编号
-
-	for {
-		block := bci.Next() // 新区块
-		for txNum, tx := range block.Body.Transactions {
-			txID := hex.EncodeToString(tx.ID) // TXID解码，string结构
-
-			// 先处理out
-			for _, out := range tx.Vout {
-				// 填写UTXO的out，交易坐标
-				USet[txID] = append(USet[txID], TXOutputsTran{
-					Out:          out,
-					CoordinatesX: blockNum,
-					CoordinatesY: txNum,
-				})
-				//USet[TxID].Outputs = append(USet[TxID].Outputs, Out)
-			}
 
-			for _, in := range tx.Vin {
-				// 处理in
-				if tx.IsCoinbase() == true {
-					fmt.Println("> 出块交易，没有in来源")
-				} else {
-					if _, ok := USet[hex.EncodeToString(in.Txid)]; !ok {
-						fmt.Println("> 在UTXO集合中没有找到使用的输出")
-						// 但是这种情况不太可能，coinbase交易除外，普通交易必须使用已有的未使用的交易输出来构造交易
-					} else {
-						for index, value := range USet[hex.EncodeToString(in.Txid)] {
-							// value.IsUse == true表示该output是特殊类型的UTXO，需要从UTXO集合中去除
-							if value.Out.Address == in.Address || value.Out.IsUse == true {
-								fmt.Println("> 使用了对应的out，out地址是：", value.Out.Address)
-								// 删除对应元素，利用切片
-								USet[hex.EncodeToString(in.Txid)] = append(USet[hex.EncodeToString(in.Txid)][:index-1], USet[hex.EncodeToString(in.Txid)][index:]...)
-								//USet[hex.EncodeToString(in.Txid)].Outputs = append(USet[hex.EncodeToString(in.Txid)].Outputs[:index-1], USet[hex.EncodeToString(in.Txid)].Outputs[index:]...)
-							}
-						}
-					}
-				}
+	bc.forEachUTXO(func(txID string, outs UTXOutputs) {
+		for _, out := range outs.Outputs {
+			if out.Pending {
+				continue
 			}
+			// 填写UTXO的out，交易坐标
+			USet[txID] = append(USet[txID], TXOutputsTran{
+				Out:          out.TXOutput,
+				Outid:        out.Outid,
+				CoordinatesX: outs.Height,
+				CoordinatesY: outs.TxIndex,
+			})
 		}
-		if len(block.Header.PrevBlock) == 0 {
-			break
-		}
-	}
+	})
+
 	return USet
 }
 
 // Iterator returns a BlockchainIterat
 func (bc *BlockChain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.db}
+	bci := &BlockchainIterator{currentHash: bc.tip, db: bc.db}
 
 	return bci
 }
 
+// IteratorWithOptions 从区块from开始向前遍历，from为空时从最新区块开始
+// 从侧链区块开始遍历时可能经过无效区块，opts.SkipInvalid为true时跳过它们
+func (bc *BlockChain) IteratorWithOptions(from []byte, opts BlockQueryOptions) *BlockchainIterator {
+	if len(from) == 0 {
+		from = bc.tip
+	}
+	return &BlockchainIterator{currentHash: from, db: bc.db, skipInvalid: opts.SkipInvalid}
+}
+
 // SignTransaction signs inputs of a Transaction
 func (bc *BlockChain) SignTransaction(tx *Transaction, privKey *ecdsa.PrivateKey) {
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx.Sign(privKey, prevTXs)
+}
+
+// VerifyTransaction verifies transaction input signatures
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Verify(prevTXs)
+}
+
+// VerifyPartialTransaction 验证交易已有的签名，花费多签out的input签名个数可以不足
+func (bc *BlockChain) VerifyPartialTransaction(tx *Transaction) error {
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.VerifyPartial(prevTXs)
+}
+
+// findPrevTransactions 查询交易中花费UTXO的input引用的前置交易
+func (bc *BlockChain) findPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
+		if !spendsUTXO(tx, vin) {
+			continue
+		}
 		prevTX, err := bc.FindTransaction(vin.Txid)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return prevTXs, nil
 }
 
 //// FindUTXO2 查找指定账户所有未使用的UTXO，以便计算账户余额
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/boltdb/bolt"
@@ -10,25 +11,40 @@ import (
 type BlockchainIterator struct {
 	currentHash []byte
 	db          *bolt.DB
+	skipInvalid bool // 跳过被标记为无效的区块
 }
 
 // Next returns next block starting from the tip
+// 返回创世区块之后再调用返回nil
 func (i *BlockchainIterator) Next() *Block {
-	var block *Block
-
-	err := i.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock) // 反序列化
-
-		return nil
-	})
-
-	if err != nil {
-		log.Panic(err)
+	for len(i.currentHash) != 0 {
+		var block *Block
+
+		err := i.db.View(func(tx *bolt.Tx) error {
+			var err error
+			block, err = readBlock(tx, i.currentHash) // 反序列化，并填写区块状态
+			if err != nil {
+				return fmt.Errorf("! 区块 %x 不存在", i.currentHash)
+			}
+			return nil
+		})
+
+		if err != nil {
+			log.Panic(err)
+		}
+
+		// 创世区块是迭代的终点
+		if block.Header.Height == 0 {
+			i.currentHash = nil
+		} else {
+			i.currentHash = block.Header.PrevBlock
+		}
+
+		if i.skipInvalid && block.Header.State == BlockInvalid {
+			continue
+		}
+		return block
 	}
 
-	i.currentHash = block.Header.PrevBlock
-
-	return block
+	return nil
 }
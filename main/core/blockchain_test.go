@@ -0,0 +1,343 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 测试中使用的私钥与对应的地址
+var (
+	keyA, _ = crypto.HexToECDSA("1111111111111111111111111111111111111111111111111111111111111111")
+	keyB, _ = crypto.HexToECDSA("2222222222222222222222222222222222222222222222222222222222222222")
+	keyC, _ = crypto.HexToECDSA("3333333333333333333333333333333333333333333333333333333333333333")
+
+	addrA = crypto.PubkeyToAddress(keyA.PublicKey)
+	addrB = crypto.PubkeyToAddress(keyB.PublicKey)
+	addrC = crypto.PubkeyToAddress(keyC.PublicKey)
+
+	testKeyring = NewKeyring(keyA, keyB, keyC)
+)
+
+// testCoordinatorKey 测试区块链创世区块中设置的协调者
+var testCoordinatorKey, _ = crypto.GenerateKey()
+
+// testCoordinator 测试区块链的协调者地址
+func testCoordinator() common.Address {
+	return crypto.PubkeyToAddress(testCoordinatorKey.PublicKey)
+}
+
+// newTestChain 在临时目录中新建只有创世区块的区块链，创世区块的协调者是testCoordinatorKey
+// 数据库文件名固定，所以需要切换工作目录，测试不能并行
+func newTestChain(t *testing.T) *BlockChain {
+	t.Helper()
+	return newTestChainWithGenesis(t, NewGenesisBlock(testCoordinator()))
+}
+
+// newTestChainWithGenesis 在临时目录中新建只有genesis的区块链
+func newTestChainWithGenesis(t *testing.T, genesis *Block) *BlockChain {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	bc, err := NewBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bc.db.Close() })
+	if err := bc.AddBlock(genesis); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// newTestBlock 组装接在最新区块之后、包含txs的区块，不写入区块链
+func newTestBlock(t *testing.T, bc *BlockChain, txs ...*Transaction) *Block {
+	t.Helper()
+	return mineTestBlock(t, bc, NewBlockWithTransactions(bc.tip, uint64(bc.TipHeight()+1), txs))
+}
+
+// mineTestBlock 按前一个区块填写难度目标与时间戳，并寻找满足难度目标的Nonce
+func mineTestBlock(t *testing.T, bc *BlockChain, block *Block) *Block {
+	t.Helper()
+	if err := bc.PrepareHeader(block); err != nil {
+		t.Fatal(err)
+	}
+	return mineBlock(t, block)
+}
+
+// mineBlock 修改过区块头或者改用变色龙模式之后重新寻找Nonce
+func mineBlock(t *testing.T, block *Block) *Block {
+	t.Helper()
+	if err := block.Mine(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// addTestBlock 把txs打包成接在最新区块之后的区块并写入区块链
+func addTestBlock(t *testing.T, bc *BlockChain, txs ...*Transaction) *Block {
+	t.Helper()
+	block := newTestBlock(t, bc, txs...)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// testCoinbase 协调者授权的ToTran交易，依次给to转入values
+func testCoinbase(t *testing.T, to common.Address, values ...Amount) *Transaction {
+	t.Helper()
+	tx := &Transaction{Vin: []TXInput{{Vout: -1, Address: to, IsToTran: true}}, Type: toTranTxType}
+	for _, v := range values {
+		tx.Vout = append(tx.Vout, *NewTXOutput(v, to))
+	}
+	if err := tx.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// unsignedToTran 还没有协调者签名的ToTran交易
+func unsignedToTran(t *testing.T, to common.Address, value Amount) *Transaction {
+	t.Helper()
+	tx, err := NewCoinbaseTX(to, to, value, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// testSpend 花费prev的第vout个out，转给outputs中的地址
+func testSpend(t *testing.T, prev *Transaction, vout int, outputs ...TXOutput) *Transaction {
+	t.Helper()
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: prev.ID, Vout: vout, Address: prev.Vout[vout].Address}},
+		Vout: outputs,
+	}
+	testSign(t, tx, prev)
+	return tx
+}
+
+// testSign 用被花费的out的地址对应的测试私钥签名tx的每个input，prevs是input引用的交易
+func testSign(t *testing.T, tx *Transaction, prevs ...*Transaction) {
+	t.Helper()
+	prevTXs := make(map[string]Transaction)
+	for _, prev := range prevs {
+		prevTXs[hex.EncodeToString(prev.ID)] = *prev
+	}
+	if err := tx.SignWithKeyring(testKeyring, prevTXs); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// utxoEntry 读取chainstate中交易剩余的未花费输出
+func utxoEntry(t *testing.T, bc *BlockChain, txid []byte) (UTXOutputs, bool) {
+	t.Helper()
+	var outs UTXOutputs
+	found := false
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		if v := dbTx.Bucket([]byte(utxoBucket)).Get(txid); v != nil {
+			outs, found = DeserializeUTXOutputs(v), true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return outs, found
+}
+
+// outids 未花费输出在原交易中的位置
+func outids(outs UTXOutputs) []int {
+	var ids []int
+	for _, out := range outs.Outputs {
+		ids = append(ids, out.Outid)
+	}
+	return ids
+}
+
+func TestAddBlockUpdatesUTXOSet(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5, 7, 9)
+	addTestBlock(t, bc, cb)
+	spend1 := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+	spend2 := testSpend(t, cb, 2, *NewTXOutput(4, addrC), *NewTXOutput(5, addrA))
+	addTestBlock(t, bc, spend1, spend2)
+	// 花费交易新产生的out在同一个区块中被花费
+	spend3 := testSpend(t, spend1, 0, *NewTXOutput(5, addrC))
+	spend4 := testSpend(t, spend3, 0, *NewTXOutput(5, addrA))
+	addTestBlock(t, bc, spend3, spend4)
+
+	tests := []struct {
+		name    string
+		tx      *Transaction
+		outids  []int // nil表示交易的out都已经被花费
+		height  int
+		txIndex int
+	}{
+		{"partly spent", cb, []int{1}, 1, 0},
+		{"fully spent", spend1, nil, 0, 0},
+		{"unspent", spend2, []int{0, 1}, 2, 1},
+		{"spent in same block", spend3, nil, 0, 0},
+		{"last", spend4, []int{0}, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outs, found := utxoEntry(t, bc, tt.tx.ID)
+			if found != (tt.outids != nil) {
+				t.Fatalf("交易在UTXO集合中: %v，期望 %v", found, tt.outids != nil)
+			}
+			if !found {
+				return
+			}
+			if got := outids(outs); !reflect.DeepEqual(got, tt.outids) {
+				t.Fatalf("未花费的out %v，期望 %v", got, tt.outids)
+			}
+			if outs.Height != tt.height || outs.TxIndex != tt.txIndex {
+				t.Fatalf("坐标 (%d, %d)，期望 (%d, %d)", outs.Height, outs.TxIndex, tt.height, tt.txIndex)
+			}
+		})
+	}
+}
+
+func TestAddBlockIsAtomic(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5)
+	addTestBlock(t, bc, cb)
+	tip := bc.tip
+
+	// 第二个交易花费不存在的out，第一个交易的修改也不能留下
+	spend := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+	missing := testSpend(t, &Transaction{ID: []byte("missing"), Vout: []TXOutput{{Value: 1, Address: addrA}}}, 0, *NewTXOutput(1, addrB))
+	block := newTestBlock(t, bc, spend, missing)
+	if err := bc.AddBlock(block); err == nil {
+		t.Fatal("花费不存在的out的区块被接受")
+	}
+
+	if !bytes.Equal(bc.tip, tip) || bc.TipHeight() != 1 {
+		t.Fatalf("失败的区块改变了最新区块")
+	}
+	if _, found := utxoEntry(t, bc, cb.ID); !found {
+		t.Fatal("失败的区块花费了out")
+	}
+	if _, found := utxoEntry(t, bc, spend.ID); found {
+		t.Fatal("失败的区块写入了out")
+	}
+}
+
+func TestReindexMatchesIncremental(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5, 7)
+	addTestBlock(t, bc, cb)
+	spend := testSpend(t, cb, 1, *NewTXOutput(3, addrB), *NewTXOutput(4, addrC))
+	addTestBlock(t, bc, spend)
+
+	before := bc.FindUTXOutputs2()
+	UTXOSet1{bc}.Reindex()
+	if after := bc.FindUTXOutputs2(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("重建后的UTXO集合 %+v，重建前 %+v", after, before)
+	}
+}
+
+// hexID 十六进制的交易ID，即UTXO查询结果的键
+func hexID(tx *Transaction) string {
+	return hex.EncodeToString(tx.ID)
+}
+
+func TestGenesisBlock(t *testing.T) {
+	genesis := NewGenesisBlock(testCoordinator())
+	if !genesis.IsGenesis() {
+		t.Fatal("创世区块的IsGenesis为false")
+	}
+	// 所有节点的创世区块相同
+	want, _ := genesis.Hash()
+	if again, _ := NewGenesisBlock(testCoordinator()).Hash(); !bytes.Equal(again, want) {
+		t.Fatalf("两次创建的创世区块哈希 %x 与 %x 不同", again, want)
+	}
+
+	bc := newTestChain(t)
+	if !bytes.Equal(bc.tip, want) || bc.TipHeight() != 0 {
+		t.Fatalf("最新区块 %x，高度 %d，期望创世区块 %x", bc.tip, bc.TipHeight(), want)
+	}
+}
+
+func TestGetBlockByHeight(t *testing.T) {
+	bc := newTestChain(t)
+	blocks := []*Block{NewGenesisBlock(testCoordinator())}
+	for i := 1; i <= 3; i++ {
+		blocks = append(blocks, addTestBlock(t, bc, testCoinbase(t, addrA, Amount(i))))
+	}
+
+	for height, want := range blocks {
+		wantHash, _ := want.Hash()
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash, _ := block.Hash(); !bytes.Equal(hash, wantHash) || block.Header.Height != uint64(height) {
+			t.Fatalf("高度 %d 的区块 %x，期望 %x", height, hash, wantHash)
+		}
+		block, err = bc.GetBlockByHash(wantHash)
+		if err != nil || block.Header.Height != uint64(height) {
+			t.Fatalf("GetBlockByHash(%x) = 高度 %d, %v", wantHash, block.Header.Height, err)
+		}
+	}
+	if _, err := bc.GetBlockByHeight(len(blocks)); err == nil {
+		t.Fatal("找到了不存在的高度")
+	}
+}
+
+func TestAddBlockRejectsWrongHeight(t *testing.T) {
+	bc := newTestChain(t)
+	tests := []struct {
+		name   string
+		height uint64
+	}{
+		{"same as tip", 0},
+		{"skips a height", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := NewBlockWithTransactions(bc.tip, tt.height, []*Transaction{testCoinbase(t, addrA, 1)})
+			if err := bc.AddBlock(block); err == nil {
+				t.Fatalf("高度为 %d 的区块被接受", tt.height)
+			}
+			if bc.TipHeight() != 0 {
+				t.Fatalf("最新高度 %d，期望 0", bc.TipHeight())
+			}
+		})
+	}
+}
+
+// TestCoordinatesAreStable 交易坐标是区块高度与位置，之后加入新区块也不会改变
+func TestCoordinatesAreStable(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5)
+	addTestBlock(t, bc, testCoinbase(t, addrB, 1), cb)
+
+	for i := 0; i < 3; i++ {
+		outs := bc.FindUTXOutputsForTran()[hexID(cb)]
+		if len(outs) != 1 || outs[0].CoordinatesX != 1 || outs[0].CoordinatesY != 1 {
+			t.Fatalf("加入 %d 个区块后交易坐标 %+v，期望 (1, 1)", i, outs)
+		}
+		tx, err := bc.FindTransactionByCoordinates(outs[0].CoordinatesX, outs[0].CoordinatesY)
+		if err != nil || !bytes.Equal(tx.ID, cb.ID) {
+			t.Fatalf("按坐标找到交易 %x, %v，期望 %x", tx.ID, err, cb.ID)
+		}
+		addTestBlock(t, bc, testCoinbase(t, addrC, Amount(i+1)))
+	}
+}
@@ -0,0 +1,261 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 变色龙哈希
+// 区块可以选择用变色龙哈希计算区块哈希，使用没有密钥泄露问题的变色龙哈希(Ateniese–de Medeiros)，运算在secp256k1上
+// Y = x·G 是写入区块头的变色龙公钥，陷门x是改写机构的私钥，只由改写机构保存，出块节点只需要公钥
+// 随机数Randomness = r || s，各32字节，CH(m; r, s) = r - X(e·Y + s·G) mod N，其中 e = H(m || r)，X(P)是点P的x坐标，m是默克尔根
+// 出块节点随机选择r与s，不知道陷门，与普通哈希一样找不到另一组(m', r', s')使CH相同
+// 改写机构随机选择k，令 r' = CH + X(k·G) mod N，e' = H(m' || r')，s' = k - e'·x mod N，即可得到m'的随机数
+// 与Schnorr签名一样，公开的碰撞不会泄露陷门x，改写机构可以一直使用同一个陷门
+// 变色龙模式的区块哈希 = sha256(MerkelRoot替换为CH(MerkelRoot; Randomness)、去掉Randomness后的区块头编码)
+// 改写机构改写区块中的交易并重新计算Randomness后，区块哈希不变，后面区块的PrevBlock仍然有效，见redaction.go
+// 链上设置了变色龙公钥后，新区块必须使用变色龙模式与这个公钥；之前的区块按各自区块头中的模式计算哈希
+
+// HashMode 区块哈希的计算方式
+type HashMode byte
+
+const (
+	HashSHA256    HashMode = iota // 区块头编码的SHA-256，区块一旦写入就不能改写
+	HashChameleon                 // 变色龙哈希，改写机构可以改写区块中的交易
+)
+
+const chameleonKeyKey = "c" // blocks bucket中存储链上变色龙公钥的键，没有时新区块使用HashSHA256
+
+var (
+	ErrHashMode         = errors.New("区块的哈希模式或变色龙公钥与链的设置不符")
+	ErrInvalidChameleon = errors.New("变色龙公钥或随机数无效")
+)
+
+// NewChameleonKey 生成改写机构的陷门私钥，公钥写入区块头，私钥只由改写机构保存，出块节点不需要也不应该持有
+func NewChameleonKey() (*ecdsa.PrivateKey, error) {
+	return crypto.GenerateKey()
+}
+
+// SaveChameleonKey 把陷门私钥以十六进制保存到file，文件只有所有者可以读写
+func SaveChameleonKey(file string, key *ecdsa.PrivateKey) error {
+	return crypto.SaveECDSA(file, key)
+}
+
+// LoadChameleonKey 从file读取陷门私钥
+func LoadChameleonKey(file string) (*ecdsa.PrivateKey, error) {
+	return crypto.LoadECDSA(file)
+}
+
+// chameleonChallenge e = H(m || r) mod N
+func chameleonChallenge(msg []byte, r *big.Int) *big.Int {
+	h := sha256.New()
+	h.Write([]byte("chameleon"))
+	h.Write(binary.AppendUvarint(nil, uint64(len(msg))))
+	h.Write(msg)
+	h.Write(common.LeftPadBytes(r.Bytes(), 32))
+	e := new(big.Int).SetBytes(h.Sum(nil))
+	return e.Mod(e, crypto.S256().Params().N)
+}
+
+// parseRandomness 随机数是r || s，各32字节大端编码的[1, N)中的标量
+func parseRandomness(randomness []byte) (*big.Int, *big.Int, error) {
+	N := crypto.S256().Params().N
+	if len(randomness) != 64 {
+		return nil, nil, fmt.Errorf("! 变色龙随机数长度 %d 不是64: %w", len(randomness), ErrInvalidChameleon)
+	}
+	r := new(big.Int).SetBytes(randomness[:32])
+	s := new(big.Int).SetBytes(randomness[32:])
+	if r.Sign() == 0 || r.Cmp(N) >= 0 || s.Sign() == 0 || s.Cmp(N) >= 0 {
+		return nil, nil, fmt.Errorf("! 变色龙随机数 %x: %w", randomness, ErrInvalidChameleon)
+	}
+	return r, s, nil
+}
+
+// encodeRandomness 把r与s编码为随机数
+func encodeRandomness(r *big.Int, s *big.Int) []byte {
+	return append(common.LeftPadBytes(r.Bytes(), 32), common.LeftPadBytes(s.Bytes(), 32)...)
+}
+
+// randomScalar 生成[1, N)中的随机标量
+func randomScalar() (*big.Int, error) {
+	for {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		k := new(big.Int).SetBytes(buf)
+		if k.Sign() != 0 && k.Cmp(crypto.S256().Params().N) < 0 {
+			return k, nil
+		}
+	}
+}
+
+// newRandomness 出块节点随机生成r与s
+func newRandomness() ([]byte, error) {
+	r, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	s, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	return encodeRandomness(r, s), nil
+}
+
+// chameleonHash CH(m; r, s) = r - X(e·Y + s·G) mod N，key是压缩编码的变色龙公钥Y，返回32字节的哈希值
+func chameleonHash(key []byte, msg []byte, randomness []byte) ([]byte, error) {
+	pub, err := crypto.DecompressPubkey(key)
+	if err != nil {
+		return nil, fmt.Errorf("! 变色龙公钥 %x: %v: %w", key, err, ErrInvalidChameleon)
+	}
+	r, s, err := parseRandomness(randomness)
+	if err != nil {
+		return nil, err
+	}
+
+	curve := crypto.S256()
+	e := chameleonChallenge(msg, r)
+	ex, ey := curve.ScalarMult(pub.X, pub.Y, common.LeftPadBytes(e.Bytes(), 32))
+	sx, sy := curve.ScalarBaseMult(common.LeftPadBytes(s.Bytes(), 32))
+	var px *big.Int
+	switch {
+	case ex.Cmp(sx) != 0:
+		px, _ = curve.Add(ex, ey, sx, sy)
+	case ey.Cmp(sy) == 0:
+		px, _ = curve.Double(ex, ey)
+	default:
+		// 两个点互为相反数时和是无穷远点，只有知道陷门才能构造出这样的随机数
+		return nil, ErrInvalidChameleon
+	}
+
+	N := curve.Params().N
+	digest := new(big.Int).Sub(r, new(big.Int).Mod(px, N))
+	digest.Mod(digest, N)
+	return common.LeftPadBytes(digest.Bytes(), 32), nil
+}
+
+// chameleonCollision 改写机构为新的消息计算随机数，使CH(newMsg; r', s')等于digest
+// 每次使用新的随机数k，碰撞与Schnorr签名一样不会泄露陷门
+func chameleonCollision(key *ecdsa.PrivateKey, digest []byte, newMsg []byte) ([]byte, error) {
+	curve := crypto.S256()
+	N := curve.Params().N
+	c := new(big.Int).SetBytes(digest)
+	for {
+		k, err := randomScalar()
+		if err != nil {
+			return nil, err
+		}
+		// r' = CH + X(k·G) mod N
+		kx, _ := curve.ScalarBaseMult(common.LeftPadBytes(k.Bytes(), 32))
+		r := new(big.Int).Add(c, kx)
+		r.Mod(r, N)
+		// s' = k - e'·x mod N
+		s := new(big.Int).Mul(chameleonChallenge(newMsg, r), key.D)
+		s.Sub(k, s)
+		s.Mod(s, N)
+		if r.Sign() != 0 && s.Sign() != 0 {
+			return encodeRandomness(r, s), nil
+		}
+	}
+}
+
+// hashedHeader 计算区块哈希时编码的区块头，变色龙模式下MerkelRoot替换为变色龙哈希，Randomness不参与编码
+func (h *Header) hashedHeader() (*Header, error) {
+	switch h.HashMode {
+	case HashSHA256:
+		return h, nil
+	case HashChameleon:
+		digest, err := chameleonHash(h.ChameleonKey, h.MerkelRoot, h.Randomness)
+		if err != nil {
+			return nil, err
+		}
+		hashed := *h
+		hashed.MerkelRoot = digest
+		hashed.Randomness = nil
+		return &hashed, nil
+	default:
+		return nil, fmt.Errorf("! 区块头的哈希模式 %d: %w", h.HashMode, ErrHashMode)
+	}
+}
+
+// SealChameleon 让区块使用变色龙模式，key是变色龙公钥，随机数随机生成，出块节点不需要陷门
+// 需要在交易与默克尔根确定之后、计算区块哈希之前调用
+func (b *Block) SealChameleon(key *ecdsa.PublicKey) error {
+	randomness, err := newRandomness()
+	if err != nil {
+		return err
+	}
+	b.Header.HashMode = HashChameleon
+	b.Header.ChameleonKey = crypto.CompressPubkey(key)
+	b.Header.Randomness = randomness
+	return nil
+}
+
+// SetChameleonKey 设置链上的变色龙公钥，之后添加的区块必须使用变色龙模式与这个公钥，nil表示之后的区块使用HashSHA256
+// 更换公钥后，之前的区块仍然只能由它们各自区块头中的公钥对应的陷门改写
+func (bc *BlockChain) SetChameleonKey(key *ecdsa.PublicKey) error {
+	return bc.db.Update(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(blocksBucket))
+		if key == nil {
+			return b.Delete([]byte(chameleonKeyKey))
+		}
+		return b.Put([]byte(chameleonKeyKey), crypto.CompressPubkey(key))
+	})
+}
+
+// ChameleonKey 链上的变色龙公钥，没有设置时返回nil
+func (bc *BlockChain) ChameleonKey() (*ecdsa.PublicKey, error) {
+	var key *ecdsa.PublicKey
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		v := dbTx.Bucket([]byte(blocksBucket)).Get([]byte(chameleonKeyKey))
+		if v == nil {
+			return nil
+		}
+		var err error
+		key, err = crypto.DecompressPubkey(v)
+		return err
+	})
+	return key, err
+}
+
+// sealBlock 按链上的设置决定新区块的哈希模式
+func sealBlock(b *bolt.Bucket, block *Block) error {
+	v := b.Get([]byte(chameleonKeyKey))
+	if v == nil {
+		return nil
+	}
+	key, err := crypto.DecompressPubkey(v)
+	if err != nil {
+		return err
+	}
+	return block.SealChameleon(key)
+}
+
+// checkHashMode 检查新添加的区块符合链上的哈希模式设置，创世区块总是使用HashSHA256
+func checkHashMode(b *bolt.Bucket, header *Header) error {
+	if len(header.PrevBlock) == 0 {
+		return nil
+	}
+	key := b.Get([]byte(chameleonKeyKey))
+	if key == nil {
+		if header.HashMode != HashSHA256 {
+			return ErrHashMode
+		}
+		return nil
+	}
+	if header.HashMode != HashChameleon || !bytes.Equal(header.ChameleonKey, key) {
+		return ErrHashMode
+	}
+	return nil
+}
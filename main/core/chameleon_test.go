@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestChameleonCollision(t *testing.T) {
+	authority, _ := NewChameleonKey()
+	producer, _ := crypto.GenerateKey()
+	key := crypto.CompressPubkey(&authority.PublicKey)
+	randomness, err := newRandomness()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("merkle root")
+	digest, err := chameleonHash(key, msg, randomness)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		newMsg []byte
+		opener func(newMsg []byte) []byte // 为newMsg计算随机数
+		same   bool
+	}{
+		{"authority", []byte("redacted root"), func(m []byte) []byte {
+			r, err := chameleonCollision(authority, digest, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return r
+		}, true},
+		{"authority same message", msg, func(m []byte) []byte {
+			r, _ := chameleonCollision(authority, digest, m)
+			return r
+		}, true},
+		{"original randomness", []byte("redacted root"), func([]byte) []byte { return randomness }, false},
+		{"fresh randomness", []byte("redacted root"), func([]byte) []byte {
+			r, _ := newRandomness()
+			return r
+		}, false},
+		// 出块节点只有自己的私钥，用它计算的"碰撞"对变色龙公钥无效
+		{"producer key", []byte("redacted root"), func(m []byte) []byte {
+			r, _ := chameleonCollision(producer, digest, m)
+			return r
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.opener(tt.newMsg)
+			got, err := chameleonHash(key, tt.newMsg, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(got, digest) != tt.same {
+				t.Fatalf("变色龙哈希相同: %v，期望 %v", !tt.same, tt.same)
+			}
+		})
+	}
+}
+
+// TestChameleonCollisionKeepsTrapdoor 公开的碰撞不会泄露陷门
+// 线性的变色龙哈希 m·G + r·Y 中，同一个哈希值的两组(m, r)可以直接解出陷门 x = (m - m') / (r' - r)
+// 这里只有e·Y + s·G两次相同时才能解出 x = (s - s') / (e' - e)，碰撞每次使用新的随机数k，看到碰撞的出块节点仍然无法构造新的碰撞
+func TestChameleonCollisionKeepsTrapdoor(t *testing.T) {
+	authority, _ := NewChameleonKey()
+	key := crypto.CompressPubkey(&authority.PublicKey)
+	N := crypto.S256().Params().N
+
+	randomness, _ := newRandomness()
+	msg, newMsg := []byte("root"), []byte("redacted root")
+	digest, _ := chameleonHash(key, msg, randomness)
+	collision, err := chameleonCollision(authority, digest, newMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := chameleonCollision(authority, digest, newMsg)
+	if bytes.Equal(collision, again) {
+		t.Fatal("同一个消息的两次碰撞相同")
+	}
+
+	r1, s1, _ := parseRandomness(randomness)
+	r2, s2, _ := parseRandomness(collision)
+	d := new(big.Int).Sub(chameleonChallenge(newMsg, r2), chameleonChallenge(msg, r1))
+	x := new(big.Int).Sub(s1, s2)
+	x.Mul(x, d.ModInverse(d.Mod(d, N), N))
+	x.Mod(x, N)
+	if x.Cmp(authority.D) == 0 {
+		t.Fatal("从两组随机数解出了陷门")
+	}
+}
+
+func TestChameleonHashRejectsInvalid(t *testing.T) {
+	authority, _ := NewChameleonKey()
+	key := crypto.CompressPubkey(&authority.PublicKey)
+	randomness, _ := newRandomness()
+	n := crypto.S256().Params().N.FillBytes(make([]byte, 32))
+
+	tests := []struct {
+		name       string
+		key        []byte
+		randomness []byte
+	}{
+		{"bad key", []byte{2, 1}, randomness},
+		{"short randomness", key, randomness[:32]},
+		{"zero r", key, append(make([]byte, 32), randomness[32:]...)},
+		{"zero s", key, append(append([]byte{}, randomness[:32]...), make([]byte, 32)...)},
+		{"r not below N", key, append(append([]byte{}, n...), randomness[32:]...)},
+		{"s not below N", key, append(append([]byte{}, randomness[:32]...), n...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := chameleonHash(tt.key, []byte("m"), tt.randomness); !errors.Is(err, ErrInvalidChameleon) {
+				t.Fatalf("错误 %v，期望 %v", err, ErrInvalidChameleon)
+			}
+		})
+	}
+}
+
+// TestSealChameleon 出块节点只用变色龙公钥封装区块，区块哈希覆盖变色龙哈希而不是默克尔根
+func TestSealChameleon(t *testing.T) {
+	authority, _ := NewChameleonKey()
+	block := NewBlockWithTransactions([]byte{1}, 1, []*Transaction{testCoinbase(t, addrA, 1)})
+	plain, _ := block.Hash()
+	if err := block.SealChameleon(&authority.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain, sealed) || len(block.Header.Randomness) != 64 {
+		t.Fatalf("变色龙模式的区块哈希 %x，随机数 %x", sealed, block.Header.Randomness)
+	}
+
+	decoded, err := DecodeHeader(EncodeHeader(block.Header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := (&Block{Header: decoded}).Hash(); !bytes.Equal(h, sealed) {
+		t.Fatal("解码后的区块头哈希不同")
+	}
+
+	// 换了交易之后，不知道陷门的出块节点无法让区块哈希保持不变
+	other := NewBlockWithTransactions([]byte{1}, 1, []*Transaction{testCoinbase(t, addrB, 1)})
+	forged := *block.Header
+	forged.MerkelRoot = other.Header.MerkelRoot
+	if h, _ := (&Block{Header: &forged}).Hash(); bytes.Equal(h, sealed) {
+		t.Fatal("改写交易后区块哈希不变")
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 找零地址策略
+// 找零总是转回发送方地址时，同一个用户的所有转账都可以通过找零关联起来，TxBuildOptions.Change可以为每次转账选择找零地址
+
+// ChangePolicy 找零地址策略
+// ChangeAddress只在交易确实需要找零output时调用，sender是发送方地址
+type ChangePolicy interface {
+	ChangeAddress(sender common.Address) (common.Address, error)
+}
+
+// SameAddressChange 找零转回发送方地址，TxBuildOptions.Change为nil时使用
+type SameAddressChange struct{}
+
+// ChangeAddress 实现ChangePolicy
+func (SameAddressChange) ChangeAddress(sender common.Address) (common.Address, error) {
+	return sender, nil
+}
+
+// FixedChangeAddress 找零转到指定的地址
+type FixedChangeAddress struct {
+	Address common.Address
+}
+
+// ChangeAddress 实现ChangePolicy
+func (p FixedChangeAddress) ChangeAddress(sender common.Address) (common.Address, error) {
+	return p.Address, nil
+}
+
+// NewWalletChange 每次找零都转到一个新建的子钱包
+// NewWallet由钱包提供，需要在返回地址之前把新子钱包的私钥保存下来，否则找零的钱无法再使用
+type NewWalletChange struct {
+	NewWallet func() (common.Address, error)
+}
+
+// ChangeAddress 实现ChangePolicy
+func (p NewWalletChange) ChangeAddress(sender common.Address) (common.Address, error) {
+	return p.NewWallet()
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestChangePolicy(t *testing.T) {
+	errNoWallet := errors.New("无法保存子钱包")
+	calls := 0
+	fresh := NewWalletChange{NewWallet: func() (common.Address, error) {
+		calls++
+		return addrC, nil
+	}}
+
+	tests := []struct {
+		name   string
+		opts   TxBuildOptions
+		coins  []Coin
+		change common.Address // 零地址表示没有找零output
+		err    error
+		calls  int // NewWallet被调用的次数
+	}{
+		{"default returns to sender", TxBuildOptions{}, testCoins(8), addrA, nil, 0},
+		{"same address", TxBuildOptions{Change: SameAddressChange{}}, testCoins(8), addrA, nil, 0},
+		{"fixed address", TxBuildOptions{Change: FixedChangeAddress{Address: addrB}}, testCoins(8), addrB, nil, 0},
+		{"fresh wallet", TxBuildOptions{Change: fresh}, testCoins(8), addrC, nil, 1},
+		// 不需要找零时不会新建子钱包
+		{"fresh wallet without change", TxBuildOptions{Change: fresh}, testCoins(5), common.Address{}, nil, 0},
+		{"fresh wallet fails", TxBuildOptions{Change: NewWalletChange{NewWallet: func() (common.Address, error) {
+			return common.Address{}, errNoWallet
+		}}}, testCoins(8), common.Address{}, errNoWallet, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			tx := &Transaction{Vout: []TXOutput{*NewTXOutput(5, addrB)}}
+			_, err := fundTransaction(tx, tt.coins, addrA, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("fundTransaction = %v，期望 %v", err, tt.err)
+			}
+			if calls != tt.calls {
+				t.Fatalf("NewWallet 被调用 %d 次，期望 %d", calls, tt.calls)
+			}
+			if tt.err != nil {
+				return
+			}
+			if tt.change == (common.Address{}) {
+				if len(tx.Vout) != 1 {
+					t.Fatalf("交易有 %d 个output，期望没有找零", len(tx.Vout))
+				}
+				return
+			}
+			if len(tx.Vout) != 2 || tx.Vout[1].Address != tt.change || tx.Vout[1].Value != 3 {
+				t.Fatalf("找零 %+v，期望给 %x 转入 3", tx.Vout[1:], tt.change)
+			}
+		})
+	}
+}
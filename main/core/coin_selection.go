@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// 选币策略
+// 构造交易时由TxBuildOptions.Selector决定使用哪些out，每次转账都可以使用不同的策略，默认为LargestFirst
+
+// SelectionTarget 选币的目标
+// 每个input都会增加交易大小，选中coin的有效金额 = out金额 - 这个input的手续费
+// 选中coin的有效金额之和不小于Amount时就足够支付转账金额与手续费
+type SelectionTarget struct {
+	Amount    Amount // output金额之和加上不含input时交易的手续费
+	ChangeFee Amount // 找零output的手续费，多出的金额不超过它时不找零，直接并入手续费
+	FeeRate   Amount // 每1000字节的手续费
+}
+
+// InputFee 花费coin的input增加的手续费，每个签名按65字节计算，多签out需要Required个签名
+func (t SelectionTarget) InputFee(c Coin) Amount {
+	in := placeholderInput(c)
+	var e encoder
+	e.bytes(EncodeTXInput(&in))
+	return TxBuildOptions{FeeRate: t.FeeRate}.Fee(e.buf.Len())
+}
+
+// EffectiveValue coin扣除自身input手续费后的有效金额，金额不够支付手续费时为0
+func (t SelectionTarget) EffectiveValue(c Coin) Amount {
+	v, err := c.Out.Value.Sub(t.InputFee(c))
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// CoinSelector 选币策略
+// Select返回选中的coin在coins中的位置，交易按返回的顺序加入input；可用的out不够时返回ErrInsufficientFunds
+type CoinSelector interface {
+	Select(coins []Coin, target SelectionTarget) ([]int, error)
+}
+
+// accumulate 按order的顺序选择coin，直到有效金额之和达到目标，有效金额为0的coin不会被选中
+func accumulate(coins []Coin, order []int, target SelectionTarget) ([]int, error) {
+	var selected []int
+	var accumulated Amount
+	for _, i := range order {
+		v := target.EffectiveValue(coins[i])
+		if v == 0 {
+			continue
+		}
+		selected = append(selected, i)
+		accumulated = accumulated.saturatingAdd(v)
+		if accumulated >= target.Amount {
+			return selected, nil
+		}
+	}
+	return nil, fmt.Errorf("! 扣除input手续费后可用余额 %d，需要 %d: %w", accumulated, target.Amount, ErrInsufficientFunds)
+}
+
+// sortedOrder 按金额排序后的coin位置，金额相同时保持原来的顺序
+func sortedOrder(coins []Coin, desc bool) []int {
+	order := make([]int, len(coins))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if desc {
+			return coins[order[i]].Out.Value > coins[order[j]].Out.Value
+		}
+		return coins[order[i]].Out.Value < coins[order[j]].Out.Value
+	})
+	return order
+}
+
+// LargestFirst 优先使用金额最大的out，input个数最少，交易手续费最低
+type LargestFirst struct{}
+
+// Select 实现CoinSelector
+func (LargestFirst) Select(coins []Coin, target SelectionTarget) ([]int, error) {
+	return accumulate(coins, sortedOrder(coins, true), target)
+}
+
+// SmallestFirst 优先使用金额最小的out，把零散的out合并起来，手续费较高
+type SmallestFirst struct{}
+
+// Select 实现CoinSelector
+func (SmallestFirst) Select(coins []Coin, target SelectionTarget) ([]int, error) {
+	return accumulate(coins, sortedOrder(coins, false), target)
+}
+
+// bnbMaxTries 分支定界最多搜索的节点数
+const bnbMaxTries = 100000
+
+// BranchAndBound 分支定界搜索不需要找零的组合
+// 有效金额之和在[Amount, Amount+ChangeFee]之间时不需要找零output，多选中的金额最少的组合最好
+// 找不到这样的组合时使用Fallback，Fallback为nil时使用LargestFirst
+type BranchAndBound struct {
+	Fallback CoinSelector
+}
+
+// Select 实现CoinSelector
+func (s BranchAndBound) Select(coins []Coin, target SelectionTarget) ([]int, error) {
+	// 只考虑有效金额为正的coin，按有效金额从高到低搜索
+	var order []int
+	values := make(map[int]Amount)
+	for _, i := range sortedOrder(coins, true) {
+		v := target.EffectiveValue(coins[i])
+		if v > 0 {
+			order = append(order, i)
+			values[i] = v
+		}
+	}
+	// remaining[k] 是order[k:]的有效金额之和
+	remaining := make([]Amount, len(order)+1)
+	for k := len(order) - 1; k >= 0; k-- {
+		remaining[k] = remaining[k+1].saturatingAdd(values[order[k]])
+	}
+
+	upper := target.Amount.saturatingAdd(target.ChangeFee)
+	var best []int
+	var bestWaste Amount
+	var current []int
+	tries := 0
+
+	// sum不超过upper，加上剩余金额时按上限截断，不会溢出
+	var search func(k int, sum Amount)
+	search = func(k int, sum Amount) {
+		tries++
+		if tries > bnbMaxTries || (best != nil && bestWaste == 0) {
+			return
+		}
+		if sum > upper || sum.saturatingAdd(remaining[k]) < target.Amount {
+			return
+		}
+		if sum >= target.Amount {
+			if waste := sum - target.Amount; best == nil || waste < bestWaste {
+				best = append([]int{}, current...)
+				bestWaste = waste
+			}
+			return
+		}
+		if k == len(order) {
+			return
+		}
+		// 先尝试选中order[k]，再尝试不选
+		current = append(current, order[k])
+		search(k+1, sum+values[order[k]])
+		current = current[:len(current)-1]
+		search(k+1, sum)
+	}
+	search(0, 0)
+
+	if best != nil {
+		return best, nil
+	}
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = LargestFirst{}
+	}
+	return fallback.Select(coins, target)
+}
+
+// Random 随机选择out，不暴露钱包中out的金额分布
+// Rand为nil时使用math/rand的全局随机数
+type Random struct {
+	Rand *rand.Rand
+}
+
+// Select 实现CoinSelector
+func (s Random) Select(coins []Coin, target SelectionTarget) ([]int, error) {
+	order := make([]int, len(coins))
+	for i := range order {
+		order[i] = i
+	}
+	shuffle := rand.Shuffle
+	if s.Rand != nil {
+		shuffle = s.Rand.Shuffle
+	}
+	shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	return accumulate(coins, order, target)
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testCoins(values ...Amount) []Coin {
+	coins := make([]Coin, len(values))
+	for i, v := range values {
+		coins[i] = Coin{Txid: []byte{byte(i)}, Vout: 0, Out: *NewTXOutput(v, addrA)}
+	}
+	return coins
+}
+
+func TestCoinSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		coins    []Coin
+		target   SelectionTarget
+		want     []int
+		err      error
+	}{
+		{"largest first", LargestFirst{}, testCoins(1, 5, 3), SelectionTarget{Amount: 6}, []int{1, 2}, nil},
+		{"largest first single", LargestFirst{}, testCoins(1, 5, 3), SelectionTarget{Amount: 5}, []int{1}, nil},
+		{"smallest first", SmallestFirst{}, testCoins(1, 5, 3), SelectionTarget{Amount: 4}, []int{0, 2}, nil},
+		{"insufficient", LargestFirst{}, testCoins(1, 2), SelectionTarget{Amount: 4}, nil, ErrInsufficientFunds},
+		{"no coins", SmallestFirst{}, nil, SelectionTarget{Amount: 1}, nil, ErrInsufficientFunds},
+		// 恰好凑出目标金额，不需要找零
+		{"bnb exact match", BranchAndBound{}, testCoins(5, 4, 3, 2), SelectionTarget{Amount: 7}, []int{0, 3}, nil},
+		{"bnb exact with three coins", BranchAndBound{}, testCoins(10, 6, 4, 1), SelectionTarget{Amount: 11}, []int{0, 3}, nil},
+		// 多出的金额不超过ChangeFee时也不需要找零，选多出最少的组合
+		{"bnb within change fee", BranchAndBound{}, testCoins(9, 6, 5), SelectionTarget{Amount: 10, ChangeFee: 2}, []int{1, 2}, nil},
+		// 没有不找零的组合时使用Fallback
+		{"bnb fallback largest", BranchAndBound{}, testCoins(8, 5), SelectionTarget{Amount: 6}, []int{0}, nil},
+		{"bnb fallback smallest", BranchAndBound{Fallback: SmallestFirst{}}, testCoins(8, 5, 2), SelectionTarget{Amount: 6}, []int{2, 1}, nil},
+		{"bnb insufficient", BranchAndBound{}, testCoins(1, 2), SelectionTarget{Amount: 4}, nil, ErrInsufficientFunds},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.selector.Select(tt.coins, tt.target)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Select = %v，期望 %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("选中 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSelectorSkipsUneconomicalCoins 有效金额不为正的coin花费它的手续费比它的金额还高，不会被选中
+func TestSelectorSkipsUneconomicalCoins(t *testing.T) {
+	target := SelectionTarget{Amount: 100, FeeRate: 1000}
+	coins := testCoins(1, 500, 2)
+	if target.EffectiveValue(coins[0]) > 0 {
+		t.Fatalf("金额为1的coin的有效金额 %d", target.EffectiveValue(coins[0]))
+	}
+	for _, s := range []CoinSelector{LargestFirst{}, SmallestFirst{}, BranchAndBound{}, Random{Rand: rand.New(rand.NewSource(1))}} {
+		got, err := s.Select(coins, target)
+		if err != nil {
+			t.Fatalf("%T: %v", s, err)
+		}
+		if !reflect.DeepEqual(got, []int{1}) {
+			t.Fatalf("%T 选中 %v，期望 [1]", s, got)
+		}
+	}
+}
+
+func TestRandomSelector(t *testing.T) {
+	coins := testCoins(1, 2, 3, 4, 5, 6, 7, 8)
+	target := SelectionTarget{Amount: 12}
+	for seed := int64(0); seed < 20; seed++ {
+		got, err := Random{Rand: rand.New(rand.NewSource(seed))}.Select(coins, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum Amount
+		seen := make(map[int]bool)
+		for _, i := range got {
+			if seen[i] {
+				t.Fatalf("coin %d 被选中了两次", i)
+			}
+			seen[i] = true
+			sum += coins[i].Out.Value
+		}
+		if sum < target.Amount {
+			t.Fatalf("选中的金额 %d 少于 %d", sum, target.Amount)
+		}
+	}
+}
+
+// TestFundTransactionWithSelector 使用BranchAndBound时恰好凑出金额的交易没有找零output
+func TestFundTransactionWithSelector(t *testing.T) {
+	coins := testCoins(9, 5, 3)
+	tx := &Transaction{Vout: []TXOutput{*NewTXOutput(8, addrB)}}
+	selected, err := fundTransaction(tx, coins, addrC, TxBuildOptions{Selector: BranchAndBound{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(selected, []int{1, 2}) || len(tx.Vout) != 1 {
+		t.Fatalf("选中 %v，%d 个output，期望 [1 2] 且没有找零", selected, len(tx.Vout))
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 跨区授权
+// 转账区中没有引用out的交易不花费转账区的钱，它的金额只能来自轻计算区
+// 从轻计算区转入钱的ToTran交易必须由协调者签名授权，否则任何人都可以凭空造钱
+// 协调者是发起轻计算区->转账区跨区转账的轻计算区节点，签名放在交易唯一的input的Signature中，覆盖除这个签名以外的整个交易
+// 协调者的地址是链上状态：创世区块中的协调者交易(Type 3)设置第一个协调者，之后由当前协调者签名的协调者交易更换
+// 区块中的交易按该区块之前生效的协调者验证，更换从下一个区块开始生效，所有节点对同一个区块得到相同的结果
+// 区块的第一个交易可以是出块奖励交易(Type 4)，金额不能超过区块内的手续费，见fee.go
+// 占位交易(Type 5)与ToTran交易一样需要协调者授权，结算交易(Type 6)由协调者签名，见placeholder.go
+// 其他类型的交易必须有input引用的out，引用了out的交易也不能带有IsToTran的input
+// 创世区块没有设置协调者时不能从轻计算区转入钱
+
+// toTranTxType 轻计算区 -> 转账区的ToTran交易的Type
+const toTranTxType = 2
+
+// coordinatorTxType 更换协调者的协调者交易的Type
+const coordinatorTxType = 3
+
+// coordinatorBucket 协调者的变更记录，区块高度 -> 该区块设置的协调者地址，只记录主链上包含协调者交易的区块
+const coordinatorBucket = "coordinator"
+
+var (
+	ErrUnauthorizedMint     = errors.New("没有引用out的交易缺少协调者的授权签名，或者这种交易不能凭空产生金额")
+	ErrNoCoordinator        = errors.New("链上没有设置协调者")
+	ErrInvalidCoordinatorTX = errors.New("协调者交易只能有一个不引用out的input，并且不能有output")
+	ErrMintReplay           = errors.New("协调者授权的交易已经在主链上")
+)
+
+// IsCoordinatorTX 判断是否是更换协调者的协调者交易
+func (tx Transaction) IsCoordinatorTX() bool {
+	return tx.Type == coordinatorTxType
+}
+
+// NewCoordinatorTX 构造把协调者更换为Coordinator的协调者交易，Coordinator为零地址时之后不再接受从轻计算区转入钱
+// 与Coinbase交易一样只有一个不引用out的input，input的Address是新的协调者，没有output
+// 除了创世区块中的协调者交易，构造的交易需要当前协调者调用Authorize签名后才能上链
+// 与ToTran交易一样同一个交易只能上链一次，重新设置以前的协调者时可以在Account中写上说明，使交易ID不同
+func NewCoordinatorTX(Coordinator common.Address) *Transaction {
+	TX := Transaction{
+		Vin: []TXInput{{
+			Txid:    nil,
+			Vout:    -1,
+			Address: Coordinator,
+		}},
+		Type: coordinatorTxType, // 3表示协调者交易
+	}
+	TX.ID = TX.Hash()
+	return &TX
+}
+
+// authorizationHash 协调者授权签名的哈希，即第一个input的Signature置空后的交易哈希
+func (tx *Transaction) authorizationHash() []byte {
+	txCopy := *tx
+	txCopy.Vin = append([]TXInput{}, tx.Vin...)
+	txCopy.Vin[0].Signature = nil
+	return txCopy.Hash()
+}
+
+// Authorize 协调者用私钥签名授权没有引用out的交易，签名后交易ID会改变
+func (tx *Transaction) Authorize(key *ecdsa.PrivateKey) error {
+	if !tx.IsCoinbase() {
+		return fmt.Errorf("! 交易 %x 引用了out: %w", tx.ID, ErrUnauthorizedMint)
+	}
+	signature, err := crypto.Sign(tx.authorizationHash(), key)
+	if err != nil {
+		return err
+	}
+	return tx.SetAuthorization(signature)
+}
+
+// SetAuthorization 放入协调者已经给出的授权签名，例如两阶段提交中协调者随commit发来的签名
+// 签名是否有效在验证交易时检查，也可以先用VerifyAuthorization检查
+func (tx *Transaction) SetAuthorization(signature []byte) error {
+	if !tx.IsCoinbase() {
+		return fmt.Errorf("! 交易 %x 引用了out: %w", tx.ID, ErrUnauthorizedMint)
+	}
+	tx.Vin[0].Signature = signature
+	tx.ID = tx.Hash()
+	return nil
+}
+
+// coordinatorAt 高度为height的区块之前生效的协调者，没有设置或者已经停止转入时返回false
+func coordinatorAt(dbTx *bolt.Tx, height uint64) (common.Address, bool) {
+	c := dbTx.Bucket([]byte(coordinatorBucket)).Cursor()
+	// 找到高度小于height的最后一次变更
+	k, v := c.Seek(heightKey(int(height)))
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil {
+		return common.Address{}, false
+	}
+	coordinator := common.BytesToAddress(v)
+	return coordinator, coordinator != (common.Address{})
+}
+
+// verifyCoordinatorSignature 签名者是高度为height的区块之前生效的协调者
+func verifyCoordinatorSignature(dbTx *bolt.Tx, height uint64, hash []byte, signature []byte) error {
+	coordinator, ok := coordinatorAt(dbTx, height)
+	if !ok {
+		return ErrNoCoordinator
+	}
+	// s必须在曲线阶的低半部分，否则第三方可以把授权签名变形为另一个合法签名，得到交易ID不同的同一笔转入
+	if len(signature) != crypto.SignatureLength ||
+		!crypto.ValidateSignatureValues(signature[crypto.RecoveryIDOffset], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:64]), true) {
+		return fmt.Errorf("! 协调者签名格式错误: %w", ErrUnauthorizedMint)
+	}
+	pub, err := crypto.SigToPub(hash, signature)
+	if err != nil || crypto.PubkeyToAddress(*pub) != coordinator {
+		return fmt.Errorf("! 签名者不是协调者 %x: %w", coordinator, ErrUnauthorizedMint)
+	}
+	return nil
+}
+
+// checkMint 验证没有引用out的交易可以产生新的金额，或者可以更换协调者
+func checkMint(view *utxoView, tx *Transaction) error {
+	// 授权过的交易只能上链一次，否则在之后的区块中重复包含同一个交易可以再次转入钱
+	if view.dbTx.Bucket([]byte(txIndexBucket)).Get(tx.ID) != nil {
+		return ErrMintReplay
+	}
+
+	switch tx.Type {
+	case toTranTxType, placeholderTxType:
+		return verifyCoordinatorSignature(view.dbTx, view.lock.Height, tx.authorizationHash(), tx.Vin[0].Signature)
+	case coordinatorTxType:
+		if len(tx.Vout) != 0 || tx.Vin[0].IsToTran {
+			return ErrInvalidCoordinatorTX
+		}
+		// 创世区块设置第一个协调者，不需要签名
+		if view.lock.Height == 0 {
+			return nil
+		}
+		return verifyCoordinatorSignature(view.dbTx, view.lock.Height, tx.authorizationHash(), tx.Vin[0].Signature)
+	case rewardTxType:
+		// 奖励交易只能领取区块内的手续费，由validateBlock检查它的位置与金额
+		if !view.inBlock {
+			return ErrInvalidReward
+		}
+		return nil
+	default:
+		return fmt.Errorf("! 类型为 %d 的交易: %w", tx.Type, ErrUnauthorizedMint)
+	}
+}
+
+// connectCoordinator 记录区块中的协调者交易设置的协调者，需要在写入区块的同一个事务中调用
+func connectCoordinator(dbTx *bolt.Tx, block *Block, height int) error {
+	b := dbTx.Bucket([]byte(coordinatorBucket))
+	for _, tx := range block.Body.Transactions {
+		if !tx.IsCoordinatorTX() {
+			continue
+		}
+		err := b.Put(heightKey(height), tx.Vin[0].Address.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Coordinator 下一个区块生效的协调者，从轻计算区转入钱的交易需要由它签名授权
+func (bc *BlockChain) Coordinator() (common.Address, error) {
+	var coordinator common.Address
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		height := getTipHeight(dbTx.Bucket([]byte(blocksBucket))) + 1
+		var ok bool
+		coordinator, ok = coordinatorAt(dbTx, uint64(height))
+		if !ok {
+			return ErrNoCoordinator
+		}
+		return nil
+	})
+	return coordinator, err
+}
+
+// VerifyAuthorization 检查没有引用out的交易带有下一个区块生效的协调者的授权签名，不检查交易的其他部分
+func (bc *BlockChain) VerifyAuthorization(tx *Transaction) error {
+	if !tx.IsCoinbase() {
+		return fmt.Errorf("! 交易 %x 引用了out: %w", tx.ID, ErrUnauthorizedMint)
+	}
+	return bc.db.View(func(dbTx *bolt.Tx) error {
+		height := getTipHeight(dbTx.Bucket([]byte(blocksBucket))) + 1
+		return verifyCoordinatorSignature(dbTx, uint64(height), tx.authorizationHash(), tx.Vin[0].Signature)
+	})
+}
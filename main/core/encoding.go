@@ -0,0 +1,550 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 区块与交易的规范二进制编码，用于计算哈希值、保存到数据库以及gRPC传输
+// 编码是确定的：同样的内容总是得到同样的字节，其他语言按下面的格式即可重新计算出相同的区块哈希与交易ID
+//
+// 基本类型:
+//
+//	byte     1字节
+//	uvarint  无符号LEB128变长整数(encoding/binary.PutUvarint)
+//	varint   ZigZag编码后的uvarint(encoding/binary.PutVarint)，用于Go中的int、int32、int64
+//	bool     1字节，0或1
+//	bytes    uvarint长度 || 内容
+//	string   按bytes编码的UTF-8
+//	address  20字节，不带长度
+//
+// Header (版本1，版本2，版本3):
+//
+//	byte 编码版本 | varint Version | varint TimeStamp | uvarint Height | bytes PrevBlock | bytes MerkelRoot
+//	区块状态不参与编码，区块哈希 = sha256(Header编码)
+//	版本2追加: byte HashMode | bytes ChameleonKey | bytes Randomness，即变色龙哈希模式，HashMode为HashSHA256时使用版本1
+//	变色龙模式的区块哈希计算方式见chameleon.go
+//	版本3追加: uvarint Bits | uvarint Nonce，即工作量证明，两者都为0时不使用版本3
+//
+// TXInput (版本1，版本2):
+//
+//	byte 编码版本 | bytes Txid | varint Vout | bytes Signature | address Address | bool IsToTran
+//	Signature是65字节的可恢复secp256k1签名 r || s || v
+//	版本2追加: uvarint 签名个数 | 每个签名按bytes编码，即多签的Signatures，没有时使用版本1
+//	版本3追加: bytes Preimage，即领取HTLC时给出的原像，为空时不使用版本3
+//
+// TXOutput (版本1，版本2，版本3，版本4):
+//
+//	byte 编码版本 | varint Value | address Address | bool IsUse
+//	Value是Amount，不能为负数，也不能超过MaxAmount
+//	版本2追加: uvarint LockTime | uvarint Maturity，两者都为0并且没有多签锁定条件时使用版本1
+//	版本3追加: uvarint Required | uvarint 地址个数 | 每个address，即多签锁定条件Multisig，为nil时编码为0 | 0
+//	版本4追加: bytes Hash | address Recipient | address Refund | uvarint Timeout，即HTLC锁定条件，为nil时不使用版本4
+//
+// Transaction (版本1，版本2，版本3):
+//
+//	byte 编码版本 | varint Type | string Account | uvarint 输入个数 | 每个输入按bytes编码的TXInput
+//	| uvarint 输出个数 | 每个输出按bytes编码的TXOutput
+//	版本2追加: uvarint LockTime，为0时使用版本1
+//	版本3追加: uvarint Height | varint Index | bytes Txid | bool Fill，即结算交易的Slot，为nil时不使用版本3
+//	交易ID不参与编码，交易ID = sha256(Transaction编码)，解码时重新计算
+//
+// Block:
+//
+//	bytes Header编码 | uvarint 交易个数 | 每个交易按bytes编码的Transaction
+//
+// 以后增加字段时把对应的编码版本加一，新字段追加在末尾，解码时只有版本不低于该字段引入的版本才读取它
+// 编码时使用能表示全部字段的最低版本：新字段为零值时编码与旧版本完全相同，已有的交易ID与区块哈希值不会改变
+const (
+	headerEncodingVersion   byte = 3
+	txEncodingVersion       byte = 3
+	txInputEncodingVersion  byte = 3
+	txOutputEncodingVersion byte = 4
+)
+
+// ErrEncodingVersion 编码版本比当前程序支持的更新
+var ErrEncodingVersion = errors.New("不支持的编码版本")
+
+// ErrMalformedEncoding 编码数据不完整或含有多余的字节
+var ErrMalformedEncoding = errors.New("编码数据格式错误")
+
+// encoder 按规范编码写入基本类型
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) byte(v byte) {
+	e.buf.WriteByte(v)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf.Write(tmp[:n])
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uvarint(uint64(len(v)))
+	e.buf.Write(v)
+}
+
+func (e *encoder) string(v string) {
+	e.bytes([]byte(v))
+}
+
+func (e *encoder) address(v common.Address) {
+	e.buf.Write(v[:])
+}
+
+// decoder 按规范编码读取基本类型，出错后的读取都返回零值，最后通过finish检查错误
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrMalformedEncoding
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || d.pos >= len(d.data) {
+		d.fail()
+		return 0
+	}
+	v := d.data[d.pos]
+	d.pos++
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) bool() bool {
+	switch d.byte() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.fail()
+		return false
+	}
+}
+
+// bytes 读取带长度的字节，空数据返回nil
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	v := append([]byte{}, d.data[d.pos:d.pos+int(n)]...)
+	d.pos += int(n)
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) address() common.Address {
+	var a common.Address
+	if d.err != nil || len(d.data)-d.pos < len(a) {
+		d.fail()
+		return a
+	}
+	copy(a[:], d.data[d.pos:])
+	d.pos += len(a)
+	return a
+}
+
+// count 读取元素个数，每个元素至少占一个字节，个数不可能超过剩余的字节数
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// version 读取编码版本，比supported更新的版本无法解码
+func (d *decoder) version(supported byte, name string) byte {
+	v := d.byte()
+	if d.err == nil && (v == 0 || v > supported) {
+		d.err = fmt.Errorf("! %s 的编码版本 %d: %w", name, v, ErrEncodingVersion)
+	}
+	return v
+}
+
+// finish 检查解码是否成功并且用完了所有数据
+func (d *decoder) finish() error {
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail()
+	}
+	return d.err
+}
+
+// canonical 检查数据是否就是解码结果的规范编码
+// 变长整数可以带多余的0字节，不检查的话同一个交易可以有多种编码，也就有多个交易ID
+func canonical(data []byte, encoded []byte) error {
+	if !bytes.Equal(data, encoded) {
+		return ErrMalformedEncoding
+	}
+	return nil
+}
+
+// EncodeHeader 按规范编码区块头
+func EncodeHeader(h *Header) []byte {
+	var e encoder
+	version := byte(1)
+	if h.HashMode != HashSHA256 {
+		version = 2
+	}
+	if h.Bits != 0 || h.Nonce != 0 {
+		version = 3
+	}
+	e.byte(version)
+	e.varint(int64(h.Version))
+	e.varint(h.TimeStamp)
+	e.uvarint(h.Height)
+	e.bytes(h.PrevBlock)
+	e.bytes(h.MerkelRoot)
+	if version >= 2 {
+		e.byte(byte(h.HashMode))
+		e.bytes(h.ChameleonKey)
+		e.bytes(h.Randomness)
+	}
+	if version >= 3 {
+		e.uvarint(uint64(h.Bits))
+		e.uvarint(h.Nonce)
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeHeader 解码区块头，区块状态为commit，需要从数据库中另外读取
+func DecodeHeader(data []byte) (*Header, error) {
+	d := decoder{data: data}
+	version := d.version(headerEncodingVersion, "区块头")
+	h := &Header{
+		Version:   int32(d.varint()),
+		TimeStamp: d.varint(),
+		Height:    d.uvarint(),
+		PrevBlock: d.bytes(),
+	}
+	h.MerkelRoot = d.bytes()
+	if version >= 2 {
+		h.HashMode = HashMode(d.byte())
+		h.ChameleonKey = d.bytes()
+		h.Randomness = d.bytes()
+		if d.err == nil && h.HashMode > HashChameleon {
+			d.err = fmt.Errorf("! 区块头的哈希模式 %d: %w", h.HashMode, ErrEncodingVersion)
+		}
+	}
+	if version >= 3 {
+		bits := d.uvarint()
+		if d.err == nil && bits > uint64(^uint32(0)) {
+			d.fail()
+		}
+		h.Bits = uint32(bits)
+		h.Nonce = d.uvarint()
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if err := canonical(data, EncodeHeader(h)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// EncodeTXInput 按规范编码交易输入
+func EncodeTXInput(in *TXInput) []byte {
+	var e encoder
+	version := byte(1)
+	if len(in.Signatures) != 0 {
+		version = 2
+	}
+	if len(in.Preimage) != 0 {
+		version = 3
+	}
+	e.byte(version)
+	e.bytes(in.Txid)
+	e.varint(int64(in.Vout))
+	e.bytes(in.Signature)
+	e.address(in.Address)
+	e.bool(in.IsToTran)
+	if version >= 2 {
+		e.uvarint(uint64(len(in.Signatures)))
+		for _, sig := range in.Signatures {
+			e.bytes(sig)
+		}
+	}
+	if version >= 3 {
+		e.bytes(in.Preimage)
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeTXInput 解码交易输入
+func DecodeTXInput(data []byte) (*TXInput, error) {
+	d := decoder{data: data}
+	version := d.version(txInputEncodingVersion, "交易输入")
+	in := &TXInput{}
+	in.Txid = d.bytes()
+	in.Vout = int(d.varint())
+	in.Signature = d.bytes()
+	in.Address = d.address()
+	in.IsToTran = d.bool()
+	if version >= 2 {
+		for n := d.count(); n > 0 && d.err == nil; n-- {
+			in.Signatures = append(in.Signatures, d.bytes())
+		}
+	}
+	if version >= 3 {
+		in.Preimage = d.bytes()
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// EncodeTXOutput 按规范编码交易输出
+func EncodeTXOutput(out *TXOutput) []byte {
+	var e encoder
+	version := byte(1)
+	if out.LockTime != 0 || out.Maturity != 0 {
+		version = 2
+	}
+	if out.Multisig != nil {
+		version = 3
+	}
+	if out.HTLC != nil {
+		version = 4
+	}
+	e.byte(version)
+	e.varint(int64(out.Value))
+	e.address(out.Address)
+	e.bool(out.IsUse)
+	if version >= 2 {
+		e.uvarint(out.LockTime)
+		e.uvarint(out.Maturity)
+	}
+	if version >= 3 {
+		if out.Multisig != nil {
+			out.Multisig.encode(&e)
+		} else {
+			e.uvarint(0)
+			e.uvarint(0)
+		}
+	}
+	if version >= 4 {
+		out.HTLC.encode(&e)
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeTXOutput 解码交易输出
+func DecodeTXOutput(data []byte) (*TXOutput, error) {
+	d := decoder{data: data}
+	version := d.version(txOutputEncodingVersion, "交易输出")
+	out := &TXOutput{}
+	value := d.varint()
+	if value < 0 {
+		d.fail()
+	}
+	out.Value = Amount(value)
+	out.Address = d.address()
+	out.IsUse = d.bool()
+	if version >= 2 {
+		out.LockTime = d.uvarint()
+		out.Maturity = d.uvarint()
+	}
+	if version >= 3 {
+		lock := &MultisigLock{Required: int(d.uvarint())}
+		n := d.uvarint()
+		if n > maxMultisigAddresses {
+			d.fail()
+		}
+		for ; n > 0 && d.err == nil; n-- {
+			lock.Addresses = append(lock.Addresses, d.address())
+		}
+		if lock.Required != 0 || len(lock.Addresses) != 0 {
+			out.Multisig = lock
+		}
+	}
+	if version >= 4 {
+		out.HTLC = &HTLCLock{Hash: d.bytes(), Recipient: d.address(), Refund: d.address(), Timeout: d.uvarint()}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if err := canonical(data, EncodeTXOutput(out)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EncodeTransaction 按规范编码交易，不包含交易ID
+func EncodeTransaction(tx *Transaction) []byte {
+	var e encoder
+	version := byte(1)
+	if tx.LockTime != 0 {
+		version = 2
+	}
+	if tx.Slot != nil {
+		version = 3
+	}
+	e.byte(version)
+	e.varint(int64(tx.Type))
+	e.string(tx.Account)
+	e.uvarint(uint64(len(tx.Vin)))
+	for i := range tx.Vin {
+		e.bytes(EncodeTXInput(&tx.Vin[i]))
+	}
+	e.uvarint(uint64(len(tx.Vout)))
+	for i := range tx.Vout {
+		e.bytes(EncodeTXOutput(&tx.Vout[i]))
+	}
+	if version >= 2 {
+		e.uvarint(tx.LockTime)
+	}
+	if version >= 3 {
+		e.uvarint(tx.Slot.Height)
+		e.varint(int64(tx.Slot.Index))
+		e.bytes(tx.Slot.Txid)
+		e.bool(tx.Slot.Fill)
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeTransaction 解码交易，并根据编码重新计算交易ID
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	d := decoder{data: data}
+	version := d.version(txEncodingVersion, "交易")
+	tx := &Transaction{}
+	tx.Type = int(d.varint())
+	tx.Account = d.string()
+
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		in, err := DecodeTXInput(d.bytes())
+		if err != nil {
+			d.err = err
+			break
+		}
+		tx.Vin = append(tx.Vin, *in)
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		out, err := DecodeTXOutput(d.bytes())
+		if err != nil {
+			d.err = err
+			break
+		}
+		tx.Vout = append(tx.Vout, *out)
+	}
+	if version >= 2 {
+		tx.LockTime = d.uvarint()
+	}
+	if version >= 3 {
+		tx.Slot = &SlotRef{Height: d.uvarint(), Index: int(d.varint()), Txid: d.bytes(), Fill: d.bool()}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if err := canonical(data, EncodeTransaction(tx)); err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(data)
+	tx.ID = h[:]
+	return tx, nil
+}
+
+// EncodeBlock 按规范编码区块
+func EncodeBlock(b *Block) []byte {
+	var e encoder
+	e.bytes(EncodeHeader(b.Header))
+	e.uvarint(uint64(len(b.Body.Transactions)))
+	for _, tx := range b.Body.Transactions {
+		e.bytes(EncodeTransaction(tx))
+	}
+	return e.buf.Bytes()
+}
+
+// DecodeBlock 解码区块
+func DecodeBlock(data []byte) (*Block, error) {
+	d := decoder{data: data}
+	header, err := DecodeHeader(d.bytes())
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block := &Block{Header: header, Body: &Body{Transactions: []*Transaction{}}}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		tx, err := DecodeTransaction(d.bytes())
+		if err != nil {
+			d.err = err
+			break
+		}
+		block.Body.Transactions = append(block.Body.Transactions, tx)
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,208 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  *Header
+		version byte
+	}{
+		{"sha256", &Header{Version: 1, TimeStamp: 1630041600, Height: 7, PrevBlock: []byte{1, 2}, MerkelRoot: []byte{3, 4}}, 1},
+		{"genesis", &Header{Version: 1, TimeStamp: genesisTimeStamp}, 1},
+		{"chameleon", &Header{Version: 1, Height: 7, HashMode: HashChameleon, ChameleonKey: []byte{5}, Randomness: []byte{6, 7}}, 2},
+		{"proof of work", &Header{Version: 1, Height: 7, PrevBlock: []byte{1, 2}, Bits: powLimitBits, Nonce: 1 << 40}, 3},
+		{"nonce only", &Header{Version: 1, Height: 7, Nonce: 1}, 3},
+		{"chameleon proof of work", &Header{Version: 1, Height: 7, HashMode: HashChameleon, ChameleonKey: []byte{5}, Randomness: []byte{6, 7}, Bits: powLimitBits}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := EncodeHeader(tt.header)
+			if data[0] != tt.version {
+				t.Fatalf("编码版本 %d，期望 %d", data[0], tt.version)
+			}
+			got, err := DecodeHeader(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.header) {
+				t.Fatalf("解码结果 %+v，期望 %+v", got, tt.header)
+			}
+		})
+	}
+}
+
+func TestTransactionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      *Transaction
+		version byte
+	}{
+		{"normal", &Transaction{
+			Vin:  []TXInput{{Txid: []byte{1}, Vout: 0, Signature: []byte{2}, Address: addrA}},
+			Vout: []TXOutput{{Value: 10, Address: addrB}, {Value: 3, Address: addrA, IsUse: true}},
+		}, 1},
+		{"account", &Transaction{
+			Vin:     []TXInput{{Txid: []byte{1}, Vout: 1, Address: addrA}},
+			Vout:    []TXOutput{{Value: 10, Address: addrB}},
+			Account: "aaa",
+		}, 1},
+		{"coinbase", &Transaction{
+			Vin:  []TXInput{{Vout: -1, Signature: []byte{3}, Address: addrA, IsToTran: true}},
+			Vout: []TXOutput{{Value: 1, Address: addrB}},
+			Type: toTranTxType,
+		}, 1},
+		{"coordinator", &Transaction{
+			Vin:  []TXInput{{Vout: -1, Address: addrC}},
+			Type: coordinatorTxType,
+		}, 1},
+		{"placeholder", &Transaction{
+			Vin:  []TXInput{{Vout: -1, Signature: []byte{3}, Address: addrA, IsToTran: true, Preimage: []byte("transfer-1")}},
+			Vout: []TXOutput{{Value: 1, Address: addrB}},
+			Type: placeholderTxType,
+		}, 1},
+		{"settlement", &Transaction{
+			Vin:  []TXInput{{Vout: -1, Signature: []byte{3}, Address: addrC}},
+			Type: settlementTxType,
+			Slot: &SlotRef{Height: 3, Index: 1, Txid: []byte{4}, Fill: true},
+		}, 3},
+		{"lock time", &Transaction{
+			Vin:      []TXInput{{Txid: []byte{1}, Vout: 0, Address: addrA}},
+			Vout:     []TXOutput{{Value: 10, Address: addrB}},
+			LockTime: 100,
+		}, 2},
+		// 只有output带有时间锁时交易本身仍然使用版本1，output使用版本2
+		{"output timelock", &Transaction{
+			Vin:  []TXInput{{Txid: []byte{1}, Vout: 0, Address: addrA}},
+			Vout: []TXOutput{{Value: 10, Address: addrB, LockTime: LockTimeThreshold + 1, Maturity: 6}},
+		}, 1},
+		{"multisig output", &Transaction{
+			Vin:  []TXInput{{Txid: []byte{1}, Vout: 0, Address: addrA, Signatures: [][]byte{{1}, {2}}}},
+			Vout: []TXOutput{{Value: 10, Address: addrB, Multisig: &MultisigLock{Required: 2, Addresses: []common.Address{addrA, addrB, addrC}}}},
+		}, 1},
+		{"htlc output", &Transaction{
+			Vin:  []TXInput{{Txid: []byte{1}, Vout: 0, Address: addrA, Signature: []byte{1}, Preimage: []byte{2, 3}}},
+			Vout: []TXOutput{{Value: 10, Address: addrB, HTLC: &HTLCLock{Hash: make([]byte, 32), Recipient: addrB, Refund: addrA, Timeout: 9}}},
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tx.ID = tt.tx.Hash()
+			data := EncodeTransaction(tt.tx)
+			if data[0] != tt.version {
+				t.Fatalf("编码版本 %d，期望 %d", data[0], tt.version)
+			}
+			got, err := DecodeTransaction(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.tx) {
+				t.Fatalf("解码结果 %+v，期望 %+v", got, tt.tx)
+			}
+		})
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	tx := &Transaction{Vin: []TXInput{{Txid: []byte{1}}}, Vout: []TXOutput{{Value: 1}}}
+	tx.ID = tx.Hash()
+	block := NewBlockWithTransactions([]byte{1}, 1, []*Transaction{tx})
+
+	data := EncodeBlock(block)
+	got, err := DecodeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, block) {
+		t.Fatalf("解码结果 %+v，期望 %+v", got, block)
+	}
+	want, _ := block.Hash()
+	hash, _ := got.Hash()
+	if !bytes.Equal(hash, want) {
+		t.Fatalf("解码后的区块哈希 %x，期望 %x", hash, want)
+	}
+}
+
+// TestDecodeRejectsMalleated 同一个交易或区块头只能有一种编码，否则同一笔交易可以有多个交易ID
+func TestDecodeRejectsMalleated(t *testing.T) {
+	tx := &Transaction{Vin: []TXInput{{Txid: []byte{1}}}, Vout: []TXOutput{{Value: 1}}}
+	txData := EncodeTransaction(tx)
+	headerData := EncodeHeader(&Header{Version: 1, Height: 1})
+	powData := EncodeHeader(&Header{Version: 1, Height: 1, Bits: powLimitBits})
+
+	modify := func(data []byte, f func([]byte) []byte) []byte {
+		return f(append([]byte{}, data...))
+	}
+	tests := []struct {
+		name   string
+		decode func([]byte) error
+		data   []byte
+		err    error
+	}{
+		{"trailing byte", decodeTx, append(append([]byte{}, txData...), 0), ErrMalformedEncoding},
+		{"truncated", decodeTx, txData[:len(txData)-1], ErrMalformedEncoding},
+		{"empty", decodeTx, nil, ErrMalformedEncoding},
+		{"version 0", decodeTx, modify(txData, func(d []byte) []byte { d[0] = 0; return d }), ErrEncodingVersion},
+		{"future version", decodeTx, modify(txData, func(d []byte) []byte { d[0] = txEncodingVersion + 1; return d }), ErrEncodingVersion},
+		// Type按varint编码，0x80 0x00同样解码为0
+		{"padded varint", decodeTx, modify(txData, func(d []byte) []byte {
+			return append([]byte{d[0], 0x80, 0x00}, d[2:]...)
+		}), ErrMalformedEncoding},
+		// 锁定时间为0的交易只能使用版本1编码
+		{"version 2 without lock time", decodeTx, append(modify(txData, func(d []byte) []byte { d[0] = 2; return d }), 0), ErrMalformedEncoding},
+		{"header trailing byte", decodeHeader, append(append([]byte{}, headerData...), 0), ErrMalformedEncoding},
+		{"header future version", decodeHeader, modify(headerData, func(d []byte) []byte { d[0] = headerEncodingVersion + 1; return d }), ErrEncodingVersion},
+		// Bits与Nonce都为0的区块头只能使用版本1编码
+		{"header version 3 without pow", decodeHeader, append(modify(headerData, func(d []byte) []byte { d[0] = 3; return d }), 0, 0, 0, 0, 0), ErrMalformedEncoding},
+		{"header truncated nonce", decodeHeader, powData[:len(powData)-1], ErrMalformedEncoding},
+		// Bits超过32位
+		{"header bits overflow", decodeHeader, append(append([]byte{}, powData[:len(powData)-6]...), 0xff, 0xff, 0xff, 0xff, 0x1f, 0), ErrMalformedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.decode(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("错误 %v，期望 %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDecodeTXOutputRejectsMalleated(t *testing.T) {
+	data := EncodeTXOutput(&TXOutput{Value: 5})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		// IsUse只能是0或1
+		{"bad bool", append(append([]byte{}, data[:len(data)-1]...), 2)},
+		{"missing bool", data[:len(data)-1]},
+		// 超过MaxAmount的金额按varint编码后是负数
+		{"negative value", EncodeTXOutput(&TXOutput{Value: MaxAmount + 1})},
+		{"version 2 without timelock", append(append([]byte{2}, data[1:]...), 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeTXOutput(tt.data); !errors.Is(err, ErrMalformedEncoding) {
+				t.Fatalf("错误 %v，期望 %v", err, ErrMalformedEncoding)
+			}
+		})
+	}
+}
+
+func decodeTx(data []byte) error {
+	_, err := DecodeTransaction(data)
+	return err
+}
+
+func decodeHeader(data []byte) error {
+	_, err := DecodeHeader(data)
+	return err
+}
@@ -0,0 +1,375 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 手续费
+// 交易的手续费是隐式的：所有input花费的out金额之和减去所有output金额之和
+// 手续费按交易规范编码的字节数计算，出块节点在区块的第一个交易(奖励交易)中领取区块内所有交易的手续费与出块补贴
+// 奖励交易的out要经过coinbaseMaturity个区块才能花费，区块被重组掉时奖励也随之消失，不会留下已经被花费的奖励
+
+// rewardTxType 出块奖励交易的Type，奖励交易是区块的第一个交易，领取区块内所有交易的手续费与出块补贴
+const rewardTxType = 4
+
+const (
+	initialSubsidy         Amount = 50     // 高度1的区块的出块补贴，没有手续费的区块也能奖励出块节点
+	subsidyHalvingInterval        = 210000 // 每经过这么多个区块出块补贴减半
+	coinbaseMaturity              = 20     // 奖励交易的out的相对锁定区块数
+)
+
+// ErrInsufficientFunds 可用的out不足以支付转账金额与手续费
+var ErrInsufficientFunds = errors.New("余额不足以支付转账金额与手续费")
+
+// DefaultFeeRate 默认的手续费率，每1000字节10
+const DefaultFeeRate Amount = 10
+
+// TxBuildOptions 构造交易时的选项
+type TxBuildOptions struct {
+	FeeRate  Amount       // 每1000字节的手续费，0表示不支付手续费
+	Selector CoinSelector // 选币策略，nil表示LargestFirst
+	Change   ChangePolicy // 找零地址策略，nil表示找零转回发送方地址
+
+	// 时间锁，0表示不锁定，见timelock.go
+	LockTime       uint64 // 交易的锁定时间
+	OutputLockTime uint64 // 转账output的绝对锁定，例如按时间发放的工资，找零output不锁定
+	OutputMaturity uint64 // 转账output的相对锁定区块数，找零output不锁定
+
+	changeLock *MultisigLock // 找零output的多签锁定条件，由NewMultisigSpend设置，找零转回多签地址
+}
+
+// changeOutput 金额为value、地址为address的找零output
+func (o TxBuildOptions) changeOutput(value Amount, address common.Address) *TXOutput {
+	if o.changeLock != nil {
+		return NewMultisigOutput(value, o.changeLock)
+	}
+	return NewTXOutput(value, address)
+}
+
+// Fee 按交易大小计算需要的手续费，不足1的部分向上取整
+// 手续费率过高导致溢出时返回MaxAmount，这样的交易不可能支付得起
+func (o TxBuildOptions) Fee(size int) Amount {
+	fee, err := o.FeeRate.Mul(uint64(size))
+	if err != nil {
+		return MaxAmount
+	}
+	if fee%1000 != 0 {
+		return fee/1000 + 1
+	}
+	return fee / 1000
+}
+
+// TxSize 交易规范编码的字节数，手续费按它计算
+func (tx *Transaction) TxSize() int {
+	return len(tx.Serialize())
+}
+
+// estimateSignedSize 估计交易签名后的大小，还没有签名的input按65字节签名计算
+func estimateSignedSize(tx *Transaction) int {
+	txCopy := *tx
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	copy(txCopy.Vin, tx.Vin)
+	for i, vin := range txCopy.Vin {
+		if spendsUTXO(&txCopy, vin) && len(vin.Signature) == 0 && len(vin.Signatures) == 0 {
+			txCopy.Vin[i].Signature = make([]byte, crypto.SignatureLength)
+		}
+	}
+	return txCopy.TxSize()
+}
+
+// placeholderInput 花费c的input，签名用签名后长度相同的0字节占位，用于估计交易大小
+// 花费多签out的input有Required个签名
+func placeholderInput(c Coin) TXInput {
+	in := TXInput{Txid: c.Txid, Vout: c.Vout, Address: c.Out.Address}
+	if c.Out.Multisig == nil {
+		in.Signature = make([]byte, crypto.SignatureLength)
+		return in
+	}
+	for i := 0; i < c.Out.Multisig.Required; i++ {
+		in.Signatures = append(in.Signatures, make([]byte, crypto.SignatureLength))
+	}
+	return in
+}
+
+// Coin 一个可以花费的out以及它的位置
+type Coin struct {
+	Txid []byte
+	Vout int
+	Out  TXOutput
+}
+
+// coinPrevTXs 用coins中已有的被花费的out组装签名需要的前置交易，签名不需要再从区块链查询
+// 客户端与节点同时运行时客户端不能打开区块链数据库，coin来自节点返回的余额查询结果
+// 组装的前置交易只有ID与被花费的out，其余位置的out为空
+func coinPrevTXs(coins []Coin) map[string]Transaction {
+	prevTXs := make(map[string]Transaction)
+	for _, coin := range coins {
+		key := hex.EncodeToString(coin.Txid)
+		prevTx := prevTXs[key]
+		prevTx.ID = coin.Txid
+		for len(prevTx.Vout) <= coin.Vout {
+			prevTx.Vout = append(prevTx.Vout, TXOutput{})
+		}
+		prevTx.Vout[coin.Vout] = coin.Out
+		prevTXs[key] = prevTx
+	}
+	return prevTXs
+}
+
+// fundTransaction 使用opts.Selector从coins中选择out加入tx的input，足够支付tx已有output的金额与手续费
+// 手续费按签名后的交易大小计算，剩余的钱作为找零转到opts.Change决定的地址，找零不够支付找零output自身的手续费时并入手续费
+// sender是发送方地址，地址的编码长度固定，估计大小时用它代替还没有确定的找零地址
+// 返回选中的coin在coins中的位置，顺序与加入的input相同，加入的input没有签名
+func fundTransaction(tx *Transaction, coins []Coin, sender common.Address, opts TxBuildOptions) ([]int, error) {
+	var amount Amount
+	for _, out := range tx.Vout {
+		err := CheckOutputAmount(out.Value)
+		if err != nil {
+			return nil, err
+		}
+		amount, err = amount.Add(out.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 选币目标：output金额加上不含input时的手续费，找零output的手续费按找零金额上限估计
+	target, err := amount.Add(opts.Fee(estimateSignedSize(tx)))
+	if err != nil {
+		return nil, err
+	}
+	var change encoder
+	change.bytes(EncodeTXOutput(opts.changeOutput(amount.saturatingAdd(sumCoins(coins)), sender)))
+
+	selector := opts.Selector
+	if selector == nil {
+		selector = LargestFirst{}
+	}
+	selected, err := selector.Select(coins, SelectionTarget{
+		Amount:    target,
+		ChangeFee: opts.Fee(change.buf.Len()),
+		FeeRate:   opts.FeeRate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 加入的input先带上占位签名，估计大小后再去掉
+	first := len(tx.Vin)
+	var accumulated Amount
+	for _, i := range selected {
+		c := coins[i]
+		tx.Vin = append(tx.Vin, placeholderInput(c))
+		accumulated, err = accumulated.Add(c.Out.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 按实际大小重新计算手续费，input很多时个数的变长编码会变长，选币时的估计可能偏少
+	fee := opts.Fee(estimateSignedSize(tx))
+	need, err := amount.Add(fee)
+	if err != nil {
+		return nil, err
+	}
+	if accumulated < need {
+		return nil, fmt.Errorf("! 选中的金额 %d，需要 %d 以及手续费 %d: %w", accumulated, amount, fee, ErrInsufficientFunds)
+	}
+
+	// 带找零时需要的手续费，找零金额按上限accumulated估计大小
+	withChange := *tx
+	withChange.Vout = append(append([]TXOutput{}, tx.Vout...), *opts.changeOutput(accumulated, sender))
+	rest := accumulated - amount // accumulated >= need >= amount
+	if feeWithChange := opts.Fee(estimateSignedSize(&withChange)); rest > feeWithChange {
+		policy := opts.Change
+		if policy == nil {
+			policy = SameAddressChange{}
+		}
+		changeAddress, err := policy.ChangeAddress(sender)
+		if err != nil {
+			return nil, fmt.Errorf("! 获取找零地址失败: %w", err)
+		}
+		tx.Vout = append(tx.Vout, *opts.changeOutput(rest-feeWithChange, changeAddress))
+	}
+	for i := first; i < len(tx.Vin); i++ {
+		tx.Vin[i].Signature = nil
+		tx.Vin[i].Signatures = nil
+	}
+	return selected, nil
+}
+
+// sumCoins coins的金额之和，超过MaxAmount时返回MaxAmount
+func sumCoins(coins []Coin) Amount {
+	var sum Amount
+	for _, c := range coins {
+		sum = sum.saturatingAdd(c.Out.Value)
+	}
+	return sum
+}
+
+// BlockSubsidy 高度为height的区块的出块补贴，从initialSubsidy开始每subsidyHalvingInterval个区块减半，减到0后不再有补贴
+// 创世区块没有奖励交易，补贴为0
+func BlockSubsidy(height uint64) Amount {
+	halvings := height / subsidyHalvingInterval
+	if height == 0 || halvings >= 64 {
+		return 0
+	}
+	return initialSubsidy >> halvings
+}
+
+// BlockReward 高度为height、手续费之和为fees的区块最多可以领取的奖励，即手续费加出块补贴
+func BlockReward(fees Amount, height uint64) (Amount, error) {
+	return fees.Add(BlockSubsidy(height))
+}
+
+// NewRewardTX 出块奖励交易，把reward转给出块节点，reward不能超过BlockReward
+// 奖励交易的input没有引用任何out，Signature字段保存8字节大端的区块高度，保证每个区块的奖励交易ID不同
+// 奖励的out按coinbaseMaturity相对锁定
+func NewRewardTX(to common.Address, reward Amount, height uint64) *Transaction {
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+
+	out := NewTXOutput(reward, to)
+	out.Maturity = coinbaseMaturity
+	TX := Transaction{
+		Vin:  []TXInput{{Txid: nil, Vout: -1, Signature: heightBytes}},
+		Vout: []TXOutput{*out},
+		Type: rewardTxType, // 4表示出块奖励交易
+	}
+	TX.ID = TX.Hash()
+	return &TX
+}
+
+// IsReward 判断交易是否是出块奖励交易
+func (tx Transaction) IsReward() bool {
+	return tx.Type == rewardTxType
+}
+
+// TransactionFee 根据当前的UTXO集合计算交易的手续费，同时验证交易
+// 奖励交易只能出现在区块中，不能单独验证通过，时间锁按接在最新区块之后的下一个区块检查
+func (bc *BlockChain) TransactionFee(tx *Transaction) (Amount, error) {
+	if tx.Type == rewardTxType {
+		return 0, ErrInvalidReward
+	}
+
+	var fee Amount
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		lock, err := nextLockContext(dbTx)
+		if err != nil {
+			return err
+		}
+		fee, err = validateTransaction(tx, newUTXOView(dbTx, lock))
+		return err
+	})
+	return fee, err
+}
+
+// TransactionFeeWithParents 与TransactionFee相同，但tx可以花费parents中还没有上链的交易的out
+// parents按依赖顺序排列，它们与tx按顺序在同一个view中验证，就像放在同一个区块中一样
+// 奖励交易只能出现在区块中，不能通过验证
+func (bc *BlockChain) TransactionFeeWithParents(tx *Transaction, parents []*Transaction) (Amount, error) {
+	if tx.Type == rewardTxType {
+		return 0, ErrInvalidReward
+	}
+	var fee Amount
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		lock, err := nextLockContext(dbTx)
+		if err != nil {
+			return err
+		}
+		view := newUTXOView(dbTx, lock)
+		for _, parent := range parents {
+			if _, err := validateTransaction(parent, view); err != nil {
+				return fmt.Errorf("! 依赖的交易 %x 验证失败: %w", parent.ID, err)
+			}
+		}
+		fee, err = validateTransaction(tx, view)
+		return err
+	})
+	return fee, err
+}
+
+// ValidateTransactions 在同一个view中按顺序验证txs，返回每个交易的手续费与验证错误，只打开一个读事务
+// txs按依赖顺序排列，后面的交易可以花费前面通过验证的交易的out；没有通过验证的交易不会应用到view，依赖它的交易也就不能通过
+// 返回的error只表示读取数据库失败
+func (bc *BlockChain) ValidateTransactions(txs []*Transaction) ([]Amount, []error, error) {
+	fees := make([]Amount, len(txs))
+	errs := make([]error, len(txs))
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		lock, err := nextLockContext(dbTx)
+		if err != nil {
+			return err
+		}
+		view := newUTXOView(dbTx, lock)
+		for i, tx := range txs {
+			if tx.Type == rewardTxType {
+				errs[i] = ErrInvalidReward
+				continue
+			}
+			fees[i], errs[i] = validateTransaction(tx, view)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return fees, errs, nil
+}
+
+// NewBlockTemplate 组装一个接在最新区块之后的新区块
+// 交易按顺序验证，同一个区块中后面的交易可以花费前面交易的out；第一个交易是把所有手续费与出块补贴转给producer的奖励交易
+// 金额为0的output是无效的，出块补贴减到0之后所有交易都没有手续费时区块中没有奖励交易
+// 链上设置了变色龙公钥时区块使用变色龙模式
+// 区块头的难度目标按难度调整规则设置，时间戳不早于前面区块时间戳的中位数，出块前还需要调用Mine寻找Nonce
+func (bc *BlockChain) NewBlockTemplate(producer common.Address, txs []*Transaction) (*Block, error) {
+	var block *Block
+
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(blocksBucket))
+		height := uint64(getTipHeight(b) + 1)
+
+		lock, err := nextLockContext(dbTx)
+		if err != nil {
+			return err
+		}
+		view := newUTXOView(dbTx, lock)
+		var fees Amount
+		for i, tx := range txs {
+			fee, err := validateTransaction(tx, view)
+			if err != nil {
+				return fmt.Errorf("! 第 %d 个交易验证失败: %w", i, err)
+			}
+			fees, err = fees.Add(fee)
+			if err != nil {
+				return err
+			}
+		}
+
+		reward, err := BlockReward(fees, height)
+		if err != nil {
+			return err
+		}
+		all := txs
+		if reward > 0 {
+			all = append([]*Transaction{NewRewardTX(producer, reward, height)}, txs...)
+		}
+		block = NewBlockWithTransactions(append([]byte{}, b.Get([]byte("l"))...), height, all)
+		err = prepareHeader(dbTx, block.Header)
+		if err != nil {
+			return err
+		}
+		return sealBlock(b, block)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
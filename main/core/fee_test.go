@@ -0,0 +1,173 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFee(t *testing.T) {
+	tests := []struct {
+		rate Amount
+		size int
+		want Amount
+	}{
+		{0, 500, 0},
+		{10, 0, 0},
+		{10, 100, 1},
+		{10, 101, 2},
+		{10, 1000, 10},
+		{1000, 250, 250},
+	}
+	for _, tt := range tests {
+		if got := (TxBuildOptions{FeeRate: tt.rate}).Fee(tt.size); got != tt.want {
+			t.Errorf("FeeRate %d 大小 %d 的手续费 %d，期望 %d", tt.rate, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestFundTransaction(t *testing.T) {
+	coin := func(value Amount) Coin {
+		return Coin{Txid: []byte{byte(value)}, Vout: 0, Out: *NewTXOutput(value, addrA)}
+	}
+	tests := []struct {
+		name     string
+		amount   Amount
+		coins    []Coin
+		opts     TxBuildOptions
+		inputs   int
+		change   bool
+		insuffic bool
+	}{
+		{"exact without fee", 5, []Coin{coin(5)}, TxBuildOptions{}, 1, false, false},
+		{"change without fee", 5, []Coin{coin(8)}, TxBuildOptions{}, 1, true, false},
+		{"second coin", 5, []Coin{coin(3), coin(4)}, TxBuildOptions{}, 2, true, false},
+		{"fee paid from change", 5, []Coin{coin(100)}, TxBuildOptions{FeeRate: 10}, 1, true, false},
+		// 剩余的钱不够支付找零output自身的手续费时并入手续费
+		{"dust change merged into fee", 5, []Coin{coin(7)}, TxBuildOptions{FeeRate: 10}, 1, false, false},
+		{"insufficient", 10, []Coin{coin(3), coin(4)}, TxBuildOptions{}, 0, false, true},
+		{"insufficient for fee", 5, []Coin{coin(5)}, TxBuildOptions{FeeRate: 10}, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := &Transaction{Vout: []TXOutput{*NewTXOutput(tt.amount, addrB)}}
+			selected, err := fundTransaction(tx, tt.coins, addrC, tt.opts)
+			if tt.insuffic {
+				if !errors.Is(err, ErrInsufficientFunds) {
+					t.Fatalf("fundTransaction = %v，期望 %v", err, ErrInsufficientFunds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(selected) != tt.inputs || len(tx.Vin) != tt.inputs {
+				t.Fatalf("选中 %d 个out，input %d 个，期望 %d", len(selected), len(tx.Vin), tt.inputs)
+			}
+			if hasChange := len(tx.Vout) == 2; hasChange != tt.change {
+				t.Fatalf("找零: %v，期望 %v", hasChange, tt.change)
+			}
+			if tt.change && tx.Vout[1].Address != addrC {
+				t.Fatalf("找零地址 %x，期望 %x", tx.Vout[1].Address, addrC)
+			}
+
+			// 实际支付的手续费不少于按签名后大小计算的手续费
+			var inputs, outputs Amount
+			for _, i := range selected {
+				inputs += tt.coins[i].Out.Value
+			}
+			for _, out := range tx.Vout {
+				outputs += out.Value
+			}
+			if fee := inputs - outputs; fee < tt.opts.Fee(estimateSignedSize(tx)) {
+				t.Fatalf("手续费 %d 少于需要的 %d", fee, tt.opts.Fee(estimateSignedSize(tx)))
+			}
+		})
+	}
+}
+
+func TestBlockReward(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 10, 20)
+	addTestBlock(t, bc, cb)
+	spend1 := testSpend(t, cb, 0, *NewTXOutput(7, addrB))  // 手续费3
+	spend2 := testSpend(t, cb, 1, *NewTXOutput(19, addrB)) // 手续费1
+
+	if fee, err := bc.TransactionFee(spend1); err != nil || fee != 3 {
+		t.Fatalf("TransactionFee = %d, %v，期望 3", fee, err)
+	}
+
+	tests := []struct {
+		name string
+		txs  func() []*Transaction
+		want error
+	}{
+		{"reward exceeds fees", func() []*Transaction {
+			return []*Transaction{NewRewardTX(addrC, BlockSubsidy(2)+5, 2), spend1, spend2}
+		}, ErrRewardExceedsFees},
+		{"reward not first", func() []*Transaction {
+			return []*Transaction{spend1, NewRewardTX(addrC, 3, 2)}
+		}, ErrInvalidReward},
+		{"wrong height", func() []*Transaction {
+			return []*Transaction{NewRewardTX(addrC, 3, 3), spend1}
+		}, ErrInvalidReward},
+		{"reward exceeds subsidy", func() []*Transaction {
+			return []*Transaction{NewRewardTX(addrC, BlockSubsidy(2)+1, 2), testCoinbase(t, addrA, 1)}
+		}, ErrRewardExceedsFees},
+		{"immature reward", func() []*Transaction {
+			reward := NewRewardTX(addrC, 3, 2)
+			reward.Vout[0].Maturity = coinbaseMaturity - 1
+			reward.ID = reward.Hash()
+			return []*Transaction{reward, spend1}
+		}, ErrInvalidReward},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bc.AddBlock(newTestBlock(t, bc, tt.txs()...)); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+
+	// 奖励交易不能单独验证通过
+	if err := bc.ValidateTransaction(NewRewardTX(addrC, 0, 2)); !errors.Is(err, ErrInvalidReward) {
+		t.Fatalf("ValidateTransaction = %v，期望 %v", err, ErrInvalidReward)
+	}
+	if _, err := bc.NewBlockTemplate(addrC, []*Transaction{NewRewardTX(addrC, 0, 2)}); !errors.Is(err, ErrInvalidReward) {
+		t.Fatalf("NewBlockTemplate 接受了奖励交易: %v", err)
+	}
+
+	// 出块节点领取全部手续费与出块补贴
+	block, err := bc.NewBlockTemplate(addrC, []*Transaction{spend1, spend2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, block)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	reward := block.Body.Transactions[0]
+	want := BlockSubsidy(2) + 4
+	if reward.Type != rewardTxType || reward.Vout[0].Value != want || reward.Vout[0].Address != addrC || reward.Vout[0].Maturity != coinbaseMaturity {
+		t.Fatalf("奖励交易 %+v，期望给 %x 转入 %d", reward, addrC, want)
+	}
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	tests := []struct {
+		height uint64
+		want   Amount
+	}{
+		{0, 0}, // 创世区块没有奖励
+		{1, initialSubsidy},
+		{subsidyHalvingInterval - 1, initialSubsidy},
+		{subsidyHalvingInterval, initialSubsidy / 2},
+		{3 * subsidyHalvingInterval, initialSubsidy / 8},
+		{6 * subsidyHalvingInterval, 0},
+		{64 * subsidyHalvingInterval, 0},
+	}
+	for _, tt := range tests {
+		if got := BlockSubsidy(tt.height); got != tt.want {
+			t.Errorf("BlockSubsidy(%d) = %d，期望 %d", tt.height, got, tt.want)
+		}
+	}
+}
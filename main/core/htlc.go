@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 哈希时间锁 HTLC
+// 带HTLC的out有两种花费方式：超时之前收款方Recipient给出哈希值为Hash的原像并签名领取，或者超过Timeout后退款方Refund签名取回
+// 两种方式在时间上互斥，超时之后原像不再有效，收款方与退款方不会同时可以花费同一个out
+// 跨区转账时两个区按同一个Hash锁定资金，收款方在一个区公开原像领取后，另一个区也可以用这个原像完成转账
+// 收款方一直不领取时双方在超时后各自退款，转账要么在两个区都完成，要么都退回
+// out的Address是由锁定条件计算出的HTLC地址，与多签地址一样没有私钥
+// 花费HTLC out的input在Preimage中给出原像，Preimage为空表示退款；签名仍然放在Signature中，签名不覆盖Preimage
+
+// DefaultHTLCTimeout 跨区转账的HTLC默认在当前高度之后经过的区块数超时
+const DefaultHTLCTimeout = 100
+
+// preimageLength 原像的长度
+const preimageLength = 32
+
+var (
+	ErrInvalidHTLC     = errors.New("HTLC锁定条件无效")
+	ErrInvalidPreimage = errors.New("原像的哈希值与HTLC锁定条件不符")
+	ErrNotHTLC         = errors.New("out没有HTLC锁定条件")
+	ErrHTLCExpired     = errors.New("HTLC已经超时，只能由退款方取回")
+)
+
+// HTLCLock 哈希时间锁定条件
+type HTLCLock struct {
+	Hash      []byte         // 原像的sha256哈希值，32字节
+	Recipient common.Address // 给出原像并签名后可以领取
+	Refund    common.Address // 超时后签名可以取回
+	Timeout   uint64         // 超时时间，与LockTime的含义相同，小于LockTimeThreshold时是区块高度，否则是Unix时间戳
+}
+
+// NewHTLCLock 创建哈希时间锁定条件
+func NewHTLCLock(hash []byte, recipient common.Address, refund common.Address, timeout uint64) (*HTLCLock, error) {
+	lock := &HTLCLock{Hash: append([]byte{}, hash...), Recipient: recipient, Refund: refund, Timeout: timeout}
+	if err := lock.Validate(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// NewPreimage 生成随机的原像，返回原像与它的哈希值
+func NewPreimage() ([]byte, []byte, error) {
+	preimage := make([]byte, preimageLength)
+	if _, err := rand.Read(preimage); err != nil {
+		return nil, nil, err
+	}
+	return preimage, HashPreimage(preimage), nil
+}
+
+// HashPreimage 原像的哈希值
+func HashPreimage(preimage []byte) []byte {
+	hash := sha256.Sum256(preimage)
+	return hash[:]
+}
+
+// Validate 检查Hash是32字节，Timeout不为0
+func (l *HTLCLock) Validate() error {
+	if len(l.Hash) != sha256.Size {
+		return fmt.Errorf("! 哈希值长度 %d 不是 %d: %w", len(l.Hash), sha256.Size, ErrInvalidHTLC)
+	}
+	if l.Timeout == 0 {
+		return fmt.Errorf("! 没有设置超时时间: %w", ErrInvalidHTLC)
+	}
+	return nil
+}
+
+// encode 锁定条件的规范编码: bytes Hash | address Recipient | address Refund | uvarint Timeout
+func (l *HTLCLock) encode(e *encoder) {
+	e.bytes(l.Hash)
+	e.address(l.Recipient)
+	e.address(l.Refund)
+	e.uvarint(l.Timeout)
+}
+
+// Address HTLC地址 = keccak256("htlc" || 锁定条件的规范编码)的后20字节
+func (l *HTLCLock) Address() common.Address {
+	var e encoder
+	e.buf.WriteString("htlc")
+	l.encode(&e)
+	return common.BytesToAddress(crypto.Keccak256(e.buf.Bytes())[12:])
+}
+
+// Equal 判断两个锁定条件是否相同
+func (l *HTLCLock) Equal(other *HTLCLock) bool {
+	if l == nil || other == nil {
+		return l == other
+	}
+	return bytes.Equal(l.Hash, other.Hash) && l.Recipient == other.Recipient && l.Refund == other.Refund && l.Timeout == other.Timeout
+}
+
+// signer 花费HTLC out的input需要的签名者，给出原像时是Recipient，否则是Refund
+func (l *HTLCLock) signer(preimage []byte) common.Address {
+	if len(preimage) != 0 {
+		return l.Recipient
+	}
+	return l.Refund
+}
+
+// NewHTLCOutput 创建锁定到lock的out，Address是lock的HTLC地址
+func NewHTLCOutput(value Amount, lock *HTLCLock) *TXOutput {
+	txo := NewTXOutput(value, lock.Address())
+	txo.HTLC = lock
+	return txo
+}
+
+// checkHTLCOutput 检查out的HTLC锁定条件有效，Address是锁定条件的HTLC地址，并且没有同时设置多签
+func checkHTLCOutput(out *TXOutput) error {
+	if out.HTLC == nil {
+		return nil
+	}
+	if out.Multisig != nil {
+		return fmt.Errorf("! out不能同时有多签与HTLC锁定条件: %w", ErrInvalidHTLC)
+	}
+	if err := out.HTLC.Validate(); err != nil {
+		return err
+	}
+	if out.Address != out.HTLC.Address() {
+		return fmt.Errorf("! out的地址 %x 不是HTLC地址: %w", out.Address, ErrInvalidHTLC)
+	}
+	return nil
+}
+
+// checkHTLCInput 检查花费HTLC out的input：领取必须在超时之前并且给出的原像正确，退款必须已经超时
+// ctx是包含交易的区块的高度与时间
+func checkHTLCInput(vin TXInput, lock *HTLCLock, ctx LockContext) error {
+	if len(vin.Preimage) != 0 {
+		if !bytes.Equal(HashPreimage(vin.Preimage), lock.Hash) {
+			return ErrInvalidPreimage
+		}
+		if lockTimeReached(lock.Timeout, ctx) {
+			return ErrHTLCExpired
+		}
+		return nil
+	}
+	if !lockTimeReached(lock.Timeout, ctx) {
+		return ErrOutputLocked
+	}
+	return nil
+}
+
+// HTLCCoin 交易中第一个带HTLC锁定条件的out，用于构造领取或退款交易
+func (tx *Transaction) HTLCCoin() (Coin, error) {
+	for i, out := range tx.Vout {
+		if out.HTLC != nil {
+			return Coin{Txid: tx.ID, Vout: i, Out: out}, nil
+		}
+	}
+	return Coin{}, fmt.Errorf("! 交易 %x: %w", tx.ID, ErrNotHTLC)
+}
+
+// NewHTLCClaim 收款方给出原像领取HTLC out，钱转到lock.Recipient，交易需要在超时之前上链
+// toLight为true时领取的钱转到轻计算区：交易是ToLight交易，out的IsUse为true，不再留在转账区
+// 手续费从领取的金额中扣除，keyring需要有Recipient的私钥
+func NewHTLCClaim(coin Coin, preimage []byte, keyring Keyring, toLight bool, opts TxBuildOptions) (*Transaction, error) {
+	if coin.Out.HTLC == nil {
+		return nil, ErrNotHTLC
+	}
+	if len(preimage) == 0 || !bytes.Equal(HashPreimage(preimage), coin.Out.HTLC.Hash) {
+		return nil, ErrInvalidPreimage
+	}
+	return newHTLCSpend(coin, preimage, keyring, toLight, opts)
+}
+
+// NewHTLCRefund 超时后退款方取回HTLC out，钱转到lock.Refund
+// toLight为true时退回轻计算区，用于轻计算区转入的资金没有被领取的情况
+// 交易的LockTime设置为超时时间，超时之前不能被打包，keyring需要有Refund的私钥
+func NewHTLCRefund(coin Coin, keyring Keyring, toLight bool, opts TxBuildOptions) (*Transaction, error) {
+	if coin.Out.HTLC == nil {
+		return nil, ErrNotHTLC
+	}
+	opts.LockTime = coin.Out.HTLC.Timeout
+	return newHTLCSpend(coin, nil, keyring, toLight, opts)
+}
+
+// newHTLCSpend 构造花费HTLC out的交易，只有一个input与一个output
+func newHTLCSpend(coin Coin, preimage []byte, keyring Keyring, toLight bool, opts TxBuildOptions) (*Transaction, error) {
+	lock := coin.Out.HTLC
+	TX := Transaction{
+		Vin:      []TXInput{{Txid: coin.Txid, Vout: coin.Vout, Address: coin.Out.Address, Preimage: preimage}},
+		Vout:     []TXOutput{{Value: coin.Out.Value, Address: lock.signer(preimage), IsUse: toLight}},
+		Type:     0,
+		LockTime: opts.LockTime,
+	}
+	if toLight {
+		TX.Type = 1 // 跨区转账ToLight
+	}
+
+	// 手续费按签名后的大小计算
+	fee := opts.Fee(estimateSignedSize(&TX))
+	value, err := coin.Out.Value.Sub(fee)
+	if err != nil || value == 0 {
+		return nil, fmt.Errorf("! HTLC金额 %d 不足以支付手续费 %d: %w", coin.Out.Value, fee, ErrInsufficientFunds)
+	}
+	TX.Vout[0].Value = value
+
+	TX.ID = TX.Hash()
+	// coin中已经有被花费的out，签名不需要再从区块链查询前置交易
+	err = TX.SignWithKeyring(keyring, coinPrevTXs([]Coin{coin}))
+	if err != nil {
+		return nil, err
+	}
+	return &TX, nil
+}
+
+// NewHTLCCoinbaseTX 轻计算区转入转账区的ToTran Coinbase交易，转入的钱锁定在HTLC out中
+// 收款方给出原像后才能使用，超时没有领取时由轻计算区的FromAddress退回
+// 与NewCoinbaseTX相同，需要协调者调用Authorize签名授权后才能上链，授权签名覆盖锁定条件，签名后不能再修改
+func NewHTLCCoinbaseTX(FromAddress common.Address, lock *HTLCLock, Money Amount, UserAccount string) (*Transaction, error) {
+	if err := lock.Validate(); err != nil {
+		return nil, err
+	}
+	TX, err := NewCoinbaseTX(FromAddress, lock.Address(), Money, UserAccount)
+	if err != nil {
+		return nil, err
+	}
+	TX.Vout[0].HTLC = lock
+	TX.ID = TX.Hash()
+	return TX, nil
+}
+
+// HTLCTimeoutHeight 跨区转账HTLC的默认超时时间：当前最新区块高度之后DefaultHTLCTimeout个区块
+func (bc *BlockChain) HTLCTimeoutHeight() uint64 {
+	return uint64(bc.TipHeight()+1) + DefaultHTLCTimeout
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestHTLCLockValidate(t *testing.T) {
+	_, hash, err := NewPreimage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		hash    []byte
+		timeout uint64
+		err     error
+	}{
+		{"valid", hash, 10, nil},
+		{"short hash", hash[:31], 10, ErrInvalidHTLC},
+		{"no hash", nil, 10, ErrInvalidHTLC},
+		{"no timeout", hash, 0, ErrInvalidHTLC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHTLCLock(tt.hash, addrB, addrA, tt.timeout); !errors.Is(err, tt.err) {
+				t.Fatalf("NewHTLCLock = %v，期望 %v", err, tt.err)
+			}
+		})
+	}
+
+	// 锁定条件的任何一项不同，HTLC地址都不同
+	lock, _ := NewHTLCLock(hash, addrB, addrA, 10)
+	for _, other := range []*HTLCLock{
+		{Hash: HashPreimage(hash), Recipient: addrB, Refund: addrA, Timeout: 10},
+		{Hash: hash, Recipient: addrC, Refund: addrA, Timeout: 10},
+		{Hash: hash, Recipient: addrB, Refund: addrC, Timeout: 10},
+		{Hash: hash, Recipient: addrB, Refund: addrA, Timeout: 11},
+	} {
+		if lock.Address() == other.Address() || lock.Equal(other) {
+			t.Fatalf("锁定条件 %+v 与 %+v 的HTLC地址相同", lock, other)
+		}
+	}
+}
+
+// newTestHTLC 上链一个锁定到addrB、超时后退回addrA的HTLC，超时高度为timeout
+func newTestHTLC(t *testing.T, bc *BlockChain, timeout uint64) (Coin, []byte) {
+	t.Helper()
+	preimage, hash, err := NewPreimage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := NewHTLCLock(hash, addrB, addrA, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewHTLCCoinbaseTX(addrA, lock, 10, "light")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, tx)
+	coin, err := tx.HTLCCoin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coin, preimage
+}
+
+func TestHTLCClaimAndRefund(t *testing.T) {
+	bc := newTestChain(t)
+	coin, preimage := newTestHTLC(t, bc, 4) // 高度1
+
+	claim, err := NewHTLCClaim(coin, preimage, testKeyring, false, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimToLight, err := NewHTLCClaim(coin, preimage, testKeyring, true, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refund, err := NewHTLCRefund(coin, testKeyring, false, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有设置LockTime的退款交易，用于检查HTLC本身的超时
+	earlyRefund, err := newHTLCSpend(coin, nil, testKeyring, false, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongPreimage := append([]byte{}, preimage...)
+	wrongPreimage[0] ^= 1
+	if _, err := NewHTLCClaim(coin, wrongPreimage, testKeyring, false, TxBuildOptions{}); !errors.Is(err, ErrInvalidPreimage) {
+		t.Fatalf("错误的原像: %v，期望 %v", err, ErrInvalidPreimage)
+	}
+	forged := *claim
+	forged.Vin = []TXInput{claim.Vin[0]}
+	forged.Vin[0].Preimage = wrongPreimage
+	forged.ID = forged.Hash()
+	// keyring中没有收款方的私钥时不能构造领取交易
+	if _, err := NewHTLCClaim(coin, preimage, NewKeyring(keyA), false, TxBuildOptions{}); err == nil {
+		t.Fatal("没有收款方的私钥也能签名领取交易")
+	}
+	// 退款方知道原像后用自己的私钥签名领取
+	stolen := *claim
+	stolen.Vin = []TXInput{claim.Vin[0]}
+	stolen.Vout = []TXOutput{claim.Vout[0]}
+	stolen.Vout[0].Address = addrA
+	stolen.Vin[0].Signature, err = crypto.Sign(stolen.SignatureHash(0, coin.Out), keyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen.ID = stolen.Hash()
+
+	if claim.Vout[0].Address != addrB || claim.Vout[0].IsUse || claimToLight.Type != 1 || !claimToLight.Vout[0].IsUse {
+		t.Fatalf("领取交易的output %+v / %+v", claim.Vout[0], claimToLight.Vout[0])
+	}
+	if refund.Vout[0].Address != addrA || refund.LockTime != 4 {
+		t.Fatalf("退款交易 %+v", refund)
+	}
+
+	// 下一个区块的高度，超时高度为4
+	tests := []struct {
+		name   string
+		tx     *Transaction
+		before error // 高度2，还没有超时
+		after  error // 高度4，已经超时
+	}{
+		{"claim", claim, nil, ErrHTLCExpired},
+		{"claim to light", claimToLight, nil, ErrHTLCExpired},
+		{"wrong preimage", &forged, ErrInvalidPreimage, ErrInvalidPreimage},
+		{"wrong signer", &stolen, ErrInvalidSignature, ErrHTLCExpired},
+		{"refund", refund, ErrTxLocked, nil},
+		{"refund without lock time", earlyRefund, ErrOutputLocked, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" before timeout", func(t *testing.T) {
+			if err := bc.ValidateTransaction(tt.tx); !errors.Is(err, tt.before) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, tt.before)
+			}
+		})
+	}
+
+	addTestBlock(t, bc, testCoinbase(t, addrC, 1)) // 高度2
+	addTestBlock(t, bc, testCoinbase(t, addrC, 2)) // 高度3
+	for _, tt := range tests {
+		t.Run(tt.name+" after timeout", func(t *testing.T) {
+			if err := bc.ValidateTransaction(tt.tx); !errors.Is(err, tt.after) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, tt.after)
+			}
+		})
+	}
+	addTestBlock(t, bc, refund)
+}
+
+// TestHTLCClaimOnChain 领取交易在超时之前上链后，原像公开在链上，退款交易不能再花费同一个out
+func TestHTLCClaimOnChain(t *testing.T) {
+	bc := newTestChain(t)
+	coin, preimage := newTestHTLC(t, bc, 3)
+	claim, err := NewHTLCClaim(coin, preimage, testKeyring, false, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, claim)
+
+	tx, err := bc.FindTransaction(claim.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tx.Vin[0].Preimage, preimage) {
+		t.Fatalf("链上的原像 %x，期望 %x", tx.Vin[0].Preimage, preimage)
+	}
+
+	refund, err := NewHTLCRefund(coin, testKeyring, false, TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, testCoinbase(t, addrC, 1))
+	if err := bc.ValidateTransaction(refund); !errors.Is(err, ErrMissingInput) {
+		t.Fatalf("领取后退款: %v，期望 %v", err, ErrMissingInput)
+	}
+}
+
+// TestHTLCCoinbaseAuthorization 转入HTLC的ToTran交易与普通的ToTran交易一样需要协调者授权，授权后锁定条件不能修改
+func TestHTLCCoinbaseAuthorization(t *testing.T) {
+	bc := newTestChain(t)
+	_, hash, _ := NewPreimage()
+	lock, _ := NewHTLCLock(hash, addrB, addrA, 10)
+
+	tests := []struct {
+		name string
+		tx   func() *Transaction
+		want error
+	}{
+		{"unsigned", func() *Transaction {
+			tx, _ := NewHTLCCoinbaseTX(addrA, lock, 10, "")
+			return tx
+		}, ErrUnauthorizedMint},
+		{"lock changed after authorization", func() *Transaction {
+			tx, _ := NewHTLCCoinbaseTX(addrA, lock, 10, "")
+			tx.Authorize(testCoordinatorKey)
+			changed := *lock
+			changed.Recipient = addrC
+			tx.Vout[0] = *NewHTLCOutput(10, &changed)
+			tx.ID = tx.Hash()
+			return tx
+		}, ErrUnauthorizedMint},
+		{"output address is not lock address", func() *Transaction {
+			tx, _ := NewHTLCCoinbaseTX(addrA, lock, 10, "")
+			tx.Vout[0].Address = addrB
+			tx.Authorize(testCoordinatorKey)
+			return tx
+		}, ErrInvalidHTLC},
+		{"authorized", func() *Transaction {
+			tx, _ := NewHTLCCoinbaseTX(addrA, lock, 10, "")
+			tx.Authorize(testCoordinatorKey)
+			return tx
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bc.AddBlock(newTestBlock(t, bc, tt.tx())); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Keyring 多钱包账户下所有子钱包的私钥，按子钱包地址索引
+// 构造交易时只选择Keyring中地址拥有的out，签名时每个input使用它花费的out的地址对应的私钥
+type Keyring map[common.Address]*ecdsa.PrivateKey
+
+// NewKeyring 根据一组私钥创建Keyring
+func NewKeyring(keys ...*ecdsa.PrivateKey) Keyring {
+	k := make(Keyring, len(keys))
+	for _, key := range keys {
+		k.Add(key)
+	}
+	return k
+}
+
+// Add 加入一个私钥
+func (k Keyring) Add(key *ecdsa.PrivateKey) {
+	k[crypto.PubkeyToAddress(key.PublicKey)] = key
+}
+
+// Owns 判断地址是否属于Keyring
+func (k Keyring) Owns(address common.Address) bool {
+	_, ok := k[address]
+	return ok
+}
+
+// Key 返回地址对应的私钥
+func (k Keyring) Key(address common.Address) (*ecdsa.PrivateKey, error) {
+	key, ok := k[address]
+	if !ok {
+		return nil, fmt.Errorf("! 没有地址 %x 的私钥", address)
+	}
+	return key, nil
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring := NewKeyring(keyA, keyB)
+	tests := []struct {
+		address common.Address
+		owns    bool
+	}{
+		{addrA, true},
+		{addrB, true},
+		{addrC, false},
+	}
+	for _, tt := range tests {
+		if keyring.Owns(tt.address) != tt.owns {
+			t.Errorf("Owns(%x) = %v，期望 %v", tt.address, !tt.owns, tt.owns)
+		}
+		key, err := keyring.Key(tt.address)
+		if (err == nil) != tt.owns {
+			t.Errorf("Key(%x) 错误 %v", tt.address, err)
+		}
+		if err == nil && key.D.Cmp(testKeyring[tt.address].D) != 0 {
+			t.Errorf("Key(%x) 返回了其他私钥", tt.address)
+		}
+	}
+}
+
+// TestSignWithKeyring 每个input使用它花费的out所属子钱包的私钥签名
+func TestSignWithKeyring(t *testing.T) {
+	prevA := testCoinbase(t, addrA, 5)
+	prevB := testCoinbase(t, addrB, 7)
+	prevTXs := map[string]Transaction{hexID(prevA): *prevA, hexID(prevB): *prevB}
+	newTx := func() *Transaction {
+		return &Transaction{
+			Vin:  []TXInput{{Txid: prevA.ID, Vout: 0, Address: addrA}, {Txid: prevB.ID, Vout: 0, Address: addrB}},
+			Vout: []TXOutput{*NewTXOutput(12, addrC)},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keyring Keyring
+		ok      bool
+	}{
+		{"all keys", NewKeyring(keyA, keyB), true},
+		{"extra key", NewKeyring(keyA, keyB, keyC), true},
+		{"missing key", NewKeyring(keyA), false},
+		{"empty", NewKeyring(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newTx()
+			err := tx.SignWithKeyring(tt.keyring, prevTXs)
+			if (err == nil) != tt.ok {
+				t.Fatalf("SignWithKeyring = %v，期望成功: %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			if err := tx.Verify(prevTXs); err != nil {
+				t.Fatalf("Verify = %v", err)
+			}
+		})
+	}
+
+	// 只用一个私钥签名的多地址交易不能通过验证
+	tx := newTx()
+	tx.Sign(keyA, prevTXs)
+	if err := tx.Verify(prevTXs); err == nil {
+		t.Fatal("一个私钥签名的多地址交易通过了验证")
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// 默克尔树节点哈希的前缀，区分叶子节点与中间节点，防止第二原像攻击
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleTree 区块交易的默克尔树
+// Levels[0]是叶子节点层，最后一层只有一个元素，即默克尔根
+type MerkleTree struct {
+	Levels [][][]byte
+}
+
+// MerkleProof 交易的默克尔包含证明，用于在不提供完整区块的情况下证明某个交易在区块中
+type MerkleProof struct {
+	TxID     []byte   // 被证明的交易ID
+	Index    int      // 交易在区块中的位置，同时决定每一层兄弟节点在左边还是右边
+	Siblings [][]byte // 从叶子节点到根节点路径上每一层的兄弟节点哈希
+}
+
+// hashMerkleLeaf 计算叶子节点哈希
+func hashMerkleLeaf(data []byte) []byte {
+	h := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return h[:]
+}
+
+// hashMerkleNode 计算中间节点哈希
+func hashMerkleNode(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleNodePrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// NewMerkleTree 根据叶子数据(交易ID)构建默克尔树
+// 某一层节点个数为奇数时，复制最后一个节点补齐；没有叶子时根为空数据的哈希
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var level [][]byte
+	for _, d := range data {
+		level = append(level, hashMerkleLeaf(d))
+	}
+	if len(level) == 0 {
+		h := sha256.Sum256(nil)
+		return &MerkleTree{Levels: [][][]byte{{h[:]}}}
+	}
+
+	tree := &MerkleTree{Levels: [][][]byte{level}}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			left := level[i]
+			right := left
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, hashMerkleNode(left, right))
+		}
+		tree.Levels = append(tree.Levels, next)
+		level = next
+	}
+
+	return tree
+}
+
+// Root 返回默克尔根
+func (t *MerkleTree) Root() []byte {
+	return t.Levels[len(t.Levels)-1][0]
+}
+
+// Proof 生成第index个叶子节点的包含证明，返回的证明中TxID需要调用者填写
+func (t *MerkleTree) Proof(index int) (*MerkleProof, error) {
+	if len(t.Levels) == 0 || index < 0 || index >= len(t.Levels[0]) {
+		return nil, fmt.Errorf("! 默克尔证明的叶子位置 %d 超出范围", index)
+	}
+
+	proof := &MerkleProof{Index: index}
+	pos := index
+	// 最后一层是根节点，不需要兄弟节点
+	for _, level := range t.Levels[:len(t.Levels)-1] {
+		sibling := pos ^ 1
+		if sibling >= len(level) {
+			sibling = pos // 奇数个节点时兄弟节点是自己的复制
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+		pos /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof 验证交易的默克尔包含证明是否能推导出给定的默克尔根
+func VerifyMerkleProof(root []byte, proof *MerkleProof) bool {
+	if proof == nil || proof.Index < 0 {
+		return false
+	}
+
+	h := hashMerkleLeaf(proof.TxID)
+	pos := proof.Index
+	for _, sibling := range proof.Siblings {
+		if pos%2 == 0 {
+			h = hashMerkleNode(h, sibling)
+		} else {
+			h = hashMerkleNode(sibling, h)
+		}
+		pos /= 2
+	}
+	// 所有层处理完后位置必须回到根节点，否则Index与证明长度不匹配
+	if pos != 0 {
+		return false
+	}
+
+	return bytes.Equal(h, root)
+}
+
+// MerkleTree 根据区块中的交易ID构建默克尔树
+func (b *Body) MerkleTree() *MerkleTree {
+	var data [][]byte
+	for _, tx := range b.Transactions {
+		data = append(data, tx.ID)
+	}
+	return NewMerkleTree(data)
+}
+
+// MerkleRoot 计算区块交易的默克尔根
+func (b *Body) MerkleRoot() []byte {
+	return b.MerkleTree().Root()
+}
+
+// MerkleProof 为区块中交易ID为txid的交易生成默克尔包含证明
+func (b *Block) MerkleProof(txid []byte) (*MerkleProof, error) {
+	for i, tx := range b.Body.Transactions {
+		if bytes.Equal(tx.ID, txid) {
+			proof, err := b.Body.MerkleTree().Proof(i)
+			if err != nil {
+				return nil, err
+			}
+			proof.TxID = txid
+			return proof, nil
+		}
+	}
+
+	return nil, fmt.Errorf("! 区块中没有找到交易 %x", txid)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// merkleLeaves n个不同的叶子数据
+func merkleLeaves(n int) [][]byte {
+	var data [][]byte
+	for i := 0; i < n; i++ {
+		data = append(data, []byte{byte(i), byte(i * 7)})
+	}
+	return data
+}
+
+func TestMerkleRoot(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	empty := sha256.Sum256(nil)
+	tests := []struct {
+		name string
+		data [][]byte
+		want []byte
+	}{
+		{"empty", nil, empty[:]},
+		{"one leaf", [][]byte{a}, hashMerkleLeaf(a)},
+		{"two leaves", [][]byte{a, b}, hashMerkleNode(hashMerkleLeaf(a), hashMerkleLeaf(b))},
+		// 奇数个节点时复制最后一个节点
+		{"three leaves", [][]byte{a, b, c}, hashMerkleNode(
+			hashMerkleNode(hashMerkleLeaf(a), hashMerkleLeaf(b)),
+			hashMerkleNode(hashMerkleLeaf(c), hashMerkleLeaf(c)),
+		)},
+		// 叶子与中间节点使用不同的前缀，一个叶子的数据等于两个叶子哈希的拼接时根也不同
+		{"leaf looks like node", [][]byte{append(hashMerkleLeaf(a), hashMerkleLeaf(b)...)},
+			hashMerkleLeaf(append(hashMerkleLeaf(a), hashMerkleLeaf(b)...))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewMerkleTree(tt.data).Root(); !bytes.Equal(got, tt.want) {
+				t.Fatalf("默克尔根 %x，期望 %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		data := merkleLeaves(n)
+		tree := NewMerkleTree(data)
+		for i := range data {
+			proof, err := tree.Proof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proof.TxID = data[i]
+			if !VerifyMerkleProof(tree.Root(), proof) {
+				t.Fatalf("%d 个叶子中第 %d 个的证明验证失败", n, i)
+			}
+		}
+		for _, index := range []int{-1, n} {
+			if _, err := tree.Proof(index); err == nil {
+				t.Fatalf("%d 个叶子时位置 %d 生成了证明", n, index)
+			}
+		}
+	}
+}
+
+func TestVerifyMerkleProofRejects(t *testing.T) {
+	data := merkleLeaves(5)
+	tree := NewMerkleTree(data)
+	valid, _ := tree.Proof(2)
+	valid.TxID = data[2]
+
+	tests := []struct {
+		name   string
+		modify func(p *MerkleProof)
+	}{
+		{"other txid", func(p *MerkleProof) { p.TxID = data[3] }},
+		{"other index", func(p *MerkleProof) { p.Index = 3 }},
+		{"negative index", func(p *MerkleProof) { p.Index = -1 }},
+		// Index超出证明长度能表示的范围
+		{"index too large", func(p *MerkleProof) { p.Index += 1 << len(p.Siblings) }},
+		{"tampered sibling", func(p *MerkleProof) {
+			p.Siblings[1] = append([]byte{}, p.Siblings[1]...)
+			p.Siblings[1][0] ^= 1
+		}},
+		{"missing sibling", func(p *MerkleProof) { p.Siblings = p.Siblings[:len(p.Siblings)-1] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := *valid
+			proof.Siblings = append([][]byte{}, valid.Siblings...)
+			tt.modify(&proof)
+			if VerifyMerkleProof(tree.Root(), &proof) {
+				t.Fatal("无效的默克尔证明验证通过")
+			}
+		})
+	}
+	if VerifyMerkleProof(tree.Root(), nil) {
+		t.Fatal("空的默克尔证明验证通过")
+	}
+}
+
+func TestBlockMerkleProof(t *testing.T) {
+	var txs []*Transaction
+	for _, id := range merkleLeaves(3) {
+		txs = append(txs, &Transaction{ID: id})
+	}
+	block := &Block{Header: &Header{}, Body: &Body{Transactions: txs}}
+	block.Header.MerkelRoot = block.Body.MerkleRoot()
+
+	for _, tx := range txs {
+		proof, err := block.MerkleProof(tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyMerkleProof(block.Header.MerkelRoot, proof) {
+			t.Fatalf("交易 %x 的证明验证失败", tx.ID)
+		}
+	}
+	if _, err := block.MerkleProof([]byte("missing")); err == nil {
+		t.Fatal("区块中不存在的交易生成了证明")
+	}
+}
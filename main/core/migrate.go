@@ -0,0 +1,169 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// blockEncodingVersion 数据库中区块的编码格式，0是旧版本的gob编码，1是encoding.go中的规范编码
+const blockEncodingVersion = 1
+const blockEncodingKey = "e" // blocks bucket中存储区块编码格式的键
+
+// blockEncoding 返回数据库中区块的编码格式，旧数据库没有记录时为0
+func (bc *BlockChain) blockEncoding() int {
+	version := 0
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(blocksBucket)).Get([]byte(blockEncodingKey))
+		if v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return version
+}
+
+// putBlockEncoding 保存区块编码格式
+func putBlockEncoding(b *bolt.Bucket, version int) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(version))
+	return b.Put([]byte(blockEncodingKey), v)
+}
+
+// migrateBlockEncoding 把gob编码的旧数据库改写为规范编码
+// 交易ID与区块哈希值的计算方式都改变了，因此按高度从低到高重新计算每个交易ID和区块哈希值，
+// 并把input引用的交易ID、PrevBlock、tip与区块状态改为新的值，高度与默克尔根也一起重新计算
+// 迁移完成后需要调用Reindex重建所有派生索引
+func (bc *BlockChain) migrateBlockEncoding() error {
+	var tip []byte
+	err := bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		sb := tx.Bucket([]byte(blockStateBucket))
+
+		// 读取所有旧区块，blocks bucket中除区块外还保存了tip、高度等短键
+		blocks := make(map[string]*Block)
+		states := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			if len(k) != sha256.Size {
+				return nil
+			}
+			block, err := deserializeBlockGob(v)
+			if err != nil {
+				return fmt.Errorf("! 区块 %x 无法解码: %v", k, err)
+			}
+			blocks[string(k)] = block
+			if s := sb.Get(k); s != nil {
+				states[string(k)] = append([]byte{}, s...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// 旧区块头中可能没有高度，沿PrevBlock重新计算
+		heights := make(map[string]uint64)
+		var heightOf func(hash string) uint64
+		heightOf = func(hash string) uint64 {
+			if h, ok := heights[hash]; ok {
+				return h
+			}
+			var h uint64
+			prev := string(blocks[hash].Header.PrevBlock)
+			if _, ok := blocks[prev]; ok && len(prev) != 0 {
+				h = heightOf(prev) + 1
+			}
+			heights[hash] = h
+			return h
+		}
+		oldHashes := make([]string, 0, len(blocks))
+		for hash := range blocks {
+			heightOf(hash)
+			oldHashes = append(oldHashes, hash)
+		}
+		sort.Slice(oldHashes, func(i, j int) bool {
+			if heights[oldHashes[i]] != heights[oldHashes[j]] {
+				return heights[oldHashes[i]] < heights[oldHashes[j]]
+			}
+			return oldHashes[i] < oldHashes[j]
+		})
+
+		// 按高度顺序重新计算，被引用的交易与前一个区块总是先处理
+		txids := make(map[string][]byte)
+		hashes := make(map[string][]byte)
+		for _, oldHash := range oldHashes {
+			block := blocks[oldHash]
+			for _, t := range block.Body.Transactions {
+				for i := range t.Vin {
+					if newID, ok := txids[string(t.Vin[i].Txid)]; ok {
+						t.Vin[i].Txid = newID
+					}
+				}
+				oldID := string(t.ID)
+				t.ID = t.Hash()
+				txids[oldID] = t.ID
+			}
+
+			block.Header.Height = heights[oldHash]
+			if newPrev, ok := hashes[string(block.Header.PrevBlock)]; ok {
+				block.Header.PrevBlock = newPrev
+			}
+			block.Header.MerkelRoot = block.Body.MerkleRoot()
+			newHash, err := block.Hash()
+			if err != nil {
+				return err
+			}
+			hashes[oldHash] = newHash
+		}
+
+		// 删除旧区块，写入新区块
+		for _, oldHash := range oldHashes {
+			err := b.Delete([]byte(oldHash))
+			if err != nil {
+				return err
+			}
+			err = sb.Delete([]byte(oldHash))
+			if err != nil {
+				return err
+			}
+		}
+		for _, oldHash := range oldHashes {
+			newHash := hashes[oldHash]
+			err := b.Put(newHash, blocks[oldHash].Serialize())
+			if err != nil {
+				return err
+			}
+			if s, ok := states[oldHash]; ok {
+				err = sb.Put(newHash, s)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		var ok bool
+		tip, ok = hashes[string(b.Get([]byte("l")))]
+		if !ok {
+			return fmt.Errorf("! 最新区块 %x 不存在", b.Get([]byte("l")))
+		}
+		err = b.Put([]byte("l"), tip)
+		if err != nil {
+			return err
+		}
+
+		return putBlockEncoding(b, blockEncodingVersion)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = tip
+	return nil
+}
@@ -0,0 +1,312 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 多重签名
+// 带Multisig的out需要Addresses中Required个不同地址的私钥签名才能花费，out的Address是由锁定条件计算出的多签地址
+// 多签地址没有私钥，按地址查询余额、索引out与普通地址相同，但只能按多签的方式花费
+// 花费多签out的input把签名放在Signatures中，签名按签名者在Addresses中的位置从小到大排列，个数必须正好是Required
+// 每个签名者签名的都是同一个SignatureHash，签名之间互不影响，负责人可以依次对同一个交易签名
+
+// maxMultisigAddresses 多签锁定条件中最多的地址个数
+const maxMultisigAddresses = 16
+
+// 国库资金的锁定条件：三位负责人中任意两位签名才能花费
+const (
+	TreasuryOfficers        = 3
+	TreasuryRequiredSigners = 2
+)
+
+var (
+	ErrInvalidMultisig = errors.New("多签锁定条件无效")
+	ErrNoMultisigKey   = errors.New("没有多签锁定条件中任何一个还没有签名的地址的私钥")
+)
+
+// MultisigLock M-of-N多签锁定条件
+type MultisigLock struct {
+	Required  int              // 需要的签名个数M
+	Addresses []common.Address // 可以签名的地址，共N个，不能重复
+}
+
+// NewMultisigLock 创建需要addresses中required个地址签名的锁定条件
+func NewMultisigLock(required int, addresses ...common.Address) (*MultisigLock, error) {
+	lock := &MultisigLock{Required: required, Addresses: append([]common.Address{}, addresses...)}
+	if err := lock.Validate(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// NewTreasuryLock 国库资金的锁定条件，officers是三位负责人的地址
+func NewTreasuryLock(officers ...common.Address) (*MultisigLock, error) {
+	if len(officers) != TreasuryOfficers {
+		return nil, fmt.Errorf("! 国库需要 %d 位负责人，实际为 %d 位: %w", TreasuryOfficers, len(officers), ErrInvalidMultisig)
+	}
+	return NewMultisigLock(TreasuryRequiredSigners, officers...)
+}
+
+// Validate 检查 1 <= Required <= N <= maxMultisigAddresses，并且地址不重复
+func (l *MultisigLock) Validate() error {
+	n := len(l.Addresses)
+	if n == 0 || n > maxMultisigAddresses {
+		return fmt.Errorf("! 多签地址个数 %d 不在 1 到 %d 之间: %w", n, maxMultisigAddresses, ErrInvalidMultisig)
+	}
+	if l.Required < 1 || l.Required > n {
+		return fmt.Errorf("! 需要的签名个数 %d 不在 1 到 %d 之间: %w", l.Required, n, ErrInvalidMultisig)
+	}
+	seen := make(map[common.Address]bool)
+	for _, addr := range l.Addresses {
+		if seen[addr] {
+			return fmt.Errorf("! 多签地址 %x 重复: %w", addr, ErrInvalidMultisig)
+		}
+		seen[addr] = true
+	}
+	return nil
+}
+
+// encode 锁定条件的规范编码: uvarint Required | uvarint 地址个数 | 每个地址
+func (l *MultisigLock) encode(e *encoder) {
+	e.uvarint(uint64(l.Required))
+	e.uvarint(uint64(len(l.Addresses)))
+	for _, addr := range l.Addresses {
+		e.address(addr)
+	}
+}
+
+// Address 多签地址 = keccak256("multisig" || 锁定条件的规范编码)的后20字节
+// 地址的顺序不同时多签地址也不同
+func (l *MultisigLock) Address() common.Address {
+	var e encoder
+	e.buf.WriteString("multisig")
+	l.encode(&e)
+	return common.BytesToAddress(crypto.Keccak256(e.buf.Bytes())[12:])
+}
+
+// Equal 判断两个锁定条件是否相同
+func (l *MultisigLock) Equal(other *MultisigLock) bool {
+	if l == nil || other == nil {
+		return l == other
+	}
+	if l.Required != other.Required || len(l.Addresses) != len(other.Addresses) {
+		return false
+	}
+	for i := range l.Addresses {
+		if l.Addresses[i] != other.Addresses[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// signerIndex 从签名恢复签名者，返回签名者在Addresses中的位置
+func (l *MultisigLock) signerIndex(hash []byte, sig []byte) (int, error) {
+	signer, err := recoverSigner(hash, sig)
+	if err != nil {
+		return 0, err
+	}
+	for i, addr := range l.Addresses {
+		if addr == signer {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("! 签名者 %x 不在多签锁定条件中", signer)
+}
+
+// NewMultisigOutput 创建锁定到lock的out，Address是lock的多签地址
+func NewMultisigOutput(value Amount, lock *MultisigLock) *TXOutput {
+	txo := NewTXOutput(value, lock.Address())
+	txo.Multisig = lock
+	return txo
+}
+
+// checkMultisigOutput 检查out的多签锁定条件有效，并且Address是锁定条件的多签地址
+func checkMultisigOutput(out *TXOutput) error {
+	if out.Multisig == nil {
+		return nil
+	}
+	if err := out.Multisig.Validate(); err != nil {
+		return err
+	}
+	if out.Address != out.Multisig.Address() {
+		return fmt.Errorf("! out的地址 %x 不是多签地址: %w", out.Address, ErrInvalidMultisig)
+	}
+	return nil
+}
+
+// signMultisig 用keyFor能提供的私钥为第inIndex个花费多签out的input补充签名，已有Required个签名时不再签名
+// 已有的签名必须有效，签名完成后按签名者的位置排序
+func (tx *Transaction) signMultisig(inIndex int, prevOut TXOutput, keyFor func(common.Address) (*ecdsa.PrivateKey, error)) error {
+	lock := prevOut.Multisig
+	hash := tx.SignatureHash(inIndex, prevOut)
+
+	signed := make(map[int][]byte) // 签名者在Addresses中的位置 -> 签名
+	for _, sig := range tx.Vin[inIndex].Signatures {
+		pos, err := lock.signerIndex(hash, sig)
+		if err != nil {
+			return fmt.Errorf("! 第 %d 个input已有的签名无效: %w", inIndex, err)
+		}
+		signed[pos] = sig
+	}
+	if len(signed) >= lock.Required {
+		return nil
+	}
+
+	added := false
+	for pos, addr := range lock.Addresses {
+		if len(signed) >= lock.Required {
+			break
+		}
+		if _, ok := signed[pos]; ok {
+			continue
+		}
+		privKey, err := keyFor(addr)
+		if err != nil || crypto.PubkeyToAddress(privKey.PublicKey) != addr {
+			continue
+		}
+		sig, err := crypto.Sign(hash, privKey)
+		if err != nil {
+			return err
+		}
+		signed[pos] = sig
+		added = true
+	}
+	if !added {
+		return fmt.Errorf("! 第 %d 个input: %w", inIndex, ErrNoMultisigKey)
+	}
+
+	tx.Vin[inIndex].Signatures = nil
+	for pos := range lock.Addresses {
+		if sig, ok := signed[pos]; ok {
+			tx.Vin[inIndex].Signatures = append(tx.Vin[inIndex].Signatures, sig)
+		}
+	}
+	return nil
+}
+
+// verifyMultisig 验证第inIndex个花费多签out的input，partial为true时允许签名个数少于Required
+// 签名者必须按在Addresses中的位置严格递增，同一个签名者不能签名两次，签名的排列也只有一种
+func (tx *Transaction) verifyMultisig(inIndex int, prevOut TXOutput, partial bool) error {
+	vin := tx.Vin[inIndex]
+	lock := prevOut.Multisig
+	if len(vin.Signature) != 0 {
+		return fmt.Errorf("! 第 %d 个input花费多签out，签名应该放在Signatures中: %w", inIndex, ErrInvalidSignature)
+	}
+	if len(vin.Signatures) > lock.Required || (!partial && len(vin.Signatures) != lock.Required) {
+		return fmt.Errorf("! 第 %d 个input有 %d 个签名，需要 %d 个: %w", inIndex, len(vin.Signatures), lock.Required, ErrInvalidSignature)
+	}
+
+	hash := tx.SignatureHash(inIndex, prevOut)
+	last := -1
+	for _, sig := range vin.Signatures {
+		pos, err := lock.signerIndex(hash, sig)
+		if err != nil {
+			return fmt.Errorf("! 第 %d 个input: %v: %w", inIndex, err, ErrInvalidSignature)
+		}
+		if pos <= last {
+			return fmt.Errorf("! 第 %d 个input的签名重复或没有按签名者排序: %w", inIndex, ErrInvalidSignature)
+		}
+		last = pos
+	}
+	return nil
+}
+
+// NewTransactionToMultisig 把Money转到lock锁定的多签out，例如向国库转账
+// 与NewTransaction相同，使用keyring中子钱包拥有的out支付金额与手续费，每个input由对应子钱包的私钥签名
+func NewTransactionToMultisig(wallet *TransactionWallet, keyring Keyring, lock *MultisigLock, Money Amount, AUTXO map[string]TXOutputs2, opts TxBuildOptions) (*Transaction, error) {
+	if err := lock.Validate(); err != nil {
+		return nil, err
+	}
+	Coins, err := keyringCoins(keyring, AUTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	out := NewMultisigOutput(Money, lock)
+	out.LockTime = opts.OutputLockTime
+	out.Maturity = opts.OutputMaturity
+	TX := Transaction{
+		Vout:     []TXOutput{*out},
+		Type:     0,
+		Account:  wallet.Account,
+		LockTime: opts.LockTime,
+	}
+	_, err = fundTransaction(&TX, Coins, wallet.GetAddress(), opts)
+	if err != nil {
+		return nil, err
+	}
+	TX.ID = TX.Hash()
+	err = TX.SignWithKeyring(keyring, coinPrevTXs(Coins))
+	if err != nil {
+		return nil, err
+	}
+	return &TX, nil
+}
+
+// NewMultisigSpend 构造花费lock锁定的多签out的交易，交易没有签名
+// AUTXO中只有锁定条件与lock相同的out会被使用，找零转回同一个多签地址，opts.Change不起作用
+// 负责人依次调用SignTransactionWithKeyring补充签名，签名个数达到lock.Required后交易才能通过验证
+func NewMultisigSpend(lock *MultisigLock, AddressMoney map[common.Address]Amount, AUTXO map[string]TXOutputs2, opts TxBuildOptions) (*Transaction, error) {
+	if err := lock.Validate(); err != nil {
+		return nil, err
+	}
+	address := lock.Address()
+
+	var Coins []Coin
+	for txID, outputs := range AUTXO {
+		for _, output := range outputs.Outputs {
+			if output.Address != address || !lock.Equal(output.Multisig) {
+				continue
+			}
+			txid, err := hex.DecodeString(txID)
+			if err != nil {
+				return nil, err
+			}
+			Coins = append(Coins, Coin{Txid: txid, Vout: output.Outid, Out: output.Output()})
+		}
+	}
+	// map的遍历顺序是随机的，排序后每个负责人构造出的交易相同
+	sort.Slice(Coins, func(i, j int) bool {
+		if Coins[i].Out.Value != Coins[j].Out.Value {
+			return Coins[i].Out.Value > Coins[j].Out.Value
+		}
+		if c := bytes.Compare(Coins[i].Txid, Coins[j].Txid); c != 0 {
+			return c < 0
+		}
+		return Coins[i].Vout < Coins[j].Vout
+	})
+
+	var Outputs []TXOutput
+	for k, v := range AddressMoney {
+		out := NewTXOutput(v, k)
+		out.LockTime = opts.OutputLockTime
+		out.Maturity = opts.OutputMaturity
+		Outputs = append(Outputs, *out)
+	}
+	sort.Slice(Outputs, func(i, j int) bool {
+		return bytes.Compare(Outputs[i].Address[:], Outputs[j].Address[:]) < 0
+	})
+
+	TX := Transaction{
+		Vout:     Outputs,
+		Type:     0,
+		LockTime: opts.LockTime,
+	}
+	opts.Change = SameAddressChange{}
+	opts.changeLock = lock
+	_, err := fundTransaction(&TX, Coins, address, opts)
+	if err != nil {
+		return nil, err
+	}
+	TX.ID = TX.Hash()
+	return &TX, nil
+}
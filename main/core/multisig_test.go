@@ -0,0 +1,192 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestMultisigLockValidate(t *testing.T) {
+	many := make([]common.Address, maxMultisigAddresses+1)
+	for i := range many {
+		many[i] = common.BigToAddress(common.Big1.Lsh(common.Big1, uint(i)))
+	}
+	tests := []struct {
+		name      string
+		required  int
+		addresses []common.Address
+		err       error
+	}{
+		{"1-of-1", 1, []common.Address{addrA}, nil},
+		{"2-of-3", 2, []common.Address{addrA, addrB, addrC}, nil},
+		{"3-of-3", 3, []common.Address{addrA, addrB, addrC}, nil},
+		{"16 addresses", 1, many[:maxMultisigAddresses], nil},
+		{"required 0", 0, []common.Address{addrA, addrB}, ErrInvalidMultisig},
+		{"required exceeds N", 3, []common.Address{addrA, addrB}, ErrInvalidMultisig},
+		{"no addresses", 1, nil, ErrInvalidMultisig},
+		{"too many addresses", 1, many, ErrInvalidMultisig},
+		{"duplicate address", 2, []common.Address{addrA, addrB, addrA}, ErrInvalidMultisig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMultisigLock(tt.required, tt.addresses...)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("NewMultisigLock = %v，期望 %v", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := NewTreasuryLock(addrA, addrB); !errors.Is(err, ErrInvalidMultisig) {
+		t.Fatalf("两位负责人的国库: %v，期望 %v", err, ErrInvalidMultisig)
+	}
+}
+
+func TestMultisigAddress(t *testing.T) {
+	lock, _ := NewMultisigLock(2, addrA, addrB, addrC)
+	same, _ := NewMultisigLock(2, addrA, addrB, addrC)
+	reordered, _ := NewMultisigLock(2, addrB, addrA, addrC)
+	threshold, _ := NewMultisigLock(3, addrA, addrB, addrC)
+
+	if lock.Address() != same.Address() || !lock.Equal(same) {
+		t.Fatal("相同的锁定条件得到不同的多签地址")
+	}
+	for _, other := range []*MultisigLock{reordered, threshold} {
+		if lock.Address() == other.Address() || lock.Equal(other) {
+			t.Fatalf("锁定条件 %+v 与 %+v 的多签地址相同", lock, other)
+		}
+	}
+	for _, addr := range lock.Addresses {
+		if lock.Address() == addr {
+			t.Fatal("多签地址与签名者地址相同")
+		}
+	}
+}
+
+// TestMultisigSpend 2-of-3的国库out需要任意两位负责人签名才能花费
+func TestMultisigSpend(t *testing.T) {
+	bc := newTestChain(t)
+	lock, err := NewTreasuryLock(addrA, addrB, addrC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb := lockedCoinbase(t, *NewMultisigOutput(10, lock))
+	addTestBlock(t, bc, cb)
+	prevTXs := map[string]Transaction{hex.EncodeToString(cb.ID): *cb}
+
+	keyD, _ := crypto.HexToECDSA("4444444444444444444444444444444444444444444444444444444444444444")
+	newSpend := func(t *testing.T) *Transaction {
+		tx, err := NewMultisigSpend(lock, map[common.Address]Amount{addrB: 6}, bc.FindUTXOutputs2(), TxBuildOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	// signBy 负责人依次用自己的私钥补充签名
+	signBy := func(t *testing.T, tx *Transaction, keys ...*ecdsa.PrivateKey) {
+		t.Helper()
+		for _, key := range keys {
+			if err := tx.SignWithKeyring(NewKeyring(key), prevTXs); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.VerifyPartial(prevTXs); err != nil {
+				t.Fatalf("补充签名后 VerifyPartial = %v", err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		signed func(t *testing.T, tx *Transaction)
+		want   error
+	}{
+		{"A and B", func(t *testing.T, tx *Transaction) { signBy(t, tx, keyA, keyB) }, nil},
+		{"C then A", func(t *testing.T, tx *Transaction) { signBy(t, tx, keyC, keyA) }, nil},
+		{"B and C", func(t *testing.T, tx *Transaction) { signBy(t, tx, keyB, keyC) }, nil},
+		// 已有两个签名后第三位负责人不再签名
+		{"all three", func(t *testing.T, tx *Transaction) { signBy(t, tx, keyA, keyB, keyC) }, nil},
+		{"unsigned", func(t *testing.T, tx *Transaction) {}, ErrInvalidSignature},
+		{"only A", func(t *testing.T, tx *Transaction) { signBy(t, tx, keyA) }, ErrInvalidSignature},
+		{"duplicate signer", func(t *testing.T, tx *Transaction) {
+			signBy(t, tx, keyA)
+			tx.Vin[0].Signatures = append(tx.Vin[0].Signatures, tx.Vin[0].Signatures[0])
+		}, ErrInvalidSignature},
+		{"unsorted signatures", func(t *testing.T, tx *Transaction) {
+			signBy(t, tx, keyA, keyB)
+			s := tx.Vin[0].Signatures
+			s[0], s[1] = s[1], s[0]
+		}, ErrInvalidSignature},
+		{"extra signature", func(t *testing.T, tx *Transaction) {
+			signBy(t, tx, keyA, keyB)
+			other := newSpend(t)
+			signBy(t, other, keyC)
+			tx.Vin[0].Signatures = append(tx.Vin[0].Signatures, other.Vin[0].Signatures[0])
+		}, ErrInvalidSignature},
+		{"signature in Signature field", func(t *testing.T, tx *Transaction) {
+			signBy(t, tx, keyA, keyB)
+			tx.Vin[0].Signature = tx.Vin[0].Signatures[0]
+		}, ErrInvalidSignature},
+		{"outsider", func(t *testing.T, tx *Transaction) {
+			signBy(t, tx, keyA)
+			tx.Vin[0].Signatures = nil
+			if err := tx.SignWithKeyring(NewKeyring(keyD), prevTXs); !errors.Is(err, ErrNoMultisigKey) {
+				t.Fatalf("非负责人签名: %v，期望 %v", err, ErrNoMultisigKey)
+			}
+		}, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newSpend(t)
+			tt.signed(t, tx)
+			tx.ID = tx.Hash()
+			if err := tx.Verify(prevTXs); !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v，期望 %v", err, tt.want)
+			}
+			if err := bc.ValidateTransaction(tx); !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+
+	// 找零转回同一个多签地址，仍然由同一个锁定条件锁定
+	tx := newSpend(t)
+	signBy(t, tx, keyA, keyC)
+	tx.ID = tx.Hash()
+	addTestBlock(t, bc, tx)
+	change := tx.Vout[len(tx.Vout)-1]
+	if change.Address != lock.Address() || !lock.Equal(change.Multisig) || change.Value != 4 {
+		t.Fatalf("找零 %+v，期望转回多签地址 4", change)
+	}
+}
+
+func TestMultisigOutputValidation(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 10)
+	addTestBlock(t, bc, cb)
+	lock, _ := NewMultisigLock(2, addrA, addrB)
+
+	tests := []struct {
+		name string
+		out  func() TXOutput
+	}{
+		{"address is not lock address", func() TXOutput {
+			out := *NewMultisigOutput(10, lock)
+			out.Address = addrA
+			return out
+		}},
+		{"invalid lock", func() TXOutput {
+			return *NewMultisigOutput(10, &MultisigLock{Required: 3, Addresses: []common.Address{addrA, addrB}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.ValidateTransaction(testSpend(t, cb, 0, tt.out()))
+			if !errors.Is(err, ErrInvalidMultisig) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, ErrInvalidMultisig)
+			}
+		})
+	}
+}
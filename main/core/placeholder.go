@@ -0,0 +1,265 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 占位交易
+// 结果还不确定的跨区转账先用占位交易(Type 5)在区块中预留一个位置，占位交易所在的区块高度与位置就是它的坐标
+// 占位交易的out与ToTran交易一样来自轻计算区，需要协调者签名授权，见coordinator.go
+// 占位交易的out会进入UTXO集合与地址索引，但处于待定状态：计入待定余额，不能被花费
+// 转账结果确定后，由结算区块之前生效的协调者签名结算交易(Type 6)，按坐标与交易ID引用占位交易：
+// 填充后占位交易的out变为普通的out，从下一个区块开始可以花费；作废后占位交易的out从UTXO集合中删除
+// 每个占位交易只能结算一次，结算交易必须在占位交易之后的区块中
+
+const (
+	placeholderTxType = 5 // 占位交易
+	settlementTxType  = 6 // 结算交易
+)
+
+// maxPlaceholderReference 占位交易引用的跨区转账标识的最大长度
+const maxPlaceholderReference = 64
+
+var (
+	ErrPendingOutput      = errors.New("input花费的out属于还没有结算的占位交易")
+	ErrInvalidPlaceholder = errors.New("占位交易格式错误")
+	ErrInvalidSettlement  = errors.New("结算交易无效")
+	ErrSlotSettled        = errors.New("占位交易已经结算")
+)
+
+// SlotRef 结算交易引用的占位交易坐标与结算结果
+// 坐标在重组后可能指向另一个交易，所以同时引用占位交易ID，签名覆盖两者，结算交易只能结算签名时的那个占位交易
+type SlotRef struct {
+	Height uint64 // 占位交易所在区块的高度
+	Index  int    // 占位交易在区块中的位置
+	Txid   []byte // 占位交易的ID
+	Fill   bool   // true表示转账完成，占位交易的out变为可以花费；false表示转账失败，占位交易的out作废
+}
+
+// IsPlaceholder 判断交易是否是占位交易
+func (tx Transaction) IsPlaceholder() bool {
+	return tx.Type == placeholderTxType
+}
+
+// IsSettlement 判断交易是否是结算交易
+func (tx Transaction) IsSettlement() bool {
+	return tx.Type == settlementTxType
+}
+
+// NewPlaceholderTX 为结果还不确定的跨区转账构造占位交易，预留从轻计算区的FromAddress转给Address的Money
+// Reference是跨区转账的标识，例如两阶段提交的转账ID，保证不同转账的占位交易ID不同
+// 与Coinbase交易一样只有一个不引用out的input，input的Address是FromAddress，Preimage字段保存Reference，Signature字段留给协调者的授权签名
+// 构造的交易还没有授权，需要协调者调用Authorize签名后才能上链；之后只有协调者可以结算它
+func NewPlaceholderTX(FromAddress common.Address, Address common.Address, Money Amount, Reference []byte, UserAccount string) (*Transaction, error) {
+	err := CheckOutputAmount(Money)
+	if err != nil {
+		return nil, err
+	}
+	if len(Reference) > maxPlaceholderReference {
+		return nil, fmt.Errorf("! 跨区转账标识长度 %d 超过 %d: %w", len(Reference), maxPlaceholderReference, ErrInvalidPlaceholder)
+	}
+
+	TX := Transaction{
+		Vin: []TXInput{{
+			Txid:     nil,
+			Vout:     -1,
+			Address:  FromAddress,
+			IsToTran: true, // 钱来自轻计算区
+			Preimage: Reference,
+		}},
+		Vout:    []TXOutput{*NewTXOutput(Money, Address)},
+		Type:    placeholderTxType, // 5表示占位交易
+		Account: UserAccount,
+	}
+	TX.ID = TX.Hash()
+	return &TX, nil
+}
+
+// NewSettlementTX 结算slot引用的占位交易，key是协调者的私钥
+// 结算交易只有一个不引用out的input，签名覆盖包括结算结果在内的整个交易，没有output
+func NewSettlementTX(slot SlotRef, key *ecdsa.PrivateKey) (*Transaction, error) {
+	TX := Transaction{
+		Vin: []TXInput{{
+			Txid:    nil,
+			Vout:    -1,
+			Address: crypto.PubkeyToAddress(key.PublicKey),
+		}},
+		Type: settlementTxType, // 6表示结算交易
+		Slot: &slot,
+	}
+	signature, err := crypto.Sign(TX.settlementHash(), key)
+	if err != nil {
+		return nil, err
+	}
+	TX.Vin[0].Signature = signature
+	TX.ID = TX.Hash()
+	return &TX, nil
+}
+
+// settlementHash 结算交易的签名哈希，sha256(TrimmedCopy的规范编码)
+func (tx *Transaction) settlementHash() []byte {
+	txCopy := tx.TrimmedCopy()
+	hash := sha256.Sum256(EncodeTransaction(&txCopy))
+	return hash[:]
+}
+
+// checkPlaceholder 检查占位交易的格式：只有一个不引用out的input，标识不超过最大长度，out不能转到轻计算区
+func checkPlaceholder(tx *Transaction) error {
+	if !tx.IsCoinbase() || len(tx.Vin[0].Preimage) > maxPlaceholderReference || tx.Slot != nil {
+		return ErrInvalidPlaceholder
+	}
+	for _, out := range tx.Vout {
+		if out.IsUse {
+			return ErrInvalidPlaceholder
+		}
+	}
+	return nil
+}
+
+// findPlaceholder 按坐标查询主链上的占位交易，坐标处的交易ID必须是slot引用的占位交易ID
+func findPlaceholder(dbTx *bolt.Tx, slot *SlotRef) (*Transaction, error) {
+	if slot.Index < 0 {
+		return nil, fmt.Errorf("! 坐标 (%d, %d) 无效: %w", slot.Height, slot.Index, ErrInvalidSettlement)
+	}
+	txid := dbTx.Bucket([]byte(txCoordBucket)).Get(txCoordKey(int(slot.Height), slot.Index))
+	if txid == nil {
+		return nil, fmt.Errorf("! 坐标 (%d, %d) 没有交易: %w", slot.Height, slot.Index, ErrInvalidSettlement)
+	}
+	if !bytes.Equal(txid, slot.Txid) {
+		return nil, fmt.Errorf("! 坐标 (%d, %d) 的交易不是 %x: %w", slot.Height, slot.Index, slot.Txid, ErrInvalidSettlement)
+	}
+	v := dbTx.Bucket([]byte(txIndexBucket)).Get(txid)
+	if v == nil {
+		return nil, fmt.Errorf("! 交易 %x 不在交易索引中", txid)
+	}
+	loc := DeserializeTxLocation(v)
+	block, err := readBlock(dbTx, loc.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if loc.Index >= len(block.Body.Transactions) {
+		return nil, errors.New("Transaction index is corrupted")
+	}
+	tx := block.Body.Transactions[loc.Index]
+	if !tx.IsPlaceholder() {
+		return nil, fmt.Errorf("! 坐标 (%d, %d) 的交易不是占位交易: %w", slot.Height, slot.Index, ErrInvalidSettlement)
+	}
+	return tx, nil
+}
+
+// isUnsettled 占位交易的out还在chainstate中并且处于待定状态
+func isUnsettled(utxo *bolt.Bucket, txid []byte) bool {
+	data := utxo.Get(txid)
+	if data == nil {
+		return false
+	}
+	outs := DeserializeUTXOutputs(data)
+	return len(outs.Outputs) != 0 && outs.Outputs[0].Pending
+}
+
+// validateSettlement 在view的基础上验证结算交易：引用的占位交易存在且还没有结算，签名者是结算区块之前生效的协调者
+// 结算者不取自占位交易：占位交易的input只是付款地址，结算权限只属于协调者
+func validateSettlement(tx *Transaction, view *utxoView) error {
+	if !tx.IsCoinbase() || len(tx.Vout) != 0 || tx.Slot == nil {
+		return ErrInvalidSettlement
+	}
+	placeholder, err := findPlaceholder(view.dbTx, tx.Slot)
+	if err != nil {
+		return err
+	}
+	if view.settled[string(placeholder.ID)] || !isUnsettled(view.b, placeholder.ID) {
+		return ErrSlotSettled
+	}
+
+	hash := tx.settlementHash()
+	err = verifyCoordinatorSignature(view.dbTx, view.lock.Height, hash, tx.Vin[0].Signature)
+	if err != nil {
+		return fmt.Errorf("! 结算交易: %v: %w", err, ErrInvalidSignature)
+	}
+	if signer, _ := recoverSigner(hash, tx.Vin[0].Signature); signer != tx.Vin[0].Address {
+		return fmt.Errorf("! 结算交易的地址 %x 不是签名者: %w", tx.Vin[0].Address, ErrInvalidSignature)
+	}
+
+	view.settled[string(placeholder.ID)] = true
+	return nil
+}
+
+// applySettlement 把结算交易应用到chainstate与地址索引
+// 填充时把占位交易的out改为普通的out，返回占位交易ID用于回滚；作废时删除这些out，返回被删除的out用于回滚
+func applySettlement(dbTx *bolt.Tx, tx *Transaction) ([]SpentOutput, []byte, error) {
+	utxo := dbTx.Bucket([]byte(utxoBucket))
+	ai := dbTx.Bucket([]byte(addrIndexBucket))
+
+	placeholder, err := findPlaceholder(dbTx, tx.Slot)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := utxo.Get(placeholder.ID)
+	if data == nil {
+		return nil, nil, fmt.Errorf("! 占位交易 %x 的out不在UTXO集合中", placeholder.ID)
+	}
+	outs := DeserializeUTXOutputs(data)
+
+	if tx.Slot.Fill {
+		for i := range outs.Outputs {
+			outs.Outputs[i].Pending = false
+		}
+		return nil, placeholder.ID, utxo.Put(placeholder.ID, outs.Serialize())
+	}
+
+	var spent []SpentOutput
+	for _, out := range outs.Outputs {
+		spent = append(spent, SpentOutput{Txid: placeholder.ID, Out: out, Height: outs.Height, TxIndex: outs.TxIndex})
+		err := unindexAddressOutput(ai, out.Address, placeholder.ID, out.Outid)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return spent, nil, utxo.Delete(placeholder.ID)
+}
+
+// unfillPlaceholders 回滚区块时把区块中结算交易填充的占位交易的out恢复为待定状态
+func unfillPlaceholders(utxo *bolt.Bucket, filled [][]byte) error {
+	for _, txid := range filled {
+		data := utxo.Get(txid)
+		if data == nil {
+			return fmt.Errorf("! 占位交易 %x 的out不在UTXO集合中", txid)
+		}
+		outs := DeserializeUTXOutputs(data)
+		for i := range outs.Outputs {
+			outs.Outputs[i].Pending = true
+		}
+		err := utxo.Put(txid, outs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindPlaceholder 按坐标与交易ID查询占位交易，返回交易以及它是否已经结算
+func (bc *BlockChain) FindPlaceholder(slot SlotRef) (*Transaction, bool, error) {
+	var tx *Transaction
+	settled := false
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		var err error
+		tx, err = findPlaceholder(dbTx, &slot)
+		if err != nil {
+			return err
+		}
+		settled = !isUnsettled(dbTx.Bucket([]byte(utxoBucket)), tx.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return tx, settled, nil
+}
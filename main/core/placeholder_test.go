@@ -0,0 +1,173 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newTestPlaceholder 上链一个协调者授权的、预留给addrB的占位交易，返回交易与它的坐标
+func newTestPlaceholder(t *testing.T, bc *BlockChain, value Amount) (*Transaction, SlotRef) {
+	t.Helper()
+	tx, err := NewPlaceholderTX(addrA, addrB, value, []byte("transfer-1"), "light")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, tx)
+	loc, err := bc.FindTxLocation(tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx, SlotRef{Height: uint64(loc.Height), Index: loc.Index, Txid: tx.ID}
+}
+
+// testSettlement 协调者对slot的结算交易，fill为结算结果
+func testSettlement(t *testing.T, slot SlotRef, fill bool) *Transaction {
+	t.Helper()
+	slot.Fill = fill
+	tx, err := NewSettlementTX(slot, testCoordinatorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestPlaceholderAuthorization(t *testing.T) {
+	bc := newTestChain(t)
+	if _, err := NewPlaceholderTX(addrA, addrB, 5, make([]byte, maxPlaceholderReference+1), ""); !errors.Is(err, ErrInvalidPlaceholder) {
+		t.Fatalf("NewPlaceholderTX = %v，期望 %v", err, ErrInvalidPlaceholder)
+	}
+
+	tests := []struct {
+		name  string
+		build func() *Transaction
+		want  error
+	}{
+		{"unsigned", func() *Transaction {
+			tx, _ := NewPlaceholderTX(addrA, addrB, 5, []byte("r"), "")
+			return tx
+		}, ErrUnauthorizedMint},
+		{"signed by other", func() *Transaction {
+			tx, _ := NewPlaceholderTX(addrA, addrB, 5, []byte("r"), "")
+			tx.Authorize(keyA)
+			return tx
+		}, ErrUnauthorizedMint},
+		{"output to light", func() *Transaction {
+			tx, _ := NewPlaceholderTX(addrA, addrB, 5, []byte("r"), "")
+			tx.Vout[0].IsUse = true
+			tx.Authorize(testCoordinatorKey)
+			return tx
+		}, ErrInvalidPlaceholder},
+		{"authorized", func() *Transaction {
+			tx, _ := NewPlaceholderTX(addrA, addrB, 5, []byte("r"), "")
+			tx.Authorize(testCoordinatorKey)
+			return tx
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bc.AddBlock(newTestBlock(t, bc, tt.build())); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettlePlaceholder(t *testing.T) {
+	tests := []struct {
+		name    string
+		settle  func(t *testing.T, slot SlotRef) *Transaction
+		want    error
+		balance Amount // 结算后addrB可以花费的余额
+		pending Amount // 结算后addrB的待定余额
+		utxo    bool   // 结算后占位交易的out是否还在UTXO集合中
+	}{
+		{"fill", func(t *testing.T, slot SlotRef) *Transaction {
+			return testSettlement(t, slot, true)
+		}, nil, 5, 0, true},
+		{"void", func(t *testing.T, slot SlotRef) *Transaction {
+			return testSettlement(t, slot, false)
+		}, nil, 0, 0, false},
+		{"signed by recipient", func(t *testing.T, slot SlotRef) *Transaction {
+			slot.Fill = true
+			tx, _ := NewSettlementTX(slot, keyB)
+			return tx
+		}, ErrInvalidSignature, 0, 5, true},
+		{"wrong txid", func(t *testing.T, slot SlotRef) *Transaction {
+			slot.Txid = []byte("other")
+			return testSettlement(t, slot, true)
+		}, ErrInvalidSettlement, 0, 5, true},
+		{"wrong coordinate", func(t *testing.T, slot SlotRef) *Transaction {
+			slot.Index++
+			return testSettlement(t, slot, true)
+		}, ErrInvalidSettlement, 0, 5, true},
+		{"with output", func(t *testing.T, slot SlotRef) *Transaction {
+			tx := testSettlement(t, slot, true)
+			tx.Vout = []TXOutput{*NewTXOutput(1, addrC)}
+			tx.ID = tx.Hash()
+			return tx
+		}, ErrInvalidSettlement, 0, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t)
+			placeholder, slot := newTestPlaceholder(t, bc, 5)
+
+			if err := bc.AddBlock(newTestBlock(t, bc, tt.settle(t, slot))); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+			result, err := bc.FindAddressUTXOs([]common.Address{addrB})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if au := result[addrB]; au.Balance != tt.balance || au.Pending != tt.pending {
+				t.Fatalf("余额 %d，待定 %d，期望 %d, %d", au.Balance, au.Pending, tt.balance, tt.pending)
+			}
+			if _, ok := utxoEntry(t, bc, placeholder.ID); ok != tt.utxo {
+				t.Fatalf("占位交易的out在UTXO集合中: %v，期望 %v", ok, tt.utxo)
+			}
+			if _, settled, err := bc.FindPlaceholder(slot); err != nil || settled != (tt.want == nil) {
+				t.Fatalf("FindPlaceholder settled = %v, %v", settled, err)
+			}
+		})
+	}
+}
+
+func TestPlaceholderSpendAndSettleOnce(t *testing.T) {
+	bc := newTestChain(t)
+	placeholder, slot := newTestPlaceholder(t, bc, 5)
+	spend := testSpend(t, placeholder, 0, *NewTXOutput(5, addrC))
+
+	// 结算之前不能花费，同一个区块中也不能先结算再花费
+	if err := bc.AddBlock(newTestBlock(t, bc, spend)); !errors.Is(err, ErrPendingOutput) {
+		t.Fatalf("花费待定的out: %v，期望 %v", err, ErrPendingOutput)
+	}
+	fill := testSettlement(t, slot, true)
+	if err := bc.AddBlock(newTestBlock(t, bc, fill, spend)); !errors.Is(err, ErrPendingOutput) {
+		t.Fatalf("同一个区块中花费刚填充的out: %v，期望 %v", err, ErrPendingOutput)
+	}
+	if err := bc.AddBlock(newTestBlock(t, bc, fill, testSettlement(t, slot, false))); !errors.Is(err, ErrSlotSettled) {
+		t.Fatalf("同一个区块中结算两次: %v，期望 %v", err, ErrSlotSettled)
+	}
+
+	fillBlock := addTestBlock(t, bc, fill)
+	if err := bc.AddBlock(newTestBlock(t, bc, testSettlement(t, slot, false))); !errors.Is(err, ErrSlotSettled) {
+		t.Fatalf("再次结算: %v，期望 %v", err, ErrSlotSettled)
+	}
+	addTestBlock(t, bc, spend)
+
+	// 回滚填充的区块后占位交易的out恢复为待定状态
+	fillHash, _ := fillBlock.Hash()
+	addTestBranch(t, bc, fillBlock.Header.PrevBlock, testCoinbase(t, addrC, 1), testCoinbase(t, addrC, 2), testCoinbase(t, addrC, 3))
+	if bc.IsMainChain(fillHash) {
+		t.Fatal("填充的区块仍在主链上")
+	}
+	outs, ok := utxoEntry(t, bc, placeholder.ID)
+	if !ok || !outs.Outputs[0].Pending {
+		t.Fatalf("回滚后占位交易的out %+v，期望待定", outs)
+	}
+}
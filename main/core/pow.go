@@ -0,0 +1,292 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 工作量证明
+// 区块头中的Bits是压缩编码的难度目标，区块哈希按大端整数解释后必须不大于目标，出块节点通过改变Nonce寻找满足目标的哈希
+// 压缩编码与比特币相同：最高字节是以字节计的长度，低3字节是有效数字，目标 = 有效数字 · 256^(长度-3)
+// 每retargetInterval个区块按实际出块时间调整一次难度，使平均出块间隔接近targetBlockSpacing，每次最多调整maxRetargetFactor倍
+// 区块的时间戳必须晚于前面medianTimeBlocks个区块时间戳的中位数，并且不能超前本地时间maxFutureBlockTime
+// 每个区块的工作量是 2^256 / (目标+1)，主链是累计工作量最大的分支
+// 创世区块与旧版本数据库中的区块没有Bits，不检查工作量证明，工作量按最低难度计算
+
+const (
+	powLimitBits       uint32 = 0x1f00ffff // 最低难度，测试网络上普通计算机几十毫秒就能找到满足目标的哈希
+	targetBlockSpacing        = 10         // 目标出块间隔，秒
+	retargetInterval          = 20         // 每隔多少个区块调整一次难度
+	maxRetargetFactor         = 4          // 每次难度调整的最大倍数
+	maxFutureBlockTime        = 2 * 60 * 60
+
+	chainWorkBucket = "chainwork" // 区块哈希 -> 从创世区块到该区块的累计工作量，大端编码
+)
+
+var (
+	ErrInsufficientWork = errors.New("区块哈希值不满足难度目标")
+	ErrBadDifficulty    = errors.New("区块的难度目标与难度调整规则不符")
+	ErrBlockTime        = errors.New("区块时间戳早于前面区块时间戳的中位数或超前本地时间太多")
+)
+
+var powLimit = CompactToBig(powLimitBits)
+
+// CompactToBig 把压缩编码的难度目标转换为整数，符号位为1时是负数
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		n = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		n = big.NewInt(mantissa)
+		n.Lsh(n, 8*(exponent-3))
+	}
+	if negative {
+		n.Neg(n)
+	}
+	return n
+}
+
+// BigToCompact 把非负的难度目标转换为压缩编码，低位超出3字节有效数字的部分被截断
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(n, 8*(exponent-3)).Bits()[0])
+	}
+	// 有效数字的最高位是符号位，需要多用一个字节
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent<<24) | mantissa
+}
+
+// blockTarget 区块头的难度目标，目标必须为正数并且不能低于最低难度
+func blockTarget(bits uint32) (*big.Int, error) {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return nil, fmt.Errorf("! 难度目标 %08x: %w", bits, ErrBadDifficulty)
+	}
+	return target, nil
+}
+
+// blockWork 难度目标为bits的区块的工作量 2^256 / (目标+1)，没有Bits的区块按最低难度计算
+func blockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if bits == 0 || target.Sign() <= 0 {
+		target = powLimit
+	}
+	work := new(big.Int).Lsh(big.NewInt(1), 256)
+	return work.Div(work, new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// checkProofOfWork 区块哈希不大于区块头中的难度目标
+func checkProofOfWork(header *Header, hash []byte) error {
+	target, err := blockTarget(header.Bits)
+	if err != nil {
+		return ErrBadDifficulty
+	}
+	if new(big.Int).SetBytes(hash).Cmp(target) > 0 {
+		return ErrInsufficientWork
+	}
+	return nil
+}
+
+// requiredBits 接在parent之后的区块需要的难度目标
+// 高度是retargetInterval的整数倍时，按前retargetInterval个区块的实际出块时间调整，其余区块沿用parent的难度
+func requiredBits(dbTx *bolt.Tx, parent *Header) (uint32, error) {
+	if parent.Bits == 0 {
+		return powLimitBits, nil
+	}
+	if (parent.Height+1)%retargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	// 找到调整周期内的第一个区块，周期内的区块之间有retargetInterval-1个间隔
+	first := parent
+	for i := 0; i < retargetInterval-1 && len(first.PrevBlock) != 0; i++ {
+		var err error
+		first, err = readHeader(dbTx, first.PrevBlock)
+		if err != nil {
+			return 0, err
+		}
+	}
+	expected := int64(retargetInterval-1) * targetBlockSpacing
+	actual := parent.TimeStamp - first.TimeStamp
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+
+	target := CompactToBig(parent.Bits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(powLimit) > 0 {
+		target = powLimit
+	}
+	return BigToCompact(target), nil
+}
+
+// checkBlockHeader 检查区块头的工作量证明、难度目标与时间戳，创世区块不检查
+// 前一个区块必须已知，否则返回ErrOrphanBlock
+func checkBlockHeader(dbTx *bolt.Tx, header *Header, hash []byte) error {
+	fail := func(err error) error {
+		return &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: err}
+	}
+	if len(header.PrevBlock) == 0 {
+		return nil
+	}
+	parent, err := readHeader(dbTx, header.PrevBlock)
+	if err != nil {
+		return fail(ErrOrphanBlock)
+	}
+
+	bits, err := requiredBits(dbTx, parent)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return fail(ErrBadDifficulty)
+	}
+	if err := checkProofOfWork(header, hash); err != nil {
+		return fail(err)
+	}
+
+	lock, err := lockContextAfter(dbTx, header.PrevBlock)
+	if err != nil {
+		return err
+	}
+	if header.TimeStamp <= lock.Time || header.TimeStamp > time.Now().Unix()+maxFutureBlockTime {
+		return fail(ErrBlockTime)
+	}
+	return nil
+}
+
+// prepareHeader 按难度调整规则与时间戳规则填写接在header.PrevBlock之后的区块头的Bits与TimeStamp
+func prepareHeader(dbTx *bolt.Tx, header *Header) error {
+	parent, err := readHeader(dbTx, header.PrevBlock)
+	if err != nil {
+		return err
+	}
+	header.Bits, err = requiredBits(dbTx, parent)
+	if err != nil {
+		return err
+	}
+	header.TimeStamp, err = nextBlockTime(dbTx, header.PrevBlock)
+	return err
+}
+
+// PrepareHeader 为自己组装的区块填写难度目标与时间戳，之后还需要调用Mine，NewBlockTemplate组装的区块已经填写好
+// 前一个区块必须已知
+func (bc *BlockChain) PrepareHeader(block *Block) error {
+	return bc.db.View(func(dbTx *bolt.Tx) error {
+		return prepareHeader(dbTx, block.Header)
+	})
+}
+
+// nextBlockTime 接在prevHash之后的新区块的时间戳：本地时间，但至少比前面区块时间戳的中位数晚1秒
+func nextBlockTime(dbTx *bolt.Tx, prevHash []byte) (int64, error) {
+	lock, err := lockContextAfter(dbTx, prevHash)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	if now <= lock.Time {
+		now = lock.Time + 1
+	}
+	return now, nil
+}
+
+// chainWork 从创世区块到hash的累计工作量
+// 旧版本数据库中的区块没有记录累计工作量，向前找到有记录的区块后依次计算并保存
+func chainWork(dbTx *bolt.Tx, hash []byte) (*big.Int, error) {
+	cw := dbTx.Bucket([]byte(chainWorkBucket))
+
+	var headers []*Header
+	var hashes [][]byte
+	work := new(big.Int)
+	for len(hash) != 0 {
+		if v := cw.Get(hash); v != nil {
+			work.SetBytes(v)
+			break
+		}
+		header, err := readHeader(dbTx, hash)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, header)
+		hashes = append(hashes, hash)
+		hash = header.PrevBlock
+	}
+
+	for i := len(headers) - 1; i >= 0; i-- {
+		work.Add(work, blockWork(headers[i].Bits))
+		err := cw.Put(hashes[i], work.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return work, nil
+}
+
+// Mine 寻找使区块哈希满足难度目标的Nonce，需要在交易、默克尔根与变色龙随机数确定之后调用
+// ctx被取消时返回ctx.Err()，区块头的Nonce保持调用前的值
+func (b *Block) Mine(ctx context.Context) error {
+	target, err := blockTarget(b.Header.Bits)
+	if err != nil {
+		return err
+	}
+	// 变色龙模式下参与哈希计算的区块头只需要计算一次，之后只改变Nonce
+	header, err := b.Header.hashedHeader()
+	if err != nil {
+		return err
+	}
+	hashed := *header
+
+	hashInt := new(big.Int)
+	for nonce := uint64(0); ; nonce++ {
+		if nonce%(1<<14) == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		hashed.Nonce = nonce
+		hash := sha256.Sum256(EncodeHeader(&hashed))
+		if hashInt.SetBytes(hash[:]).Cmp(target) <= 0 {
+			b.Header.Nonce = nonce
+			return nil
+		}
+		if nonce == ^uint64(0) {
+			return ErrInsufficientWork
+		}
+	}
+}
+
+// ChainWork 从创世区块到hash的累计工作量
+func (bc *BlockChain) ChainWork(hash []byte) (*big.Int, error) {
+	var work *big.Int
+	err := bc.db.Update(func(dbTx *bolt.Tx) error {
+		var err error
+		work, err = chainWork(dbTx, hash)
+		return err
+	})
+	return work, err
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		bits   uint32
+		target string // 十六进制
+	}{
+		{"pow limit", powLimitBits, "ffff" + strings.Repeat("0", 56)},
+		{"bitcoin genesis", 0x1d00ffff, "ffff" + strings.Repeat("0", 52)},
+		{"three bytes", 0x03123456, "123456"},
+		// 有效数字的最高位是符号位，0x80需要多用一个字节
+		{"sign bit", 0x02008000, "80"},
+		{"sign bit shifted", 0x05009234, "92340000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, _ := new(big.Int).SetString(tt.target, 16)
+			if got := CompactToBig(tt.bits); got.Cmp(want) != 0 {
+				t.Fatalf("CompactToBig(%08x) = %x，期望 %x", tt.bits, got, want)
+			}
+			if got := BigToCompact(want); got != tt.bits {
+				t.Fatalf("BigToCompact(%x) = %08x，期望 %08x", want, got, tt.bits)
+			}
+		})
+	}
+
+	if CompactToBig(0x04923456).Sign() >= 0 {
+		t.Fatal("符号位为1的压缩编码应该是负数")
+	}
+}
+
+func TestBlockTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		bits uint32
+		want error
+	}{
+		{"pow limit", powLimitBits, nil},
+		{"harder", 0x1d00ffff, nil},
+		{"zero", 0, ErrBadDifficulty},
+		{"negative", 0x04923456, ErrBadDifficulty},
+		{"above limit", 0x2000ffff, ErrBadDifficulty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := blockTarget(tt.bits); !errors.Is(err, tt.want) {
+				t.Fatalf("blockTarget(%08x) = %v，期望 %v", tt.bits, err, tt.want)
+			}
+		})
+	}
+}
+
+// addTimedBranch 在prev之后依次写入n个区块，每个区块的时间戳比前一个区块晚spacing秒，返回最后一个区块的哈希
+// 不同分支的value不同，交易ID就不同
+func addTimedBranch(t *testing.T, bc *BlockChain, prev []byte, n int, spacing int64, value Amount) []byte {
+	t.Helper()
+	parent, err := bc.GetBlockByHash(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := parent.Header
+	for i := 0; i < n; i++ {
+		block := NewBlockWithTransactions(prev, header.Height+1, []*Transaction{testCoinbase(t, addrC, value+Amount(i))})
+		if err := bc.PrepareHeader(block); err != nil {
+			t.Fatal(err)
+		}
+		block.Header.TimeStamp = header.TimeStamp + spacing
+		mineBlock(t, block)
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		prev, _ = block.Hash()
+		header = block.Header
+	}
+	return prev
+}
+
+// TestRequiredBits 每retargetInterval个区块按实际出块时间调整难度，每次最多调整maxRetargetFactor倍，不能低于最低难度
+func TestRequiredBits(t *testing.T) {
+	expected := int64(retargetInterval-1) * targetBlockSpacing
+	scaled := func(actual int64) uint32 {
+		target := new(big.Int).Mul(powLimit, big.NewInt(actual))
+		return BigToCompact(target.Div(target, big.NewInt(expected)))
+	}
+	tests := []struct {
+		name    string
+		spacing int64
+		want    uint32
+	}{
+		{"on target", targetBlockSpacing, powLimitBits},
+		{"twice as fast", targetBlockSpacing / 2, scaled(expected / 2)},
+		{"clamped harder", 1, scaled(expected / maxRetargetFactor)},
+		{"capped at limit", targetBlockSpacing * 10, powLimitBits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t)
+			tip := addTimedBranch(t, bc, bc.tip, retargetInterval-1, tt.spacing, 1)
+
+			// 调整周期内的其他区块沿用前一个区块的难度
+			err := bc.db.View(func(dbTx *bolt.Tx) error {
+				parent, err := readHeader(dbTx, tip)
+				if err != nil {
+					return err
+				}
+				prev, err := readHeader(dbTx, parent.PrevBlock)
+				if err != nil {
+					return err
+				}
+				if bits, err := requiredBits(dbTx, prev); err != nil || bits != powLimitBits {
+					t.Errorf("高度 %d 之后的难度 %08x, %v，期望 %08x", prev.Height, bits, err, powLimitBits)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			block := mineTestBlock(t, bc, NewBlockWithTransactions(tip, retargetInterval, []*Transaction{testCoinbase(t, addrA, 1)}))
+			if block.Header.Bits != tt.want {
+				t.Fatalf("高度 %d 的难度 %08x，期望 %08x", retargetInterval, block.Header.Bits, tt.want)
+			}
+			if err := bc.AddBlock(block); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestCheckBlockHeader 难度目标、工作量证明或时间戳不符的区块被拒绝，区块链不变
+func TestCheckBlockHeader(t *testing.T) {
+	bc := newTestChain(t)
+	addTestBlock(t, bc, testCoinbase(t, addrA, 1))
+
+	tests := []struct {
+		name   string
+		modify func(h *Header)
+		remine bool
+		want   error
+	}{
+		{"valid", func(h *Header) {}, false, nil},
+		{"wrong bits", func(h *Header) { h.Bits = 0x1f007fff }, true, ErrBadDifficulty},
+		{"bits above limit", func(h *Header) { h.Bits = 0x2000ffff }, false, ErrBadDifficulty},
+		{"insufficient work", nil, false, ErrInsufficientWork},
+		{"before median time", func(h *Header) { h.TimeStamp = genesisTimeStamp }, true, ErrBlockTime},
+		{"too far in future", func(h *Header) { h.TimeStamp = time.Now().Unix() + maxFutureBlockTime + 60 }, true, ErrBlockTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := newTestBlock(t, bc, testCoinbase(t, addrB, 2))
+			if tt.modify != nil {
+				tt.modify(block.Header)
+			} else {
+				// 换一个不满足难度目标的Nonce
+				for {
+					block.Header.Nonce++
+					hash, _ := block.Hash()
+					if checkProofOfWork(block.Header, hash) != nil {
+						break
+					}
+				}
+			}
+			if tt.remine {
+				mineBlock(t, block)
+			}
+
+			height := bc.TipHeight()
+			err := bc.AddBlock(block)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+			if err != nil && bc.TipHeight() != height {
+				t.Fatal("无效的区块改变了区块链")
+			}
+		})
+	}
+}
+
+func TestMine(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		bits uint32
+		seal bool // 变色龙模式
+		want error
+	}{
+		{"pow limit", context.Background(), powLimitBits, false, nil},
+		{"chameleon", context.Background(), powLimitBits, true, nil},
+		{"canceled", canceled, 0x1d00ffff, false, context.Canceled},
+		{"bad bits", context.Background(), 0, false, ErrBadDifficulty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := NewBlockWithTransactions([]byte{1}, 1, []*Transaction{testCoinbase(t, addrA, 1)})
+			if tt.seal {
+				authority, _ := NewChameleonKey()
+				if err := block.SealChameleon(&authority.PublicKey); err != nil {
+					t.Fatal(err)
+				}
+			}
+			block.Header.Bits = tt.bits
+			block.Header.Nonce = 7
+			err := block.Mine(tt.ctx)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Mine = %v，期望 %v", err, tt.want)
+			}
+			if err != nil {
+				if block.Header.Nonce != 7 {
+					t.Fatal("Mine失败时改变了Nonce")
+				}
+				return
+			}
+			hash, _ := block.Hash()
+			if err := checkProofOfWork(block.Header, hash); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestChainSelectionByWork 主链是累计工作量最大的分支，不一定是最长的分支
+func TestChainSelectionByWork(t *testing.T) {
+	bc := newTestChain(t)
+	genesis := append([]byte{}, bc.tip...)
+
+	// 按目标间隔出块的分支难度不变，比另一个分支多一个区块
+	slow := addTimedBranch(t, bc, genesis, retargetInterval+1, targetBlockSpacing, 100)
+	if !bytes.Equal(bc.tip, slow) {
+		t.Fatal("没有选择唯一的分支")
+	}
+
+	// 出块很快的分支在调整难度前工作量较少，调整难度后的区块工作量约为4倍
+	fast := addTimedBranch(t, bc, genesis, retargetInterval-1, 1, 200)
+	if !bytes.Equal(bc.tip, slow) {
+		t.Fatal("切换到了工作量较少的分支")
+	}
+	fast = addTimedBranch(t, bc, fast, 1, 1, 300)
+	if !bytes.Equal(bc.tip, fast) || bc.TipHeight() != retargetInterval {
+		t.Fatalf("高度 %d，没有切换到累计工作量最大的分支", bc.TipHeight())
+	}
+
+	slowWork, err := bc.ChainWork(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fastWork, err := bc.ChainWork(fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fastWork.Cmp(slowWork) <= 0 {
+		t.Fatalf("分支工作量 %d 不大于 %d", fastWork, slowWork)
+	}
+}
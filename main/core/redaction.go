@@ -0,0 +1,368 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 区块改写
+// 变色龙模式的区块可以由持有陷门的改写机构改写区块中的交易，例如清除错误的跨区转账记录，区块哈希不变，后面的区块不受影响
+// 改写后从创世区块开始重新验证整条主链并重建UTXO集合与索引，改写导致任何区块无效时整个改写不会生效
+// 例如删除的交易的out已经被后面的交易花费时改写会被拒绝；删除有手续费的交易时需要同时修改奖励交易
+// 每次改写都在改写日志中留下由改写机构签名的记录，记录改写前后的默克尔根、随机数以及删除和新增的交易ID
+// 任何人都可以用区块头中的变色龙公钥验证记录，其他节点收到改写后的区块与记录后用ApplyRedaction执行同样的改写
+// 改写后被改写区块中交易的坐标与默克尔证明会改变，之前发给轻计算区的证明需要重新获取
+
+// redactionBucket 改写日志 8字节大端序号 -> RedactionRecord，改写日志无法从区块重新推导，不属于derivedBuckets
+const redactionBucket = "redactions"
+
+var (
+	ErrNotRedactable    = errors.New("区块不是主链上的变色龙模式区块，不能改写")
+	ErrInvalidRedaction = errors.New("改写记录无效")
+)
+
+// RedactionRecord 改写日志中的一条记录
+type RedactionRecord struct {
+	Seq           uint64   // 在本节点改写日志中的序号，从1开始，不参与签名
+	BlockHash     []byte   // 被改写的区块
+	Height        uint64   // 被改写区块的高度
+	ChameleonKey  []byte   // 区块头中的变色龙公钥，签名者
+	OldMerkleRoot []byte   // 改写前的默克尔根
+	NewMerkleRoot []byte   // 改写后的默克尔根
+	OldRandomness []byte   // 改写前的随机数
+	NewRandomness []byte   // 改写后的随机数
+	Removed       [][]byte // 改写前区块中有、改写后没有的交易ID
+	Added         [][]byte // 改写后区块中新出现的交易ID
+	Reason        string   // 改写原因
+	Time          int64    // 改写时间，Unix时间
+	Signature     []byte   // 改写机构用陷门私钥对signingHash的ECDSA签名
+}
+
+// signingHash 签名覆盖的内容，Seq除外的所有字段按规范编码后的sha256，前面加上"redaction"与变色龙哈希区分
+func (r *RedactionRecord) signingHash() []byte {
+	var e encoder
+	e.string("redaction")
+	e.bytes(r.BlockHash)
+	e.uvarint(r.Height)
+	e.bytes(r.ChameleonKey)
+	e.bytes(r.OldMerkleRoot)
+	e.bytes(r.NewMerkleRoot)
+	e.bytes(r.OldRandomness)
+	e.bytes(r.NewRandomness)
+	for _, ids := range [][][]byte{r.Removed, r.Added} {
+		e.uvarint(uint64(len(ids)))
+		for _, id := range ids {
+			e.bytes(id)
+		}
+	}
+	e.string(r.Reason)
+	e.varint(r.Time)
+	hash := sha256.Sum256(e.buf.Bytes())
+	return hash[:]
+}
+
+// Serialize serializes RedactionRecord
+func (r RedactionRecord) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(r)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeRedactionRecord deserializes RedactionRecord
+func DeserializeRedactionRecord(data []byte) (*RedactionRecord, error) {
+	var r RedactionRecord
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// VerifyRedaction 验证改写记录：改写前后的变色龙哈希相同，并且签名者是变色龙公钥
+func VerifyRedaction(r *RedactionRecord) error {
+	before, err := chameleonHash(r.ChameleonKey, r.OldMerkleRoot, r.OldRandomness)
+	if err != nil {
+		return err
+	}
+	after, err := chameleonHash(r.ChameleonKey, r.NewMerkleRoot, r.NewRandomness)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(before, after) {
+		return fmt.Errorf("! 区块 %x 改写前后的变色龙哈希不同: %w", r.BlockHash, ErrInvalidRedaction)
+	}
+
+	pub, err := crypto.SigToPub(r.signingHash(), r.Signature)
+	if err != nil || !bytes.Equal(crypto.CompressPubkey(pub), r.ChameleonKey) {
+		return fmt.Errorf("! 区块 %x 的改写记录不是由变色龙公钥签名的: %w", r.BlockHash, ErrInvalidRedaction)
+	}
+	return nil
+}
+
+// RedactBlock 改写机构把区块hash中的交易改写为txs，返回写入改写日志的记录
+// 区块必须是主链上使用key的公钥的变色龙模式区块，改写后的主链必须仍然有效
+func (bc *BlockChain) RedactBlock(hash []byte, txs []*Transaction, key *ecdsa.PrivateKey, reason string) (*RedactionRecord, error) {
+	block, err := bc.GetBlockByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if block.Header.HashMode != HashChameleon {
+		return nil, fmt.Errorf("! 区块 %x: %w", hash, ErrNotRedactable)
+	}
+	if !bytes.Equal(crypto.CompressPubkey(&key.PublicKey), block.Header.ChameleonKey) {
+		return nil, fmt.Errorf("! 陷门私钥与区块 %x 的变色龙公钥不符: %w", hash, ErrInvalidChameleon)
+	}
+
+	header := *block.Header
+	redacted := &Block{Header: &header, Body: &Body{Transactions: txs}}
+	redacted.Header.MerkelRoot = redacted.Body.MerkleRoot()
+	digest, err := chameleonHash(block.Header.ChameleonKey, block.Header.MerkelRoot, block.Header.Randomness)
+	if err != nil {
+		return nil, err
+	}
+	redacted.Header.Randomness, err = chameleonCollision(key, digest, redacted.Header.MerkelRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, added := diffTransactions(block, redacted)
+	record := &RedactionRecord{
+		BlockHash:     hash,
+		Height:        block.Header.Height,
+		ChameleonKey:  block.Header.ChameleonKey,
+		OldMerkleRoot: block.Header.MerkelRoot,
+		NewMerkleRoot: redacted.Header.MerkelRoot,
+		OldRandomness: block.Header.Randomness,
+		NewRandomness: redacted.Header.Randomness,
+		Removed:       removed,
+		Added:         added,
+		Reason:        reason,
+		Time:          time.Now().Unix(),
+	}
+	record.Signature, err = crypto.Sign(record.signingHash(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bc.ApplyRedaction(redacted, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ApplyRedaction 按改写记录把本地的区块替换为改写后的block，不需要陷门私钥，其他节点用它执行同样的改写
+// 记录必须有效，block必须与记录中改写后的内容一致，本地区块必须是记录中改写前的内容；已经执行过的改写直接返回
+func (bc *BlockChain) ApplyRedaction(block *Block, record *RedactionRecord) error {
+	err := VerifyRedaction(record)
+	if err != nil {
+		return err
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, record.BlockHash) || !bytes.Equal(block.Header.ChameleonKey, record.ChameleonKey) ||
+		!bytes.Equal(block.Header.MerkelRoot, record.NewMerkleRoot) || !bytes.Equal(block.Header.Randomness, record.NewRandomness) {
+		return fmt.Errorf("! 改写后的区块与改写记录不符: %w", ErrInvalidRedaction)
+	}
+
+	return bc.db.Update(func(dbTx *bolt.Tx) error {
+		old, err := readBlock(dbTx, hash)
+		if err != nil {
+			return err
+		}
+		if old.Header.HashMode != HashChameleon || !isMainChain(dbTx, hash, int(old.Header.Height)) {
+			return fmt.Errorf("! 区块 %x: %w", hash, ErrNotRedactable)
+		}
+		if bytes.Equal(old.Header.MerkelRoot, record.NewMerkleRoot) && bytes.Equal(old.Header.Randomness, record.NewRandomness) {
+			return nil
+		}
+		if !bytes.Equal(old.Header.MerkelRoot, record.OldMerkleRoot) || !bytes.Equal(old.Header.Randomness, record.OldRandomness) {
+			return fmt.Errorf("! 区块 %x 的当前内容不是改写记录中改写前的内容: %w", hash, ErrInvalidRedaction)
+		}
+		removed, added := diffTransactions(old, block)
+		if !equalIDs(removed, record.Removed) || !equalIDs(added, record.Added) {
+			return fmt.Errorf("! 改写记录中删除或新增的交易与区块不符: %w", ErrInvalidRedaction)
+		}
+		err = checkBlockSanity(block, hash)
+		if err != nil {
+			return err
+		}
+
+		err = dbTx.Bucket([]byte(blocksBucket)).Put(hash, block.Serialize())
+		if err != nil {
+			return err
+		}
+		err = replayMainChain(dbTx)
+		if err != nil {
+			return fmt.Errorf("! 改写区块 %x 后主链无效: %w", hash, err)
+		}
+
+		lb := dbTx.Bucket([]byte(redactionBucket))
+		record.Seq, err = lb.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, record.Seq)
+		return lb.Put(key, record.Serialize())
+	})
+}
+
+// diffTransactions 比较改写前后区块中的交易ID
+func diffTransactions(before *Block, after *Block) ([][]byte, [][]byte) {
+	ids := func(block *Block) map[string]bool {
+		set := make(map[string]bool)
+		for _, tx := range block.Body.Transactions {
+			set[string(tx.ID)] = true
+		}
+		return set
+	}
+	beforeIDs, afterIDs := ids(before), ids(after)
+
+	var removed, added [][]byte
+	for _, tx := range before.Body.Transactions {
+		if !afterIDs[string(tx.ID)] {
+			removed = append(removed, tx.ID)
+		}
+	}
+	for _, tx := range after.Body.Transactions {
+		if !beforeIDs[string(tx.ID)] {
+			added = append(added, tx.ID)
+		}
+	}
+	return removed, added
+}
+
+// equalIDs 判断两组交易ID是否按顺序相同
+func equalIDs(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// replayMainChain 清空派生bucket，从创世区块开始依次验证并应用主链上的区块
+// 与Reindex不同，每个区块都要重新通过validateBlock，需要在改写区块的同一个事务中调用
+func replayMainChain(dbTx *bolt.Tx) error {
+	b := dbTx.Bucket([]byte(blocksBucket))
+	heights := dbTx.Bucket([]byte(heightIndexBucket))
+
+	var hashes [][]byte
+	for height := 0; height <= getTipHeight(b); height++ {
+		hash := heights.Get(heightKey(height))
+		if hash == nil {
+			return fmt.Errorf("! 高度 %d 的区块不在高度索引中", height)
+		}
+		hashes = append(hashes, append([]byte{}, hash...))
+	}
+
+	for _, name := range derivedBuckets {
+		err := dbTx.DeleteBucket([]byte(name))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err = dbTx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+	}
+	err := b.Delete([]byte("l"))
+	if err != nil {
+		return err
+	}
+	err = b.Delete([]byte(tipHeightKey))
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		block, err := readBlock(dbTx, hash)
+		if err != nil {
+			return err
+		}
+		err = validateBlock(dbTx, block, hash)
+		if err != nil {
+			return err
+		}
+		err = connectBlock(dbTx, block, hash, int(block.Header.Height))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedactionLog 按序号返回改写日志中的所有记录
+func (bc *BlockChain) RedactionLog() ([]*RedactionRecord, error) {
+	var records []*RedactionRecord
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		return dbTx.Bucket([]byte(redactionBucket)).ForEach(func(k, v []byte) error {
+			r, err := DeserializeRedactionRecord(v)
+			if err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// VerifyRedactionLog 审计改写日志：每条记录有效，同一个区块的记录前后相接，区块的当前内容就是最后一次改写的结果
+func (bc *BlockChain) VerifyRedactionLog() error {
+	records, err := bc.RedactionLog()
+	if err != nil {
+		return err
+	}
+
+	latest := make(map[string]*RedactionRecord)
+	for _, r := range records {
+		err := VerifyRedaction(r)
+		if err != nil {
+			return fmt.Errorf("! 第 %d 条改写记录: %w", r.Seq, err)
+		}
+		if prev, ok := latest[string(r.BlockHash)]; ok {
+			if !bytes.Equal(prev.NewMerkleRoot, r.OldMerkleRoot) || !bytes.Equal(prev.NewRandomness, r.OldRandomness) {
+				return fmt.Errorf("! 第 %d 条改写记录与第 %d 条不相接: %w", r.Seq, prev.Seq, ErrInvalidRedaction)
+			}
+		}
+		latest[string(r.BlockHash)] = r
+	}
+
+	for _, r := range latest {
+		block, err := bc.GetBlockByHash(r.BlockHash)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(block.Header.MerkelRoot, r.NewMerkleRoot) || !bytes.Equal(block.Header.Randomness, r.NewRandomness) {
+			return fmt.Errorf("! 区块 %x 的内容与第 %d 条改写记录不符: %w", r.BlockHash, r.Seq, ErrInvalidRedaction)
+		}
+	}
+	return nil
+}
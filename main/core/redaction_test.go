@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// addSealedBlock 把txs打包成用变色龙公钥封装的区块并写入区块链
+func addSealedBlock(t *testing.T, bc *BlockChain, key *ecdsa.PublicKey, txs ...*Transaction) *Block {
+	t.Helper()
+	block := newTestBlock(t, bc, txs...)
+	if err := block.SealChameleon(key); err != nil {
+		t.Fatal(err)
+	}
+	mineBlock(t, block)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestHashModeEnforced(t *testing.T) {
+	bc := newTestChain(t)
+	authority, _ := NewChameleonKey()
+	other, _ := NewChameleonKey()
+	if err := bc.SetChameleonKey(&authority.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		seal *ecdsa.PublicKey
+		want error
+	}{
+		{"sha256", nil, ErrHashMode},
+		{"other key", &other.PublicKey, ErrHashMode},
+		{"chain key", &authority.PublicKey, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := newTestBlock(t, bc, testCoinbase(t, addrA, 1))
+			if tt.seal != nil {
+				block.SealChameleon(tt.seal)
+				mineBlock(t, block)
+			}
+			if err := bc.AddBlock(block); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestRedactBlock 改写机构删除区块中错误的转入，区块哈希不变，重新验证主链时按更换后的协调者验证后面的区块
+func TestRedactBlock(t *testing.T) {
+	bc := newTestChain(t)
+	authority, _ := NewChameleonKey()
+	if err := bc.SetChameleonKey(&authority.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// 区块1中更换协调者，区块2中的转入由新的协调者授权
+	newKey, _ := crypto.GenerateKey()
+	rotate := NewCoordinatorTX(crypto.PubkeyToAddress(newKey.PublicKey))
+	if err := rotate.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	cb1 := testCoinbase(t, addrA, 10)
+	wrong := testCoinbase(t, addrB, 5)
+	block1 := addSealedBlock(t, bc, &authority.PublicKey, cb1, wrong, rotate)
+	hash1, _ := block1.Hash()
+
+	in := &Transaction{Vin: []TXInput{{Vout: -1, Address: addrC, IsToTran: true}}, Vout: []TXOutput{*NewTXOutput(3, addrC)}, Type: toTranTxType}
+	if err := in.Authorize(newKey); err != nil {
+		t.Fatal(err)
+	}
+	spend := testSpend(t, cb1, 0, *NewTXOutput(10, addrB))
+	addSealedBlock(t, bc, &authority.PublicKey, in, spend)
+	tip := append([]byte{}, bc.tip...)
+
+	t.Run("spent output", func(t *testing.T) {
+		_, err := bc.RedactBlock(hash1, []*Transaction{wrong, rotate}, authority, "删除已经被花费的转入")
+		if err == nil {
+			t.Fatal("删除被花费的交易的改写成功")
+		}
+		if _, ok := utxoEntry(t, bc, spend.ID); !ok {
+			t.Fatal("改写失败后区块链被修改")
+		}
+	})
+	t.Run("wrong key", func(t *testing.T) {
+		other, _ := NewChameleonKey()
+		if _, err := bc.RedactBlock(hash1, []*Transaction{cb1, rotate}, other, ""); !errors.Is(err, ErrInvalidChameleon) {
+			t.Fatalf("RedactBlock = %v，期望 %v", err, ErrInvalidChameleon)
+		}
+	})
+	t.Run("sha256 block", func(t *testing.T) {
+		genesis, _ := bc.GetBlockByHeight(0)
+		hash, _ := genesis.Hash()
+		if _, err := bc.RedactBlock(hash, nil, authority, ""); !errors.Is(err, ErrNotRedactable) {
+			t.Fatalf("RedactBlock = %v，期望 %v", err, ErrNotRedactable)
+		}
+	})
+
+	record, err := bc.RedactBlock(hash1, []*Transaction{cb1, rotate}, authority, "删除错误的跨区转入")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.tip, tip) {
+		t.Fatalf("改写后最新区块 %x，期望 %x", bc.tip, tip)
+	}
+	redacted, err := bc.GetBlockByHash(hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := redacted.Hash(); !bytes.Equal(h, hash1) || len(redacted.Body.Transactions) != 2 {
+		t.Fatalf("改写后的区块哈希 %x，交易数 %d", h, len(redacted.Body.Transactions))
+	}
+	if _, ok := utxoEntry(t, bc, wrong.ID); ok {
+		t.Fatal("删除的转入仍在UTXO集合中")
+	}
+	if _, ok := utxoEntry(t, bc, in.ID); !ok {
+		t.Fatal("新协调者授权的转入不在UTXO集合中")
+	}
+	if len(record.Removed) != 1 || !bytes.Equal(record.Removed[0], wrong.ID) || len(record.Added) != 0 {
+		t.Fatalf("改写记录删除 %x，新增 %x", record.Removed, record.Added)
+	}
+	if log, err := bc.RedactionLog(); err != nil || len(log) != 1 || log[0].Seq != 1 {
+		t.Fatalf("RedactionLog = %v, %v", log, err)
+	}
+	if err := bc.VerifyRedactionLog(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestApplyRedaction 其他节点只凭改写后的区块与改写机构签名的记录执行改写，出块节点无法伪造改写
+func TestApplyRedaction(t *testing.T) {
+	authority, _ := NewChameleonKey()
+	producer, _ := crypto.GenerateKey()
+	cb1 := testCoinbase(t, addrA, 10)
+	wrong := testCoinbase(t, addrB, 5)
+
+	// 改写机构所在的节点与另一个节点有相同的区块
+	source := newTestChain(t)
+	source.SetChameleonKey(&authority.PublicKey)
+	block1 := addSealedBlock(t, source, &authority.PublicKey, cb1, wrong)
+	hash1, _ := block1.Hash()
+
+	bc := newTestChain(t)
+	bc.SetChameleonKey(&authority.PublicKey)
+	if err := bc.AddBlock(block1); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := source.RedactBlock(hash1, []*Transaction{cb1}, authority, "删除错误的跨区转入")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, _ := source.GetBlockByHash(hash1)
+
+	// 出块节点用自己的私钥计算"碰撞"并签名记录
+	forge := func(key *ecdsa.PrivateKey) (*Block, *RedactionRecord) {
+		header := *block1.Header
+		block := &Block{Header: &header, Body: &Body{Transactions: []*Transaction{cb1}}}
+		block.Header.MerkelRoot = block.Body.MerkleRoot()
+		digest, _ := chameleonHash(block1.Header.ChameleonKey, block1.Header.MerkelRoot, block1.Header.Randomness)
+		block.Header.Randomness, _ = chameleonCollision(key, digest, block.Header.MerkelRoot)
+		r := *record
+		r.NewMerkleRoot, r.NewRandomness = block.Header.MerkelRoot, block.Header.Randomness
+		r.Signature, _ = crypto.Sign(r.signingHash(), key)
+		return block, &r
+	}
+	forgedBlock, forgedRecord := forge(producer)
+	tampered := *record
+	tampered.Reason = "另一个原因"
+
+	tests := []struct {
+		name   string
+		block  *Block
+		record *RedactionRecord
+		want   error
+	}{
+		{"producer forgery", forgedBlock, forgedRecord, ErrInvalidRedaction},
+		{"tampered record", redacted, &tampered, ErrInvalidRedaction},
+		{"authority", redacted, record, nil},
+		{"already applied", redacted, record, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bc.ApplyRedaction(tt.block, tt.record); !errors.Is(err, tt.want) {
+				t.Fatalf("ApplyRedaction = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, ok := utxoEntry(t, bc, wrong.ID); ok {
+		t.Fatal("删除的转入仍在UTXO集合中")
+	}
+	if log, _ := bc.RedactionLog(); len(log) != 1 {
+		t.Fatalf("改写日志有 %d 条记录，期望 1", len(log))
+	}
+	if err := bc.VerifyRedactionLog(); err != nil {
+		t.Fatal(err)
+	}
+}
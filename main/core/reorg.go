@@ -0,0 +1,276 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// undoBucket 主链区块的回滚数据 区块哈希值 -> BlockUndo
+const undoBucket = "undo"
+
+// SpentOutput 区块中的交易花费掉的一个out，回滚区块时放回UTXO集合
+type SpentOutput struct {
+	Txid    []byte    // out所在的交易
+	Out     UTXOutput // 被花费的out
+	Height  int       // out所在交易的区块高度
+	TxIndex int       // out所在交易在区块中的位置
+}
+
+// BlockUndo 回滚一个区块需要的数据，Spent[i]是区块中第i个交易花费掉的out
+// 结算交易作废的占位交易out也记录在Spent中，Filled是区块中结算交易填充的占位交易ID
+type BlockUndo struct {
+	Spent  [][]SpentOutput
+	Filled [][]byte
+}
+
+// Serialize serializes BlockUndo
+func (u BlockUndo) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(u)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeBlockUndo deserializes BlockUndo
+func DeserializeBlockUndo(data []byte) BlockUndo {
+	var u BlockUndo
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&u)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return u
+}
+
+// isMainChain 判断区块是否在主链上
+func isMainChain(dbTx *bolt.Tx, hash []byte, height int) bool {
+	return bytes.Equal(dbTx.Bucket([]byte(heightIndexBucket)).Get(heightKey(height)), hash)
+}
+
+// IsMainChain 判断区块是否在主链上，侧链区块返回false
+func (bc *BlockChain) IsMainChain(hash []byte) bool {
+	result := false
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		block, err := readBlock(tx, hash)
+		if err != nil {
+			return err
+		}
+		result = isMainChain(tx, hash, int(block.Header.Height))
+		return nil
+	})
+	return err == nil && result
+}
+
+// addSideBlock 保存一个不接在最新区块之后的区块
+// 前一个区块必须已知；新分支的累计工作量比主链大时切换到新分支，返回从主链上回滚的区块
+func addSideBlock(dbTx *bolt.Tx, block *Block, hash []byte) ([]*Block, error) {
+	b := dbTx.Bucket([]byte(blocksBucket))
+
+	parent, err := readBlock(dbTx, block.Header.PrevBlock)
+	if err != nil {
+		return nil, &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: ErrOrphanBlock}
+	}
+	if block.Header.Height != parent.Header.Height+1 {
+		return nil, &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: ErrHeightMismatch}
+	}
+	err = checkBlockSanity(block, hash)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Header.State == BlockInvalid {
+		return nil, &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: ErrInvalidBlock}
+	}
+
+	err = b.Put(hash, block.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	err = setBlockState(dbTx, hash, BlockCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	// 侧链的累计工作量没有超过主链，只保存区块
+	work, err := chainWork(dbTx, hash)
+	if err != nil {
+		return nil, err
+	}
+	tipWork, err := chainWork(dbTx, b.Get([]byte("l")))
+	if err != nil {
+		return nil, err
+	}
+	if work.Cmp(tipWork) <= 0 {
+		return nil, nil
+	}
+
+	return reorganize(dbTx, hash)
+}
+
+// reorganize 把主链切换到以newTip为最新区块的分支
+// 先回滚主链上分叉点之后的区块，再依次验证并应用新分支上的区块，任何一个区块验证失败整个事务都会回滚
+// 返回回滚的区块，按高度从低到高排列
+func reorganize(dbTx *bolt.Tx, newTip []byte) ([]*Block, error) {
+	b := dbTx.Bucket([]byte(blocksBucket))
+
+	// 从新的最新区块向前找到与主链的分叉点
+	var branch []*Block
+	var branchHashes [][]byte
+	forkHeight := -1
+	for hash := newTip; len(hash) != 0; {
+		block, err := readBlock(dbTx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if isMainChain(dbTx, hash, int(block.Header.Height)) {
+			forkHeight = int(block.Header.Height)
+			break
+		}
+		if block.Header.State == BlockInvalid {
+			return nil, &BlockValidationError{BlockHash: hash, TxIndex: -1, Err: ErrInvalidBlock}
+		}
+		branch = append(branch, block)
+		branchHashes = append(branchHashes, hash)
+		hash = block.Header.PrevBlock
+	}
+
+	// 回滚主链上分叉点之后的区块
+	var disconnected []*Block
+	for getTipHeight(b) > forkHeight {
+		tipHash := append([]byte{}, b.Get([]byte("l"))...)
+		tipBlock, err := readBlock(dbTx, tipHash)
+		if err != nil {
+			return nil, err
+		}
+		err = disconnectBlock(dbTx, tipBlock, tipHash)
+		if err != nil {
+			return nil, err
+		}
+		disconnected = append([]*Block{tipBlock}, disconnected...)
+	}
+
+	// 从分叉点开始依次应用新分支上的区块
+	for i := len(branch) - 1; i >= 0; i-- {
+		err := validateBlock(dbTx, branch[i], branchHashes[i])
+		if err != nil {
+			return nil, err
+		}
+		err = connectBlock(dbTx, branch[i], branchHashes[i], int(branch[i].Header.Height))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return disconnected, nil
+}
+
+// disconnectBlock 从主链上回滚最新区块，恢复UTXO集合与所有索引，区块数据本身仍然保留
+func disconnectBlock(dbTx *bolt.Tx, block *Block, hash []byte) error {
+	height := int(block.Header.Height)
+	utxo := dbTx.Bucket([]byte(utxoBucket))
+	ai := dbTx.Bucket([]byte(addrIndexBucket))
+	ti := dbTx.Bucket([]byte(txIndexBucket))
+	tc := dbTx.Bucket([]byte(txCoordBucket))
+	ub := dbTx.Bucket([]byte(undoBucket))
+
+	undoData := ub.Get(hash)
+	if undoData == nil {
+		log.Panicf("! 区块 %x 没有回滚数据", hash)
+	}
+	undo := DeserializeBlockUndo(undoData)
+
+	// 按相反的顺序处理交易，同一个区块中后面的交易可能花费了前面交易的out
+	for i := len(block.Body.Transactions) - 1; i >= 0; i-- {
+		tx := block.Body.Transactions[i]
+
+		// 删除交易产生的out
+		if data := utxo.Get(tx.ID); data != nil {
+			for _, out := range DeserializeUTXOutputs(data).Outputs {
+				err := unindexAddressOutput(ai, out.Address, tx.ID, out.Outid)
+				if err != nil {
+					return err
+				}
+			}
+			err := utxo.Delete(tx.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// 恢复交易花费的out
+		if i < len(undo.Spent) {
+			for _, spent := range undo.Spent[i] {
+				err := restoreOutput(utxo, ai, spent)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// 删除交易索引
+		err := ti.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+		err = tc.Delete(txCoordKey(height, i))
+		if err != nil {
+			return err
+		}
+	}
+
+	// 填充的占位交易恢复为待定状态
+	err := unfillPlaceholders(utxo, undo.Filled)
+	if err != nil {
+		return err
+	}
+
+	err = dbTx.Bucket([]byte(heightIndexBucket)).Delete(heightKey(height))
+	if err != nil {
+		return err
+	}
+	// 区块中的协调者交易不再生效
+	err = dbTx.Bucket([]byte(coordinatorBucket)).Delete(heightKey(height))
+	if err != nil {
+		return err
+	}
+	err = ub.Delete(hash)
+	if err != nil {
+		return err
+	}
+
+	// 前一个区块成为最新区块
+	b := dbTx.Bucket([]byte(blocksBucket))
+	err = b.Put([]byte("l"), block.Header.PrevBlock)
+	if err != nil {
+		return err
+	}
+	return putTipHeight(b, height-1)
+}
+
+// restoreOutput 把被花费的out放回UTXO集合与地址索引
+func restoreOutput(utxo *bolt.Bucket, ai *bolt.Bucket, spent SpentOutput) error {
+	outs := UTXOutputs{Height: spent.Height, TxIndex: spent.TxIndex}
+	if data := utxo.Get(spent.Txid); data != nil {
+		outs = DeserializeUTXOutputs(data)
+	}
+	outs.Outputs = append(outs.Outputs, spent.Out)
+	sort.Slice(outs.Outputs, func(i, j int) bool {
+		return outs.Outputs[i].Outid < outs.Outputs[j].Outid
+	})
+
+	err := utxo.Put(spent.Txid, outs.Serialize())
+	if err != nil {
+		return err
+	}
+	return indexAddressOutput(ai, spent.Out.Address, spent.Txid, spent.Out.Outid)
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// addTestBranch 在prev之后依次加入包含txs[i]的区块，返回最后一个区块的哈希值
+func addTestBranch(t *testing.T, bc *BlockChain, prev []byte, txs ...*Transaction) []byte {
+	t.Helper()
+	parent, err := bc.GetBlockByHash(prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height := parent.Header.Height
+	for _, tx := range txs {
+		height++
+		block := mineTestBlock(t, bc, NewBlockWithTransactions(prev, height, []*Transaction{tx}))
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		prev, _ = block.Hash()
+	}
+	return prev
+}
+
+func TestReorganizeToLongerBranch(t *testing.T) {
+	bc := newTestChain(t)
+	genesis := append([]byte{}, bc.tip...)
+	cb := testCoinbase(t, addrA, 5)
+	addTestBlock(t, bc, cb)
+	spend := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+	mainTip := addTestBlock(t, bc, spend)
+	mainHash, _ := mainTip.Hash()
+	var disconnected [][]*Block
+	bc.OnReorg(func(blocks []*Block) {
+		disconnected = append(disconnected, blocks)
+	})
+
+	// 与主链一样长的侧链只保存，不切换
+	side1 := testCoinbase(t, addrC, 1)
+	side2 := testCoinbase(t, addrC, 2)
+	sideTip := addTestBranch(t, bc, genesis, side1, side2)
+	if !bytes.Equal(bc.tip, mainHash) || bc.IsMainChain(sideTip) {
+		t.Fatal("一样长的侧链成为了主链")
+	}
+	if _, found := utxoEntry(t, bc, side1.ID); found {
+		t.Fatal("侧链区块修改了UTXO集合")
+	}
+
+	// 侧链更长后切换
+	side3 := testSpend(t, side2, 0, *NewTXOutput(2, addrA))
+	newTip := addTestBranch(t, bc, sideTip, side3)
+	if !bytes.Equal(bc.tip, newTip) || bc.TipHeight() != 3 {
+		t.Fatalf("最新区块 %x，高度 %d，期望 %x", bc.tip, bc.TipHeight(), newTip)
+	}
+	if bc.IsMainChain(mainHash) {
+		t.Fatal("被回滚的区块仍在主链上")
+	}
+	// 切换时回调一次，回滚的区块按高度从低到高排列
+	if len(disconnected) != 1 || len(disconnected[0]) != 2 || !bytes.Equal(disconnected[0][0].Body.Transactions[0].ID, cb.ID) || !bytes.Equal(disconnected[0][1].Body.Transactions[0].ID, spend.ID) {
+		t.Fatalf("OnReorg回调 %v，期望回滚两个主链区块", disconnected)
+	}
+
+	tests := []struct {
+		name  string
+		tx    *Transaction
+		utxo  bool
+		index bool
+	}{
+		{"rolled back coinbase", cb, false, false},
+		{"rolled back spend", spend, false, false},
+		{"branch coinbase", side1, true, true},
+		{"spent on branch", side2, false, true},
+		{"branch spend", side3, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, found := utxoEntry(t, bc, tt.tx.ID); found != tt.utxo {
+				t.Errorf("交易在UTXO集合中: %v，期望 %v", found, tt.utxo)
+			}
+			if _, err := bc.FindTxLocation(tt.tx.ID); (err == nil) != tt.index {
+				t.Errorf("交易在交易索引中: %v，期望 %v", err == nil, tt.index)
+			}
+		})
+	}
+
+	// 回滚后的UTXO集合与重建的结果相同
+	before := bc.FindUTXOutputs2()
+	UTXOSet1{bc}.Reindex()
+	if after := bc.FindUTXOutputs2(); !reflect.DeepEqual(after, before) {
+		t.Fatalf("重建后的UTXO集合 %+v，重建前 %+v", after, before)
+	}
+}
+
+func TestAddBlockRejectsOrphanAndDuplicate(t *testing.T) {
+	bc := newTestChain(t)
+	block := addTestBlock(t, bc, testCoinbase(t, addrA, 1))
+	if err := bc.AddBlock(block); !errors.Is(err, ErrBlockExists) {
+		t.Fatalf("重复的区块: %v，期望 %v", err, ErrBlockExists)
+	}
+	orphan := NewBlockWithTransactions([]byte("unknown"), 5, []*Transaction{testCoinbase(t, addrA, 2)})
+	if err := bc.AddBlock(orphan); !errors.Is(err, ErrOrphanBlock) {
+		t.Fatalf("前一个区块不存在: %v，期望 %v", err, ErrOrphanBlock)
+	}
+}
+
+// TestReorganizeAcrossCoordinatorChange 回滚协调者交易后，新分支按回滚后的协调者验证
+func TestReorganizeAcrossCoordinatorChange(t *testing.T) {
+	bc := newTestChain(t)
+	genesis := append([]byte{}, bc.tip...)
+	newKey, _ := crypto.GenerateKey()
+	change := NewCoordinatorTX(crypto.PubkeyToAddress(newKey.PublicKey))
+	if err := change.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, change)
+	minted := unsignedToTran(t, addrB, 3)
+	if err := minted.Authorize(newKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, minted)
+
+	// 没有更换协调者的分支仍然由原来的协调者授权
+	addTestBranch(t, bc, genesis, testCoinbase(t, addrA, 1), testCoinbase(t, addrA, 2), testCoinbase(t, addrA, 3))
+	if bc.TipHeight() != 3 {
+		t.Fatalf("最新高度 %d，期望切换到高度为 3 的分支", bc.TipHeight())
+	}
+	if got, err := bc.Coordinator(); err != nil || got != testCoordinator() {
+		t.Fatalf("Coordinator() = %x, %v，期望 %x", got, err, testCoordinator())
+	}
+	if _, found := utxoEntry(t, bc, minted.ID); found {
+		t.Fatal("回滚的区块中新协调者授权的out仍在UTXO集合中")
+	}
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// 时间锁
+// 交易的LockTime是绝对锁定：在锁定时间之前交易不能被打包
+// output的LockTime是绝对锁定，Maturity是相对锁定：包含out的区块之后还要再经过Maturity个区块，out才能被花费
+// LockTime小于LockTimeThreshold时表示区块高度，否则表示Unix时间戳(秒)，0表示不锁定
+// 时间戳与前面medianTimeBlocks个区块时间戳的中位数比较，出块节点无法通过修改单个区块的时间戳提前解锁
+
+// LockTimeThreshold LockTime小于它时表示区块高度，否则表示Unix时间戳
+const LockTimeThreshold = 500000000
+
+// medianTimeBlocks 计算时间戳中位数使用的区块个数
+const medianTimeBlocks = 11
+
+var (
+	ErrTxLocked     = errors.New("交易的锁定时间还没有到")
+	ErrOutputLocked = errors.New("input花费的out还在锁定中")
+)
+
+// LockContext 判断时间锁时使用的区块高度与时间
+type LockContext struct {
+	Height uint64 // 包含交易的区块高度
+	Time   int64  // 前一个区块及其之前共medianTimeBlocks个区块时间戳的中位数
+}
+
+// lockTimeReached 判断锁定时间是否已经到了
+func lockTimeReached(lockTime uint64, ctx LockContext) bool {
+	if lockTime == 0 {
+		return true
+	}
+	if lockTime < LockTimeThreshold {
+		return ctx.Height >= lockTime
+	}
+	return ctx.Time >= 0 && uint64(ctx.Time) >= lockTime
+}
+
+// IsFinal 交易的锁定时间是否已经到了，可以被高度与时间为ctx的区块打包
+func (tx *Transaction) IsFinal(ctx LockContext) bool {
+	return lockTimeReached(tx.LockTime, ctx)
+}
+
+// IsSpendable out是否可以被高度与时间为ctx的区块中的交易花费，height是包含out的区块高度
+func (out *TXOutput) IsSpendable(height uint64, ctx LockContext) bool {
+	if !lockTimeReached(out.LockTime, ctx) {
+		return false
+	}
+	return ctx.Height >= height && ctx.Height-height >= out.Maturity
+}
+
+// IsLocked out是否设置了时间锁
+func (out *TXOutput) IsLocked() bool {
+	return out.LockTime != 0 || out.Maturity != 0
+}
+
+// readHeader 在事务中按哈希值读取区块头，不解码区块中的交易
+func readHeader(dbTx *bolt.Tx, hash []byte) (*Header, error) {
+	encodedBlock := dbTx.Bucket([]byte(blocksBucket)).Get(hash)
+	if encodedBlock == nil {
+		return nil, fmt.Errorf("! 区块 %x 不存在", hash)
+	}
+	d := decoder{data: encodedBlock}
+	encodedHeader := d.bytes()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return DecodeHeader(encodedHeader)
+}
+
+// lockContextAfter 接在prevHash之后的区块的LockContext
+// 时间是prevHash及其之前最多medianTimeBlocks个区块时间戳的中位数，prevHash为空时是创世区块，高度与时间都为0
+func lockContextAfter(dbTx *bolt.Tx, prevHash []byte) (LockContext, error) {
+	if len(prevHash) == 0 {
+		return LockContext{}, nil
+	}
+	header, err := readHeader(dbTx, prevHash)
+	if err != nil {
+		return LockContext{}, err
+	}
+	ctx := LockContext{Height: header.Height + 1}
+
+	var times []int64
+	for len(times) < medianTimeBlocks {
+		times = append(times, header.TimeStamp)
+		if len(header.PrevBlock) == 0 {
+			break
+		}
+		header, err = readHeader(dbTx, header.PrevBlock)
+		if err != nil {
+			return LockContext{}, err
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	ctx.Time = times[len(times)/2]
+	return ctx, nil
+}
+
+// nextLockContext 接在当前最新区块之后的区块的LockContext，用于验证还没有被打包的交易
+func nextLockContext(dbTx *bolt.Tx) (LockContext, error) {
+	return lockContextAfter(dbTx, dbTx.Bucket([]byte(blocksBucket)).Get([]byte("l")))
+}
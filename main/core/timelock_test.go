@@ -0,0 +1,187 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestLockTimeReached(t *testing.T) {
+	ctx := LockContext{Height: 10, Time: LockTimeThreshold + 100}
+	tests := []struct {
+		name     string
+		lockTime uint64
+		want     bool
+	}{
+		{"not locked", 0, true},
+		{"past height", 9, true},
+		{"current height", 10, true},
+		{"future height", 11, false},
+		// 刚好小于阈值的仍然是高度
+		{"huge height", LockTimeThreshold - 1, false},
+		{"past time", LockTimeThreshold + 99, true},
+		{"current time", LockTimeThreshold + 100, true},
+		{"future time", LockTimeThreshold + 101, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockTimeReached(tt.lockTime, ctx); got != tt.want {
+				t.Fatalf("lockTimeReached(%d) = %v，期望 %v", tt.lockTime, got, tt.want)
+			}
+			tx := &Transaction{LockTime: tt.lockTime}
+			if got := tx.IsFinal(ctx); got != tt.want {
+				t.Fatalf("IsFinal = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSpendable(t *testing.T) {
+	ctx := LockContext{Height: 10, Time: LockTimeThreshold}
+	tests := []struct {
+		name   string
+		out    TXOutput
+		height uint64 // 包含out的区块高度
+		want   bool
+	}{
+		{"unlocked", TXOutput{}, 10, true},
+		{"mature", TXOutput{Maturity: 3}, 7, true},
+		{"immature", TXOutput{Maturity: 3}, 8, false},
+		// 同一个区块中产生的out只有Maturity为0时可以花费
+		{"same block", TXOutput{Maturity: 1}, 10, false},
+		{"lock time reached", TXOutput{LockTime: 10}, 1, true},
+		{"lock time not reached", TXOutput{LockTime: 11}, 1, false},
+		{"both, only lock time reached", TXOutput{LockTime: 5, Maturity: 5}, 6, false},
+		{"both reached", TXOutput{LockTime: 5, Maturity: 5}, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.out.IsSpendable(tt.height, ctx); got != tt.want {
+				t.Fatalf("IsSpendable = %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// lockedCoinbase 协调者授权的ToTran交易，outputs可以带有时间锁
+func lockedCoinbase(t *testing.T, outputs ...TXOutput) *Transaction {
+	t.Helper()
+	tx := &Transaction{Vin: []TXInput{{Vout: -1, Address: addrA, IsToTran: true}}, Vout: outputs, Type: toTranTxType}
+	if err := tx.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestValidateTimelocks(t *testing.T) {
+	bc := newTestChain(t)
+	free := *NewTXOutput(10, addrA)
+	maturing := *NewTXOutput(5, addrA)
+	maturing.Maturity = 2
+	timed := *NewTXOutput(4, addrA)
+	timed.LockTime = 3
+	cb := lockedCoinbase(t, free, maturing, timed)
+	addTestBlock(t, bc, cb) // 高度1
+
+	// 锁定中的out单独统计，不计入可以花费的余额
+	au, err := bc.FindAddressUTXOs([]common.Address{addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if au[addrA].Balance != 10 || au[addrA].Locked != 9 {
+		t.Fatalf("余额 %d，锁定 %d，期望 10 与 9", au[addrA].Balance, au[addrA].Locked)
+	}
+
+	lockedTx := testSpend(t, cb, 0, *NewTXOutput(10, addrB))
+	lockedTx.LockTime = 3
+	testSign(t, lockedTx, cb)
+
+	// 下一个区块高度为2，三种时间锁都没有到期
+	tests := []struct {
+		name string
+		tx   *Transaction
+		want error
+	}{
+		{"immature output", testSpend(t, cb, 1, *NewTXOutput(5, addrB)), ErrOutputLocked},
+		{"output lock time", testSpend(t, cb, 2, *NewTXOutput(4, addrB)), ErrOutputLocked},
+		{"transaction lock time", lockedTx, ErrTxLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := bc.ValidateTransaction(tt.tx); !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateTransaction = %v，期望 %v", err, tt.want)
+			}
+			if err := bc.AddBlock(newTestBlock(t, bc, tt.tx)); !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+
+	// 同一个区块中产生的带Maturity的out不能在这个区块中花费
+	parent := testSpend(t, cb, 0, maturing)
+	child := testSpend(t, parent, 0, *NewTXOutput(5, addrB))
+	if err := bc.AddBlock(newTestBlock(t, bc, parent, child)); !errors.Is(err, ErrOutputLocked) {
+		t.Fatalf("花费同一个区块中未成熟的out: %v，期望 %v", err, ErrOutputLocked)
+	}
+
+	addTestBlock(t, bc, testCoinbase(t, addrC, 1)) // 高度2
+	for _, tt := range tests {
+		if err := bc.ValidateTransaction(tt.tx); err != nil {
+			t.Fatalf("%s: 高度3时 ValidateTransaction = %v", tt.name, err)
+		}
+	}
+	addTestBlock(t, bc, tests[0].tx, tests[1].tx, tests[2].tx)
+}
+
+// TestMedianTimeLock 时间戳锁定与前面区块时间戳的中位数比较，不是与单个区块的时间戳比较
+func TestMedianTimeLock(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 10)
+
+	// 创世区块之后的区块时间戳依次为 T+5, T+10, T+20, T+30, 最后一个区块的时间戳很大
+	// 每个区块的时间戳都要晚于前面区块时间戳的中位数
+	const base = genesisTimeStamp
+	for i, ts := range []int64{base + 5, base + 10, base + 20, base + 30, base + 1000000} {
+		tx := cb
+		if i > 0 {
+			tx = testCoinbase(t, addrC, Amount(i))
+		}
+		block := newTestBlock(t, bc, tx)
+		block.Header.TimeStamp = ts
+		mineBlock(t, block)
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lock LockContext
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		var err error
+		lock, err = nextLockContext(dbTx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 6个区块的时间戳排序后的中位数是第4个，即 T+20
+	if lock.Height != 6 || lock.Time != base+20 {
+		t.Fatalf("LockContext %+v，期望高度 6，时间 %d", lock, base+20)
+	}
+
+	for _, tt := range []struct {
+		lockTime uint64
+		want     error
+	}{
+		{base + 20, nil},
+		{base + 21, ErrTxLocked},
+	} {
+		tx := testSpend(t, cb, 0, *NewTXOutput(10, addrB))
+		tx.LockTime = tt.lockTime
+		testSign(t, tx, cb)
+		if err := bc.ValidateTransaction(tx); !errors.Is(err, tt.want) {
+			t.Fatalf("LockTime %d: ValidateTransaction = %v，期望 %v", tt.lockTime, err, tt.want)
+		}
+	}
+}
@@ -2,15 +2,14 @@ package core
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"log"
+	"math/big"
 	"sort"
 	// "transfer/wallet"
 
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/sha256"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,6 +25,9 @@ type Transaction struct {
 	Type int
 	// TODO:需要添加一个字段账户，表明这个交易是谁发出来的，也需要提供一个专门的查询函数来查询账户对应的公钥
 	Account string // 发送者账户
+	// LockTime 锁定时间，交易只能被高度或时间达到它的区块打包，小于LockTimeThreshold时是区块高度，否则是Unix时间戳，0表示不锁定
+	LockTime uint64
+	Slot     *SlotRef // 结算交易引用的占位交易坐标与结算结果，其他交易为nil，见placeholder.go
 }
 
 // TransactionWallet 为了解决循环引用的结构体
@@ -48,10 +50,14 @@ func (tx Transaction) IsCoinbase() bool {
 }
 
 // NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(wallet *TransactionWallet, to common.Address, amount int, UTXOSet *UTXOSet1) *Transaction {
+func NewUTXOTransaction(wallet *TransactionWallet, to common.Address, amount Amount, UTXOSet *UTXOSet1) *Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
+	if err := CheckOutputAmount(amount); err != nil {
+		log.Panic(err)
+	}
+
 	from := wallet.GetAddress() // 获得交易地址
 
 	// (based public key byte)Find the unspent, valid outputs to reference in the inputs
@@ -71,7 +77,7 @@ func NewUTXOTransaction(wallet *TransactionWallet, to common.Address, amount int
 		}
 
 		for _, out := range outs {
-			input := TXInput{txID, out, nil, from, false}
+			input := TXInput{Txid: txID, Vout: out, Address: from}
 			inputs = append(inputs, input)
 		}
 	}
@@ -85,7 +91,7 @@ func NewUTXOTransaction(wallet *TransactionWallet, to common.Address, amount int
 		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
 	}
 
-	tx := Transaction{nil, inputs, outputs, 0, wallet.Account}
+	tx := Transaction{ID: nil, Vin: inputs, Vout: outputs, Type: 0, Account: wallet.Account}
 	tx.ID = tx.Hash()
 	UTXOSet.Blockchain.SignTransaction(&tx, &wallet.PrivateKey)
 
@@ -106,22 +112,14 @@ func (w TransactionWallet) GetAddress() common.Address {
 }
 
 // NewTransaction 现已支持多钱包多地址转账
-func NewTransaction(wallet *TransactionWallet, AddressMoney map[common.Address]int, AUTXO map[string]TXOutputs) (*Transaction, error) {
+// keyring是账户下所有子钱包的私钥，可以使用任意子钱包拥有的out，每个input由对应子钱包的私钥签名
+// AUTXO中的out带有它在原交易中的位置Outid
+// 转账金额必须大于0，opts.FeeRate是每1000字节的手续费，选择out时会把每个input增加的交易大小计入手续费，手续费 = input金额之和 - output金额之和
+// opts.Selector是选币策略，nil时优先使用金额最大的out；opts.Change是找零地址策略，nil时找零转回发送方地址
+func NewTransaction(wallet *TransactionWallet, keyring Keyring, AddressMoney map[common.Address]Amount, AUTXO map[string]TXOutputs2, opts TxBuildOptions) (*Transaction, error) {
 	// 必要的数据
-	AAddress := wallet.GetAddress()                      // 发送方地址
-	APrivatekey := wallet.PrivateKey                     // 发送方私钥
-	var sortedUTXO []NewUTXOut                           // 排序后的UTXO数组 新
-	var FinalUTXO []NewUTXOut                            // 选中的UTXO集合
-	pubKeyByte := crypto.FromECDSAPub(&wallet.PublicKey) // 将PublicKey转为字节形式，为之后做准备
-	var Accumulated int = 0                              // 记录总余额
-	var AllNeed int = 0                                  // 记录需要的总金额
-	var Inputs []TXInput                                 // input集合
-	var Outputs []TXOutput                               // output集合
-
-	// 计算需要的总金额
-	for _, v := range AddressMoney {
-		AllNeed += v
-	}
+	AAddress := wallet.GetAddress() // 发送方地址
+	var Outputs []TXOutput          // output集合
 
 	//// UTXO按照余额从高到低排序
 	//// 统计有多少out
@@ -157,80 +155,81 @@ func NewTransaction(wallet *TransactionWallet, AddressMoney map[common.Address]i
 	//	})
 	//}
 
+	// 任意子钱包拥有的out都可以使用，按金额从高到低排序
+	Coins, err := keyringCoins(keyring, AUTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	// output，转账output按opts设置时间锁
+	for k, v := range AddressMoney {
+		out := NewTXOutput(v, k)
+		out.LockTime = opts.OutputLockTime
+		out.Maturity = opts.OutputMaturity
+		Outputs = append(Outputs, *out)
+	}
+	// 构造交易
+	TX := Transaction{
+		ID:       nil,
+		Vout:     Outputs,
+		Type:     0, // Type值为0表示普通交易
+		LockTime: opts.LockTime,
+	}
+	// 选择合适的out作为input，找零地址由opts.Change决定
+	_, err = fundTransaction(&TX, Coins, AAddress, opts)
+	if err != nil {
+		return nil, err
+	}
+	TX.ID = TX.Hash() // 交易ID
+	// 交易签名，每个input使用对应子钱包的私钥
+	err = TX.SignWithKeyring(keyring, coinPrevTXs(Coins))
+	if err != nil {
+		fmt.Println("! 交易签名方法出现错误")
+		return nil, err
+	}
+	// 新的交易构造完成
+
+	// 返回交易
+	return &TX, nil
+}
+
+// keyringCoins AUTXO中keyring的子钱包拥有的out，按金额从高到低排序
+// 多签out的地址是多签地址，不属于任何子钱包，需要通过NewMultisigSpend花费
+func keyringCoins(keyring Keyring, AUTXO map[string]TXOutputs2) ([]Coin, error) {
+	var sortedUTXO []NewUTXOut // 排序后的UTXO数组 新
+	var Coins []Coin           // 可以使用的out
+
 	// UTXO按照余额从高到低排序
 	// 将UTXO映射到一个临时的切片中进行排序
 	for txID, outputs := range AUTXO {
-		for outID, output := range outputs.Outputs {
+		for _, output := range outputs.Outputs {
 			sortedUTXO = append(sortedUTXO, NewUTXOut{
 				txID:     txID,
-				outID:    outID,
-				TXOutput: output,
+				outID:    output.Outid,
+				TXOutput: output.Output(),
 			})
 		}
 	}
 
-	// 对切片进行排序
+	// 对切片进行排序，具体选择哪些out由opts.Selector决定
 	sort.Slice(sortedUTXO, func(i, j int) bool {
 		return sortedUTXO[i].Value > sortedUTXO[j].Value
 	})
 
-	// 选择合适的out
+	// 任意子钱包拥有的out都可以使用
 	for _, v := range sortedUTXO {
-		if v.TXOutput.IsLockedWithKey(pubKeyByte) {
-			FinalUTXO = append(FinalUTXO, v) // 添加到选中的UTXO结果中
-			Accumulated += v.TXOutput.Value  // 当前累加的余额
-			if Accumulated > AllNeed {
-				// 余额足够
-				break
-			}
+		if !keyring.Owns(v.TXOutput.Address) {
+			continue
 		}
-	}
-
-	// 构造交易
-	// input
-	for _, out := range FinalUTXO {
 		// txID转为字节
-		txID, err := hex.DecodeString(out.txID)
+		txID, err := hex.DecodeString(v.txID)
 		if err != nil {
 			fmt.Println("! txID转为字节出错")
 			return nil, err
 		}
-		// 构造一个交易的其中一个input结构
-		input := TXInput{
-			Txid:      txID,
-			Vout:      out.outID,
-			Signature: nil,         // 不知道要填什么字段，源程序也没有填写字段
-			Address:   out.Address, // 来源地址
-		}
-		Inputs = append(Inputs, input) // 添加到最终Inputs集合中
+		Coins = append(Coins, Coin{Txid: txID, Vout: v.outID, Out: v.TXOutput})
 	}
-	// output
-	for k, v := range AddressMoney {
-		Outputs = append(Outputs, *NewTXOutput(v, k))
-	}
-	//Outputs = append(Outputs, *NewTXOutput(Amount, BAddress))
-	// 找零
-	if Accumulated > AllNeed {
-		Outputs = append(Outputs, *NewTXOutput(Accumulated-AllNeed, AAddress)) // TODO: 当前版本只支持将零钱转回自己的账户
-	}
-	// 构造交易
-	TX := Transaction{
-		ID:   nil,
-		Vin:  Inputs,
-		Vout: Outputs,
-		Type: 0, // Type值为0表示普通交易
-	}
-	TX.ID = TX.Hash() // 交易ID
-	// 交易签名
-	err := SignTransactionNoBlockchain(&TX, &APrivatekey)
-	if err != nil {
-		fmt.Println("! 交易签名方法出现错误")
-		return nil, err
-	}
-	// 新的交易构造完成
-
-	// 返回交易
-	return &TX, nil
+	return Coins, nil
 }
 
 // NewOutToLight 用于跨区转账交易To轻计算区Output按照余额排序
@@ -241,89 +240,81 @@ type NewOutToLight struct {
 }
 
 // NewTransactionToLight 跨区转账To轻计算区使用的交易构造函数
-func NewTransactionToLight(wallet *TransactionWallet, BAddress common.Address, Amount int, AUTXO map[string][]TXOutputsTran) (*Transaction, []NewOutToLight, error) {
+// keyring是账户下所有子钱包的私钥，每个input由对应子钱包的私钥签名
+// opts.FeeRate是每1000字节的手续费，手续费从找零中扣除，opts.Selector是选币策略，opts.Change是找零地址策略
+// lock不为nil时转账金额先锁定在转账区的HTLC out中，lock.Recipient给出原像领取后才转到轻计算区，超时没有领取时退回lock.Refund
+// lock为nil时转账金额直接转到轻计算区的BAddress
+func NewTransactionToLight(wallet *TransactionWallet, keyring Keyring, BAddress common.Address, Money Amount, AUTXO map[string][]TXOutputsTran, lock *HTLCLock, opts TxBuildOptions) (*Transaction, []NewOutToLight, error) {
 	// 必要的数据
-	AAddress := wallet.GetAddress()                      // 发送方地址
-	APrivatekey := wallet.PrivateKey                     // 发送方私钥
-	var SortedUTXO []NewOutToLight                       // 排序后的UTXO数组 新
-	var FinalUTXO []NewOutToLight                        // 选中的UTXO集合
-	pubKeyByte := crypto.FromECDSAPub(&wallet.PublicKey) // 将PublicKey转为字节形式，为之后做准备
-	var Accumulated int = 0                              // 记录总余额
-	var Inputs []TXInput                                 // input集合
-	var Outputs []TXOutput                               // output集合
+	AAddress := wallet.GetAddress() // 发送方地址
+	var SortedUTXO []NewOutToLight  // 排序后的UTXO数组 新
+	var OwnedUTXO []NewOutToLight   // 子钱包拥有的UTXO
+	var FinalUTXO []NewOutToLight   // 选中的UTXO集合
+	var Coins []Coin                // 可以使用的out
 
 	// UTXO按照余额从高到低排序
 	// 将UTXO映射到一个临时的切片中进行排序
 	for txID, outputs := range AUTXO {
-		for outID, output := range outputs {
+		for _, output := range outputs {
 			SortedUTXO = append(SortedUTXO, NewOutToLight{
 				TxID:  txID,
-				OutID: outID,
+				OutID: output.Outid,
 				Out:   output,
 			})
 		}
 	}
 
-	// 对切片进行排序
+	// 对切片进行排序，具体选择哪些out由opts.Selector决定
 	sort.Slice(SortedUTXO, func(i, j int) bool {
 		return SortedUTXO[i].Out.Out.Value > SortedUTXO[j].Out.Out.Value
 	})
 
-	// 选择合适的out
+	// 任意子钱包拥有的out都可以使用
 	for _, v := range SortedUTXO {
-		if v.Out.Out.IsLockedWithKey(pubKeyByte) {
-			FinalUTXO = append(FinalUTXO, v) // 添加到选中的UTXO结果中
-			Accumulated += v.Out.Out.Value   // 当前累加的余额
-			if Accumulated > Amount {
-				// 余额足够
-				break
-			}
+		if !keyring.Owns(v.Out.Out.Address) {
+			continue
 		}
-	}
-
-	// 构造交易 特殊交易ToLight，需要将UTXO结构体中的Type值设置为1
-	// input
-	for _, out := range FinalUTXO {
 		// txID转为字节
-		txID, err := hex.DecodeString(out.TxID)
+		txID, err := hex.DecodeString(v.TxID)
 		if err != nil {
 			fmt.Println("! txID转为字节出错")
 			return nil, nil, err
 		}
-		// 构造一个交易的其中一个input结构
-		input := TXInput{
-			Txid:      txID,
-			Vout:      out.OutID,
-			Signature: nil,                 // 不知道要填什么字段，源程序也没有填写字段，后续用签名函数签名
-			Address:   out.Out.Out.Address, // 地址填写来自于哪里的out地址
-		}
-		Inputs = append(Inputs, input) // 添加到最终Inputs集合中
+		OwnedUTXO = append(OwnedUTXO, v)
+		Coins = append(Coins, Coin{Txid: txID, Vout: v.OutID, Out: v.Out.Out})
 	}
+
+	// 构造交易 特殊交易ToLight，需要将UTXO结构体中的Type值设置为1
 	// output
-	Outputs = append(Outputs, TXOutput{
-		Value:   Amount,
-		Address: BAddress,
-		IsUse:   true,
-	}) // BAddress是轻计算区对应地址，true表示该out属于跨区转账类型的out，已被使用
-	// 找零
-	if Accumulated > Amount {
-		Outputs = append(Outputs, TXOutput{
-			Value:   Accumulated - Amount,
-			Address: AAddress,
-			IsUse:   false,
-		}) // AAddress是转账区对应地址，false表示该out还留在转账区中，没有被使用
-	}
-	// 构造交易
 	TX := Transaction{
-		ID:      nil,
-		Vin:     Inputs,
-		Vout:    Outputs,
-		Type:    1, // Type为1表示是跨区转账的特殊交易ToLight
-		Account: wallet.Account,
+		ID: nil,
+		Vout: []TXOutput{{
+			Value:   Money,
+			Address: BAddress,
+			IsUse:   true,
+		}}, // BAddress是轻计算区对应地址，true表示该out属于跨区转账类型的out，已被使用
+		Type:     1, // Type为1表示是跨区转账的特殊交易ToLight
+		Account:  wallet.Account,
+		LockTime: opts.LockTime, // 转到轻计算区的out已经被使用，只有交易的锁定时间有意义
+	}
+	if lock != nil {
+		if err := lock.Validate(); err != nil {
+			return nil, nil, err
+		}
+		TX.Vout[0] = *NewHTLCOutput(Money, lock) // 还留在转账区，领取或退款时才被花费
+	}
+	// 选择合适的out作为input
+	// 找零的out地址由opts.Change决定，IsUse为false表示该out还留在转账区中，没有被使用
+	selected, err := fundTransaction(&TX, Coins, AAddress, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, i := range selected {
+		FinalUTXO = append(FinalUTXO, OwnedUTXO[i])
 	}
 	TX.ID = TX.Hash() // 交易ID
-	// 交易签名
-	err := SignTransactionNoBlockchain(&TX, &APrivatekey)
+	// 交易签名，每个input使用对应子钱包的私钥
+	err = TX.SignWithKeyring(keyring, coinPrevTXs(Coins))
 	if err != nil {
 		fmt.Println("! 交易签名方法出现错误")
 		return nil, nil, err
@@ -335,8 +326,12 @@ func NewTransactionToLight(wallet *TransactionWallet, BAddress common.Address, A
 }
 
 // NewCoinbaseTX 新建ToTran Coinbase交易
-// 目前铸币交易能自己构造的也只有ToTran的跨链交易
-func NewCoinbaseTX(FromAddress common.Address, Address common.Address, Amount int, UserAccount string) *Transaction {
+// 目前铸币交易能自己构造的也只有ToTran的跨链交易，金额必须大于0，需要协调者调用Authorize签名授权后才能上链，见coordinator.go
+func NewCoinbaseTX(FromAddress common.Address, Address common.Address, Money Amount, UserAccount string) (*Transaction, error) {
+	err := CheckOutputAmount(Money)
+	if err != nil {
+		return nil, err
+	}
 	// tx.Vin只有一个，且没有Txid，Vout = -1
 	var Inputs []TXInput   // input集合
 	var Outputs []TXOutput // output集合
@@ -350,7 +345,7 @@ func NewCoinbaseTX(FromAddress common.Address, Address common.Address, Amount in
 	}
 	Inputs = append(Inputs, input)
 	// output
-	Outputs = append(Outputs, *NewTXOutput(Amount, Address)) // 不用找零，跨链交易ToTran将所有钱全部转入转账区对应账户
+	Outputs = append(Outputs, *NewTXOutput(Money, Address)) // 不用找零，跨链交易ToTran将所有钱全部转入转账区对应账户
 	// Coinbase交易不用签名
 	// 构造交易
 	TX := Transaction{
@@ -361,12 +356,17 @@ func NewCoinbaseTX(FromAddress common.Address, Address common.Address, Amount in
 		Account: UserAccount,
 	}
 	TX.ID = TX.Hash() // 交易ID
-	return &TX
+	return &TX, nil
 }
 
 // NewTransactionToTran 跨链交易ToTran构造新交易
-// BAddress是转账区对应的地址，Amount是目标转账金额
-func NewTransactionToTran(wallet *TransactionWallet, BAddress common.Address, Amount int) (*Transaction, error) {
+// BAddress是转账区对应的地址，Money是目标转账金额，必须大于0
+// 构造的交易引用了out又带有IsToTran的input，区块验证会拒绝，转入转账区需要使用协调者授权的NewCoinbaseTX
+func NewTransactionToTran(wallet *TransactionWallet, BAddress common.Address, Money Amount) (*Transaction, error) {
+	err := CheckOutputAmount(Money)
+	if err != nil {
+		return nil, err
+	}
 	// 必要的数据
 	AAddress := wallet.GetAddress()  // 发送方地址
 	APrivatekey := wallet.PrivateKey // 发送方私钥
@@ -384,7 +384,7 @@ func NewTransactionToTran(wallet *TransactionWallet, BAddress common.Address, Am
 	}
 	Inputs = append(Inputs, input)
 	// output
-	Outputs = append(Outputs, *NewTXOutput(Amount, BAddress)) // 不用找零，跨链交易ToTran将所有钱全部转入转账区对应账户
+	Outputs = append(Outputs, *NewTXOutput(Money, BAddress)) // 不用找零，跨链交易ToTran将所有钱全部转入转账区对应账户
 	// 构造交易
 	TX := Transaction{
 		ID:      nil,
@@ -395,7 +395,7 @@ func NewTransactionToTran(wallet *TransactionWallet, BAddress common.Address, Am
 	}
 	TX.ID = TX.Hash() // 交易ID
 	// 交易签名
-	err := SignTransactionNoBlockchain(&TX, &APrivatekey)
+	err = SignTransactionNoBlockchain(&TX, &APrivatekey)
 	if err != nil {
 		fmt.Println("! 交易签名方法出现错误")
 		return nil, err
@@ -409,112 +409,259 @@ func NewTransactionToTran(wallet *TransactionWallet, BAddress common.Address, Am
 // SignTransactionNoBlockchain 不使用blockchain结构体的交易签名方法
 func SignTransactionNoBlockchain(TX *Transaction, privKey *ecdsa.PrivateKey) error {
 	// 交易Inputs中对应的前置完整交易
-	PrevTXs := make(map[string]Transaction)
-
-	// 模拟获取目前阶段的blockchain
-	err, blockchain := GetBlockChain()
+	PrevTXs, err := findPrevTransactionsNoBlockchain(TX)
 	if err != nil {
-		fmt.Println("! 获取区块链信息出现错误")
 		return err
 	}
 
-	for _, vin := range TX.Vin {
-		PrevTX, err := blockchain.FindTransaction(vin.Txid)
-		if err != nil {
-			fmt.Println("! 按照TXid寻找交易方法出现错误")
-			return err
-		}
-		// 前置交易数组
-		PrevTXs[hex.EncodeToString(PrevTX.ID)] = PrevTX
-	}
-
 	// 交易签名
 	TX.Sign(privKey, PrevTXs)
 	// 返回
 	return nil
 }
 
+// SignTransactionWithKeyring 不使用blockchain结构体的多钱包交易签名方法，每个input使用对应子钱包的私钥签名
+func SignTransactionWithKeyring(TX *Transaction, keyring Keyring) error {
+	// 交易Inputs中对应的前置完整交易
+	PrevTXs, err := findPrevTransactionsNoBlockchain(TX)
+	if err != nil {
+		return err
+	}
+
+	// 交易签名
+	return TX.SignWithKeyring(keyring, PrevTXs)
+}
+
+// findPrevTransactionsNoBlockchain 获取当前区块链并查询交易的前置交易
+func findPrevTransactionsNoBlockchain(TX *Transaction) (map[string]Transaction, error) {
+	// 模拟获取目前阶段的blockchain
+	err, blockchain := GetBlockChain()
+	if err != nil {
+		fmt.Println("! 获取区块链信息出现错误")
+		return nil, err
+	}
+
+	PrevTXs, err := blockchain.findPrevTransactions(TX)
+	if err != nil {
+		fmt.Println("! 按照TXid寻找交易方法出现错误")
+		return nil, err
+	}
+	return PrevTXs, nil
+}
+
 // TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// 清空所有input的签名(包括多签的Signatures与HTLC的Preimage)，其余字段保持不变，签名覆盖交易的全部内容
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, vin.Address, false})
+		inputs = append(inputs, TXInput{Txid: vin.Txid, Vout: vin.Vout, Address: vin.Address, IsToTran: vin.IsToTran})
 	}
 
-	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.Address, false})
-	}
+	outputs = append(outputs, tx.Vout...)
 
-	txCopy := Transaction{tx.ID, inputs, outputs, 0, tx.Account}
+	txCopy := Transaction{ID: tx.ID, Vin: inputs, Vout: outputs, Type: tx.Type, Account: tx.Account, LockTime: tx.LockTime, Slot: tx.Slot}
 
 	return txCopy
 }
 
+// SignatureHash 计算第inIndex个input的签名哈希
+// sighash = sha256(TrimmedCopy的规范编码 || uvarint(inIndex) || 被花费的out的规范编码)
+// TrimmedCopy中第inIndex个input的Address替换为被花费的out的地址，签名同时覆盖out的金额，签名不能挪到其他input或其他out上使用
+func (tx *Transaction) SignatureHash(inIndex int, prevOut TXOutput) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inIndex].Address = prevOut.Address
+
+	var e encoder
+	e.buf.Write(EncodeTransaction(&txCopy))
+	e.uvarint(uint64(inIndex))
+	e.buf.Write(EncodeTXOutput(&prevOut))
+
+	hash := sha256.Sum256(e.buf.Bytes())
+	return hash[:]
+}
+
+// prevOutput 从前置交易中找到input引用的out
+func prevOutput(vin TXInput, prevTXs map[string]Transaction) (TXOutput, error) {
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || prevTx.ID == nil {
+		return TXOutput{}, fmt.Errorf("! 没有找到input引用的交易 %x", vin.Txid)
+	}
+	if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+		return TXOutput{}, fmt.Errorf("! 交易 %x 中没有第 %d 个out", vin.Txid, vin.Vout)
+	}
+	return prevTx.Vout[vin.Vout], nil
+}
+
 // Sign signs each input of a Transaction
+// 只签名花费转账区UTXO的input，Coinbase与跨链交易ToTran的input没有前置交易，不需要签名
+// 签名会改变交易内容，签名完成后重新计算交易ID
 func (tx *Transaction) Sign(privKey *ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+	err := tx.sign(prevTXs, func(common.Address) (*ecdsa.PrivateKey, error) {
+		return privKey, nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// SignWithKeyring 多钱包交易签名，每个input使用它花费的out的地址对应的子钱包私钥
+func (tx *Transaction) SignWithKeyring(keyring Keyring, prevTXs map[string]Transaction) error {
+	return tx.sign(prevTXs, keyring.Key)
+}
+
+// sign 签名每个花费UTXO的input，keyFor返回被花费的out的地址对应的私钥
+func (tx *Transaction) sign(prevTXs map[string]Transaction, keyFor func(common.Address) (*ecdsa.PrivateKey, error)) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	// ! 原来代码的逻辑是对每一个tx in 都做一次签名
+	for inID, vin := range tx.Vin {
+		if !spendsUTXO(tx, vin) {
+			continue
+		}
+		prevOut, err := prevOutput(vin, prevTXs)
+		if err != nil {
+			return err
+		}
+		// 多签out只补充keyFor能提供的签名，其余签名由其他负责人补充
+		if prevOut.Multisig != nil {
+			err = tx.signMultisig(inID, prevOut, keyFor)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		signer := prevOut.Address
+		if prevOut.HTLC != nil {
+			signer = prevOut.HTLC.signer(vin.Preimage)
+		}
+		privKey, err := keyFor(signer)
+		if err != nil {
+			return err
+		}
+
+		signature, err := crypto.Sign(tx.SignatureHash(inID, prevOut), privKey)
+		if err != nil {
+			return err
 		}
+
+		tx.Vin[inID].Signature = signature
 	}
 
-	txCopy := tx.TrimmedCopy()
+	tx.ID = tx.Hash()
+	return nil
+}
 
-	// ! 原来代码的逻辑是对每一个tx in 都做一次签名
+// Verify verifies signatures of Transaction inputs
+// 从每个花费UTXO的input的签名恢复出公钥，公钥对应的地址必须是被花费的out的地址，验证只依赖链上数据
+// 花费多签out的input需要锁定条件中Required个不同地址的签名
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
+	return tx.verify(prevTXs, false)
+}
 
-	for inID, vin := range txCopy.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].Address = prevTx.Vout[vin.Vout].Address // ?
+// VerifyPartial 与Verify相同，但允许花费多签out的input签名个数不足，用于负责人在补充签名前检查已有的签名
+func (tx *Transaction) VerifyPartial(prevTXs map[string]Transaction) error {
+	return tx.verify(prevTXs, true)
+}
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy) // 将交易对象 txCopy 格式化为十六进制字符串
+func (tx *Transaction) verify(prevTXs map[string]Transaction, partial bool) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, privKey, []byte(dataToSign))
+	for inID, vin := range tx.Vin {
+		if !spendsUTXO(tx, vin) {
+			continue
+		}
+		prevOut, err := prevOutput(vin, prevTXs)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		err = tx.verifyInput(inID, prevOut, partial)
+		if err != nil {
+			return err
 		}
+	}
 
-		signature := append(r.Bytes(), s.Bytes()...)
+	return nil
+}
 
-		// TODO:现在知道了可以使用公钥来进行签名验证 ecdsa.Verify()方法
+// verifyInput 验证第inIndex个input的签名，prevOut是它花费的out
+// partial只对花费多签out的input有效，见verifyMultisig
+func (tx *Transaction) verifyInput(inIndex int, prevOut TXOutput, partial bool) error {
+	vin := tx.Vin[inIndex]
+	// 只有花费HTLC out的input可以有Preimage，否则可以随意添加内容改变交易ID
+	if prevOut.HTLC == nil && len(vin.Preimage) != 0 {
+		return fmt.Errorf("! 第 %d 个input没有花费HTLC out，Preimage应该为空: %w", inIndex, ErrInvalidSignature)
+	}
+	if prevOut.Multisig != nil {
+		return tx.verifyMultisig(inIndex, prevOut, partial)
+	}
+	// 普通input的Signatures必须为空，否则可以随意添加内容改变交易ID
+	if len(vin.Signatures) != 0 {
+		return fmt.Errorf("! 第 %d 个input没有花费多签out，Signatures应该为空: %w", inIndex, ErrInvalidSignature)
+	}
 
-		tx.Vin[inID].Signature = signature
-		// txCopy.Vin[inID].Address = nil
+	// HTLC out给出原像时由收款方签名，否则由退款方签名，是否超时由validateTransaction检查
+	expected := prevOut.Address
+	if prevOut.HTLC != nil {
+		if len(vin.Preimage) != 0 && !bytes.Equal(HashPreimage(vin.Preimage), prevOut.HTLC.Hash) {
+			return fmt.Errorf("! 第 %d 个input: %w", inIndex, ErrInvalidPreimage)
+		}
+		expected = prevOut.HTLC.signer(vin.Preimage)
+	}
+
+	signer, err := recoverSigner(tx.SignatureHash(inIndex, prevOut), vin.Signature)
+	if err != nil {
+		return fmt.Errorf("! 第 %d 个input: %v: %w", inIndex, err, ErrInvalidSignature)
+	}
+	if signer != expected {
+		return fmt.Errorf("! 第 %d 个input的签名者不是 %x: %w", inIndex, expected, ErrInvalidSignature)
 	}
+
+	return nil
 }
 
-// Serialize returns a serialized Transaction
-func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+// recoverSigner 从签名恢复签名者的地址
+func recoverSigner(hash []byte, sig []byte) (common.Address, error) {
+	// 签名格式为 r || s || v，s必须在曲线阶的低半部分，否则同一个签名可以变形为另一个合法签名，交易ID也随之改变
+	if len(sig) != crypto.SignatureLength ||
+		!crypto.ValidateSignatureValues(sig[crypto.RecoveryIDOffset], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64]), true) {
+		return common.Address{}, fmt.Errorf("! 签名格式错误")
+	}
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+	pubKey, err := crypto.SigToPub(hash, sig)
 	if err != nil {
-		log.Panic(err)
+		return common.Address{}, fmt.Errorf("! 签名无法恢复公钥")
 	}
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
 
-	return encoded.Bytes()
+// Serialize returns a serialized Transaction
+// 使用规范二进制编码，见encoding.go，交易ID不包含在编码中
+func (tx Transaction) Serialize() []byte {
+	return EncodeTransaction(&tx)
+}
+
+// DeserializeTransaction deserializes a Transaction，交易ID根据编码重新计算
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	return DecodeTransaction(data)
 }
 
 // Hash returns the hash of the Transaction
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
-
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(tx.Serialize())
 
 	return hash[:]
 }
 
-// GetTXByCoordinates TODO:根据坐标返回交易
+// GetTXByCoordinates 根据坐标返回交易
+// CoordinatesX是区块高度，CoordinatesY是区块中的第几个交易，通过交易坐标索引直接查询
 func GetTXByCoordinates(CoordinatesX int, CoordinatesY int) (Transaction, error) {
 	// 模拟获取目前阶段的blockchain
 	err, blockchain := GetBlockChain()
@@ -523,18 +670,9 @@ func GetTXByCoordinates(CoordinatesX int, CoordinatesY int) (Transaction, error)
 		return Transaction{}, err
 	}
 
-	bci := blockchain.Iterator() // 迭代器
-	var block *Block
-	var i int
-
-	// 找到目标区块
-	for i = 0; i <= CoordinatesX; i++ {
-		block = bci.Next() // 新区块
-	}
-
-	if CoordinatesY > len(block.Body.Transactions) {
+	if CoordinatesX < 0 || CoordinatesX > blockchain.TipHeight() || CoordinatesY < 0 {
 		return Transaction{}, fmt.Errorf("! 坐标范围错误")
 	}
 
-	return *block.Body.Transactions[CoordinatesY], nil
+	return blockchain.FindTransactionByCoordinates(CoordinatesX, CoordinatesY)
 }
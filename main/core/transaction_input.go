@@ -6,11 +6,15 @@ import (
 
 // TXInput represents a transaction input
 type TXInput struct {
-	Txid      []byte // Tx Hash
-	Vout      int    // Previous Tx Output Index
-	Signature []byte
+	Txid      []byte         // Tx Hash
+	Vout      int            // Previous Tx Output Index
+	Signature []byte         // 可恢复的secp256k1签名，验证时从签名恢复公钥
 	Address   common.Address // 来源output的地址，如果是跨链交易ToTran则表示来源于轻计算区哪个地址
 	IsToTran  bool           // 是否是跨链交易ToTran的input，true表示是跨链交易ToTran的input，false表示是正常交易
+	// Signatures 花费多签out时的签名，按签名者在锁定条件中的位置排列，此时Signature为空，见multisig.go
+	Signatures [][]byte
+	// Preimage 领取HTLC out时给出的原像，为空表示超时退款，见htlc.go
+	Preimage []byte
 }
 
 // TXInputs 全面对标TXOutputs
@@ -11,23 +11,39 @@ import (
 
 // TXOutput represents a transaction output
 // IsUse 是表明该UTXO是否被使用的标识，用于跨区转账中，默认值false表示还没有被使用，true表示已经被使用
+// LockTime与Maturity是可选的时间锁，见timelock.go
+// Multisig不为空时out需要多个地址签名才能花费，Address是锁定条件的多签地址，见multisig.go
+// HTLC不为空时out是哈希时间锁，Address是锁定条件的HTLC地址，见htlc.go
 type TXOutput struct {
-	Value   int
-	Address common.Address
-	IsUse   bool
+	Value    Amount // 金额，必须大于0
+	Address  common.Address
+	IsUse    bool
+	LockTime uint64        // 绝对锁定，在这个区块高度或时间之前不能花费，0表示不锁定
+	Maturity uint64        // 相对锁定，包含out的区块之后还要再经过的区块个数，0表示不锁定
+	Multisig *MultisigLock // 多签锁定条件，nil表示由Address的私钥签名
+	HTLC     *HTLCLock     // 哈希时间锁定条件，不能与Multisig同时设置
 }
 
 // TXOutput2 用于验证
 type TXOutput2 struct {
-	Value   int
-	Address common.Address
-	Outid   int // 用于验证
-	IsUse   bool
+	Value    Amount
+	Address  common.Address
+	Outid    int // 用于验证
+	IsUse    bool
+	LockTime uint64
+	Maturity uint64
+	Multisig *MultisigLock
+	HTLC     *HTLCLock
+}
+
+// Output 去掉Outid后的out
+func (out TXOutput2) Output() TXOutput {
+	return TXOutput{Value: out.Value, Address: out.Address, IsUse: out.IsUse, LockTime: out.LockTime, Maturity: out.Maturity, Multisig: out.Multisig, HTLC: out.HTLC}
 }
 
 // NewTXOutput create a new TXOutput
-func NewTXOutput(value int, address common.Address) *TXOutput {
-	txo := &TXOutput{value, address, false}
+func NewTXOutput(value Amount, address common.Address) *TXOutput {
+	txo := &TXOutput{Value: value, Address: address, IsUse: false}
 	return txo
 }
 
@@ -56,10 +72,26 @@ type TXOutputs2 struct {
 // TXOutputsTran 用于跨区交易时的UTXO Output
 type TXOutputsTran struct {
 	Out          TXOutput // UTXO的out
-	CoordinatesX int      // 区块号
+	Outid        int      // out在原交易Vout中的位置
+	CoordinatesX int      // 区块高度
 	CoordinatesY int      // 区块中的第几个交易
 }
 
+// UTXOutput chainstate中存储的一个未花费输出
+type UTXOutput struct {
+	TXOutput
+	Outid   int  // out在原交易Vout中的位置
+	Pending bool // 属于还没有结算的占位交易，计入待定余额但不能花费，见placeholder.go
+}
+
+// UTXOutputs chainstate中存储的一笔交易剩余的未花费输出
+// 已花费的out会从Outputs中删除，因此必须通过Outid而不是切片下标来定位out
+type UTXOutputs struct {
+	Outputs []UTXOutput // 未花费的out
+	Height  int         // 交易所在区块的高度，创世区块为0
+	TxIndex int         // 交易在区块中的位置
+}
+
 // Serialize serializes TXOutputs
 func (outs TXOutputs) Serialize() []byte {
 	var buff bytes.Buffer
@@ -85,3 +117,29 @@ func DeserializeOutputs(data []byte) TXOutputs {
 
 	return outputs
 }
+
+// Serialize serializes UTXOutputs
+func (outs UTXOutputs) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(outs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeUTXOutputs deserializes UTXOutputs
+func DeserializeUTXOutputs(data []byte) UTXOutputs {
+	var outputs UTXOutputs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&outputs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return outputs
+}
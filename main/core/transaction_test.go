@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	prev := testCoinbase(t, addrA, 5, 7)
+	other := testCoinbase(t, addrB, 3)
+	prevTXs := map[string]Transaction{hexID(prev): *prev, hexID(other): *other}
+
+	// newTx 花费prev的两个out，用keyA签名
+	newTx := func() *Transaction {
+		tx := &Transaction{
+			Vin:  []TXInput{{Txid: prev.ID, Vout: 0, Address: addrA}, {Txid: prev.ID, Vout: 1, Address: addrA}},
+			Vout: []TXOutput{*NewTXOutput(12, addrC)},
+		}
+		tx.Sign(keyA, prevTXs)
+		return tx
+	}
+
+	tests := []struct {
+		name   string
+		modify func(tx *Transaction)
+		ok     bool
+	}{
+		{"valid", func(tx *Transaction) {}, true},
+		{"changed output", func(tx *Transaction) { tx.Vout[0].Value = 13 }, false},
+		{"changed output address", func(tx *Transaction) { tx.Vout[0].Address = addrB }, false},
+		{"changed type", func(tx *Transaction) { tx.Type = 1 }, false},
+		{"changed account", func(tx *Transaction) { tx.Account = "other" }, false},
+		{"swapped signatures", func(tx *Transaction) {
+			tx.Vin[0].Signature, tx.Vin[1].Signature = tx.Vin[1].Signature, tx.Vin[0].Signature
+		}, false},
+		// 签名覆盖被花费的out，不能挪到另一个out上使用
+		{"redirected input", func(tx *Transaction) { tx.Vin[0].Vout = 1; tx.Vin[1].Vout = 0 }, false},
+		{"missing signature", func(tx *Transaction) { tx.Vin[0].Signature = nil }, false},
+		{"signed by other key", func(tx *Transaction) {
+			tx.Vin[0].Signature, _ = crypto.Sign(tx.SignatureHash(0, prev.Vout[0]), keyB)
+		}, false},
+		{"truncated signature", func(tx *Transaction) { tx.Vin[1].Signature = tx.Vin[1].Signature[:64] }, false},
+		// 把s换成n-s并翻转恢复标识仍能恢复出同一个公钥，必须拒绝，否则第三方可以改变交易ID
+		{"high s", func(tx *Transaction) { tx.Vin[0].Signature = highS(tx.Vin[0].Signature) }, false},
+		{"missing previous transaction", func(tx *Transaction) { tx.Vin[0].Txid = other.ID }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newTx()
+			tt.modify(tx)
+			err := tx.Verify(prevTXs)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Verify = %v，期望通过: %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSignatureHash(t *testing.T) {
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: []byte{1}, Vout: 0, Address: addrA}, {Txid: []byte{2}, Vout: 0, Address: addrA}},
+		Vout: []TXOutput{*NewTXOutput(1, addrB)},
+	}
+	out := *NewTXOutput(5, addrA)
+	hash := tx.SignatureHash(0, out)
+
+	// 签名不参与签名哈希
+	signed := *tx
+	signed.Vin = append([]TXInput{}, tx.Vin...)
+	signed.Vin[1].Signature = []byte{9}
+	if !bytes.Equal(signed.SignatureHash(0, out), hash) {
+		t.Fatal("其他input的签名改变了签名哈希")
+	}
+
+	tests := []struct {
+		name    string
+		inIndex int
+		out     TXOutput
+	}{
+		{"other input", 1, out},
+		{"other value", 0, *NewTXOutput(6, addrA)},
+		{"other address", 0, *NewTXOutput(5, addrB)},
+	}
+	for _, tt := range tests {
+		if bytes.Equal(tx.SignatureHash(tt.inIndex, tt.out), hash) {
+			t.Errorf("%s: 签名哈希没有改变", tt.name)
+		}
+	}
+}
+
+// highS 返回同一个签名的另一种形式 (r, n-s, v^1)
+func highS(sig []byte) []byte {
+	s := new(big.Int).Sub(crypto.S256().Params().N, new(big.Int).SetBytes(sig[32:64]))
+	out := append([]byte{}, sig[:32]...)
+	out = append(out, common.LeftPadBytes(s.Bytes(), 32)...)
+	return append(out, sig[64]^1)
+}
+
+// TestVerifyInputError 验证失败的错误可以用errors.Is判断
+func TestVerifyInputError(t *testing.T) {
+	prev := testCoinbase(t, addrA, 5)
+	tx := testSpend(t, prev, 0, *NewTXOutput(5, addrB))
+	tx.Vin[0].Signature[0] ^= 1
+	err := tx.Verify(map[string]Transaction{hexID(prev): *prev})
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify = %v，期望 %v", err, ErrInvalidSignature)
+	}
+}
+
+// TestHighSRecoversSameSigner 变形后的签名能恢复出同一个签名者，只能依靠low-s规则拒绝
+func TestHighSRecoversSameSigner(t *testing.T) {
+	hash := crypto.Keccak256([]byte("sighash"))
+	sig, err := crypto.Sign(hash, keyA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := crypto.SigToPub(hash, highS(sig))
+	if err != nil || crypto.PubkeyToAddress(*pub) != addrA {
+		t.Fatalf("变形签名恢复出 %v, %v，期望 %x", pub, err, addrA)
+	}
+}
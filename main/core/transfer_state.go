@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// transferStateBucket 跨区转账两阶段提交的状态 转账ID -> TransferRecord
+// 协调者与参与者在发出或回复每个消息之前先把状态写入数据库，崩溃重启后根据这里的记录恢复没有完成的转账
+// 转账状态无法从区块重新推导，因此这个bucket不属于derivedBuckets
+const transferStateBucket = "transferstate"
+
+// TransferPhase 跨区转账在两阶段提交中的阶段
+type TransferPhase int32
+
+const (
+	TransferUnknown   TransferPhase = iota // 没有记录
+	TransferPreparing                      // 协调者已经准备好本地交易，发出了prepare，还没有做出决定
+	TransferPrepared                       // 参与者已经准备好本地交易并投票同意，等待协调者的决定
+	TransferCommitted                      // 已经决定提交
+	TransferAborted                        // 已经决定中止
+)
+
+func (p TransferPhase) String() string {
+	switch p {
+	case TransferUnknown:
+		return "unknown"
+	case TransferPreparing:
+		return "preparing"
+	case TransferPrepared:
+		return "prepared"
+	case TransferCommitted:
+		return "committed"
+	case TransferAborted:
+		return "aborted"
+	default:
+		return fmt.Sprintf("unknown(%d)", int32(p))
+	}
+}
+
+// TransferRole 本节点在转账中的角色
+type TransferRole int32
+
+const (
+	TransferCoordinator TransferRole = iota // 协调者，发起转账并做出提交或中止的决定
+	TransferParticipant                     // 参与者，投票并执行协调者的决定
+)
+
+var (
+	ErrTransferNotFound        = errors.New("跨区转账记录不存在")
+	ErrTransferStateTransition = errors.New("不允许的跨区转账状态转换")
+)
+
+// TransferRecord 一次跨区转账在本节点的状态
+type TransferRecord struct {
+	ID          []byte         // 转账ID，协调者生成，两端相同
+	Role        TransferRole   // 本节点的角色
+	Phase       TransferPhase  // 当前阶段
+	Direction   int            // 转账方向，与交易的Type相同：1表示转账区->轻计算区，2表示轻计算区->转账区
+	FromAddress common.Address // 转出地址
+	BAddress    common.Address // 转入地址
+	Amount      Amount         // 转账金额
+	HashLock    []byte         // 两端交易共同使用的HTLC哈希值
+	TX          []byte         // 本节点准备的交易的规范编码，中止时不会被提交
+	Peer        string         // 对方TransferGRPC服务的地址：协调者记录参与者，参与者记录协调者
+	Coordinator common.Address // 参与者记录签名prepare的协调者地址，之后只接受这个地址签名的决定
+	Finished    bool           // 决定已经执行完毕：本地交易已经提交，协调者已经收到参与者的确认
+	Updated     int64          // 最后一次修改的Unix时间
+}
+
+// Transaction 解码本节点准备的交易
+func (r *TransferRecord) Transaction() (*Transaction, error) {
+	return DecodeTransaction(r.TX)
+}
+
+// Serialize serializes TransferRecord
+func (r TransferRecord) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(r)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeTransferRecord deserializes TransferRecord
+func DeserializeTransferRecord(data []byte) (*TransferRecord, error) {
+	var r TransferRecord
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// canTransition 决定一旦做出就不能改变：提交与中止之间不能相互转换，也不能回到准备阶段
+func (p TransferPhase) canTransition(next TransferPhase) bool {
+	if p == next || p == TransferUnknown {
+		return true
+	}
+	switch p {
+	case TransferPreparing, TransferPrepared:
+		return next == TransferCommitted || next == TransferAborted
+	default:
+		return false
+	}
+}
+
+// PutTransfer 保存转账记录，检查阶段转换是否允许，写入完成后才返回
+func (bc *BlockChain) PutTransfer(r *TransferRecord) error {
+	return bc.db.Update(func(dbTx *bolt.Tx) error {
+		b := dbTx.Bucket([]byte(transferStateBucket))
+		current := TransferUnknown
+		if v := b.Get(r.ID); v != nil {
+			old, err := DeserializeTransferRecord(v)
+			if err != nil {
+				return err
+			}
+			current = old.Phase
+		}
+		if !current.canTransition(r.Phase) {
+			return fmt.Errorf("! 转账 %x 的状态不能从 %v 转换为 %v: %w", r.ID, current, r.Phase, ErrTransferStateTransition)
+		}
+		r.Updated = time.Now().Unix()
+		return b.Put(r.ID, r.Serialize())
+	})
+}
+
+// GetTransfer 读取转账记录，没有记录时返回ErrTransferNotFound
+func (bc *BlockChain) GetTransfer(id []byte) (*TransferRecord, error) {
+	var r *TransferRecord
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		v := dbTx.Bucket([]byte(transferStateBucket)).Get(id)
+		if v == nil {
+			return fmt.Errorf("! 转账 %x: %w", id, ErrTransferNotFound)
+		}
+		var err error
+		r, err = DeserializeTransferRecord(v)
+		return err
+	})
+	return r, err
+}
+
+// UnfinishedTransfers 所有还没有执行完毕的转账，重启后恢复时使用
+func (bc *BlockChain) UnfinishedTransfers() ([]*TransferRecord, error) {
+	var records []*TransferRecord
+	err := bc.db.View(func(dbTx *bolt.Tx) error {
+		return dbTx.Bucket([]byte(transferStateBucket)).ForEach(func(k, v []byte) error {
+			r, err := DeserializeTransferRecord(v)
+			if err != nil {
+				return err
+			}
+			if !r.Finished {
+				records = append(records, r)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
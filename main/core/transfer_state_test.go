@@ -0,0 +1,75 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestTransferStateTransition 决定一旦写入数据库就不能改变
+func TestTransferStateTransition(t *testing.T) {
+	tests := []struct {
+		from, to TransferPhase
+		ok       bool
+	}{
+		{TransferUnknown, TransferPreparing, true},
+		{TransferUnknown, TransferAborted, true},
+		{TransferPreparing, TransferCommitted, true},
+		{TransferPreparing, TransferAborted, true},
+		{TransferPrepared, TransferCommitted, true},
+		{TransferPrepared, TransferAborted, true},
+		{TransferPrepared, TransferPreparing, false},
+		{TransferCommitted, TransferCommitted, true},
+		{TransferCommitted, TransferAborted, false},
+		{TransferCommitted, TransferPrepared, false},
+		{TransferAborted, TransferCommitted, false},
+		{TransferAborted, TransferPrepared, false},
+	}
+	bc := newTestChain(t)
+	for i, tt := range tests {
+		t.Run(tt.from.String()+" to "+tt.to.String(), func(t *testing.T) {
+			id := []byte{byte(i)}
+			if tt.from != TransferUnknown {
+				if err := bc.PutTransfer(&TransferRecord{ID: id, Phase: tt.from}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &TransferRecord{ID: id, Phase: tt.to, Finished: true}
+			err := bc.PutTransfer(r)
+			if tt.ok != (err == nil) {
+				t.Fatalf("PutTransfer = %v，期望成功: %v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrTransferStateTransition) {
+				t.Fatalf("PutTransfer = %v，期望 %v", err, ErrTransferStateTransition)
+			}
+			got, err := bc.GetTransfer(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.to
+			if !tt.ok {
+				want = tt.from
+			}
+			if got.Phase != want {
+				t.Fatalf("记录的阶段 %v，期望 %v", got.Phase, want)
+			}
+		})
+	}
+
+	// 被拒绝的转换没有写入，之前写入的记录仍然没有完成
+	rejected := 0
+	for _, tt := range tests {
+		if !tt.ok {
+			rejected++
+		}
+	}
+	unfinished, err := bc.UnfinishedTransfers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished) != rejected {
+		t.Fatalf("%d 个未完成的转账，期望 %d 个", len(unfinished), rejected)
+	}
+	if _, err := bc.GetTransfer([]byte("missing")); !errors.Is(err, ErrTransferNotFound) {
+		t.Fatalf("GetTransfer = %v，期望 %v", err, ErrTransferNotFound)
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// txIndexBucket 交易索引 txid -> TxLocation
+// txCoordBucket 交易坐标索引 区块高度||区块中的位置 -> txid
+const txIndexBucket = "txindex"
+const txCoordBucket = "txcoord"
+
+// TxLocation 交易在区块链中的位置
+type TxLocation struct {
+	BlockHash []byte // 交易所在区块的哈希值
+	Height    int    // 交易所在区块的高度
+	Index     int    // 交易在区块中的位置
+}
+
+// Serialize serializes TxLocation
+func (l TxLocation) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(l)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeTxLocation deserializes TxLocation
+func DeserializeTxLocation(data []byte) TxLocation {
+	var l TxLocation
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&l)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return l
+}
+
+// txCoordKey 交易坐标索引的键：8字节大端高度 || 4字节大端位置
+func txCoordKey(height int, index int) []byte {
+	key := make([]byte, 12)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	binary.BigEndian.PutUint32(key[8:], uint32(index))
+	return key
+}
+
+// indexBlockTransactions 将区块中所有交易的位置写入交易索引，需要在写入区块的同一个事务中调用
+func indexBlockTransactions(dbTx *bolt.Tx, block *Block, blockHash []byte, height int) error {
+	ti := dbTx.Bucket([]byte(txIndexBucket))
+	tc := dbTx.Bucket([]byte(txCoordBucket))
+
+	for i, tx := range block.Body.Transactions {
+		loc := TxLocation{BlockHash: blockHash, Height: height, Index: i}
+		err := ti.Put(tx.ID, loc.Serialize())
+		if err != nil {
+			return err
+		}
+		err = tc.Put(txCoordKey(height, i), tx.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readBlock 在事务中按哈希值读取区块
+func readBlock(dbTx *bolt.Tx, hash []byte) (*Block, error) {
+	encodedBlock := dbTx.Bucket([]byte(blocksBucket)).Get(hash)
+	if encodedBlock == nil {
+		return nil, errors.New("Block is not found")
+	}
+	block := DeserializeBlock(encodedBlock)
+	block.Header.State = getBlockState(dbTx, hash)
+	return block, nil
+}
+
+// FindTxLocation 通过交易索引查询交易所在的区块与位置
+func (bc *BlockChain) FindTxLocation(ID []byte) (TxLocation, error) {
+	var loc TxLocation
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(txIndexBucket)).Get(ID)
+		if v == nil {
+			return errors.New("Transaction is not found")
+		}
+		loc = DeserializeTxLocation(v)
+		return nil
+	})
+
+	return loc, err
+}
+
+// findIndexedTransaction 通过交易索引读取交易以及它所在的区块
+func (bc *BlockChain) findIndexedTransaction(ID []byte) (*Transaction, *Block, error) {
+	var transaction *Transaction
+	var block *Block
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(txIndexBucket)).Get(ID)
+		if v == nil {
+			return errors.New("Transaction is not found")
+		}
+		loc := DeserializeTxLocation(v)
+
+		var err error
+		block, err = readBlock(tx, loc.BlockHash)
+		if err != nil {
+			return err
+		}
+		if loc.Index >= len(block.Body.Transactions) {
+			return errors.New("Transaction index is corrupted")
+		}
+		transaction = block.Body.Transactions[loc.Index]
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return transaction, block, nil
+}
+
+// FindTransactionByCoordinates 通过交易坐标索引查询交易，height是区块高度，index是交易在区块中的位置
+func (bc *BlockChain) FindTransactionByCoordinates(height int, index int) (Transaction, error) {
+	var txid []byte
+
+	err := bc.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(txCoordBucket)).Get(txCoordKey(height, index))
+		if v == nil {
+			return errors.New("Transaction is not found")
+		}
+		txid = append([]byte{}, v...)
+		return nil
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	return bc.FindTransaction(txid)
+}
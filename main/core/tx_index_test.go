@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTransactionIndex(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5, 7)
+	first := addTestBlock(t, bc, cb)
+	spend := testSpend(t, cb, 1, *NewTXOutput(7, addrB))
+	other := testCoinbase(t, addrC, 1)
+	second := addTestBlock(t, bc, other, spend)
+	firstHash, _ := first.Hash()
+	secondHash, _ := second.Hash()
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		loc  TxLocation
+	}{
+		{"first block", cb, TxLocation{BlockHash: firstHash, Height: 1, Index: 0}},
+		{"second position", spend, TxLocation{BlockHash: secondHash, Height: 2, Index: 1}},
+		{"first position", other, TxLocation{BlockHash: secondHash, Height: 2, Index: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := bc.FindTxLocation(tt.tx.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(loc.BlockHash, tt.loc.BlockHash) || loc.Height != tt.loc.Height || loc.Index != tt.loc.Index {
+				t.Fatalf("交易位置 %+v，期望 %+v", loc, tt.loc)
+			}
+
+			tx, err := bc.FindTransaction(tt.tx.ID)
+			if err != nil || !bytes.Equal(tx.ID, tt.tx.ID) {
+				t.Fatalf("FindTransaction = %x, %v", tx.ID, err)
+			}
+			tx, err = bc.FindTransactionByCoordinates(tt.loc.Height, tt.loc.Index)
+			if err != nil || !bytes.Equal(tx.ID, tt.tx.ID) {
+				t.Fatalf("FindTransactionByCoordinates = %x, %v", tx.ID, err)
+			}
+			// 花费之后的out仍然可以通过交易索引找到
+			if out := bc.FindTXOut(tt.tx.ID, 0); out != tt.tx.Vout[0] {
+				t.Fatalf("FindTXOut = %+v，期望 %+v", out, tt.tx.Vout[0])
+			}
+
+			proof, root, err := bc.GetMerkleProof(tt.tx.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyMerkleProof(root, proof) {
+				t.Fatal("交易的默克尔证明验证失败")
+			}
+		})
+	}
+
+	if _, err := bc.FindTransaction([]byte("missing")); err == nil {
+		t.Error("找到了不存在的交易")
+	}
+	if _, err := bc.FindTransactionByCoordinates(2, 2); err == nil {
+		t.Error("找到了不存在的坐标")
+	}
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"encoding/hex"
+	"fmt"
 	"log"
 
 	"github.com/boltdb/bolt"
@@ -20,9 +21,9 @@ type UTXOSet []Transaction
 // FindSpendableOutputs finds and returns unspent outputs to reference in inputs
 // TODO : 考虑将pubkeyByte改为address
 // 暂时不用这个方法
-func (u UTXOSet1) FindSpendableOutputs(pubkeyByte []byte, amount int) (int, map[string][]int) {
+func (u UTXOSet1) FindSpendableOutputs(pubkeyByte []byte, amount Amount) (Amount, map[string][]int) {
 	unspentOutputs := make(map[string][]int)
-	accumulated := 0
+	var accumulated Amount
 	db := u.Blockchain.db
 
 	err := db.View(func(tx *bolt.Tx) error {
@@ -41,15 +42,15 @@ func (u UTXOSet1) FindSpendableOutputs(pubkeyByte []byte, amount int) (int, map[
 			txID := hex.EncodeToString(k)
 			// 将存储在数据库中的序列化的交易输出数据反序列化为原始的交易输出对象。
 			//在很多数据库中，包括 BoltDB 在内，数据存储的时候通常会以一种紧凑的格式进行序列化，以便于存储和传输。在这种情况下，当你需要使用这些数据时，你需要将其反序列化为原始的数据结构，以便于对其进行操作和处理
-			outs := DeserializeOutputs(v)
+			outs := DeserializeUTXOutputs(v)
 
 			// 针对每个交易输出，检查它是否被指定的公钥（pubkeyByte）锁定，并且累计的未花费总额（accumulated）小于某个指定的金额（amount）。如果满足这些条件，则将该输出添加到未花费输出的列表中（unspentOutputs），并更新累计金额
-			for outIdx, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyByte) && accumulated < amount {
+			for _, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubkeyByte) && !out.Pending && accumulated < amount {
 					// 每次累加UTXO的余额，直到超过余额后跳出循环
-					accumulated += out.Value
-					// 如果满足这些条件，则将该输出添加到未花费输出的列表中
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIdx)
+					accumulated = accumulated.saturatingAdd(out.Value)
+					// 如果满足这些条件，则将该输出添加到未花费输出的列表中，记录的是out在原交易中的位置
+					unspentOutputs[txID] = append(unspentOutputs[txID], out.Outid)
 				}
 			}
 
@@ -75,11 +76,11 @@ func (u UTXOSet1) FindUTXO(pubKeyHash []byte) []TXOutput {
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
+			outs := DeserializeUTXOutputs(v)
 
 			for _, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
+					UTXOs = append(UTXOs, out.TXOutput)
 				}
 			}
 		}
@@ -116,98 +117,191 @@ func (u UTXOSet1) CountTransactions() int {
 }
 
 // Reindex rebuilds the UTXO set
+// 从第一个区块开始按顺序重新应用所有区块，同时重建地址索引与交易索引，在一个事务中完成，失败时保持原样
 func (u UTXOSet1) Reindex() {
 	db := u.Blockchain.db
-	bucketName := []byte(utxoBucket)
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
-		}
 
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			log.Panic(err)
+	// 沿PrevBlock从tip向前遍历，需要反转为从创世区块开始的顺序
+	// 旧版本的区块头中没有高度，因此这里不依赖Header.Height，区块高度按位置重新计算
+	var blocks []*Block
+	var hashes [][]byte
+	err := db.View(func(tx *bolt.Tx) error {
+		for hash := u.Blockchain.tip; len(hash) != 0; {
+			block, err := readBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+			hashes = append(hashes, hash)
+			hash = block.Header.PrevBlock
 		}
-
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	UTXO := u.Blockchain.FindUTXOutputs()
-
 	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+		// 地址索引与交易索引同样由区块派生，一起重建
+		for _, name := range derivedBuckets {
+			err := tx.DeleteBucket([]byte(name))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
+			_, err = tx.CreateBucket([]byte(name))
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
+		}
 
-			err = b.Put(key, outs.Serialize())
+		for height := 0; height < len(blocks); height++ {
+			i := len(blocks) - 1 - height
+			err := connectBlock(tx, blocks[i], hashes[i], height)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
 
-		return nil
+		// 主链区块在connectBlock中标记为valid，其余区块是commit
+		err := fillMissingBlockStates(tx)
+		if err != nil {
+			return err
+		}
+
+		return putIndexVersion(tx.Bucket([]byte(blocksBucket)), indexVersion)
 	})
+	if err != nil {
+		log.Panic(err)
+	}
 }
 
 // Update updates the UTXO set with transactions from the Block
 // The Block is considered to be the tip of a blockchain
+// 正常情况下不需要调用，AddBlock在保存区块的同一个事务中已经更新了UTXO集合
 func (u UTXOSet1) Update(block *Block) {
 	db := u.Blockchain.db
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+		height := getTipHeight(tx.Bucket([]byte(blocksBucket)))
+		_, err := applyBlockUTXO(tx, block, height)
+		return err
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-		for _, tx := range block.Body.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+// spendsUTXO 判断交易的input是否花费了转账区的UTXO
+// Coinbase交易与跨链交易ToTran的input来源于轻计算区，不引用转账区的out
+func spendsUTXO(tx *Transaction, in TXInput) bool {
+	return !tx.IsCoinbase() && !in.IsToTran
+}
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
+// SpentOutPoints 交易花费的转账区out，交易池据此发现重复花费同一个out的交易
+func (tx *Transaction) SpentOutPoints() []OutPoint {
+	var ops []OutPoint
+	for _, vin := range tx.Vin {
+		if spendsUTXO(tx, vin) {
+			ops = append(ops, OutPoint{vin.Txid, vin.Vout})
+		}
+	}
+	return ops
+}
+
+// applyBlockUTXO 将区块中的交易应用到chainstate与地址索引：删除input花费的out，加入新产生的out
+// height是区块的高度，需要在写入区块的同一个事务中调用，保证区块与UTXO集合同时更新
+// 返回的BlockUndo记录了被花费的out，回滚区块时用于恢复UTXO集合
+func applyBlockUTXO(dbTx *bolt.Tx, block *Block, height int) (*BlockUndo, error) {
+	b := dbTx.Bucket([]byte(utxoBucket))
+	ai := dbTx.Bucket([]byte(addrIndexBucket))
+	undo := &BlockUndo{Spent: make([][]SpentOutput, len(block.Body.Transactions))}
+
+	for txIndex, tx := range block.Body.Transactions {
+		if tx.IsSettlement() {
+			spent, filled, err := applySettlement(dbTx, tx)
+			if err != nil {
+				return nil, err
+			}
+			undo.Spent[txIndex] = append(undo.Spent[txIndex], spent...)
+			if filled != nil {
+				undo.Filled = append(undo.Filled, filled)
+			}
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			if !spendsUTXO(tx, vin) {
+				continue
+			}
 
+			outsBytes := b.Get(vin.Txid)
+			if outsBytes == nil {
+				return nil, fmt.Errorf("! 交易 %x 花费的输出 %x:%d 不在UTXO集合中", tx.ID, vin.Txid, vin.Vout)
+			}
+			outs := DeserializeUTXOutputs(outsBytes)
+
+			updatedOuts := UTXOutputs{Height: outs.Height, TxIndex: outs.TxIndex}
+			found := false
+			for _, out := range outs.Outputs {
+				if out.Outid == vin.Vout {
+					found = true
+					undo.Spent[txIndex] = append(undo.Spent[txIndex], SpentOutput{
+						Txid:    vin.Txid,
+						Out:     out,
+						Height:  outs.Height,
+						TxIndex: outs.TxIndex,
+					})
+					err := unindexAddressOutput(ai, out.Address, vin.Txid, out.Outid)
+					if err != nil {
+						return nil, err
+					}
+					continue
 				}
+				updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+			}
+			if !found {
+				return nil, fmt.Errorf("! 交易 %x 花费的输出 %x:%d 不在UTXO集合中", tx.ID, vin.Txid, vin.Vout)
 			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
+			if len(updatedOuts.Outputs) == 0 {
+				err := b.Delete(vin.Txid)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				err := b.Put(vin.Txid, updatedOuts.Serialize())
+				if err != nil {
+					return nil, err
+				}
 			}
+		}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
+		newOutputs := UTXOutputs{Height: height, TxIndex: txIndex}
+		for outIdx, out := range tx.Vout {
+			// IsUse为true的out已经转到轻计算区，不属于转账区的UTXO
+			if out.IsUse {
+				continue
+			}
+			newOutputs.Outputs = append(newOutputs.Outputs, UTXOutput{
+				TXOutput: out,
+				Outid:    outIdx,
+				Pending:  tx.IsPlaceholder(), // 占位交易的out在结算之前处于待定状态
+			})
+			err := indexAddressOutput(ai, out.Address, tx.ID, outIdx)
 			if err != nil {
-				log.Panic(err)
+				return nil, err
 			}
 		}
+		if len(newOutputs.Outputs) == 0 {
+			continue
+		}
 
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
+		err := b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	return undo, nil
 }
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// 区块被拒绝的原因，可以通过errors.Is判断
+var (
+	ErrPrevBlockMismatch   = errors.New("前一个区块哈希值与当前最新区块不符")
+	ErrHeightMismatch      = errors.New("区块高度与当前最新区块不连续")
+	ErrMerkleRootMismatch  = errors.New("默克尔根与区块中的交易不符")
+	ErrTxIDMismatch        = errors.New("交易ID与交易内容的哈希值不符")
+	ErrDuplicateTx         = errors.New("区块中存在重复的交易")
+	ErrEmptyTx             = errors.New("交易没有input或output")
+	ErrMissingInput        = errors.New("input引用的输出不存在或已经被花费")
+	ErrInputAddress        = errors.New("input的地址与引用输出的地址不符")
+	ErrDoubleSpend         = errors.New("区块中的多个交易花费了同一个输出")
+	ErrOutputsExceedInputs = errors.New("交易输出总额大于输入总额")
+	ErrOrphanBlock         = errors.New("前一个区块不存在")
+	ErrBlockExists         = errors.New("区块已经存在")
+	ErrInvalidBlock        = errors.New("区块或它的祖先已经被标记为无效")
+	ErrInvalidSignature    = errors.New("input的签名无效")
+	ErrInvalidReward       = errors.New("奖励交易只能是区块的第一个交易，不能引用任何out，并且需要记录区块高度")
+	ErrRewardExceedsFees   = errors.New("奖励交易的金额超过区块内交易的手续费与出块补贴")
+	ErrInvalidAmount       = errors.New("output的金额必须大于0，金额之和不能超过上限")
+)
+
+// BlockValidationError 区块验证失败时返回的错误，说明哪个区块、哪个交易因为什么原因被拒绝
+type BlockValidationError struct {
+	BlockHash []byte // 被拒绝的区块哈希值
+	TxIndex   int    // 出错的交易在区块中的位置，-1表示区块头出错
+	Err       error  // 具体原因，是上面定义的错误之一
+}
+
+func (e *BlockValidationError) Error() string {
+	if e.TxIndex < 0 {
+		return fmt.Sprintf("! 区块 %x 验证失败: %v", e.BlockHash, e.Err)
+	}
+	return fmt.Sprintf("! 区块 %x 的第 %d 个交易验证失败: %v", e.BlockHash, e.TxIndex, e.Err)
+}
+
+func (e *BlockValidationError) Unwrap() error {
+	return e.Err
+}
+
+// utxoView 在chainstate之上叠加尚未写入的修改，用于按顺序验证同一个区块中的交易
+type utxoView struct {
+	dbTx    *bolt.Tx
+	inBlock bool // 正在验证完整的区块，只有这时可以出现奖励交易
+	b       *bolt.Bucket
+	lock    LockContext          // 交易所在区块的高度与时间，用于检查时间锁与确定生效的协调者
+	spent   map[string]bool      // 已经被前面的交易花费的输出，键是OutPoint.Key()
+	created map[string]UTXOutput // 前面的交易新产生的输出，它们在高度为lock.Height的区块中
+	settled map[string]bool      // 已经被前面的结算交易结算的占位交易，键是占位交易ID
+}
+
+func newUTXOView(dbTx *bolt.Tx, lock LockContext) *utxoView {
+	return &utxoView{
+		dbTx:    dbTx,
+		b:       dbTx.Bucket([]byte(utxoBucket)),
+		lock:    lock,
+		spent:   make(map[string]bool),
+		created: make(map[string]UTXOutput),
+		settled: make(map[string]bool),
+	}
+}
+
+// fetch 查询一个尚未花费的输出，同时返回包含它的区块高度
+func (v *utxoView) fetch(op OutPoint) (UTXOutput, uint64, bool) {
+	key := string(op.Key())
+	if v.spent[key] {
+		return UTXOutput{}, 0, false
+	}
+	if out, ok := v.created[key]; ok {
+		return out, v.lock.Height, true
+	}
+
+	data := v.b.Get(op.Txid)
+	if data == nil {
+		return UTXOutput{}, 0, false
+	}
+	entry := DeserializeUTXOutputs(data)
+	for _, out := range entry.Outputs {
+		if out.Outid == op.Vout {
+			return out, uint64(entry.Height), true
+		}
+	}
+	return UTXOutput{}, 0, false
+}
+
+// spend 标记输出已经被花费
+func (v *utxoView) spend(op OutPoint) {
+	v.spent[string(op.Key())] = true
+}
+
+// add 加入交易新产生的输出
+func (v *utxoView) add(tx *Transaction) {
+	for i, out := range tx.Vout {
+		if out.IsUse {
+			continue
+		}
+		v.created[string(OutPoint{tx.ID, i}.Key())] = UTXOutput{TXOutput: out, Outid: i, Pending: tx.IsPlaceholder()}
+	}
+}
+
+// validateTransaction 在view的基础上验证一个交易，验证通过后把交易的修改应用到view
+// 返回交易的手续费，即花费的out金额之和减去output金额之和，没有花费out的交易手续费为0
+// 每个output的金额必须大于0，金额的求和都检查溢出
+// 交易的锁定时间与花费的out的时间锁按view所在区块的高度与时间检查
+// 结算交易没有output，手续费为0
+func validateTransaction(tx *Transaction, view *utxoView) (Amount, error) {
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return 0, ErrTxIDMismatch
+	}
+	if len(tx.Vin) == 0 || (len(tx.Vout) == 0 && !tx.IsCoordinatorTX() && !tx.IsSettlement()) {
+		return 0, ErrEmptyTx
+	}
+	if !tx.IsFinal(view.lock) {
+		return 0, ErrTxLocked
+	}
+	if tx.IsSettlement() {
+		return 0, validateSettlement(tx, view)
+	}
+	if tx.IsPlaceholder() && checkPlaceholder(tx) != nil {
+		return 0, ErrInvalidPlaceholder
+	}
+
+	// 没有引用out的交易只能是协调者授权的ToTran交易、占位交易、协调者交易或奖励交易，见coordinator.go
+	mint := tx.IsCoinbase()
+	if mint {
+		err := checkMint(view, tx)
+		if err != nil {
+			return 0, err
+		}
+	} else if tx.IsCoordinatorTX() {
+		return 0, ErrInvalidCoordinatorTX
+	}
+
+	var inputs, outputs Amount
+	seen := make(map[string]bool)
+	for i, vin := range tx.Vin {
+		// 引用了out的交易不能再从轻计算区转入钱
+		if !mint && vin.IsToTran {
+			return 0, ErrUnauthorizedMint
+		}
+		if !spendsUTXO(tx, vin) {
+			continue
+		}
+
+		op := OutPoint{vin.Txid, vin.Vout}
+		if seen[string(op.Key())] {
+			return 0, ErrDoubleSpend
+		}
+		seen[string(op.Key())] = true
+
+		out, height, ok := view.fetch(op)
+		if !ok {
+			if view.spent[string(op.Key())] {
+				return 0, ErrDoubleSpend
+			}
+			return 0, ErrMissingInput
+		}
+		if out.Pending {
+			return 0, ErrPendingOutput
+		}
+		if out.Address != vin.Address {
+			return 0, ErrInputAddress
+		}
+		if !out.IsSpendable(height, view.lock) {
+			return 0, ErrOutputLocked
+		}
+		if out.HTLC != nil {
+			if err := checkHTLCInput(vin, out.HTLC, view.lock); err != nil {
+				return 0, err
+			}
+		}
+		if err := tx.verifyInput(i, out.TXOutput, false); err != nil {
+			return 0, ErrInvalidSignature
+		}
+		var err error
+		inputs, err = inputs.Add(out.Value)
+		if err != nil {
+			return 0, ErrInvalidAmount
+		}
+	}
+	for _, out := range tx.Vout {
+		if CheckOutputAmount(out.Value) != nil {
+			return 0, ErrInvalidAmount
+		}
+		if checkMultisigOutput(&out) != nil {
+			return 0, ErrInvalidMultisig
+		}
+		if checkHTLCOutput(&out) != nil {
+			return 0, ErrInvalidHTLC
+		}
+		var err error
+		outputs, err = outputs.Add(out.Value)
+		if err != nil {
+			return 0, ErrInvalidAmount
+		}
+	}
+	// 协调者授权的ToTran交易的钱来自轻计算区，奖励交易的钱来自手续费，都没有转账区的输入
+	if !mint && outputs > inputs {
+		return 0, ErrOutputsExceedInputs
+	}
+	var fee Amount
+	if !mint {
+		fee = inputs - outputs
+	}
+
+	for _, vin := range tx.Vin {
+		if spendsUTXO(tx, vin) {
+			view.spend(OutPoint{vin.Txid, vin.Vout})
+		}
+	}
+	view.add(tx)
+
+	return fee, nil
+}
+
+// checkBlockSanity 不依赖链上状态的区块检查：默克尔根正确，交易ID正确且没有重复
+// 侧链区块在保存时只做这部分检查，完整的验证在它成为主链的一部分时进行
+func checkBlockSanity(block *Block, hash []byte) error {
+	fail := func(txIndex int, err error) error {
+		return &BlockValidationError{BlockHash: hash, TxIndex: txIndex, Err: err}
+	}
+
+	if !bytes.Equal(block.Header.MerkelRoot, block.Body.MerkleRoot()) {
+		return fail(-1, ErrMerkleRootMismatch)
+	}
+
+	txids := make(map[string]bool)
+	for i, tx := range block.Body.Transactions {
+		if txids[string(tx.ID)] {
+			return fail(i, ErrDuplicateTx)
+		}
+		txids[string(tx.ID)] = true
+
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return fail(i, ErrTxIDMismatch)
+		}
+		if len(tx.Vin) == 0 || (len(tx.Vout) == 0 && !tx.IsCoordinatorTX() && !tx.IsSettlement()) {
+			return fail(i, ErrEmptyTx)
+		}
+	}
+
+	return nil
+}
+
+// validateBlock 在写入区块之前验证区块：必须接在当前最新区块之后，默克尔根正确，每个交易有效且没有重复花费
+// 需要在写入区块的同一个事务中调用，保证验证时看到的UTXO集合就是区块将要应用的UTXO集合
+func validateBlock(dbTx *bolt.Tx, block *Block, hash []byte) error {
+	fail := func(txIndex int, err error) error {
+		return &BlockValidationError{BlockHash: hash, TxIndex: txIndex, Err: err}
+	}
+
+	b := dbTx.Bucket([]byte(blocksBucket))
+	tip := b.Get([]byte("l"))
+	if !bytes.Equal(block.Header.PrevBlock, tip) {
+		return fail(-1, ErrPrevBlockMismatch)
+	}
+	if block.Header.Height != uint64(getTipHeight(b)+1) {
+		return fail(-1, ErrHeightMismatch)
+	}
+
+	err := checkBlockSanity(block, hash)
+	if err != nil {
+		return err
+	}
+
+	lock, err := lockContextAfter(dbTx, block.Header.PrevBlock)
+	if err != nil {
+		return fail(-1, err)
+	}
+	view := newUTXOView(dbTx, lock)
+	view.inBlock = true
+	var fees, reward Amount
+	for i, tx := range block.Body.Transactions {
+		if tx.Type == rewardTxType {
+			if i != 0 || !isValidReward(tx, block.Header.Height) {
+				return fail(i, ErrInvalidReward)
+			}
+		}
+
+		fee, err := validateTransaction(tx, view)
+		if err != nil {
+			return fail(i, err)
+		}
+		if tx.Type == rewardTxType {
+			// 奖励交易没有花费out，手续费为0，它的output金额之和已经在validateTransaction中检查过溢出
+			for _, out := range tx.Vout {
+				reward += out.Value
+			}
+		}
+		fees, err = fees.Add(fee)
+		if err != nil {
+			return fail(i, ErrInvalidAmount)
+		}
+	}
+	limit, err := BlockReward(fees, block.Header.Height)
+	if err != nil {
+		return fail(0, ErrInvalidAmount)
+	}
+	if reward > limit {
+		return fail(0, ErrRewardExceedsFees)
+	}
+
+	return nil
+}
+
+// isValidReward 奖励交易不引用任何out，input的Signature字段是8字节大端的区块高度，每个out至少锁定coinbaseMaturity个区块
+func isValidReward(tx *Transaction, height uint64) bool {
+	if !tx.IsCoinbase() || len(tx.Vin[0].Signature) != 8 {
+		return false
+	}
+	for _, out := range tx.Vout {
+		if out.Maturity < coinbaseMaturity {
+			return false
+		}
+	}
+	return binary.BigEndian.Uint64(tx.Vin[0].Signature) == height
+}
+
+// ValidateTransaction 根据当前的UTXO集合验证一个交易：交易ID正确，input引用的out存在且没有被花费，金额足够，签名有效
+// 只依赖链上数据，不会修改数据库
+// 奖励交易只能出现在区块中，不能单独验证通过
+func (bc *BlockChain) ValidateTransaction(tx *Transaction) error {
+	_, err := bc.TransactionFee(tx)
+	return err
+}
+
+// ValidateBlock 验证区块是否可以接在当前最新区块之后，不会修改数据库
+func (bc *BlockChain) ValidateBlock(block *Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+
+	return bc.db.View(func(tx *bolt.Tx) error {
+		if err := checkBlockHeader(tx, block.Header, hash); err != nil {
+			return err
+		}
+		return validateBlock(tx, block, hash)
+	})
+}
@@ -0,0 +1,223 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestValidateBlockRejects(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5, 7)
+	addTestBlock(t, bc, cb)
+	otherKey, _ := crypto.GenerateKey()
+
+	tests := []struct {
+		name  string
+		block func() *Block
+		want  error
+	}{
+		{"unknown prev block", func() *Block {
+			return NewBlockWithTransactions([]byte("other"), 2, []*Transaction{testCoinbase(t, addrA, 1)})
+		}, ErrOrphanBlock},
+		{"wrong height", func() *Block {
+			return mineTestBlock(t, bc, NewBlockWithTransactions(bc.tip, 3, []*Transaction{testCoinbase(t, addrA, 1)}))
+		}, ErrHeightMismatch},
+		{"wrong merkle root", func() *Block {
+			block := newTestBlock(t, bc, testCoinbase(t, addrA, 1))
+			block.Body.Transactions = append(block.Body.Transactions, testCoinbase(t, addrB, 1))
+			return block
+		}, ErrMerkleRootMismatch},
+		{"tampered transaction", func() *Block {
+			tx := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+			tx.Vout[0].Address = addrC
+			return newTestBlock(t, bc, tx)
+		}, ErrTxIDMismatch},
+		{"duplicate transaction", func() *Block {
+			tx := testCoinbase(t, addrA, 1)
+			return newTestBlock(t, bc, tx, tx)
+		}, ErrDuplicateTx},
+		{"no outputs", func() *Block {
+			return newTestBlock(t, bc, testSpend(t, cb, 0))
+		}, ErrEmptyTx},
+		{"missing input", func() *Block {
+			missing := &Transaction{ID: []byte("missing"), Vout: []TXOutput{{Value: 1, Address: addrA}}}
+			return newTestBlock(t, bc, testSpend(t, missing, 0, *NewTXOutput(1, addrB)))
+		}, ErrMissingInput},
+		{"wrong input address", func() *Block {
+			tx := &Transaction{
+				Vin:  []TXInput{{Txid: cb.ID, Vout: 0, Address: addrB}},
+				Vout: []TXOutput{*NewTXOutput(5, addrB)},
+			}
+			tx.ID = tx.Hash()
+			return newTestBlock(t, bc, tx)
+		}, ErrInputAddress},
+		{"same input twice", func() *Block {
+			tx := &Transaction{
+				Vin:  []TXInput{{Txid: cb.ID, Vout: 0, Address: addrA}, {Txid: cb.ID, Vout: 0, Address: addrA}},
+				Vout: []TXOutput{*NewTXOutput(10, addrB)},
+			}
+			testSign(t, tx, cb)
+			return newTestBlock(t, bc, tx)
+		}, ErrDoubleSpend},
+		{"double spend in block", func() *Block {
+			return newTestBlock(t, bc, testSpend(t, cb, 0, *NewTXOutput(5, addrB)), testSpend(t, cb, 0, *NewTXOutput(5, addrC)))
+		}, ErrDoubleSpend},
+		{"unsigned input", func() *Block {
+			tx := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+			tx.Vin[0].Signature = nil
+			tx.ID = tx.Hash()
+			return newTestBlock(t, bc, tx)
+		}, ErrInvalidSignature},
+		{"signed by other key", func() *Block {
+			tx := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+			tx.Sign(keyB, map[string]Transaction{hexID(cb): *cb})
+			return newTestBlock(t, bc, tx)
+		}, ErrInvalidSignature},
+		{"outputs exceed inputs", func() *Block {
+			return newTestBlock(t, bc, testSpend(t, cb, 0, *NewTXOutput(6, addrB)))
+		}, ErrOutputsExceedInputs},
+		{"unsigned ToTran", func() *Block {
+			return newTestBlock(t, bc, unsignedToTran(t, addrB, 100))
+		}, ErrUnauthorizedMint},
+		{"ToTran signed by other key", func() *Block {
+			tx := unsignedToTran(t, addrB, 100)
+			tx.Authorize(otherKey)
+			return newTestBlock(t, bc, tx)
+		}, ErrUnauthorizedMint},
+		{"coinbase of other type", func() *Block {
+			tx := &Transaction{Vin: []TXInput{{Vout: -1, Address: addrB}}, Vout: []TXOutput{*NewTXOutput(100, addrB)}}
+			tx.Authorize(testCoordinatorKey)
+			return newTestBlock(t, bc, tx)
+		}, ErrUnauthorizedMint},
+		{"ToTran input on spending transaction", func() *Block {
+			tx := testSpend(t, cb, 0, *NewTXOutput(100, addrB))
+			tx.Vin = append(tx.Vin, TXInput{Vout: 0, Address: addrB, IsToTran: true})
+			testSign(t, tx, cb)
+			return newTestBlock(t, bc, tx)
+		}, ErrUnauthorizedMint},
+		{"unsigned coordinator change", func() *Block {
+			return newTestBlock(t, bc, NewCoordinatorTX(addrB))
+		}, ErrUnauthorizedMint},
+		{"coordinator change with outputs", func() *Block {
+			tx := NewCoordinatorTX(addrB)
+			tx.Vout = []TXOutput{*NewTXOutput(100, addrB)}
+			tx.Authorize(testCoordinatorKey)
+			return newTestBlock(t, bc, tx)
+		}, ErrInvalidCoordinatorTX},
+		{"coordinator change spending outputs", func() *Block {
+			tx := testSpend(t, cb, 0)
+			tx.Type = coordinatorTxType
+			tx.ID = tx.Hash()
+			return newTestBlock(t, bc, tx)
+		}, ErrInvalidCoordinatorTX},
+		{"malleated coordinator signature", func() *Block {
+			tx := unsignedToTran(t, addrB, 100)
+			tx.Authorize(testCoordinatorKey)
+			tx.Vin[0].Signature = highS(tx.Vin[0].Signature)
+			tx.ID = tx.Hash()
+			return newTestBlock(t, bc, tx)
+		}, ErrUnauthorizedMint},
+		{"replayed ToTran", func() *Block {
+			return newTestBlock(t, bc, cb)
+		}, ErrMintReplay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bc.AddBlock(tt.block())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("AddBlock = %v，期望 %v", err, tt.want)
+			}
+			var verr *BlockValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("错误 %T 不是BlockValidationError", err)
+			}
+			if bc.TipHeight() != 1 {
+				t.Fatalf("被拒绝的区块改变了最新高度 %d", bc.TipHeight())
+			}
+		})
+	}
+}
+
+func TestGenesisWithoutCoordinator(t *testing.T) {
+	bc := newTestChainWithGenesis(t, NewGenesisBlock(common.Address{}))
+	if _, err := bc.Coordinator(); !errors.Is(err, ErrNoCoordinator) {
+		t.Fatalf("Coordinator() = %v，期望 %v", err, ErrNoCoordinator)
+	}
+	err := bc.AddBlock(newTestBlock(t, bc, testCoinbase(t, addrA, 1)))
+	if !errors.Is(err, ErrNoCoordinator) {
+		t.Fatalf("没有协调者时转入钱: %v", err)
+	}
+	// 也不能由任何人设置协调者
+	err = bc.AddBlock(newTestBlock(t, bc, NewCoordinatorTX(addrA)))
+	if !errors.Is(err, ErrNoCoordinator) {
+		t.Fatalf("没有协调者时设置协调者: %v", err)
+	}
+}
+
+// TestCoordinatorChange 协调者交易从下一个区块开始生效，旧协调者之后不能再授权
+func TestCoordinatorChange(t *testing.T) {
+	bc := newTestChain(t)
+	if got, err := bc.Coordinator(); err != nil || got != testCoordinator() {
+		t.Fatalf("Coordinator() = %x, %v，期望 %x", got, err, testCoordinator())
+	}
+
+	newKey, _ := crypto.GenerateKey()
+	newCoordinator := crypto.PubkeyToAddress(newKey.PublicKey)
+	change := NewCoordinatorTX(newCoordinator)
+	if err := change.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	// 同一个区块中的交易仍然由旧协调者授权
+	addTestBlock(t, bc, change, testCoinbase(t, addrA, 1))
+	if got, err := bc.Coordinator(); err != nil || got != newCoordinator {
+		t.Fatalf("Coordinator() = %x, %v，期望 %x", got, err, newCoordinator)
+	}
+
+	err := bc.AddBlock(newTestBlock(t, bc, testCoinbase(t, addrA, 2)))
+	if !errors.Is(err, ErrUnauthorizedMint) {
+		t.Fatalf("旧协调者授权的交易: %v，期望 %v", err, ErrUnauthorizedMint)
+	}
+	tx := unsignedToTran(t, addrB, 3)
+	if err := tx.Authorize(newKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, tx)
+
+	// 重建索引后协调者的变更记录不变
+	UTXOSet1{bc}.Reindex()
+	if got, err := bc.Coordinator(); err != nil || got != newCoordinator {
+		t.Fatalf("重建后 Coordinator() = %x, %v，期望 %x", got, err, newCoordinator)
+	}
+
+	// 协调者可以停止转入
+	stop := NewCoordinatorTX(common.Address{})
+	if err := stop.Authorize(newKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, stop)
+	if _, err := bc.Coordinator(); !errors.Is(err, ErrNoCoordinator) {
+		t.Fatalf("停止转入后 Coordinator() = %v", err)
+	}
+}
+
+func TestValidateTransaction(t *testing.T) {
+	bc := newTestChain(t)
+	cb := testCoinbase(t, addrA, 5)
+	addTestBlock(t, bc, cb)
+
+	spend := testSpend(t, cb, 0, *NewTXOutput(5, addrB))
+	if err := bc.ValidateTransaction(spend); err != nil {
+		t.Fatalf("ValidateTransaction = %v", err)
+	}
+	// 验证不会修改UTXO集合
+	if err := bc.ValidateTransaction(spend); err != nil {
+		t.Fatalf("第二次 ValidateTransaction = %v", err)
+	}
+	addTestBlock(t, bc, spend)
+	if err := bc.ValidateTransaction(testSpend(t, cb, 0, *NewTXOutput(5, addrC))); !errors.Is(err, ErrMissingInput) {
+		t.Fatalf("花费已花费的out: %v，期望 %v", err, ErrMissingInput)
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"log"
+	"math"
+	"transfer/core"
 	pb "transfer/grpc/proto"
 
 	"google.golang.org/grpc"
@@ -34,9 +36,32 @@ func main() {
 	BAddress[2] = 'r'
 	BAddress[3] = 'l'
 	BAddress[4] = 'd'
-	r, err := c.ToTransferCommit(context.Background(), &pb.ToTransferRequest{FromAddress: FromAddress, BAddress: BAddress, Amount: 3}) // 调用 client.Send 方法向服务器发送请求
+	// 金额放在Amount64中，能用int32表示时同时填写旧的Amount字段，旧版本的服务器也能处理
+	var amount core.Amount = 3
+	// 轻计算区生成原像，转出的钱与转账区转入的钱都按原像的哈希值锁定
+	preimage, hashLock, err := core.NewPreimage()
+	if err != nil {
+		log.Fatalf("生成原像失败: %v", err)
+	}
+	req := &pb.ToTransferRequest{FromAddress: FromAddress, BAddress: BAddress, Amount64: uint64(amount), HashLock: hashLock}
+	if amount <= math.MaxInt32 {
+		req.Amount = int32(amount)
+	}
+	r, err := c.ToTransferCommit(context.Background(), req) // 调用 client.Send 方法向服务器发送请求
 	if err != nil {
 		log.Fatalf("连接轻计算区grpc接口失败: %v", err)
 	}
-	log.Printf("返回结果: %s", r.GetResult()) // 不用管返回值，加返回值是因为返回空值需要下载一个包
+	log.Printf("返回结果: %v", r.GetResult()) // 不用管返回值，加返回值是因为返回空值需要下载一个包
+
+	if !r.GetResult() {
+		log.Fatalf("转账区拒绝了转账请求")
+	}
+
+	// 解码转账区构造的交易
+	TX, err := core.DecodeTransaction(r.GetTransaction())
+	if err != nil {
+		log.Fatalf("交易解码失败: %v", err)
+	}
+	log.Printf("交易ID: %x", TX.ID)
+	log.Printf("领取时使用的原像: %x", preimage)
 }
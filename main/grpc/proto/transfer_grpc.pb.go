@@ -19,7 +19,13 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	TransferGRPC_ToTransferCommit_FullMethodName = "/proto.TransferGRPC/ToTransferCommit"
+	TransferGRPC_ToTransferCommit_FullMethodName  = "/proto.TransferGRPC/ToTransferCommit"
+	TransferGRPC_Prepare_FullMethodName           = "/proto.TransferGRPC/Prepare"
+	TransferGRPC_Commit_FullMethodName            = "/proto.TransferGRPC/Commit"
+	TransferGRPC_Abort_FullMethodName             = "/proto.TransferGRPC/Abort"
+	TransferGRPC_Status_FullMethodName            = "/proto.TransferGRPC/Status"
+	TransferGRPC_SubmitTransaction_FullMethodName = "/proto.TransferGRPC/SubmitTransaction"
+	TransferGRPC_FindAddressUTXOs_FullMethodName  = "/proto.TransferGRPC/FindAddressUTXOs"
 )
 
 // TransferGRPCClient is the client API for TransferGRPC service.
@@ -27,6 +33,13 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TransferGRPCClient interface {
 	ToTransferCommit(ctx context.Context, in *ToTransferRequest, opts ...grpc.CallOption) (*ToTransferReply, error)
+	// 跨区转账两阶段提交，见interconnected/twophase.go
+	Prepare(ctx context.Context, in *PrepareRequest, opts ...grpc.CallOption) (*PrepareReply, error)
+	Commit(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*DecisionReply, error)
+	Abort(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*DecisionReply, error)
+	Status(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*StatusReply, error)
+	SubmitTransaction(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitReply, error)
+	FindAddressUTXOs(ctx context.Context, in *AddressUTXOsRequest, opts ...grpc.CallOption) (*AddressUTXOsReply, error)
 }
 
 type transferGRPCClient struct {
@@ -46,11 +59,72 @@ func (c *transferGRPCClient) ToTransferCommit(ctx context.Context, in *ToTransfe
 	return out, nil
 }
 
+func (c *transferGRPCClient) Prepare(ctx context.Context, in *PrepareRequest, opts ...grpc.CallOption) (*PrepareReply, error) {
+	out := new(PrepareReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_Prepare_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transferGRPCClient) Commit(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*DecisionReply, error) {
+	out := new(DecisionReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_Commit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transferGRPCClient) Abort(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*DecisionReply, error) {
+	out := new(DecisionReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_Abort_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transferGRPCClient) Status(ctx context.Context, in *DecisionRequest, opts ...grpc.CallOption) (*StatusReply, error) {
+	out := new(StatusReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transferGRPCClient) SubmitTransaction(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitReply, error) {
+	out := new(SubmitReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_SubmitTransaction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *transferGRPCClient) FindAddressUTXOs(ctx context.Context, in *AddressUTXOsRequest, opts ...grpc.CallOption) (*AddressUTXOsReply, error) {
+	out := new(AddressUTXOsReply)
+	err := c.cc.Invoke(ctx, TransferGRPC_FindAddressUTXOs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TransferGRPCServer is the server API for TransferGRPC service.
 // All implementations must embed UnimplementedTransferGRPCServer
 // for forward compatibility
 type TransferGRPCServer interface {
 	ToTransferCommit(context.Context, *ToTransferRequest) (*ToTransferReply, error)
+	// 跨区转账两阶段提交，见interconnected/twophase.go
+	Prepare(context.Context, *PrepareRequest) (*PrepareReply, error)
+	Commit(context.Context, *DecisionRequest) (*DecisionReply, error)
+	Abort(context.Context, *DecisionRequest) (*DecisionReply, error)
+	Status(context.Context, *DecisionRequest) (*StatusReply, error)
+	SubmitTransaction(context.Context, *SubmitRequest) (*SubmitReply, error)
+	FindAddressUTXOs(context.Context, *AddressUTXOsRequest) (*AddressUTXOsReply, error)
 	mustEmbedUnimplementedTransferGRPCServer()
 }
 
@@ -61,6 +135,24 @@ type UnimplementedTransferGRPCServer struct {
 func (UnimplementedTransferGRPCServer) ToTransferCommit(context.Context, *ToTransferRequest) (*ToTransferReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ToTransferCommit not implemented")
 }
+func (UnimplementedTransferGRPCServer) Prepare(context.Context, *PrepareRequest) (*PrepareReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prepare not implemented")
+}
+func (UnimplementedTransferGRPCServer) Commit(context.Context, *DecisionRequest) (*DecisionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Commit not implemented")
+}
+func (UnimplementedTransferGRPCServer) Abort(context.Context, *DecisionRequest) (*DecisionReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Abort not implemented")
+}
+func (UnimplementedTransferGRPCServer) Status(context.Context, *DecisionRequest) (*StatusReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedTransferGRPCServer) SubmitTransaction(context.Context, *SubmitRequest) (*SubmitReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitTransaction not implemented")
+}
+func (UnimplementedTransferGRPCServer) FindAddressUTXOs(context.Context, *AddressUTXOsRequest) (*AddressUTXOsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindAddressUTXOs not implemented")
+}
 func (UnimplementedTransferGRPCServer) mustEmbedUnimplementedTransferGRPCServer() {}
 
 // UnsafeTransferGRPCServer may be embedded to opt out of forward compatibility for this service.
@@ -92,6 +184,114 @@ func _TransferGRPC_ToTransferCommit_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TransferGRPC_Prepare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrepareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).Prepare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_Prepare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).Prepare(ctx, req.(*PrepareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransferGRPC_Commit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecisionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).Commit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_Commit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).Commit(ctx, req.(*DecisionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransferGRPC_Abort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecisionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).Abort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_Abort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).Abort(ctx, req.(*DecisionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransferGRPC_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DecisionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).Status(ctx, req.(*DecisionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransferGRPC_SubmitTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).SubmitTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_SubmitTransaction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).SubmitTransaction(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TransferGRPC_FindAddressUTXOs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddressUTXOsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TransferGRPCServer).FindAddressUTXOs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TransferGRPC_FindAddressUTXOs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TransferGRPCServer).FindAddressUTXOs(ctx, req.(*AddressUTXOsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TransferGRPC_ServiceDesc is the grpc.ServiceDesc for TransferGRPC service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -103,6 +303,30 @@ var TransferGRPC_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ToTransferCommit",
 			Handler:    _TransferGRPC_ToTransferCommit_Handler,
 		},
+		{
+			MethodName: "Prepare",
+			Handler:    _TransferGRPC_Prepare_Handler,
+		},
+		{
+			MethodName: "Commit",
+			Handler:    _TransferGRPC_Commit_Handler,
+		},
+		{
+			MethodName: "Abort",
+			Handler:    _TransferGRPC_Abort_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _TransferGRPC_Status_Handler,
+		},
+		{
+			MethodName: "SubmitTransaction",
+			Handler:    _TransferGRPC_SubmitTransaction_Handler,
+		},
+		{
+			MethodName: "FindAddressUTXOs",
+			Handler:    _TransferGRPC_FindAddressUTXOs_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "transfer.proto",
@@ -2,39 +2,228 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
+	"sync"
+	"time"
 
+	"transfer/core"
 	pb "transfer/grpc/proto"
 	"transfer/interconnected"
+	"transfer/mempool"
+	"transfer/producer"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"google.golang.org/grpc"
 )
 
 const (
 	port = ":1145"
+	// recoverInterval 定期恢复没有完成的跨区转账，例如协调者暂时不可用时处于prepared阶段的转账
+	recoverInterval = 30 * time.Second
 )
 
 type server struct {
 	*pb.UnimplementedTransferGRPCServer
+	node *interconnected.Node // 跨区转账两阶段提交
+	bc   *core.BlockChain     // 客户端不能在节点运行时打开数据库，余额查询由节点代为完成
+
+	mu    sync.Mutex
+	conns map[string]pb.TransferGRPCClient // 到其他节点的连接，按地址复用
+	pool  *mempool.Mempool                 // 交易池，两阶段提交决定提交后的本地交易放入交易池
+}
+
+// newServer key是本节点作为跨区转账协调者时签名消息的私钥，nil时本节点只能作为参与者
+func newServer(key *ecdsa.PrivateKey) (*server, error) {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		bc:    bc,
+		conns: make(map[string]pb.TransferGRPCClient),
+		pool:  mempool.New(bc, mempool.DefaultConfig()),
+	}
+	// 主链切换分支后回滚区块中的交易放回交易池，等待重新打包
+	bc.OnReorg(s.pool.Reorg)
+	s.node = interconnected.NewNode("localhost"+port, key, s.dial, s.submit)
+	return s, nil
+}
+
+// dial 连接其他节点的TransferGRPC服务
+func (s *server) dial(address string) (pb.TransferGRPCClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if client, ok := s.conns[address]; ok {
+		return client, nil
+	}
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	client := pb.NewTransferGRPCClient(conn)
+	s.conns[address] = client
+	return client, nil
+}
+
+// submit 验证提交后的本地交易并放入交易池
+// 恢复时可能重复提交已经在交易池中的交易，不算错误
+func (s *server) submit(TX *core.Transaction) error {
+	err := s.pool.Add(TX)
+	if errors.Is(err, mempool.ErrDuplicate) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("交易 %x 已放入交易池", TX.ID)
+	return nil
 }
 
+// ToTransferCommit 单方面构造转入交易，不经过两阶段提交，保留给旧版本的轻计算区
 func (s *server) ToTransferCommit(ctx context.Context, in *pb.ToTransferRequest) (*pb.ToTransferReply, error) { // 实现具体方法
 	log.Println("收到了一个调用请求")
 	// 调用相关函数
-	interconnected.ToTransfer(common.BytesToAddress(in.FromAddress), common.BytesToAddress(in.BAddress), int(in.Amount))
-	return &pb.ToTransferReply{Result: true}, nil
+	amount, err := requestAmount(in)
+	if err != nil {
+		log.Println("转账金额无效:", err)
+		return &pb.ToTransferReply{Result: false}, nil
+	}
+	// 转入的钱锁定在HashLock的HTLC中，收款方给出原像领取后转账才完成
+	TX, err := interconnected.ToTransfer(common.BytesToAddress(in.FromAddress), common.BytesToAddress(in.BAddress), amount, in.GetHashLock(), in.GetAccount())
+	if err != nil {
+		log.Println(err)
+		return &pb.ToTransferReply{Result: false}, nil
+	}
+	// 交易使用规范编码传输，轻计算区可以据此重新计算出相同的交易ID
+	return &pb.ToTransferReply{Result: true, Transaction: core.EncodeTransaction(TX)}, nil
+}
+
+// requestAmount 读取请求中的转账金额
+// 新版本的客户端使用64位的Amount64，旧版本的客户端只填写int32的Amount，Amount64为0时使用Amount，负数会被拒绝
+func requestAmount(in *pb.ToTransferRequest) (core.Amount, error) {
+	if in.GetAmount64() != 0 {
+		return core.Amount(in.GetAmount64()), nil
+	}
+	return core.NewAmount(int64(in.GetAmount()))
+}
+
+func (s *server) Prepare(ctx context.Context, in *pb.PrepareRequest) (*pb.PrepareReply, error) {
+	log.Printf("收到跨区转账 %x 的prepare", in.GetTransferID())
+	return s.node.Prepare(in), nil
+}
+
+func (s *server) Commit(ctx context.Context, in *pb.DecisionRequest) (*pb.DecisionReply, error) {
+	log.Printf("收到跨区转账 %x 的commit", in.GetTransferID())
+	return &pb.DecisionReply{Result: s.node.Commit(in.GetTransferID(), in.GetSignature(), in.GetAuthorization())}, nil
+}
+
+func (s *server) Abort(ctx context.Context, in *pb.DecisionRequest) (*pb.DecisionReply, error) {
+	log.Printf("收到跨区转账 %x 的abort", in.GetTransferID())
+	return &pb.DecisionReply{Result: s.node.Abort(in.GetTransferID(), in.GetSignature())}, nil
+}
+
+func (s *server) Status(ctx context.Context, in *pb.DecisionRequest) (*pb.StatusReply, error) {
+	return s.node.Status(in.GetTransferID()), nil
+}
+
+// SubmitTransaction 客户端发送的交易放入本节点的交易池，由本节点或者从本节点获取交易的出块节点打包
+func (s *server) SubmitTransaction(ctx context.Context, in *pb.SubmitRequest) (*pb.SubmitReply, error) {
+	TX, err := core.DecodeTransaction(in.GetTransaction())
+	if err != nil {
+		return &pb.SubmitReply{Result: false, Error: err.Error()}, nil
+	}
+	err = s.pool.Add(TX)
+	if err != nil {
+		log.Printf("交易 %x 没有通过交易池的验证: %v", TX.ID, err)
+		return &pb.SubmitReply{Result: false, Error: err.Error()}, nil
+	}
+	log.Printf("交易 %x 已放入交易池", TX.ID)
+	return &pb.SubmitReply{Result: true}, nil
+}
+
+// FindAddressUTXOs 查询客户端钱包地址拥有的UTXO与余额
+func (s *server) FindAddressUTXOs(ctx context.Context, in *pb.AddressUTXOsRequest) (*pb.AddressUTXOsReply, error) {
+	var addresses []common.Address
+	for _, a := range in.GetAddresses() {
+		if len(a) != common.AddressLength {
+			return nil, fmt.Errorf("! 地址 %x 的长度不是 %d 字节", a, common.AddressLength)
+		}
+		addresses = append(addresses, common.BytesToAddress(a))
+	}
+	result, err := s.bc.FindAddressUTXOs(addresses)
+	if err != nil {
+		return nil, err
+	}
+	reply := &pb.AddressUTXOsReply{}
+	for _, address := range addresses {
+		reply.AddressUTXOs = append(reply.AddressUTXOs, result[address].Serialize())
+	}
+	return reply, nil
+}
+
+// recoverTransfers 启动时以及之后定期恢复没有完成的跨区转账
+func (s *server) recoverTransfers() {
+	for {
+		if err := s.node.Recover(); err != nil {
+			log.Println("恢复跨区转账:", err)
+		}
+		time.Sleep(recoverInterval)
+	}
+}
+
+// startProducer 从交易池中挑选交易定期出块，出块奖励转给address
+func (s *server) startProducer(address common.Address, interval time.Duration) {
+	cfg := producer.DefaultConfig(address)
+	cfg.Interval = interval
+	go producer.New(s.bc, s.pool, cfg).Run(context.Background())
 }
 
 func main() {
+	coordinator := flag.String("coordinator", "", "创世区块中的协调者地址，新建数据库时使用，同一个网络的所有节点必须相同")
+	keyFile := flag.String("key", "", "跨区转账协调者签名消息的私钥文件，为空时本节点只作为参与者")
+	producerAddress := flag.String("producer", "", "出块奖励地址，为空时本节点不出块")
+	interval := flag.Duration("interval", 10*time.Second, "出块间隔")
+	flag.Parse()
+	if *coordinator != "" {
+		if !common.IsHexAddress(*coordinator) {
+			log.Fatalf("invalid coordinator address: %s", *coordinator)
+		}
+		core.GenesisCoordinator = common.HexToAddress(*coordinator)
+	}
+
+	var key *ecdsa.PrivateKey
+	if *keyFile != "" {
+		var err error
+		key, err = crypto.LoadECDSA(*keyFile)
+		if err != nil {
+			log.Fatalf("failed to load key: %v", err)
+		}
+	}
+
 	lis, err := net.Listen("tcp", port) // 监听器
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()                       // 服务器实例
-	pb.RegisterTransferGRPCServer(s, &server{}) // 将服务器实例注册到服务器上
-	if err := s.Serve(lis); err != nil {        // 启动服务器并监听
+	node, err := newServer(key)
+	if err != nil {
+		log.Fatalf("failed to open blockchain: %v", err)
+	}
+	go node.recoverTransfers()
+	if *producerAddress != "" {
+		if !common.IsHexAddress(*producerAddress) {
+			log.Fatalf("invalid producer address: %s", *producerAddress)
+		}
+		node.startProducer(common.HexToAddress(*producerAddress), *interval)
+	}
+	s := grpc.NewServer()                  // 服务器实例
+	pb.RegisterTransferGRPCServer(s, node) // 将服务器实例注册到服务器上
+	if err := s.Serve(lis); err != nil {   // 启动服务器并监听
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
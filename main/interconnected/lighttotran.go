@@ -4,6 +4,7 @@ import (
 	"crypto/ecdsa"
 	"fmt"
 	"transfer/core"
+	"transfer/wallet"
 
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -14,76 +15,75 @@ import (
 // 传入 目标地址AAd dress，转账金额AMoney 生成 Type类型为2的UTXO，监测目标账户是否存在并创建
 // 返回 生成的转账交易，操作结果，执行错误
 
-// ToTransferAccount 用于跨区转账ToTransfer向轻计算区返回新建账户信息的结构体，注意是新建账户才使用这个结构体
-type ToTransferAccount struct {
-	Account    string
-	Password   string
-	Publickey  ecdsa.PublicKey
-	Privatekey ecdsa.PrivateKey
-}
-
 // ToTransfer 跨区交易ToTransfer轻计算区向转账区转钱
-// 第一个返回值bool表示是否成功转账，默认向Publickey对应的地址转账，没有就创建新的地址，并返回ToTransferAccount信息 TODO: 但是目前无法根据已知的公钥创建对应的私钥
-// 不需要私钥信息，按照Coinbase交易构造 TODO:现在互相信任，认为对方转账一定能成功，所以去掉第一个bool值
-func ToTransfer(FromAddress common.Address, BAddress common.Address, Money int) bool {
-	fmt.Println("> 开始执行轻计算区 转 转账区 账户转换")
-
-	// var B []byte
-	// B[0] = 'H'
-	// B[1] = 'E'
-	// B[2] = 'L'
-	// B[3] = 'L'
-	// B[4] = 'O'
-
-	// FromAddress = common.BytesToAddress(B)
-
-	// TODO:存在一点问题，输入的应该是账号和密码，系统显示子地址让用户选择，现在输入的是多钱包的公私钥但是多钱包地址无法转账
-	// TODO: 现阶段先按照传入地址一定存在来写
-	// 因为存在旧帐户和新账户两种情况，所以需要统一收集信息
-	// var Pubkey ecdsa.PublicKey  // 账户公钥
-	var UserAccount string // 发送者账户
-	// var IsNew bool = true       // 是否需要新建账户
-	// var reAccount ToTransferAccount
-
-	// // 使用已有账户，检查目标公钥是否存在
-	// //BAddress := crypto.PubkeyToAddress(Publickey)
-	// // 更改为多钱包账户
-	// for _, v := range wallet.AccountData2 {
-	// 	// k是Account，v是密码，公钥，私钥的结构体
-	// 	if v. == Publickey {
-	// 		// 公钥正确，说明存在对应地址
-	// 		fmt.Println("> 目标地址存在")
-	// 		UserAccount = v.Account
-	// 	}
-	// }
-	// if IsNew == true {
-	// 	fmt.Println("> 目标地址不存在")
-	// 	// 将目标地址设置为新建钱包的公钥 TODO: 暂时无法实现，创建新账户
-	// 	// 创建新的账户与地址 TODO: 后续可以设置为用户自定义账户信息
-	// 	a, _ := crypto.GenerateKey()
-	// 	wallet.AccountData["TestA"] = wallet.AccountECDSA{Password: "TestA", Publickey: a.PublicKey, PrivateKey: *a}
-	// 	UserAccount = "TestA"
-	// 	Pubkey = a.PublicKey
-
-	// 	// 构造返回账户信息
-	// 	reAccount = ToTransferAccount{
-	// 		Account:    "TestA",
-	// 		Password:   "TestA",
-	// 		Publickey:  Pubkey,
-	// 		Privatekey: *a,
-	// 	}
-	// }
+// 不需要私钥信息，按照Coinbase交易构造，转入的钱锁定在哈希值为HashLock的HTLC中，轻计算区按同一个HashLock锁定转出的钱
+// BAddress用原像调用CompleteToTransfer领取后转账才完成，轻计算区也用这个原像完成扣款；超时没有领取时FromAddress用RefundToTransfer退回轻计算区
+// UserAccount是发送者在轻计算区的账户，记录在交易的Account中
+// 返回的交易还没有授权，需要协调者调用Authorize签名后才能上链，gRPC接口把它的规范编码返回给轻计算区
+func ToTransfer(FromAddress common.Address, BAddress common.Address, Money core.Amount, HashLock []byte, UserAccount string) (*core.Transaction, error) {
+	// 哈希时间锁，超时后退回轻计算区的FromAddress
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		return nil, fmt.Errorf("! 跨区转账ToTransfer获取区块链相关信息出现错误: %w", err)
+	}
+	lock, err := core.NewHTLCLock(HashLock, BAddress, FromAddress, bc.HTLCTimeoutHeight())
+	if err != nil {
+		return nil, fmt.Errorf("! 跨区转账ToTransfer哈希时间锁无效: %w", err)
+	}
 
 	// 构造新的UTXO Coinbase交易
-	core.NewCoinbaseTX(FromAddress, BAddress, Money, UserAccount)
+	TX, err := core.NewHTLCCoinbaseTX(FromAddress, lock, Money, UserAccount)
+	if err != nil {
+		return nil, fmt.Errorf("! 跨区转账ToTransfer构造交易出现错误: %w", err)
+	}
+	return TX, nil
+}
+
+// CompleteToTransfer 完成轻计算区转到转账区：BAddress给出原像领取ToTransfer转入的金额
+// TX是ToTransfer构造并已经上链的交易，w的子钱包需要有BAddress的私钥，手续费从领取的金额中扣除
+func CompleteToTransfer(w wallet.Wallets, TX core.Transaction, Preimage []byte) (*core.Transaction, error) {
+	coin, err := TX.HTLCCoin()
+	if err != nil {
+		return nil, err
+	}
+	return core.NewHTLCClaim(coin, Preimage, w.Keyring(), false, core.TxBuildOptions{FeeRate: core.DefaultFeeRate})
+}
 
-	return true
+// RefundToTransfer 超时没有领取时，把ToTransfer转入的金额退回轻计算区，keyring需要有FromAddress的私钥
+func RefundToTransfer(TX core.Transaction, keyring core.Keyring) (*core.Transaction, error) {
+	coin, err := TX.HTLCCoin()
+	if err != nil {
+		return nil, err
+	}
+	return core.NewHTLCRefund(coin, keyring, true, core.TxBuildOptions{FeeRate: core.DefaultFeeRate})
 }
 
 // TODO:根据坐标返回交易
 
-// TODO:空白交易占位符？
+// ReserveToTransfer 结果还不确定的轻计算区转到转账区，先构造占位交易预留从FromAddress转给BAddress的Money
+// Reference是跨区转账的标识，UserAccount是发送者在轻计算区的账户
+// 返回的占位交易需要协调者调用Authorize签名后才能上链，上链后BAddress的这笔钱计入待定余额，由协调者用SettleToTransfer结算后才能花费或作废
+func ReserveToTransfer(FromAddress common.Address, BAddress common.Address, Money core.Amount, Reference []byte, UserAccount string) (*core.Transaction, error) {
+	TX, err := core.NewPlaceholderTX(FromAddress, BAddress, Money, Reference, UserAccount)
+	if err != nil {
+		return nil, fmt.Errorf("! 跨区转账构造占位交易出现错误: %w", err)
+	}
+	return TX, nil
+}
+
+// SettleToTransfer 结算已经上链的占位交易TX，fill为true表示转账完成，false表示转账失败作废，key是协调者的私钥
+func SettleToTransfer(TX core.Transaction, fill bool, key *ecdsa.PrivateKey) (*core.Transaction, error) {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		return nil, err
+	}
+	loc, err := bc.FindTxLocation(TX.ID)
+	if err != nil {
+		return nil, err
+	}
+	return core.NewSettlementTX(core.SlotRef{Height: uint64(loc.Height), Index: loc.Index, Txid: TX.ID, Fill: fill}, key)
+}
 
-// TODO:变色龙哈希
+// 变色龙哈希 见core/chameleon.go，区块改写见core/redaction.go
 
-// TODO:互联 2 phase commit
+// 互联 2 phase commit 见twophase.go
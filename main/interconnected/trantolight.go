@@ -11,24 +11,30 @@ import (
 // 转账区 -> 轻计算区
 
 // TXLog 交易记录
+// Proof与MerkleRoot让轻计算区只需要区块头就能确认TX确实被打包在坐标对应的区块中
 type TXLog struct {
-	TX           core.Transaction // 交易
-	CoordinatesX int              // 区块号
-	CoordinatesY int              // 区块中的第几个交易
+	TX           core.Transaction  // 交易
+	CoordinatesX int               // 区块高度
+	CoordinatesY int               // 区块中的第几个交易
+	Proof        *core.MerkleProof // 交易在区块中的默克尔包含证明
+	MerkleRoot   []byte            // 交易所在区块的默克尔根
 }
 
 // ToLightComputeReturn 转账区转到轻计算区 返回结构体
 type ToLightComputeReturn struct {
-	Amount  int              // 转账金额
-	Balance int              // 转账区剩余金额
+	Amount  core.Amount      // 转账金额
+	Balance core.Amount      // 转账区剩余金额
 	TxLogs  []TXLog          // 交易记录
 	TX      core.Transaction // 构造的交易
+	HTLC    *core.HTLCLock   // 转账金额的哈希时间锁定条件
 }
 
 // ToLightCompute 转账区转到轻计算区，返回TXInput和TXOutput，并返回余额，还有交易记录
 // TODO: 目前只支持向单一地址转账
 // 单纯转换，Money表示转多少钱过去，APublickey表示转账区用户的公钥，APrivatekey表示转账区用户的私钥，BAddress表示转到轻计算区的地址
-func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money int) (error, ToLightComputeReturn) {
+// 转账金额锁定在哈希值为HashLock的HTLC中：轻计算区按同一个HashLock入账，BAddress用原像调用CompleteToLight领取后转账才完成
+// 超时没有领取时用RefundToLight退回到新建的子钱包，转账要么在两个区都完成，要么退回
+func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money core.Amount, HashLock []byte) (error, ToLightComputeReturn) {
 	fmt.Println("> 开始执行 转账区 -> 轻计算区 转换函数")
 
 	// 转账区对应的地址
@@ -42,9 +48,13 @@ func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money int) (error
 	}
 
 	// 计算总余额
-	var AllMoney int = 0
+	var AllMoney core.Amount
 	for _, v := range AUTXO {
-		AllMoney += v.Balance
+		AllMoney, err = AllMoney.Add(v.Balance)
+		if err != nil {
+			fmt.Println("! 钱包总余额超过上限")
+			return err, ToLightComputeReturn{}
+		}
 	}
 	if AllMoney < Money {
 		fmt.Println("! 您的余额不满足您的跨区转账需求")
@@ -54,7 +64,24 @@ func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money int) (error
 	// 新建钱包
 	ws := core.NewWallet(w.Account, w.Privatekey, w.Publickey)
 
-	var AllUTXOs map[string][]core.TXOutputsTran // 可用的UTXO集合
+	// 哈希时间锁，超时后退回到一个新的子钱包
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 跨区转账ToLight获取区块链相关信息出现错误")
+		return err, ToLightComputeReturn{}
+	}
+	refund, err := w.NewChangeWallet()
+	if err != nil {
+		fmt.Println("! 跨区转账ToLight新建退款子钱包出现错误")
+		return err, ToLightComputeReturn{}
+	}
+	lock, err := core.NewHTLCLock(HashLock, BAddress, refund, bc.HTLCTimeoutHeight())
+	if err != nil {
+		fmt.Println("! 跨区转账ToLight哈希时间锁无效")
+		return err, ToLightComputeReturn{}
+	}
+
+	AllUTXOs := make(map[string][]core.TXOutputsTran) // 可用的UTXO集合
 
 	// 合并可用的UTXO TODO:有点麻烦，看后续有没有其他的解决方法
 	for _, v := range AUTXO {
@@ -68,9 +95,19 @@ func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money int) (error
 		}
 	}
 	// 方法内包含了对UTXO out的签名
-	TX, FinalUTXO, err := core.NewTransactionToLight(ws, BAddress, Money, AllUTXOs)
+	TX, FinalUTXO, err := core.NewTransactionToLight(ws, w.Keyring(), BAddress, Money, AllUTXOs, lock, core.TxBuildOptions{FeeRate: core.DefaultFeeRate, Change: w.ChangePolicy()})
 	if err != nil {
 		fmt.Println("! 跨区转账ToLight构造新交易时出现错误")
+		return err, ToLightComputeReturn{}
+	}
+	// 手续费 = 选中的out金额之和 - 交易的output金额之和
+	// 交易构造时已经检查过金额不会溢出，并且input金额之和不小于output金额之和
+	var Fee core.Amount
+	for _, v := range FinalUTXO {
+		Fee += v.Out.Out.Value
+	}
+	for _, v := range TX.Vout {
+		Fee -= v.Value
 	}
 
 	// 交易上链
@@ -86,31 +123,54 @@ func ToLightCompute(w wallet.Wallets, BAddress common.Address, Money int) (error
 			return err, ToLightComputeReturn{}
 		}
 		// 寻找vin对应的交易，用于轻计算区验证
-		err, bc := core.GetBlockChain()
+		tx, err := bc.FindTransaction(txid)
 		if err != nil {
-			fmt.Println("! 跨区转账ToLight获取区块链相关信息出现错误")
+			fmt.Println("! 跨区转账ToLight按照txid寻找交易时出现错误")
 			return err, ToLightComputeReturn{}
 		}
-		tx, err := bc.FindTransaction(txid)
+		// 生成该交易的默克尔包含证明
+		proof, root, err := bc.GetMerkleProof(txid)
 		if err != nil {
-			fmt.Println("! 跨区转账ToLight按照txid寻找交易时出现错误")
+			fmt.Println("! 跨区转账ToLight生成默克尔证明时出现错误")
 			return err, ToLightComputeReturn{}
 		}
 		txlog = append(txlog, TXLog{
 			TX:           tx,
 			CoordinatesX: value.Out.CoordinatesX,
 			CoordinatesY: value.Out.CoordinatesY,
+			Proof:        proof,
+			MerkleRoot:   root,
 		})
 	}
 
 	// 构造 ToLightComputeReturn
 	rm := ToLightComputeReturn{
 		Amount:  Money,
-		Balance: AllMoney - Money,
+		Balance: AllMoney - Money - Fee,
 		TxLogs:  txlog,
 		TX:      *TX,
+		HTLC:    lock,
 	}
 
 	// 返回
 	return nil, rm
 }
+
+// CompleteToLight 完成转账区转到轻计算区：BAddress给出原像领取ToLightCompute锁定的金额，领取的out转到轻计算区
+// TX是ToLightCompute构造并已经上链的交易，keyring需要有BAddress的私钥，手续费从领取的金额中扣除
+func CompleteToLight(TX core.Transaction, Preimage []byte, keyring core.Keyring) (*core.Transaction, error) {
+	coin, err := TX.HTLCCoin()
+	if err != nil {
+		return nil, err
+	}
+	return core.NewHTLCClaim(coin, Preimage, keyring, true, core.TxBuildOptions{FeeRate: core.DefaultFeeRate})
+}
+
+// RefundToLight 超时没有领取时，把ToLightCompute锁定的金额退回转账区的退款子钱包
+func RefundToLight(w wallet.Wallets, TX core.Transaction) (*core.Transaction, error) {
+	coin, err := TX.HTLCCoin()
+	if err != nil {
+		return nil, err
+	}
+	return core.NewHTLCRefund(coin, w.Keyring(), false, core.TxBuildOptions{FeeRate: core.DefaultFeeRate})
+}
@@ -0,0 +1,545 @@
+package interconnected
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"transfer/core"
+	pb "transfer/grpc/proto"
+	"transfer/wallet"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// 跨区转账两阶段提交
+// 协调者先在本地准备好交易并记录为preparing，再向参与者发出prepare；参与者准备好自己的交易，记录为prepared之后才投票同意
+// 参与者同意时协调者记录committed，否则记录aborted，决定写入数据库之后才提交本地交易并通知参与者
+// 崩溃重启后Recover根据数据库中的记录继续：协调者还没有做出决定的转账按中止处理，已经做出决定的重新通知参与者
+// 参与者在prepared阶段不能自己做决定，需要向协调者查询决定后再执行
+// 两端的交易都带有同一个HashLock的HTLC，某一端在决定之后长时间不可用时，资金也会在超时后退回
+// 转账区->轻计算区由转账区协调，轻计算区->转账区由轻计算区协调，转账区只作为这个方向的参与者
+// 协调者签名prepare、commit、abort以及回复参与者查询的决定，参与者只接受链上当前协调者签名的prepare，之后只接受同一个地址签名的决定
+// 决定一旦记录就不会改变，与已经记录的提交或中止相反的决定一律拒绝
+// 轻计算区->转账区提交时，commit还带有协调者对参与者准备的转入交易的授权签名，见core/coordinator.go
+// 调用对方节点时不持有n.mu，一个转账等待对方回复不会阻塞其他转账
+
+// rpcTimeout 每次gRPC调用的超时时间
+const rpcTimeout = 5 * time.Second
+
+// 转账方向，与交易的Type相同
+const (
+	DirectionToLight    = 1 // 转账区 -> 轻计算区
+	DirectionToTransfer = 2 // 轻计算区 -> 转账区
+)
+
+var (
+	ErrTransferRejected = errors.New("参与者拒绝了跨区转账")
+	ErrNoSubmit         = errors.New("没有设置提交本地交易的方法")
+	ErrNoKey            = errors.New("没有设置签名两阶段提交消息的私钥")
+	ErrUnauthenticated  = errors.New("两阶段提交消息的签名者不是协调者")
+	ErrDecisionConflict = errors.New("决定与已经记录的决定相反")
+)
+
+// Node 跨区转账两阶段提交的一端，作为协调者发起转账，也作为参与者响应对方发起的转账
+type Node struct {
+	Address string                                              // 本节点TransferGRPC服务的地址，参与者恢复时据此向协调者查询决定
+	Key     *ecdsa.PrivateKey                                   // 本节点作为协调者签名消息的私钥，对方用对应的公钥验证，nil时不能发起转账
+	Dial    func(address string) (pb.TransferGRPCClient, error) // 连接对方的TransferGRPC服务
+	Submit  func(TX *core.Transaction) error                    // 提交决定之后执行本地交易，例如放入交易池
+
+	mu sync.Mutex // 保证读-改-写转账记录不会交错，调用对方节点时不持有
+}
+
+// NewNode 创建两阶段提交的节点
+func NewNode(Address string, Key *ecdsa.PrivateKey, Dial func(address string) (pb.TransferGRPCClient, error), Submit func(TX *core.Transaction) error) *Node {
+	return &Node{Address: Address, Key: Key, Dial: Dial, Submit: Submit}
+}
+
+// NewTransferID 生成随机的转账ID
+func NewTransferID() ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// messageHash 两阶段提交消息的签名哈希，sha256(每个字段的uvarint长度 || 内容)，第一个字段是消息类型
+func messageHash(fields ...[]byte) []byte {
+	h := sha256.New()
+	for _, f := range fields {
+		h.Write(binary.AppendUvarint(nil, uint64(len(f))))
+		h.Write(f)
+	}
+	return h.Sum(nil)
+}
+
+// prepareHash prepare的签名哈希，覆盖除签名以外的所有字段
+func prepareHash(req *pb.PrepareRequest) []byte {
+	return messageHash([]byte("prepare"), req.GetTransferID(),
+		binary.AppendVarint(nil, int64(req.GetDirection())),
+		req.GetFromAddress(), req.GetBAddress(),
+		binary.AppendUvarint(nil, req.GetAmount()),
+		req.GetHashLock(), []byte(req.GetCoordinator()))
+}
+
+// decisionHash 协调者决定的签名哈希，commit、abort与查询决定的回复使用同一个哈希
+func decisionHash(id []byte, phase core.TransferPhase) []byte {
+	return messageHash([]byte("decision"), id, binary.AppendVarint(nil, int64(phase)))
+}
+
+// sign 本节点作为协调者签名消息
+func (n *Node) sign(hash []byte) ([]byte, error) {
+	if n.Key == nil {
+		return nil, ErrNoKey
+	}
+	return crypto.Sign(hash, n.Key)
+}
+
+// verifySignature 从签名恢复出签名者，签名者必须是signer
+func verifySignature(signer common.Address, hash []byte, signature []byte) error {
+	pub, err := crypto.SigToPub(hash, signature)
+	if err != nil || crypto.PubkeyToAddress(*pub) != signer {
+		return fmt.Errorf("! 签名者不是协调者 %x: %w", signer, ErrUnauthenticated)
+	}
+	return nil
+}
+
+// ToLightCompute 通过两阶段提交完成转账区转到轻计算区，peer是轻计算区TransferGRPC服务的地址
+// 本地交易与ToLightCompute相同，只有轻计算区投票同意后才会提交；参与者拒绝或无法连接时中止，本地交易不会被提交
+// 决定提交后通知参与者失败不影响结果，Recover会继续通知
+func (n *Node) ToLightCompute(peer string, w wallet.Wallets, BAddress common.Address, Money core.Amount, HashLock []byte) (error, ToLightComputeReturn) {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 模拟获取区块链出现错误")
+		return err, ToLightComputeReturn{}
+	}
+	if n.Key == nil {
+		return ErrNoKey, ToLightComputeReturn{}
+	}
+	id, err := NewTransferID()
+	if err != nil {
+		return err, ToLightComputeReturn{}
+	}
+
+	// 准备本地交易，写入preparing之后才发出prepare
+	err, rm := ToLightCompute(w, BAddress, Money, HashLock)
+	if err != nil {
+		return err, ToLightComputeReturn{}
+	}
+	record := &core.TransferRecord{
+		ID:          id,
+		Role:        core.TransferCoordinator,
+		Phase:       core.TransferPreparing,
+		Direction:   DirectionToLight,
+		FromAddress: rm.HTLC.Refund,
+		BAddress:    BAddress,
+		Amount:      Money,
+		HashLock:    HashLock,
+		TX:          core.EncodeTransaction(&rm.TX),
+		Peer:        peer,
+	}
+	n.mu.Lock()
+	err = bc.PutTransfer(record)
+	n.mu.Unlock()
+	if err != nil {
+		return err, ToLightComputeReturn{}
+	}
+
+	vote, err := n.prepare(record)
+	if err != nil || !vote {
+		if err == nil {
+			err = ErrTransferRejected
+		}
+		fmt.Println("! 跨区转账ToLight中止:", err)
+		if derr := n.decide(bc, record, core.TransferAborted); derr != nil {
+			fmt.Println("! 通知参与者中止失败，稍后重试:", derr)
+		}
+		return err, ToLightComputeReturn{}
+	}
+
+	// 提交的决定写入数据库之后转账就已经提交，之后的失败由Recover重试
+	n.mu.Lock()
+	record.Phase = core.TransferCommitted
+	err = bc.PutTransfer(record)
+	n.mu.Unlock()
+	if err != nil {
+		return err, ToLightComputeReturn{}
+	}
+	if err := n.finish(bc, record); err != nil {
+		fmt.Println("! 跨区转账ToLight已经提交，执行失败，稍后重试:", err)
+	}
+	return nil, rm
+}
+
+// prepare 向参与者发出签名的prepare，返回参与者的投票
+func (n *Node) prepare(r *core.TransferRecord) (bool, error) {
+	req := &pb.PrepareRequest{
+		TransferID:  r.ID,
+		Direction:   int32(r.Direction),
+		FromAddress: r.FromAddress.Bytes(),
+		BAddress:    r.BAddress.Bytes(),
+		Amount:      uint64(r.Amount),
+		HashLock:    r.HashLock,
+		Coordinator: n.Address,
+	}
+	signature, err := n.sign(prepareHash(req))
+	if err != nil {
+		return false, err
+	}
+	req.Signature = signature
+
+	client, err := n.Dial(r.Peer)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	reply, err := client.Prepare(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	return reply.GetVote(), nil
+}
+
+// decide 记录决定，然后执行决定，调用时不能持有n.mu
+func (n *Node) decide(bc *core.BlockChain, r *core.TransferRecord, phase core.TransferPhase) error {
+	n.mu.Lock()
+	r.Phase = phase
+	err := bc.PutTransfer(r)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.finish(bc, r)
+}
+
+// finish 执行已经记录的决定：提交时先提交本地交易，协调者再通知参与者，全部完成后记录Finished
+// 失败时记录保持未完成，Recover会重新执行，本地交易已经在链上时不会重复提交
+// 调用时不能持有n.mu，通知参与者期间不持有锁
+func (n *Node) finish(bc *core.BlockChain, r *core.TransferRecord) error {
+	if r.Phase == core.TransferCommitted && len(r.TX) != 0 {
+		n.mu.Lock()
+		err := n.submitOnce(bc, r)
+		n.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	if r.Role == core.TransferCoordinator {
+		err := n.notify(r)
+		if err != nil {
+			return err
+		}
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r.Finished = true
+	return bc.PutTransfer(r)
+}
+
+// submitOnce 提交本地交易，交易已经在链上时跳过
+func (n *Node) submitOnce(bc *core.BlockChain, r *core.TransferRecord) error {
+	TX, err := r.Transaction()
+	if err != nil {
+		return err
+	}
+	if _, err := bc.FindTransaction(TX.ID); err == nil {
+		return nil
+	}
+	if n.Submit == nil {
+		return ErrNoSubmit
+	}
+	return n.Submit(TX)
+}
+
+// notify 把签名的决定发给参与者，参与者确认后才算完成
+func (n *Node) notify(r *core.TransferRecord) error {
+	signature, err := n.sign(decisionHash(r.ID, r.Phase))
+	if err != nil {
+		return err
+	}
+	client, err := n.Dial(r.Peer)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	req := &pb.DecisionRequest{TransferID: r.ID, Signature: signature}
+	var reply *pb.DecisionReply
+	if r.Phase == core.TransferCommitted {
+		reply, err = client.Commit(ctx, req)
+	} else {
+		reply, err = client.Abort(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	if !reply.GetResult() {
+		return fmt.Errorf("! 参与者没有执行转账 %x 的决定 %v", r.ID, r.Phase)
+	}
+	return nil
+}
+
+// Prepare 参与者收到prepare：准备本地交易，记录prepared之后投票同意
+// prepare必须由链上当前的协调者签名，否则直接拒绝，不留下记录
+// 转账区只参与轻计算区->转账区的转账，重复的prepare返回与第一次相同的结果
+func (n *Node) Prepare(req *pb.PrepareRequest) *pb.PrepareReply {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 模拟获取区块链出现错误")
+		return &pb.PrepareReply{Vote: false}
+	}
+	coordinator, err := bc.Coordinator()
+	if err == nil {
+		err = verifySignature(coordinator, prepareHash(req), req.GetSignature())
+	}
+	if err != nil {
+		fmt.Printf("! 拒绝跨区转账 %x 的prepare: %v\n", req.GetTransferID(), err)
+		return &pb.PrepareReply{Vote: false}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if r, err := bc.GetTransfer(req.GetTransferID()); err == nil {
+		if r.Role != core.TransferParticipant || r.Phase == core.TransferAborted {
+			return &pb.PrepareReply{Vote: false}
+		}
+		return &pb.PrepareReply{Vote: true, Transaction: r.TX}
+	}
+
+	record := &core.TransferRecord{
+		ID:          req.GetTransferID(),
+		Role:        core.TransferParticipant,
+		Direction:   int(req.GetDirection()),
+		FromAddress: common.BytesToAddress(req.GetFromAddress()),
+		BAddress:    common.BytesToAddress(req.GetBAddress()),
+		Amount:      core.Amount(req.GetAmount()),
+		HashLock:    req.GetHashLock(),
+		Peer:        req.GetCoordinator(),
+		Coordinator: coordinator,
+	}
+	var TX *core.Transaction
+	err = ErrTransferRejected
+	if record.Direction == DirectionToTransfer && len(record.ID) != 0 {
+		TX, err = ToTransfer(record.FromAddress, record.BAddress, record.Amount, record.HashLock, "")
+	}
+	// 拒绝时也记录下来，之后不会再为这个转账投票同意
+	if err != nil {
+		fmt.Printf("! 拒绝跨区转账 %x: %v\n", record.ID, err)
+		record.Phase = core.TransferAborted
+		record.Finished = true
+		if len(record.ID) != 0 {
+			if err := bc.PutTransfer(record); err != nil {
+				fmt.Println("! 记录跨区转账状态失败:", err)
+			}
+		}
+		return &pb.PrepareReply{Vote: false}
+	}
+
+	record.Phase = core.TransferPrepared
+	record.TX = core.EncodeTransaction(TX)
+	if err := bc.PutTransfer(record); err != nil {
+		fmt.Println("! 记录跨区转账状态失败:", err)
+		return &pb.PrepareReply{Vote: false}
+	}
+	return &pb.PrepareReply{Vote: true, Transaction: record.TX}
+}
+
+// Commit 参与者收到提交的决定，提交本地交易，已经中止的转账不能提交
+// signature是协调者对决定的签名，authorization是协调者对参与者准备的转入交易的授权签名，两者有一个无效时都不提交
+func (n *Node) Commit(id []byte, signature []byte, authorization []byte) bool {
+	return n.receive(id, core.TransferCommitted, signature, authorization)
+}
+
+// Abort 参与者收到中止的决定，没有记录的转账也记录为中止，之后到达的prepare会被拒绝
+func (n *Node) Abort(id []byte, signature []byte) bool {
+	return n.receive(id, core.TransferAborted, signature, nil)
+}
+
+// receive 参与者执行协调者的决定，返回是否已经执行
+func (n *Node) receive(id []byte, phase core.TransferPhase, signature []byte, authorization []byte) bool {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 模拟获取区块链出现错误")
+		return false
+	}
+
+	n.mu.Lock()
+	r, err := bc.GetTransfer(id)
+	if errors.Is(err, core.ErrTransferNotFound) && phase == core.TransferAborted {
+		r = &core.TransferRecord{ID: id, Role: core.TransferParticipant, Phase: core.TransferUnknown}
+	} else if err != nil || r.Role != core.TransferParticipant {
+		n.mu.Unlock()
+		return false
+	}
+	err = n.accept(bc, r, phase, signature, authorization)
+	n.mu.Unlock()
+	if err != nil {
+		fmt.Printf("! 拒绝跨区转账 %x 的决定 %v: %v\n", id, phase, err)
+		return false
+	}
+	if r.Finished {
+		return true
+	}
+
+	err = n.finish(bc, r)
+	if err != nil {
+		fmt.Println("! 执行跨区转账的决定失败:", err)
+		return false
+	}
+	return true
+}
+
+// accept 参与者验证协调者的决定并记录下来，调用时需要持有n.mu
+// 决定必须由prepare时记录的协调者签名，没有记录时使用链上当前的协调者
+// 已经记录了提交或中止时只接受同一个决定，不会再修改记录；提交时把协调者的授权签名放入参与者准备的转入交易
+func (n *Node) accept(bc *core.BlockChain, r *core.TransferRecord, phase core.TransferPhase, signature []byte, authorization []byte) error {
+	coordinator := r.Coordinator
+	if coordinator == (common.Address{}) {
+		var err error
+		coordinator, err = bc.Coordinator()
+		if err != nil {
+			return err
+		}
+	}
+	err := verifySignature(coordinator, decisionHash(r.ID, phase), signature)
+	if err != nil {
+		return err
+	}
+	if r.Phase == core.TransferCommitted || r.Phase == core.TransferAborted {
+		if r.Phase != phase {
+			return fmt.Errorf("! 转账 %x 已经记录为 %v: %w", r.ID, r.Phase, ErrDecisionConflict)
+		}
+		return nil
+	}
+	if phase == core.TransferCommitted && len(r.TX) != 0 {
+		err := authorizeTransfer(bc, r, authorization)
+		if err != nil {
+			return err
+		}
+	}
+	r.Phase = phase
+	return bc.PutTransfer(r)
+}
+
+// authorizeTransfer 把协调者的授权签名放入参与者准备的转入交易，签名有效时更新记录中的交易
+func authorizeTransfer(bc *core.BlockChain, r *core.TransferRecord, authorization []byte) error {
+	TX, err := r.Transaction()
+	if err != nil {
+		return err
+	}
+	err = TX.SetAuthorization(authorization)
+	if err != nil {
+		return err
+	}
+	err = bc.VerifyAuthorization(TX)
+	if err != nil {
+		return err
+	}
+	r.TX = core.EncodeTransaction(TX)
+	return nil
+}
+
+// Status 协调者返回签名的转账决定，参与者恢复时调用
+// 没有记录的转账一定没有提交，按中止处理；还在preparing阶段时说明协调者还没有做出决定，不签名
+func (n *Node) Status(id []byte) *pb.StatusReply {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 模拟获取区块链出现错误")
+		return &pb.StatusReply{Phase: int32(core.TransferUnknown)}
+	}
+	phase := core.TransferUnknown
+	r, err := bc.GetTransfer(id)
+	if errors.Is(err, core.ErrTransferNotFound) {
+		phase = core.TransferAborted
+	} else if err == nil && r.Role == core.TransferCoordinator {
+		phase = r.Phase
+	}
+
+	reply := &pb.StatusReply{Phase: int32(phase)}
+	if phase == core.TransferCommitted || phase == core.TransferAborted {
+		reply.Signature, err = n.sign(decisionHash(id, phase))
+		if err != nil {
+			fmt.Println("! 签名跨区转账的决定失败:", err)
+		}
+	}
+	return reply
+}
+
+// Recover 恢复所有没有完成的转账，节点启动时以及定期调用
+// 协调者没有做出决定的转账中止，已经做出决定的继续执行；参与者在prepared阶段向协调者查询决定，协调者不可用时保持不变
+// 查询到的决定同样需要协调者签名，提交的决定还需要带有转入交易的授权签名，否则保持不变，等待协调者重新发来commit
+// 返回没能完成的转账的错误，下次调用时会重试
+func (n *Node) Recover() error {
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		fmt.Println("! 模拟获取区块链出现错误")
+		return err
+	}
+	records, err := bc.UnfinishedTransfers()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, r := range records {
+		switch {
+		case r.Role == core.TransferCoordinator && r.Phase == core.TransferPreparing:
+			err = n.decide(bc, r, core.TransferAborted)
+		case r.Role == core.TransferParticipant && r.Phase == core.TransferPrepared:
+			err = n.recoverParticipant(bc, r)
+		default:
+			err = n.finish(bc, r)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("! 转账 %x: %w", r.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recoverParticipant 参与者向协调者查询决定并执行，协调者还没有做出决定时保持不变
+func (n *Node) recoverParticipant(bc *core.BlockChain, r *core.TransferRecord) error {
+	reply, err := n.queryStatus(r)
+	if err != nil {
+		return err
+	}
+	phase := core.TransferPhase(reply.GetPhase())
+	if phase != core.TransferAborted && (phase != core.TransferCommitted || len(reply.GetAuthorization()) == 0) {
+		return nil
+	}
+
+	n.mu.Lock()
+	current, err := bc.GetTransfer(r.ID)
+	if err == nil && current.Phase != core.TransferPrepared {
+		// 查询期间已经收到了协调者的决定
+		n.mu.Unlock()
+		return nil
+	}
+	err = n.accept(bc, r, phase, reply.GetSignature(), reply.GetAuthorization())
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return n.finish(bc, r)
+}
+
+// queryStatus 参与者向协调者查询决定
+func (n *Node) queryStatus(r *core.TransferRecord) (*pb.StatusReply, error) {
+	client, err := n.Dial(r.Peer)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+	return client.Status(ctx, &pb.DecisionRequest{TransferID: r.ID})
+}
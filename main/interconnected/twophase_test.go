@@ -0,0 +1,366 @@
+package interconnected
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"os"
+	"testing"
+
+	"transfer/core"
+	pb "transfer/grpc/proto"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"google.golang.org/grpc"
+)
+
+// testCoordinatorKey 测试区块链创世区块中设置的协调者，也是轻计算区签名两阶段提交消息的私钥
+var testCoordinatorKey, _ = crypto.GenerateKey()
+
+// otherKey 不是协调者的私钥
+var otherKey, _ = crypto.GenerateKey()
+
+var (
+	addrFrom = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	addrTo   = common.HexToAddress("0x2000000000000000000000000000000000000002")
+)
+
+// TestMain 所有测试共享临时目录中的同一个区块链，每个测试使用不同的转账ID
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "twophase")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	core.GenesisCoordinator = crypto.PubkeyToAddress(testCoordinatorKey.PublicKey)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func testChain(t *testing.T) *core.BlockChain {
+	t.Helper()
+	err, bc := core.GetBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// fakeCoordinator 轻计算区协调者的TransferGRPC服务，只回复查询决定
+type fakeCoordinator struct {
+	pb.TransferGRPCClient
+	status *pb.StatusReply
+}
+
+func (c *fakeCoordinator) Status(ctx context.Context, in *pb.DecisionRequest, opts ...grpc.CallOption) (*pb.StatusReply, error) {
+	return c.status, nil
+}
+
+// newParticipant 转账区的参与者，提交的本地交易记录在返回的切片中
+func newParticipant(coordinator *fakeCoordinator) (*Node, *[]*core.Transaction) {
+	var submitted []*core.Transaction
+	n := NewNode("participant", nil,
+		func(string) (pb.TransferGRPCClient, error) { return coordinator, nil },
+		func(TX *core.Transaction) error {
+			submitted = append(submitted, TX)
+			return nil
+		})
+	return n, &submitted
+}
+
+// newPrepare 轻计算区->转账区的prepare，key为nil时不签名
+func newPrepare(t *testing.T, key *ecdsa.PrivateKey) *pb.PrepareRequest {
+	t.Helper()
+	id, err := NewTransferID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, hash, err := core.NewPreimage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &pb.PrepareRequest{
+		TransferID:  id,
+		Direction:   DirectionToTransfer,
+		FromAddress: addrFrom.Bytes(),
+		BAddress:    addrTo.Bytes(),
+		Amount:      10,
+		HashLock:    hash,
+		Coordinator: "coordinator",
+	}
+	if key != nil {
+		req.Signature = signed(t, key, prepareHash(req))
+	}
+	return req
+}
+
+func signed(t *testing.T, key *ecdsa.PrivateKey, hash []byte) []byte {
+	t.Helper()
+	signature, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+// authorization 协调者对参与者准备的转入交易的授权签名
+func authorization(t *testing.T, key *ecdsa.PrivateKey, reply *pb.PrepareReply) []byte {
+	t.Helper()
+	TX, err := core.DecodeTransaction(reply.GetTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := TX.Authorize(key); err != nil {
+		t.Fatal(err)
+	}
+	return TX.Vin[0].Signature
+}
+
+func phaseOf(t *testing.T, id []byte) core.TransferPhase {
+	t.Helper()
+	r, err := testChain(t).GetTransfer(id)
+	if errors.Is(err, core.ErrTransferNotFound) {
+		return core.TransferUnknown
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.Phase
+}
+
+func TestPrepareAuthentication(t *testing.T) {
+	testChain(t)
+	n, _ := newParticipant(nil)
+
+	tests := []struct {
+		name  string
+		req   func() *pb.PrepareRequest
+		vote  bool
+		phase core.TransferPhase // 投票之后记录的阶段，签名无效时不留下记录
+	}{
+		{"unsigned", func() *pb.PrepareRequest { return newPrepare(t, nil) }, false, core.TransferUnknown},
+		{"signed by other key", func() *pb.PrepareRequest { return newPrepare(t, otherKey) }, false, core.TransferUnknown},
+		{"amount changed after signing", func() *pb.PrepareRequest {
+			req := newPrepare(t, testCoordinatorKey)
+			req.Amount = 1000
+			return req
+		}, false, core.TransferUnknown},
+		{"wrong direction", func() *pb.PrepareRequest {
+			req := newPrepare(t, nil)
+			req.Direction = DirectionToLight
+			req.Signature = signed(t, testCoordinatorKey, prepareHash(req))
+			return req
+		}, false, core.TransferAborted},
+		{"invalid hash lock", func() *pb.PrepareRequest {
+			req := newPrepare(t, nil)
+			req.HashLock = req.HashLock[:8]
+			req.Signature = signed(t, testCoordinatorKey, prepareHash(req))
+			return req
+		}, false, core.TransferAborted},
+		{"valid", func() *pb.PrepareRequest { return newPrepare(t, testCoordinatorKey) }, true, core.TransferPrepared},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req()
+			reply := n.Prepare(req)
+			if reply.GetVote() != tt.vote {
+				t.Fatalf("投票 %v，期望 %v", reply.GetVote(), tt.vote)
+			}
+			if phase := phaseOf(t, req.GetTransferID()); phase != tt.phase {
+				t.Fatalf("记录的阶段 %v，期望 %v", phase, tt.phase)
+			}
+			if !tt.vote {
+				return
+			}
+			// 重复的prepare返回相同的结果
+			again := n.Prepare(req)
+			if !again.GetVote() || string(again.GetTransaction()) != string(reply.GetTransaction()) {
+				t.Fatal("重复的prepare结果不同")
+			}
+			TX, err := core.DecodeTransaction(reply.GetTransaction())
+			if err != nil {
+				t.Fatal(err)
+			}
+			coin, err := TX.HTLCCoin()
+			if err != nil || coin.Out.Value != 10 || coin.Out.HTLC.Recipient != addrTo || coin.Out.HTLC.Refund != addrFrom {
+				t.Fatalf("准备的转入交易 %+v: %v", coin, err)
+			}
+		})
+	}
+}
+
+func TestParticipantDecisions(t *testing.T) {
+	testChain(t)
+	n, submitted := newParticipant(nil)
+
+	req := newPrepare(t, testCoordinatorKey)
+	reply := n.Prepare(req)
+	if !reply.GetVote() {
+		t.Fatal("有效的prepare被拒绝")
+	}
+	id := req.GetTransferID()
+	commitSig := signed(t, testCoordinatorKey, decisionHash(id, core.TransferCommitted))
+	abortSig := signed(t, testCoordinatorKey, decisionHash(id, core.TransferAborted))
+	auth := authorization(t, testCoordinatorKey, reply)
+
+	steps := []struct {
+		name   string
+		decide func() bool
+		result bool
+		phase  core.TransferPhase
+	}{
+		{"commit unsigned", func() bool { return n.Commit(id, nil, auth) }, false, core.TransferPrepared},
+		{"commit signed by other key", func() bool {
+			return n.Commit(id, signed(t, otherKey, decisionHash(id, core.TransferCommitted)), auth)
+		}, false, core.TransferPrepared},
+		{"commit with abort signature", func() bool { return n.Commit(id, abortSig, auth) }, false, core.TransferPrepared},
+		{"commit without authorization", func() bool { return n.Commit(id, commitSig, nil) }, false, core.TransferPrepared},
+		{"commit with other authorization", func() bool {
+			return n.Commit(id, commitSig, authorization(t, otherKey, reply))
+		}, false, core.TransferPrepared},
+		{"commit", func() bool { return n.Commit(id, commitSig, auth) }, true, core.TransferCommitted},
+		{"commit again", func() bool { return n.Commit(id, commitSig, auth) }, true, core.TransferCommitted},
+		// 已经记录提交之后，协调者签名的中止也不能改变决定
+		{"abort after commit", func() bool { return n.Abort(id, abortSig) }, false, core.TransferCommitted},
+	}
+	for _, step := range steps {
+		if got := step.decide(); got != step.result {
+			t.Fatalf("%s: 结果 %v，期望 %v", step.name, got, step.result)
+		}
+		if phase := phaseOf(t, id); phase != step.phase {
+			t.Fatalf("%s: 阶段 %v，期望 %v", step.name, phase, step.phase)
+		}
+	}
+
+	// 只提交了一次，提交的交易带有协调者的有效授权
+	if len(*submitted) != 1 {
+		t.Fatalf("提交了 %d 个交易，期望 1 个", len(*submitted))
+	}
+	if err := testChain(t).VerifyAuthorization((*submitted)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n.Prepare(req).GetTransaction() == nil {
+		t.Fatal("已经提交的转账的重复prepare没有返回交易")
+	}
+}
+
+func TestParticipantAbort(t *testing.T) {
+	testChain(t)
+	n, submitted := newParticipant(nil)
+
+	// 投票之后中止
+	req := newPrepare(t, testCoordinatorKey)
+	reply := n.Prepare(req)
+	id := req.GetTransferID()
+	if !n.Abort(id, signed(t, testCoordinatorKey, decisionHash(id, core.TransferAborted))) {
+		t.Fatal("中止被拒绝")
+	}
+	commitSig := signed(t, testCoordinatorKey, decisionHash(id, core.TransferCommitted))
+	if n.Commit(id, commitSig, authorization(t, testCoordinatorKey, reply)) {
+		t.Fatal("已经中止的转账被提交")
+	}
+	if phaseOf(t, id) != core.TransferAborted || n.Prepare(req).GetVote() {
+		t.Fatal("中止之后的记录被改变")
+	}
+
+	// prepare之前先收到中止，之后到达的prepare被拒绝
+	late := newPrepare(t, testCoordinatorKey)
+	lateID := late.GetTransferID()
+	if n.Abort(lateID, signed(t, otherKey, decisionHash(lateID, core.TransferAborted))) {
+		t.Fatal("不是协调者签名的中止被接受")
+	}
+	if !n.Abort(lateID, signed(t, testCoordinatorKey, decisionHash(lateID, core.TransferAborted))) {
+		t.Fatal("没有记录的转账的中止被拒绝")
+	}
+	if n.Prepare(late).GetVote() {
+		t.Fatal("已经中止的转账投票同意")
+	}
+	if len(*submitted) != 0 {
+		t.Fatalf("中止的转账提交了 %d 个交易", len(*submitted))
+	}
+}
+
+// TestRecoverParticipant 参与者在prepared阶段向协调者查询决定，只执行协调者签名的决定
+func TestRecoverParticipant(t *testing.T) {
+	testChain(t)
+	coordinator := &fakeCoordinator{}
+	n, submitted := newParticipant(coordinator)
+
+	req := newPrepare(t, testCoordinatorKey)
+	reply := n.Prepare(req)
+	id := req.GetTransferID()
+	commitSig := signed(t, testCoordinatorKey, decisionHash(id, core.TransferCommitted))
+	auth := authorization(t, testCoordinatorKey, reply)
+
+	steps := []struct {
+		name   string
+		status *pb.StatusReply
+		phase  core.TransferPhase
+	}{
+		{"no decision", &pb.StatusReply{Phase: int32(core.TransferPreparing)}, core.TransferPrepared},
+		{"commit without authorization", &pb.StatusReply{Phase: int32(core.TransferCommitted), Signature: commitSig}, core.TransferPrepared},
+		{"commit signed by other key", &pb.StatusReply{
+			Phase:         int32(core.TransferCommitted),
+			Signature:     signed(t, otherKey, decisionHash(id, core.TransferCommitted)),
+			Authorization: auth,
+		}, core.TransferPrepared},
+		{"commit", &pb.StatusReply{Phase: int32(core.TransferCommitted), Signature: commitSig, Authorization: auth}, core.TransferCommitted},
+		{"abort after commit", &pb.StatusReply{
+			Phase:     int32(core.TransferAborted),
+			Signature: signed(t, testCoordinatorKey, decisionHash(id, core.TransferAborted)),
+		}, core.TransferCommitted},
+	}
+	for _, step := range steps {
+		coordinator.status = step.status
+		n.Recover()
+		if phase := phaseOf(t, id); phase != step.phase {
+			t.Fatalf("%s: 阶段 %v，期望 %v", step.name, phase, step.phase)
+		}
+	}
+	if len(*submitted) != 1 {
+		t.Fatalf("提交了 %d 个交易，期望 1 个", len(*submitted))
+	}
+}
+
+// TestCoordinatorStatus 协调者只对已经做出的决定签名，没有记录的转账按中止回复
+func TestCoordinatorStatus(t *testing.T) {
+	bc := testChain(t)
+	n := NewNode("coordinator", testCoordinatorKey, nil, nil)
+
+	preparing, _ := NewTransferID()
+	if err := bc.PutTransfer(&core.TransferRecord{ID: preparing, Role: core.TransferCoordinator, Phase: core.TransferPreparing}); err != nil {
+		t.Fatal(err)
+	}
+	committed, _ := NewTransferID()
+	if err := bc.PutTransfer(&core.TransferRecord{ID: committed, Role: core.TransferCoordinator, Phase: core.TransferCommitted}); err != nil {
+		t.Fatal(err)
+	}
+	unknown, _ := NewTransferID()
+
+	tests := []struct {
+		name   string
+		id     []byte
+		phase  core.TransferPhase
+		signed bool
+	}{
+		{"preparing", preparing, core.TransferPreparing, false},
+		{"committed", committed, core.TransferCommitted, true},
+		{"unknown", unknown, core.TransferAborted, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := n.Status(tt.id)
+			if core.TransferPhase(reply.GetPhase()) != tt.phase {
+				t.Fatalf("阶段 %v，期望 %v", core.TransferPhase(reply.GetPhase()), tt.phase)
+			}
+			err := verifySignature(crypto.PubkeyToAddress(testCoordinatorKey.PublicKey), decisionHash(tt.id, tt.phase), reply.GetSignature())
+			if (err == nil) != tt.signed {
+				t.Fatalf("签名验证 %v，期望签名: %v", err, tt.signed)
+			}
+		})
+	}
+}
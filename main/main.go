@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"strconv"
 	"transfer/core"
@@ -14,6 +15,10 @@ import (
 
 // Main 开机启动界面
 func main() {
+	flag.Parse()
+	// 节点运行时独占区块链数据库，余额通过节点查询
+	wallet.FindAddressUTXOs = FindAddressUTXOs
+
 	// 模拟服务器的账户密码数据，在这里我们新建三个用户账户数据
 	NewAccount()
 	a, _ := crypto.GenerateKey()
@@ -33,29 +38,52 @@ func main() {
 	fmt.Println("> 欢迎您进入磐古区块链系统，您的登录账户为：", Account)
 
 	// 获取钱包余额，AUTXO是当前用户可用的UTXO集合
-	UTXOs, err := w.GetWalletsBalance()
+	UTXOs, err := w.GetWalletsBalance2()
 	if err != nil {
 		fmt.Println("! 获取钱包余额方法出现错误")
 		return
 	}
 	// 计算钱包总金额
-	var AllMoney1 int = 0
+	var AllMoney1 core.Amount
 	// 输出钱包余额
 	for _, u := range UTXOs {
-		AllMoney1 += u.Balance
+		AllMoney1, err = AllMoney1.Add(u.Balance)
+		if err != nil {
+			fmt.Println("! 钱包总余额超过上限")
+			return
+		}
 		fmt.Printf("> 您的 %v 钱包余额为 %d \n", u.Address, u.Balance)
+		if u.Locked > 0 {
+			fmt.Printf("> 您的 %v 钱包还有 %d 锁定中，到期后才能使用 \n", u.Address, u.Locked)
+		}
+		if u.Pending > 0 {
+			fmt.Printf("> 您的 %v 钱包还有 %d 待定中，跨区转账结算后才能使用 \n", u.Address, u.Pending)
+		}
 	}
 	fmt.Println("您的钱包总余额为 ", AllMoney1)
 
+	// 国库资金需要三位负责人中两位签名才能使用
+	treasury, err := TreasuryLock()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	TreasuryBalance, _, err := wallet.GetMultisigBalance(treasury)
+	if err != nil {
+		fmt.Println("! 获取国库余额方法出现错误")
+		return
+	}
+	fmt.Printf("> 国库地址 %v 余额为 %d，需要 %d 位负责人中的 %d 位签名才能使用 \n", treasury.Address(), TreasuryBalance, core.TreasuryOfficers, core.TreasuryRequiredSigners)
+
 	// 转账功能 A to B 一个账户可能有多个地址 后续可以新增联系人列表功能
 	fmt.Println("> 即将进行转账功能，请输入您想要转给的 用户账户")
 	var BAccouont string // 目标账户
 	//var BAddress []common.Address            // 目标地址集合
-	var BMoney int                           // 目标金额
-	var inputnum string                      // 用户输入的字符串序号
-	var IsEnd string                         // 输入转账目标地址循环是否结束
-	var BAddressMoney map[common.Address]int // 转账目标地址与目标金额
-	var AllUTXOs map[string]core.TXOutputs   // 可用的UTXO集合
+	var BMoney string                                     // 目标金额
+	var inputnum string                                   // 用户输入的字符串序号
+	var IsEnd string                                      // 输入转账目标地址循环是否结束
+	BAddressMoney := make(map[common.Address]core.Amount) // 转账目标地址与目标金额
+	AllUTXOs := make(map[string]core.TXOutputs2)          // 可用的UTXO集合，带有out在原交易中的位置
 
 	// TODO: 改成输入账户后显示该账户拥有的钱包地址集合，让用户自己选择给哪个地址转账
 
@@ -95,18 +123,27 @@ func main() {
 		}
 
 		fmt.Println("> 请输入 转账目标金额：")
-		num3, err := fmt.Scanln(BMoney)
+		num3, err := fmt.Scanln(&BMoney)
 		if num3 != 1 || err != nil {
 			fmt.Println("! 输入转账目标金额出现错误")
 			return
 		}
+		// 金额必须是大于0的整数
+		money, err := core.ParseAmount(BMoney)
+		if err == nil {
+			err = core.CheckOutputAmount(money)
+		}
+		if err != nil {
+			fmt.Println("! 转账目标金额无效：", err)
+			return
+		}
 
 		if num > len(AllBAddress) || num <= 0 {
 			fmt.Println("! 输入地址标号超出范围限制")
 			return
 		}
 		// 目标账户地址与转账金额赋值
-		BAddressMoney[AllBAddress[num]] = BMoney
+		BAddressMoney[AllBAddress[num]] = money
 
 		fmt.Println("是否结束？Y/N")
 		num4, err := fmt.Scanln(IsEnd)
@@ -148,9 +185,13 @@ func main() {
 
 	// 查询自己账户余额是否足够（多钱包）
 	// 计算总金额
-	var AllMoney2 int = 0
+	var AllMoney2 core.Amount
 	for _, v := range BAddressMoney {
-		AllMoney2 += v
+		AllMoney2, err = AllMoney2.Add(v)
+		if err != nil {
+			fmt.Println("! 转账总金额超过上限")
+			return
+		}
 	}
 	if AllMoney2 > AllMoney1 {
 		fmt.Println("! 余额不足")
@@ -165,7 +206,8 @@ func main() {
 	// 3 所有检查通过，开始发送交易，新建UTXO
 	// 方法内包含了对UTXO out的签名
 	// 新建钱包
-	w1 := core.NewWallet(Account, w.Privatekey, w.Publickey) // w.Privatekey签名，w.Publickey验证签名
+	w1 := core.NewWallet(Account, w.Privatekey, w.Publickey) // 发送方
+	keyring := w.Keyring()                                   // 每个子钱包的out由子钱包自己的私钥签名
 
 	// 合并可用的UTXO TODO:有点麻烦，看后续有没有其他的解决方法
 	for _, v := range UTXOs {
@@ -179,7 +221,7 @@ func main() {
 			}
 		}
 	}
-	TX, err := core.NewTransaction(w1, BAddressMoney, AllUTXOs)
+	TX, err := core.NewTransaction(w1, keyring, BAddressMoney, AllUTXOs, core.TxBuildOptions{FeeRate: core.DefaultFeeRate, Change: w.ChangePolicy()}) // 找零转到新的子钱包
 	if err != nil {
 		fmt.Println("! 构造新交易出现错误")
 		return
@@ -189,8 +231,12 @@ func main() {
 	fmt.Println("> 交易构造完成")
 
 	// 发送交易
-	// 模拟发送交易
-	Pool = append(Pool, TX)
+	// 交易发送给节点放入节点的交易池，等待出块节点打包
+	err = SubmitTransaction(TX)
+	if err != nil {
+		fmt.Println("! 交易没有通过节点交易池的验证：", err)
+		return
+	}
 
 	fmt.Println("> 交易已发送，欢迎您的使用")
 }
@@ -212,6 +258,15 @@ func NewAccount() {
 	wallet.AccountData["ccc"] = wallet.AccountECDSA{Password: "ccc", Publickey: c.PublicKey, PrivateKey: *c}
 }
 
+// TreasuryLock 国库的多签锁定条件，模拟的三个用户账户就是三位负责人
+func TreasuryLock() (*core.MultisigLock, error) {
+	var officers []common.Address
+	for _, account := range []string{"aaa", "bbb", "ccc"} {
+		officers = append(officers, crypto.PubkeyToAddress(wallet.AccountData[account].Publickey))
+	}
+	return core.NewTreasuryLock(officers...)
+}
+
 // NewAccount2 模拟多钱包账户数据
 func NewAccount2() {
 	// 模拟服务器的账户密码数据，在这里我们新建三个用户账户数据
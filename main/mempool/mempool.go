@@ -0,0 +1,542 @@
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"transfer/core"
+)
+
+// 交易池
+// 节点收到的交易先按当前的UTXO集合验证，通过后放入交易池，等待出块节点打包
+// 交易可以花费交易池中其他还没有上链的交易的out，这样的交易依赖它花费的交易，总是在它们之后放入交易池
+// 同一个out只能被交易池中的一个交易花费，同一个占位交易只能被交易池中的一个结算交易结算，后来的冲突交易被拒绝
+// 交易池的大小按交易规范编码的字节数计算，满了以后按手续费率驱逐：手续费率更低的交易连同依赖它的交易被移出
+// 交易在交易池中停留超过Expiry后过期移出；交易上链后由RemoveBlock移出，同时移出与区块冲突或不再有效的交易
+// 主链切换分支后由Reorg把回滚区块中的交易放回交易池
+// 所有方法都可以被gRPC服务与出块节点并发调用；验证交易需要读数据库，在锁外进行，只有修改交易池的步骤持有锁
+
+var (
+	ErrDuplicate = errors.New("交易已经在交易池中")
+	ErrConflict  = errors.New("交易与交易池中的交易花费了同一个out")
+	ErrPoolFull  = errors.New("交易池已满，交易的手续费率不足以驱逐其他交易")
+	ErrBusy      = errors.New("验证交易期间交易池或区块链持续变化，稍后重试")
+)
+
+// maxAddAttempts 验证期间交易池或区块链发生变化时Add重新验证的次数上限
+const maxAddAttempts = 3
+
+// Config 交易池的配置
+type Config struct {
+	MaxBytes int           // 交易池中所有交易规范编码的字节数上限
+	Expiry   time.Duration // 交易在交易池中的最长停留时间，0表示不过期
+}
+
+// DefaultConfig 默认配置：交易池最多32MB，交易停留24小时后过期
+func DefaultConfig() Config {
+	return Config{MaxBytes: 32 << 20, Expiry: 24 * time.Hour}
+}
+
+// entry 交易池中的一个交易
+type entry struct {
+	tx    *core.Transaction
+	fee   core.Amount
+	size  int       // 规范编码的字节数
+	added time.Time // 放入交易池的时间
+	seq   uint64    // 放入交易池的顺序，依赖的交易总是比依赖它的交易小
+}
+
+// feeRate 每字节的手续费
+func (e *entry) feeRate() float64 {
+	return float64(e.fee) / float64(e.size)
+}
+
+// Mempool 交易池
+type Mempool struct {
+	bc       *core.BlockChain
+	cfg      Config
+	now      func() time.Time                                                             // 当前时间，用于计算过期
+	validate func(tx *core.Transaction, parents []*core.Transaction) (core.Amount, error) // 在parents之后验证交易并返回手续费
+
+	mu      sync.Mutex
+	entries map[string]*entry // 键是交易ID
+	claims  map[string]string // 交易花费的out与结算的占位交易坐标，值是交易ID，用于发现冲突
+	bytes   int
+	seq     uint64
+	epoch   uint64 // 每次有区块上链加一，Add据此发现验证时使用的UTXO集合已经过期
+}
+
+// New 新建交易池，交易按bc的UTXO集合验证
+func New(bc *core.BlockChain, cfg Config) *Mempool {
+	return &Mempool{
+		bc:       bc,
+		cfg:      cfg,
+		now:      time.Now,
+		validate: bc.TransactionFeeWithParents,
+		entries:  make(map[string]*entry),
+		claims:   make(map[string]string),
+	}
+}
+
+// claimKeys 交易占用的资源：花费的out，结算交易结算的占位交易坐标
+func claimKeys(tx *core.Transaction) []string {
+	var keys []string
+	for _, op := range tx.SpentOutPoints() {
+		keys = append(keys, string(op.Key()))
+	}
+	if tx.Slot != nil {
+		keys = append(keys, fmt.Sprintf("slot:%d:%d", tx.Slot.Height, tx.Slot.Index))
+	}
+	return keys
+}
+
+// Add 验证交易并放入交易池
+// 交易ID重复时返回ErrDuplicate，与交易池中的交易冲突时返回ErrConflict，交易池已满时返回ErrPoolFull
+// 验证在锁外进行，之后重新检查重复与冲突；验证期间依赖的交易被移出或者有区块上链时重新验证
+func (m *Mempool) Add(tx *core.Transaction) error {
+	return m.add(tx, time.Time{})
+}
+
+// add 与Add相同，added不为零时作为交易放入交易池的时间，用于Reorg保留原来的过期时间
+func (m *Mempool) add(tx *core.Transaction, added time.Time) error {
+	for attempt := 0; attempt < maxAddAttempts; attempt++ {
+		m.mu.Lock()
+		m.expire()
+		err := m.checkClaims(tx)
+		parents := m.ancestors(tx)
+		epoch := m.epoch
+		m.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		fee, err := m.validate(tx, transactions(parents))
+		if err != nil {
+			return fmt.Errorf("! 交易 %x 验证失败: %w", tx.ID, err)
+		}
+
+		m.mu.Lock()
+		if m.epoch != epoch || !m.sameEntries(m.ancestors(tx), parents) {
+			m.mu.Unlock()
+			continue
+		}
+		err = m.insert(tx, fee, parents, added)
+		m.mu.Unlock()
+		return err
+	}
+	return fmt.Errorf("! 交易 %x: %w", tx.ID, ErrBusy)
+}
+
+// checkClaims 交易不在交易池中，也不与交易池中的交易冲突
+func (m *Mempool) checkClaims(tx *core.Transaction) error {
+	if _, ok := m.entries[string(tx.ID)]; ok {
+		return fmt.Errorf("! 交易 %x: %w", tx.ID, ErrDuplicate)
+	}
+	for _, key := range claimKeys(tx) {
+		if other, ok := m.claims[key]; ok {
+			return fmt.Errorf("! 交易 %x 与交易池中的交易 %x 冲突: %w", tx.ID, other, ErrConflict)
+		}
+	}
+	return nil
+}
+
+// sameEntries 两组交易是否是交易池中同样的交易
+func (m *Mempool) sameEntries(a map[string]*entry, b map[string]*entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id, e := range a {
+		if b[id] != e {
+			return false
+		}
+	}
+	return true
+}
+
+// insert 把已经验证过的交易放入交易池，需要持有锁，added为零时使用当前时间
+// 验证期间其他交易可能已经放入交易池，重新检查重复与冲突
+func (m *Mempool) insert(tx *core.Transaction, fee core.Amount, parents map[string]*entry, added time.Time) error {
+	err := m.checkClaims(tx)
+	if err != nil {
+		return err
+	}
+
+	if added.IsZero() {
+		added = m.now()
+	}
+	id := string(tx.ID)
+	e := &entry{tx: tx, fee: fee, size: tx.TxSize(), added: added}
+	evict, err := m.evictionSet(e, parents)
+	if err != nil {
+		return err
+	}
+	m.remove(evict)
+
+	m.seq++
+	e.seq = m.seq
+	m.entries[id] = e
+	for _, key := range claimKeys(tx) {
+		m.claims[key] = id
+	}
+	m.bytes += e.size
+	return nil
+}
+
+// ancestors 交易直接或间接依赖的交易池中的交易
+func (m *Mempool) ancestors(tx *core.Transaction) map[string]*entry {
+	result := make(map[string]*entry)
+	queue := []*core.Transaction{tx}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, op := range t.SpentOutPoints() {
+			parent, ok := m.entries[string(op.Txid)]
+			if !ok || result[string(op.Txid)] != nil {
+				continue
+			}
+			result[string(op.Txid)] = parent
+			queue = append(queue, parent.tx)
+		}
+	}
+	return result
+}
+
+// transactions 按放入交易池的顺序排列的交易，也就是依赖顺序
+// 只读取entry中不会改变的字段，不需要持有锁
+func transactions(set map[string]*entry) []*core.Transaction {
+	entries := make([]*entry, 0, len(set))
+	for _, e := range set {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	txs := make([]*core.Transaction, len(entries))
+	for i, e := range entries {
+		txs[i] = e.tx
+	}
+	return txs
+}
+
+// children 交易池中每个交易的直接后代
+func (m *Mempool) children() map[string][]string {
+	children := make(map[string][]string)
+	for id, e := range m.entries {
+		parents := make(map[string]bool)
+		for _, op := range e.tx.SpentOutPoints() {
+			parent := string(op.Txid)
+			if _, ok := m.entries[parent]; ok && !parents[parent] {
+				parents[parent] = true
+				children[parent] = append(children[parent], id)
+			}
+		}
+	}
+	return children
+}
+
+// collectDescendants 把id以及直接或间接依赖它的交易加入result，返回新加入的交易的字节数
+func (m *Mempool) collectDescendants(id string, children map[string][]string, result map[string]*entry) int {
+	added := 0
+	queue := []string{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		e, ok := m.entries[cur]
+		if !ok || result[cur] != nil {
+			continue
+		}
+		result[cur] = e
+		added += e.size
+		queue = append(queue, children[cur]...)
+	}
+	return added
+}
+
+// descendants ids中的交易以及直接或间接依赖它们的交易
+func (m *Mempool) descendants(ids map[string]bool) map[string]*entry {
+	result := make(map[string]*entry)
+	if len(ids) == 0 {
+		return result
+	}
+	children := m.children()
+	for id := range ids {
+		m.collectDescendants(id, children, result)
+	}
+	return result
+}
+
+// evictionSet 为新交易腾出空间需要驱逐的交易
+// 按手续费率从低到高驱逐，每个被驱逐的交易连同依赖它的交易一起移出，新交易依赖的交易不能被驱逐
+// 依赖关系在每次驱逐开始时计算一次，每个交易最多被遍历一次
+// 需要驱逐手续费率不低于新交易的交易时返回ErrPoolFull
+func (m *Mempool) evictionSet(e *entry, parents map[string]*entry) (map[string]*entry, error) {
+	evict := make(map[string]*entry)
+	if m.bytes+e.size <= m.cfg.MaxBytes {
+		return evict, nil
+	}
+	if e.size > m.cfg.MaxBytes {
+		return nil, fmt.Errorf("! 交易 %x 的大小 %d 超过交易池上限 %d: %w", e.tx.ID, e.size, m.cfg.MaxBytes, ErrPoolFull)
+	}
+
+	candidates := make([]*entry, 0, len(m.entries))
+	for id, c := range m.entries {
+		if parents[id] == nil {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].feeRate() != candidates[j].feeRate() {
+			return candidates[i].feeRate() < candidates[j].feeRate()
+		}
+		return candidates[i].seq > candidates[j].seq
+	})
+
+	children := m.children()
+	freed := 0
+	for _, c := range candidates {
+		if m.bytes-freed+e.size <= m.cfg.MaxBytes {
+			break
+		}
+		if evict[string(c.tx.ID)] != nil {
+			continue
+		}
+		if c.feeRate() >= e.feeRate() {
+			return nil, fmt.Errorf("! 交易 %x: %w", e.tx.ID, ErrPoolFull)
+		}
+		freed += m.collectDescendants(string(c.tx.ID), children, evict)
+	}
+	if m.bytes-freed+e.size > m.cfg.MaxBytes {
+		return nil, fmt.Errorf("! 交易 %x: %w", e.tx.ID, ErrPoolFull)
+	}
+	return evict, nil
+}
+
+// remove 从交易池中移出交易
+func (m *Mempool) remove(set map[string]*entry) {
+	for id, e := range set {
+		if m.entries[id] == nil {
+			continue
+		}
+		delete(m.entries, id)
+		for _, key := range claimKeys(e.tx) {
+			if m.claims[key] == id {
+				delete(m.claims, key)
+			}
+		}
+		m.bytes -= e.size
+	}
+}
+
+// expire 移出过期的交易以及依赖它们的交易
+func (m *Mempool) expire() int {
+	if m.cfg.Expiry <= 0 {
+		return 0
+	}
+	deadline := m.now().Add(-m.cfg.Expiry)
+	expired := make(map[string]bool)
+	for id, e := range m.entries {
+		if e.added.Before(deadline) {
+			expired[id] = true
+		}
+	}
+	if len(expired) == 0 {
+		return 0
+	}
+	set := m.descendants(expired)
+	m.remove(set)
+	return len(set)
+}
+
+// Expire 移出过期的交易以及依赖它们的交易，返回移出的交易个数
+func (m *Mempool) Expire() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expire()
+}
+
+// RemoveBlock 区块上链后移出区块中的交易，与区块中的交易冲突的交易，以及不再有效的交易
+// 区块中的交易花费了交易池中交易花费的out时，交易池中的交易连同依赖它的交易一起移出
+// 剩下的交易按新的UTXO集合重新验证，例如锁定时间或HTLC超时条件可能发生了变化
+func (m *Mempool) RemoveBlock(block *core.Block) {
+	m.mu.Lock()
+	included := make(map[string]*entry)
+	conflicts := make(map[string]bool)
+	for _, tx := range block.Body.Transactions {
+		if e, ok := m.entries[string(tx.ID)]; ok {
+			included[string(tx.ID)] = e
+			continue
+		}
+		for _, key := range claimKeys(tx) {
+			if id, ok := m.claims[key]; ok {
+				conflicts[id] = true
+			}
+		}
+	}
+	// 上链的交易的后代仍然有效，它们花费的out现在在UTXO集合中
+	m.remove(included)
+	m.remove(m.descendants(conflicts))
+	m.expire()
+	m.epoch++
+	snapshot := transactions(m.entries)
+	m.mu.Unlock()
+
+	m.revalidate(snapshot)
+}
+
+// Reorg 主链切换到另一个分支后调用，disconnected是从主链上回滚的区块，按高度从低到高排列
+// 回滚区块中除奖励交易以外的交易按原来的顺序重新放入交易池，交易池中原有的交易依赖的交易可能在其中，所以排在它们之后重新放入
+// 新分支已经包含的交易、与新分支冲突的交易以及不再有效的交易在重新放入时被丢弃，原有的交易保留放入交易池的时间
+func (m *Mempool) Reorg(disconnected []*core.Block) {
+	m.mu.Lock()
+	previous := transactions(m.entries)
+	added := make(map[string]time.Time, len(m.entries))
+	for id, e := range m.entries {
+		added[id] = e.added
+	}
+	m.entries = make(map[string]*entry)
+	m.claims = make(map[string]string)
+	m.bytes = 0
+	m.epoch++
+	m.mu.Unlock()
+
+	for _, block := range disconnected {
+		for _, tx := range block.Body.Transactions {
+			if tx.IsReward() {
+				continue
+			}
+			m.add(tx, time.Time{})
+		}
+	}
+	for _, tx := range previous {
+		m.add(tx, added[string(tx.ID)])
+	}
+}
+
+// revalidate 在锁外按依赖顺序重新验证snapshot中的交易，移出不再有效的交易以及依赖它们的交易
+// 验证期间放入交易池的交易已经按新的UTXO集合验证过；依赖被移出交易的交易在移出时一起移出
+func (m *Mempool) revalidate(snapshot []*core.Transaction) {
+	fees, errs, err := m.bc.ValidateTransactions(snapshot)
+	if err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	invalid := make(map[string]bool)
+	for i, tx := range snapshot {
+		e, ok := m.entries[string(tx.ID)]
+		if !ok || e.tx != tx {
+			continue
+		}
+		if errs[i] != nil {
+			invalid[string(tx.ID)] = true
+			continue
+		}
+		e.fee = fees[i]
+	}
+	m.remove(m.descendants(invalid))
+}
+
+// Select 为新区块挑选交易，规范编码的字节数之和不超过maxBytes
+// 依赖的交易全部选中之后交易才能被选中，能被选中的交易按手续费率从高到低挑选，返回的交易按依赖顺序排列
+func (m *Mempool) Select(maxBytes int) []*core.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	waiting := make(map[string]int)       // 还没有选中的依赖交易个数
+	children := make(map[string][]*entry) // 直接依赖它的交易
+	ready := &byFeeRate{}
+	for id, e := range m.entries {
+		parents := make(map[string]bool)
+		for _, op := range e.tx.SpentOutPoints() {
+			if _, ok := m.entries[string(op.Txid)]; ok && !parents[string(op.Txid)] {
+				parents[string(op.Txid)] = true
+				children[string(op.Txid)] = append(children[string(op.Txid)], e)
+			}
+		}
+		waiting[id] = len(parents)
+		if len(parents) == 0 {
+			heap.Push(ready, e)
+		}
+	}
+
+	var selected []*core.Transaction
+	used := 0
+	for ready.Len() > 0 {
+		e := heap.Pop(ready).(*entry)
+		// 放不下的交易不选，依赖它的交易也就不会被选中
+		if used+e.size > maxBytes {
+			continue
+		}
+		used += e.size
+		selected = append(selected, e.tx)
+		for _, child := range children[string(e.tx.ID)] {
+			waiting[string(child.tx.ID)]--
+			if waiting[string(child.tx.ID)] == 0 {
+				heap.Push(ready, child)
+			}
+		}
+	}
+	return selected
+}
+
+// byFeeRate 按手续费率从高到低排列的堆，手续费率相同时先放入交易池的在前
+type byFeeRate []*entry
+
+func (h byFeeRate) Len() int { return len(h) }
+func (h byFeeRate) Less(i, j int) bool {
+	if h[i].feeRate() != h[j].feeRate() {
+		return h[i].feeRate() > h[j].feeRate()
+	}
+	return h[i].seq < h[j].seq
+}
+func (h byFeeRate) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *byFeeRate) Push(x interface{}) { *h = append(*h, x.(*entry)) }
+func (h *byFeeRate) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Has 交易是否在交易池中
+func (m *Mempool) Has(txid []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[string(txid)]
+	return ok
+}
+
+// Get 按交易ID查询交易池中的交易
+func (m *Mempool) Get(txid []byte) (*core.Transaction, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[string(txid)]
+	if !ok {
+		return nil, false
+	}
+	return e.tx, true
+}
+
+// Transactions 交易池中的所有交易，按依赖顺序排列
+func (m *Mempool) Transactions() []*core.Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return transactions(m.entries)
+}
+
+// Count 交易池中的交易个数
+func (m *Mempool) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Bytes 交易池中所有交易规范编码的字节数
+func (m *Mempool) Bytes() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
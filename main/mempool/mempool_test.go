@@ -0,0 +1,604 @@
+package mempool
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"transfer/core"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	testKey, _            = crypto.GenerateKey()
+	testCoordinatorKey, _ = crypto.GenerateKey()
+
+	testAddr    = crypto.PubkeyToAddress(testKey.PublicKey)
+	testKeyring = core.NewKeyring(testKey)
+)
+
+// newTestChain 在临时目录中新建只有创世区块的区块链，数据库文件名固定，所以需要切换工作目录，测试不能并行
+func newTestChain(t *testing.T) *core.BlockChain {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	bc, err := core.NewBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(core.NewGenesisBlock(crypto.PubkeyToAddress(testCoordinatorKey.PublicKey))); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// newTestBlock 组装接在最新区块之后、包含txs的区块，不写入区块链
+func newTestBlock(bc *core.BlockChain, txs ...*core.Transaction) (*core.Block, error) {
+	tip, err := bc.GetBlockByHeight(bc.TipHeight())
+	if err != nil {
+		return nil, err
+	}
+	hash, err := tip.Hash()
+	if err != nil {
+		return nil, err
+	}
+	block := core.NewBlockWithTransactions(hash, uint64(bc.TipHeight()+1), txs)
+	return block, mineBlock(bc, block)
+}
+
+// mineBlock 按前一个区块填写难度目标与时间戳，并寻找满足难度目标的Nonce
+func mineBlock(bc *core.BlockChain, block *core.Block) error {
+	if err := bc.PrepareHeader(block); err != nil {
+		return err
+	}
+	return block.Mine(context.Background())
+}
+
+// addTestBlock 把txs打包成区块写入区块链
+func addTestBlock(t *testing.T, bc *core.BlockChain, txs ...*core.Transaction) *core.Block {
+	t.Helper()
+	block, err := newTestBlock(bc, txs...)
+	if err == nil {
+		err = bc.AddBlock(block)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// mint 协调者授权的给testAddr转入value的转入交易，account不同交易ID就不同
+func mint(t *testing.T, value core.Amount, account string) *core.Transaction {
+	t.Helper()
+	tx, err := core.NewCoinbaseTX(testAddr, testAddr, value, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// fund 在一个区块中上链n个协调者授权的转入交易，每个给testAddr转入value
+func fund(t *testing.T, bc *core.BlockChain, n int, value core.Amount) []*core.Transaction {
+	t.Helper()
+	var txs []*core.Transaction
+	for i := 0; i < n; i++ {
+		txs = append(txs, mint(t, value, fmt.Sprintf("%d-%d", bc.TipHeight(), i)))
+	}
+	addTestBlock(t, bc, txs...)
+	return txs
+}
+
+// spend 花费prev的第vout个out，扣除fee后转回testAddr
+func spend(t *testing.T, prev *core.Transaction, vout int, fee core.Amount) *core.Transaction {
+	t.Helper()
+	out := prev.Vout[vout]
+	tx := &core.Transaction{
+		Vin:  []core.TXInput{{Txid: prev.ID, Vout: vout, Address: out.Address}},
+		Vout: []core.TXOutput{*core.NewTXOutput(out.Value-fee, testAddr)},
+	}
+	if err := tx.SignWithKeyring(testKeyring, map[string]core.Transaction{hex.EncodeToString(prev.ID): *prev}); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// checkInvariants 交易池的字节数与占用的资源与其中的交易一致
+func checkInvariants(t *testing.T, m *Mempool) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	size := 0
+	claims := 0
+	for id, e := range m.entries {
+		size += e.size
+		for _, key := range claimKeys(e.tx) {
+			claims++
+			if m.claims[key] != id {
+				t.Fatalf("交易 %x 占用的 %q 记录为 %x", id, key, m.claims[key])
+			}
+		}
+	}
+	if size != m.bytes || claims != len(m.claims) {
+		t.Fatalf("字节数 %d，记录 %d；占用 %d，记录 %d", size, m.bytes, claims, len(m.claims))
+	}
+}
+
+func TestAdd(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 2, 100)
+	m := New(bc, DefaultConfig())
+
+	tx := spend(t, coins[0], 0, 1)
+	child := spend(t, tx, 0, 1)
+	unsigned := spend(t, coins[1], 0, 1)
+	unsigned.Vin[0].Signature = nil
+	unsigned.ID = unsigned.Hash()
+	missing := spend(t, spend(t, coins[1], 0, 2), 0, 1) // 依赖的交易不在交易池中也不在链上
+
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		want error
+	}{
+		{"spend", tx, nil},
+		{"duplicate", tx, ErrDuplicate},
+		{"spend pool output", child, nil},
+		{"unsigned", unsigned, core.ErrInvalidSignature},
+		{"missing input", missing, core.ErrMissingInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.Add(tt.tx); !errors.Is(err, tt.want) {
+				t.Fatalf("Add = %v，期望 %v", err, tt.want)
+			}
+		})
+	}
+	if m.Count() != 2 || !m.Has(tx.ID) || !m.Has(child.ID) || m.Bytes() != tx.TxSize()+child.TxSize() {
+		t.Fatalf("交易池有 %d 个交易，%d 字节", m.Count(), m.Bytes())
+	}
+	if got, ok := m.Get(child.ID); !ok || got != child {
+		t.Fatal("Get没有返回放入的交易")
+	}
+	checkInvariants(t, m)
+}
+
+func TestAddConflicts(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 1, 100)
+
+	// 上链一个占位交易，两个结算结果不同的结算交易结算同一个坐标
+	placeholder, err := core.NewPlaceholderTX(testAddr, testAddr, 5, []byte("transfer"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := placeholder.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, placeholder)
+	loc, err := bc.FindTxLocation(placeholder.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot := core.SlotRef{Height: uint64(loc.Height), Index: loc.Index, Txid: placeholder.ID}
+	settle := func(fill bool) *core.Transaction {
+		s := slot
+		s.Fill = fill
+		tx, err := core.NewSettlementTX(s, testCoordinatorKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	tests := []struct {
+		name  string
+		first *core.Transaction
+		later *core.Transaction
+	}{
+		{"outpoint", spend(t, coins[0], 0, 1), spend(t, coins[0], 0, 2)},
+		{"slot", settle(true), settle(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(bc, DefaultConfig())
+			if err := m.Add(tt.first); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Add(tt.later); !errors.Is(err, ErrConflict) {
+				t.Fatalf("Add = %v，期望 %v", err, ErrConflict)
+			}
+			if m.Has(tt.later.ID) || m.Count() != 1 {
+				t.Fatal("冲突的交易放入了交易池")
+			}
+			checkInvariants(t, m)
+		})
+	}
+}
+
+func TestEvictByFeeRate(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 4, 1000)
+
+	low := spend(t, coins[0], 0, 1)
+	lowChild := spend(t, low, 0, 50) // 手续费率高，但依赖被驱逐的交易
+	mid := spend(t, coins[1], 0, 10)
+	high := spend(t, coins[2], 0, 20)
+	lowest := spend(t, coins[3], 0, 0)
+
+	// 交易池只能放下三个交易
+	m := New(bc, Config{MaxBytes: low.TxSize() + lowChild.TxSize() + mid.TxSize()})
+	for _, tx := range []*core.Transaction{low, lowChild, mid} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Add(lowest); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("手续费率最低的交易: %v，期望 %v", err, ErrPoolFull)
+	}
+	if err := m.Add(high); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		in   bool
+	}{
+		{"lowest fee rate", low, false},
+		{"descendant of evicted", lowChild, false},
+		{"higher fee rate", mid, true},
+		{"new", high, true},
+		{"rejected", lowest, false},
+	}
+	for _, tt := range tests {
+		if m.Has(tt.tx.ID) != tt.in {
+			t.Errorf("%s: 在交易池中 %v，期望 %v", tt.name, !tt.in, tt.in)
+		}
+	}
+	checkInvariants(t, m)
+
+	// 比交易池上限还大的交易直接拒绝
+	small := New(bc, Config{MaxBytes: low.TxSize() - 1})
+	if err := small.Add(low); !errors.Is(err, ErrPoolFull) {
+		t.Fatalf("超过上限的交易: %v，期望 %v", err, ErrPoolFull)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 2, 100)
+	m := New(bc, Config{MaxBytes: 1 << 20, Expiry: time.Hour})
+	now := time.Unix(1700000000, 0)
+	m.now = func() time.Time { return now }
+
+	old := spend(t, coins[0], 0, 1)
+	if err := m.Add(old); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	child := spend(t, old, 0, 1) // 依赖过期的交易，自己还没有过期
+	fresh := spend(t, coins[1], 0, 1)
+	for _, tx := range []*core.Transaction{child, fresh} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now = now.Add(31 * time.Minute)
+	if n := m.Expire(); n != 2 {
+		t.Fatalf("Expire移出 %d 个交易，期望 2", n)
+	}
+	if m.Has(old.ID) || m.Has(child.ID) || !m.Has(fresh.ID) {
+		t.Fatal("过期的交易与依赖它的交易没有被移出")
+	}
+	checkInvariants(t, m)
+}
+
+func TestRemoveBlock(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 3, 100)
+
+	// 哈希时间锁在高度4超时，高度3的区块中还可以领取
+	preimage, hash, err := core.NewPreimage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := core.NewHTLCLock(hash, testAddr, testAddr, uint64(bc.TipHeight()+3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	htlc, err := core.NewHTLCCoinbaseTX(testAddr, lock, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := htlc.Authorize(testCoordinatorKey); err != nil {
+		t.Fatal(err)
+	}
+	addTestBlock(t, bc, htlc)
+	coin, err := htlc.HTLCCoin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := core.NewHTLCClaim(coin, preimage, testKeyring, false, core.TxBuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(bc, DefaultConfig())
+	included := spend(t, coins[0], 0, 1)
+	child := spend(t, included, 0, 1)
+	conflict := spend(t, coins[1], 0, 1)
+	conflictChild := spend(t, conflict, 0, 1)
+	other := spend(t, coins[2], 0, 1)
+	for _, tx := range []*core.Transaction{included, child, conflict, conflictChild, other, claim} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 区块包含交易池中的一个交易，以及与交易池中的交易花费同一个out的另一个交易
+	block := addTestBlock(t, bc, included, spend(t, coins[1], 0, 2))
+	m.RemoveBlock(block)
+
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		in   bool
+	}{
+		{"included", included, false},
+		{"child of included", child, true},
+		{"conflict", conflict, false},
+		{"descendant of conflict", conflictChild, false},
+		{"unrelated", other, true},
+		{"htlc claim after timeout", claim, false},
+	}
+	for _, tt := range tests {
+		if m.Has(tt.tx.ID) != tt.in {
+			t.Errorf("%s: 在交易池中 %v，期望 %v", tt.name, !tt.in, tt.in)
+		}
+	}
+	checkInvariants(t, m)
+}
+
+func TestReorg(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 3, 100)
+	fork, err := bc.GetBlockByHeight(bc.TipHeight())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forkHash, _ := fork.Hash()
+	m := New(bc, DefaultConfig())
+	bc.OnReorg(m.Reorg)
+
+	// 主链上的区块包含奖励交易与两个普通交易，交易池中有依赖其中一个交易的交易
+	reward := core.NewRewardTX(testAddr, 1, 2)
+	rolledBack := spend(t, coins[0], 0, 1)
+	alsoOnBranch := spend(t, coins[1], 0, 1)
+	m.RemoveBlock(addTestBlock(t, bc, reward, rolledBack, alsoOnBranch))
+	child := spend(t, rolledBack, 0, 1)
+	conflict := spend(t, coins[2], 0, 1)
+	for _, tx := range []*core.Transaction{child, conflict} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 更长的分支包含其中一个交易，以及与交易池中的交易花费同一个out的交易
+	prev := forkHash
+	for i, txs := range [][]*core.Transaction{
+		{alsoOnBranch, spend(t, coins[2], 0, 2)},
+		{mint(t, 1, "branch")},
+	} {
+		block := core.NewBlockWithTransactions(prev, fork.Header.Height+uint64(i)+1, txs)
+		if err := mineBlock(bc, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		prev, _ = block.Hash()
+	}
+	if bc.TipHeight() != 3 || !bc.IsMainChain(prev) {
+		t.Fatal("没有切换到更长的分支")
+	}
+
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		in   bool
+	}{
+		{"rolled back", rolledBack, true},
+		{"child of rolled back", child, true},
+		{"reward", reward, false},
+		{"included in branch", alsoOnBranch, false},
+		{"conflicts with branch", conflict, false},
+	}
+	for _, tt := range tests {
+		if m.Has(tt.tx.ID) != tt.in {
+			t.Errorf("%s: 在交易池中 %v，期望 %v", tt.name, !tt.in, tt.in)
+		}
+	}
+	checkInvariants(t, m)
+	// 放回的交易排在依赖它的交易之前
+	// 回滚区块中的交易是从数据库中读出的，按交易ID比较
+	if got := m.Select(1 << 20); len(got) != 2 || !bytes.Equal(got[0].ID, rolledBack.ID) || !bytes.Equal(got[1].ID, child.ID) {
+		t.Fatalf("Select = %v，期望先选中回滚的交易", got)
+	}
+}
+
+// TestAddRetriesAfterBlock 验证期间有区块上链时Add按新的UTXO集合重新验证
+func TestAddRetriesAfterBlock(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 2, 100)
+	m := New(bc, DefaultConfig())
+
+	tx := spend(t, coins[0], 0, 1)
+	calls := 0
+	m.validate = func(tx *core.Transaction, parents []*core.Transaction) (core.Amount, error) {
+		calls++
+		if calls == 1 {
+			m.RemoveBlock(addTestBlock(t, bc, spend(t, coins[1], 0, 1)))
+		}
+		return bc.TransactionFeeWithParents(tx, parents)
+	}
+	if err := m.Add(tx); err != nil || calls != 2 {
+		t.Fatalf("Add = %v，验证了 %d 次，期望 2", err, calls)
+	}
+
+	// 区块链一直变化时放弃
+	calls = 0
+	m.validate = func(tx *core.Transaction, parents []*core.Transaction) (core.Amount, error) {
+		calls++
+		fund(t, bc, 1, 1)
+		tip, err := bc.GetBlockByHeight(bc.TipHeight())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.RemoveBlock(tip)
+		return bc.TransactionFeeWithParents(tx, parents)
+	}
+	if err := m.Add(spend(t, tx, 0, 1)); !errors.Is(err, ErrBusy) || calls != maxAddAttempts {
+		t.Fatalf("Add = %v，验证了 %d 次，期望 %v", err, calls, ErrBusy)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 2, 1000)
+	m := New(bc, DefaultConfig())
+
+	parent := spend(t, coins[0], 0, 1)
+	child := spend(t, parent, 0, 100) // 手续费率最高，但要等parent被选中
+	independent := spend(t, coins[1], 0, 10)
+	for _, tx := range []*core.Transaction{parent, child, independent} {
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		maxBytes int
+		want     []*core.Transaction
+	}{
+		{"all", 1 << 20, []*core.Transaction{independent, parent, child}},
+		{"child does not fit", independent.TxSize() + parent.TxSize(), []*core.Transaction{independent, parent}},
+		{"parent does not fit", independent.TxSize() + parent.TxSize() - 1, []*core.Transaction{independent}},
+		{"none", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.Select(tt.maxBytes)
+			if len(got) != len(tt.want) {
+				t.Fatalf("选中 %d 个交易，期望 %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("第 %d 个交易 %x，期望 %x", i, got[i].ID, tt.want[i].ID)
+				}
+			}
+		})
+	}
+
+	// 选中的交易按顺序可以放进同一个区块
+	if _, _, err := bc.ValidateTransactions(m.Select(1 << 20)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConcurrentAccess gRPC服务放入交易的同时出块节点挑选交易出块，用-race运行可以发现数据竞争
+func TestConcurrentAccess(t *testing.T) {
+	bc := newTestChain(t)
+	const n = 40
+	coins := fund(t, bc, n, 100)
+	m := New(bc, DefaultConfig())
+
+	var txs []*core.Transaction
+	for _, coin := range coins {
+		tx := spend(t, coin, 0, 1)
+		txs = append(txs, tx, spend(t, tx, 0, 1))
+	}
+
+	errs := make(chan error, len(txs)+1)
+	var adders sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		adders.Add(1)
+		go func(w int) {
+			defer adders.Done()
+			for i := w; i < n; i += 4 {
+				// 父交易可能已经被打包上链，子交易仍然可以放入
+				for _, tx := range txs[2*i : 2*i+2] {
+					err := m.Add(tx)
+					for errors.Is(err, ErrBusy) {
+						err = m.Add(tx)
+					}
+					if err != nil {
+						errs <- err
+					}
+				}
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	var producer sync.WaitGroup
+	producer.Add(1)
+	go func() {
+		defer producer.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			selected := m.Select(1 << 20)
+			if len(selected) == 0 {
+				continue
+			}
+			block, err := newTestBlock(bc, selected...)
+			if err == nil {
+				err = bc.AddBlock(block)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			m.RemoveBlock(block)
+		}
+	}()
+
+	adders.Wait()
+	close(done)
+	producer.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// 每个交易要么已经上链，要么还在交易池中
+	for _, tx := range txs {
+		_, err := bc.FindTxLocation(tx.ID)
+		if (err == nil) == m.Has(tx.ID) {
+			t.Errorf("交易 %x 上链 %v，在交易池中 %v", tx.ID, err == nil, m.Has(tx.ID))
+		}
+	}
+	checkInvariants(t, m)
+}
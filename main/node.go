@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+	"transfer/core"
+	pb "transfer/grpc/proto"
+
+	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
+)
+
+// 节点
+// 节点(grpc/serve)运行时独占区块链数据库，客户端不能再打开数据库，查询余额与发送交易都通过gRPC交给节点完成
+
+// nodeAddress 节点TransferGRPC服务的地址
+var nodeAddress = flag.String("node", "localhost:1145", "节点TransferGRPC服务的地址")
+
+// nodeTimeout 每次gRPC请求的超时时间
+const nodeTimeout = 10 * time.Second
+
+// dialNode 连接节点，返回的conn由调用者关闭
+func dialNode() (pb.TransferGRPCClient, *grpc.ClientConn, error) {
+	conn, err := grpc.Dial(*nodeAddress, grpc.WithInsecure())
+	if err != nil {
+		return nil, nil, err
+	}
+	return pb.NewTransferGRPCClient(conn), conn, nil
+}
+
+// FindAddressUTXOs 向节点查询一组地址拥有的UTXO与余额，替换wallet.FindAddressUTXOs后钱包不再打开本地数据库
+func FindAddressUTXOs(addresses []common.Address) (map[common.Address]*core.AddressUTXOs, error) {
+	client, conn, err := dialNode()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := &pb.AddressUTXOsRequest{}
+	for _, address := range addresses {
+		request.Addresses = append(request.Addresses, address.Bytes())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nodeTimeout)
+	defer cancel()
+	r, err := client.FindAddressUTXOs(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.GetAddressUTXOs()) != len(addresses) {
+		return nil, fmt.Errorf("! 查询了 %d 个地址，节点返回 %d 个结果", len(addresses), len(r.GetAddressUTXOs()))
+	}
+
+	result := make(map[common.Address]*core.AddressUTXOs, len(addresses))
+	for i, data := range r.GetAddressUTXOs() {
+		au, err := core.DeserializeAddressUTXOs(data)
+		if err != nil {
+			return nil, err
+		}
+		if au.Address != addresses[i] {
+			return nil, fmt.Errorf("! 节点返回的第 %d 个结果属于地址 %x，期望 %x", i, au.Address, addresses[i])
+		}
+		result[au.Address] = au
+	}
+	return result, nil
+}
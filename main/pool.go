@@ -1,7 +1,32 @@
 package main
 
-import "transfer/core"
+import (
+	"context"
+	"errors"
+	"transfer/core"
+	pb "transfer/grpc/proto"
+)
 
-// 模拟交易网络
+// 交易池
+// 交易池属于长期运行的节点(grpc/serve)，客户端退出后交易仍然在节点的交易池中等待出块节点打包，见mempool包
+// 发送的交易通过gRPC交给节点，由节点验证后放入交易池
 
-var Pool []*core.Transaction
+// SubmitTransaction 把交易发送给节点放入交易池，交易没有通过节点的验证时返回原因
+func SubmitTransaction(TX *core.Transaction) error {
+	client, conn, err := dialNode()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeTimeout)
+	defer cancel()
+	r, err := client.SubmitTransaction(ctx, &pb.SubmitRequest{Transaction: core.EncodeTransaction(TX)})
+	if err != nil {
+		return err
+	}
+	if !r.GetResult() {
+		return errors.New(r.GetError())
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"transfer/core"
+	"transfer/mempool"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 出块
+// 从交易池中按依赖顺序与手续费率挑选交易，组装接在最新区块之后的新区块并通过AddBlock写入区块链
+// 区块的第一个交易是把区块内所有手续费与出块补贴转给出块地址的奖励交易，默克尔根、时间戳与难度目标由core.NewBlockTemplate计算
+// 奖励交易的out要经过若干个区块才能花费，见core/fee.go
+// 写入之前通过Block.Mine寻找满足难度目标的Nonce，Run的ctx被取消时正在进行的挖矿也会停止
+// Run按配置的间隔定期出块，Trigger可以随时要求立即出块，Produce同步出一个块
+
+// ErrNoTransactions 交易池中没有可以打包的交易
+var ErrNoTransactions = errors.New("交易池中没有可以打包的交易")
+
+// Config 出块配置
+type Config struct {
+	Address       common.Address // 出块奖励地址
+	Interval      time.Duration  // 定期出块的间隔，0表示只在Trigger时出块
+	MaxBlockBytes int            // 区块中交易规范编码的字节数上限，包括奖励交易
+	AllowEmpty    bool           // 交易池为空时是否也出块
+}
+
+// DefaultConfig 默认配置：每10秒出块，区块中的交易最多1MB，不出空块
+func DefaultConfig(address common.Address) Config {
+	return Config{Address: address, Interval: 10 * time.Second, MaxBlockBytes: 1 << 20}
+}
+
+// Producer 出块节点
+type Producer struct {
+	bc   *core.BlockChain
+	pool *mempool.Mempool
+	cfg  Config
+
+	mu      sync.Mutex    // 同一时间只组装一个区块
+	trigger chan struct{} // 立即出块的请求
+}
+
+// New 新建出块节点，交易从pool中挑选，区块写入bc
+func New(bc *core.BlockChain, pool *mempool.Mempool, cfg Config) *Producer {
+	return &Producer{bc: bc, pool: pool, cfg: cfg, trigger: make(chan struct{}, 1)}
+}
+
+// rewardSize 奖励交易规范编码的最大字节数，挑选交易时预留这部分空间
+func rewardSize(address common.Address) int {
+	return core.NewRewardTX(address, core.MaxAmount, math.MaxUint64).TxSize()
+}
+
+// Produce 立即组装一个区块，挖矿后写入区块链，写入后把区块中的交易移出交易池
+// 没有可以打包的交易并且不允许出空块时返回ErrNoTransactions，ctx被取消时停止挖矿并返回ctx.Err()
+func (p *Producer) Produce(ctx context.Context) (*core.Block, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	txs := p.pool.Select(p.cfg.MaxBlockBytes - rewardSize(p.cfg.Address))
+	if len(txs) == 0 && !p.cfg.AllowEmpty {
+		return nil, ErrNoTransactions
+	}
+
+	block, err := p.bc.NewBlockTemplate(p.cfg.Address, txs)
+	if err != nil {
+		// 交易池在上一个区块之后已经重新验证过交易，这里失败说明链上状态在此期间发生了变化，逐个验证去掉无效的交易
+		txs = p.validTransactions(txs)
+		if len(txs) == 0 && !p.cfg.AllowEmpty {
+			return nil, ErrNoTransactions
+		}
+		block, err = p.bc.NewBlockTemplate(p.cfg.Address, txs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = block.Mine(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.bc.AddBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("! 写入新区块失败: %w", err)
+	}
+	p.pool.RemoveBlock(block)
+	return block, nil
+}
+
+// validTransactions 按顺序验证txs，去掉无效的交易，依赖无效交易的交易也会验证失败
+func (p *Producer) validTransactions(txs []*core.Transaction) []*core.Transaction {
+	var valid []*core.Transaction
+	for _, tx := range txs {
+		if _, err := p.bc.TransactionFeeWithParents(tx, valid); err != nil {
+			log.Printf("交易 %x 无法打包: %v", tx.ID, err)
+			continue
+		}
+		valid = append(valid, tx)
+	}
+	return valid
+}
+
+// Trigger 要求Run立即出块，已经有等待处理的请求时合并为一个
+func (p *Producer) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run 按配置的间隔以及Trigger的请求出块，直到ctx被取消
+func (p *Producer) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if p.cfg.Interval > 0 {
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-p.trigger:
+		}
+
+		block, err := p.Produce(ctx)
+		if errors.Is(err, ErrNoTransactions) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Println("出块失败:", err)
+			continue
+		}
+		hash, _ := block.Hash()
+		log.Printf("新区块 %x 高度 %d，包含 %d 个交易", hash, block.Header.Height, len(block.Body.Transactions))
+	}
+}
@@ -0,0 +1,241 @@
+package producer
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"transfer/core"
+	"transfer/mempool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	testKey, _            = crypto.GenerateKey()
+	testCoordinatorKey, _ = crypto.GenerateKey()
+
+	testAddr     = crypto.PubkeyToAddress(testKey.PublicKey)
+	testKeyring  = core.NewKeyring(testKey)
+	producerAddr = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+)
+
+// newTestChain 在临时目录中新建只有创世区块的区块链，数据库文件名固定，所以需要切换工作目录，测试不能并行
+func newTestChain(t *testing.T) *core.BlockChain {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	bc, err := core.NewBlockChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlock(core.NewGenesisBlock(crypto.PubkeyToAddress(testCoordinatorKey.PublicKey))); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+// fund 在一个区块中上链n个协调者授权的转入交易，每个给testAddr转入value
+func fund(t *testing.T, bc *core.BlockChain, n int, value core.Amount) []*core.Transaction {
+	t.Helper()
+	var txs []*core.Transaction
+	for i := 0; i < n; i++ {
+		tx, err := core.NewCoinbaseTX(testAddr, testAddr, value, fmt.Sprintf("%d-%d", bc.TipHeight(), i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Authorize(testCoordinatorKey); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	tip, err := bc.GetBlockByHeight(bc.TipHeight())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := tip.Hash()
+	if err := bc.AddBlock(mineBlock(t, bc, core.NewBlockWithTransactions(hash, tip.Header.Height+1, txs))); err != nil {
+		t.Fatal(err)
+	}
+	return txs
+}
+
+// mineBlock 按前一个区块填写难度目标与时间戳，并寻找满足难度目标的Nonce
+func mineBlock(t *testing.T, bc *core.BlockChain, block *core.Block) *core.Block {
+	t.Helper()
+	if err := bc.PrepareHeader(block); err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Mine(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spend 花费prev的第vout个out，扣除fee后转回testAddr
+func spend(t *testing.T, prev *core.Transaction, vout int, fee core.Amount) *core.Transaction {
+	t.Helper()
+	out := prev.Vout[vout]
+	tx := &core.Transaction{
+		Vin:  []core.TXInput{{Txid: prev.ID, Vout: vout, Address: out.Address}},
+		Vout: []core.TXOutput{*core.NewTXOutput(out.Value-fee, testAddr)},
+	}
+	if err := tx.SignWithKeyring(testKeyring, map[string]core.Transaction{hex.EncodeToString(prev.ID): *prev}); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestProduce(t *testing.T) {
+	tests := []struct {
+		name    string
+		fees    []core.Amount // 放入交易池的交易的手续费
+		cfg     func(cfg *Config, pool []*core.Transaction)
+		want    error
+		include int // 区块中除奖励交易以外的交易个数
+	}{
+		{"empty pool", nil, nil, ErrNoTransactions, 0},
+		{"allow empty", nil, func(cfg *Config, pool []*core.Transaction) {
+			cfg.AllowEmpty = true
+		}, nil, 0},
+		{"fees and subsidy", []core.Amount{3, 5}, nil, nil, 2},
+		{"max block bytes", []core.Amount{3, 5}, func(cfg *Config, pool []*core.Transaction) {
+			cfg.MaxBlockBytes = rewardSize(cfg.Address) + pool[0].TxSize()
+		}, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := newTestChain(t)
+			coins := fund(t, bc, len(tt.fees), 100)
+			pool := mempool.New(bc, mempool.DefaultConfig())
+			var txs []*core.Transaction
+			for i, fee := range tt.fees {
+				tx := spend(t, coins[i], 0, fee)
+				if err := pool.Add(tx); err != nil {
+					t.Fatal(err)
+				}
+				txs = append(txs, tx)
+			}
+			cfg := DefaultConfig(producerAddr)
+			if tt.cfg != nil {
+				tt.cfg(&cfg, txs)
+			}
+			height := bc.TipHeight()
+
+			block, err := New(bc, pool, cfg).Produce(context.Background())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Produce = %v，期望 %v", err, tt.want)
+			}
+			if err != nil {
+				if bc.TipHeight() != height {
+					t.Fatal("出块失败时区块链发生了变化")
+				}
+				return
+			}
+
+			if bc.TipHeight() != height+1 || len(block.Body.Transactions) != tt.include+1 {
+				t.Fatalf("高度 %d，区块有 %d 个交易，期望 %d, %d", bc.TipHeight(), len(block.Body.Transactions), height+1, tt.include+1)
+			}
+			// 奖励交易领取选中交易的手续费与出块补贴
+			want := core.BlockSubsidy(block.Header.Height)
+			for _, tx := range block.Body.Transactions[1:] {
+				want += tt.fees[indexOf(txs, tx)]
+			}
+			reward := block.Body.Transactions[0]
+			if !reward.IsReward() || reward.Vout[0].Value != want || reward.Vout[0].Address != producerAddr {
+				t.Fatalf("奖励交易 %+v，期望给 %x 转入 %d", reward, producerAddr, want)
+			}
+			if pool.Count() != len(tt.fees)-tt.include {
+				t.Fatalf("交易池剩下 %d 个交易，期望 %d", pool.Count(), len(tt.fees)-tt.include)
+			}
+		})
+	}
+}
+
+// indexOf tx在txs中的位置
+func indexOf(txs []*core.Transaction, tx *core.Transaction) int {
+	for i, other := range txs {
+		if string(other.ID) == string(tx.ID) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestProduceDropsInvalid 交易池中的交易在出块前失效时，去掉无效的交易后仍然出块
+func TestProduceDropsInvalid(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 2, 100)
+	pool := mempool.New(bc, mempool.DefaultConfig())
+	stale := spend(t, coins[0], 0, 1)
+	valid := spend(t, coins[1], 0, 1)
+	for _, tx := range []*core.Transaction{stale, valid} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 其他节点的区块花费了同一个out，交易池还没有处理这个区块
+	tip, _ := bc.GetBlockByHeight(bc.TipHeight())
+	hash, _ := tip.Hash()
+	if err := bc.AddBlock(mineBlock(t, bc, core.NewBlockWithTransactions(hash, tip.Header.Height+1, []*core.Transaction{spend(t, coins[0], 0, 2)}))); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := New(bc, pool, DefaultConfig(producerAddr)).Produce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Body.Transactions) != 2 || string(block.Body.Transactions[1].ID) != string(valid.ID) {
+		t.Fatalf("区块有 %d 个交易，期望只打包有效的交易", len(block.Body.Transactions))
+	}
+	if pool.Has(stale.ID) || pool.Count() != 0 {
+		t.Fatal("无效的交易仍在交易池中")
+	}
+}
+
+func TestRunTrigger(t *testing.T) {
+	bc := newTestChain(t)
+	coins := fund(t, bc, 1, 100)
+	pool := mempool.New(bc, mempool.DefaultConfig())
+	if err := pool.Add(spend(t, coins[0], 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig(producerAddr)
+	cfg.Interval = 0 // 只在Trigger时出块
+	p := New(bc, pool, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	height := bc.TipHeight()
+	p.Trigger()
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Trigger之后没有出块")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if bc.TipHeight() != height+1 {
+		t.Fatalf("高度 %d，期望 %d", bc.TipHeight(), height+1)
+	}
+}
@@ -83,120 +83,67 @@ func WalletVerify(a, p string) (bool, ecdsa.PublicKey, ecdsa.PrivateKey) {
 	return false, emptyPublicKey, emptyPrivateKey
 }
 
-// GetBalance 查询钱包余额
-func (w Wallet) GetBalance() (int, map[string]core.TXOutputs, error) {
-	// 方法：查询这个公钥地址所有的UTXO的转入转出，并进行合并
-	// return core.BlockChain{}.FindUTXO2(PublicKey) // some bugs
-
-	var Changes int = 0 // 钱包余额
-
-	AUTXO := make(map[string]core.TXOutputs, 10) // 当前用户可用的UTXO集合
+// FindAddressUTXOs 钱包查询余额使用的函数，默认直接打开本地的区块链
+// 节点运行时数据库被节点占用，客户端把它替换为通过gRPC向节点查询，见main/pool.go
+var FindAddressUTXOs = findAddressUTXOs
 
-	// 将Publickey转为Address
-	Address := crypto.PubkeyToAddress(w.PublicKey) // 钱包公钥对应的地址
+// findAddressUTXOs 通过地址索引查询一组地址拥有的UTXO
+func findAddressUTXOs(addresses []common.Address) (map[common.Address]*core.AddressUTXOs, error) {
 	// 模拟获取目前阶段的blockchain
 	err, blockchain := core.GetBlockChain()
 	if err != nil {
 		fmt.Println("! 模拟获取区块链出现错误")
-		return 0, nil, err
+		return nil, err
 	}
-	// 寻找目前所有可用的UTXO
-	UTXOSet := blockchain.FindUTXOutputs()
-	// 遍历所有的可用UTXO，寻找地址一样的未使用交易输出
-	for key, value := range UTXOSet {
-		for _, out := range value.Outputs {
-			if out.Address == Address {
-				fmt.Println("> 找到一个可用的未使用交易输出")
-				Changes += out.Value // 累加余额
-
-				// 角色可用的UTXO集合
-				a := AUTXO[key].Outputs
-				a = append(a, out)
-				b := AUTXO[key]
-				b.Outputs = a
-				AUTXO[key] = b
-				//AUTXO[key].Outputs = append(AUTXO[key].Outputs, out)
-			}
-		}
+	// 地址索引中只有该地址拥有的未使用交易输出，不需要遍历所有UTXO
+	return blockchain.FindAddressUTXOs(addresses)
+}
+
+// GetBalance 查询钱包余额
+func (w Wallet) GetBalance() (core.Amount, map[string]core.TXOutputs, error) {
+	// 将Publickey转为Address
+	Address := crypto.PubkeyToAddress(w.PublicKey) // 钱包公钥对应的地址
+	AUTXO, err := FindAddressUTXOs([]common.Address{Address})
+	if err != nil {
+		return 0, nil, err
 	}
 	// 返回
-	return Changes, AUTXO, nil
+	return AUTXO[Address].Balance, AUTXO[Address].TXOutputs(), nil
 }
 
 // GetBalance2 查询钱包余额
-func (w Wallet) GetBalance2() (int, map[string]core.TXOutputs2, error) {
-	// 方法：查询这个公钥地址所有的UTXO的转入转出，并进行合并
-	// return core.BlockChain{}.FindUTXO2(PublicKey) // some bugs
-
-	var Changes int = 0 // 钱包余额
-
-	AUTXO := make(map[string]core.TXOutputs2, 10) // 当前用户可用的UTXO集合
-
+func (w Wallet) GetBalance2() (core.Amount, map[string]core.TXOutputs2, error) {
 	// 将Publickey转为Address
 	Address := crypto.PubkeyToAddress(w.PublicKey) // 钱包公钥对应的地址
-	// 模拟获取目前阶段的blockchain
-	err, blockchain := core.GetBlockChain()
+	AUTXO, err := FindAddressUTXOs([]common.Address{Address})
 	if err != nil {
-		fmt.Println("! 模拟获取区块链出现错误")
 		return 0, nil, err
 	}
-	// 寻找目前所有可用的UTXO
-	UTXOSet := blockchain.FindUTXOutputs2()
-	// 遍历所有的可用UTXO，寻找地址一样的未使用交易输出
-	for key, value := range UTXOSet {
-		for _, out := range value.Outputs {
-			if out.Address == Address {
-				fmt.Println("> 找到一个可用的未使用交易输出")
-				Changes += out.Value // 累加余额
-
-				// 角色可用的UTXO集合
-				a := AUTXO[key].Outputs
-				a = append(a, out)
-				b := AUTXO[key]
-				b.Outputs = a
-				AUTXO[key] = b
-				//AUTXO[key].Outputs = append(AUTXO[key].Outputs, out)
-			}
-		}
+	// 返回
+	return AUTXO[Address].Balance, AUTXO[Address].TXOutputs2(), nil
+}
+
+// GetMultisigBalance 查询多签地址的余额，返回的out可以交给core.NewMultisigSpend花费
+func GetMultisigBalance(lock *core.MultisigLock) (core.Amount, map[string]core.TXOutputs2, error) {
+	Address := lock.Address() // 锁定条件对应的多签地址
+	AUTXO, err := FindAddressUTXOs([]common.Address{Address})
+	if err != nil {
+		return 0, nil, err
 	}
 	// 返回
-	return Changes, AUTXO, nil
+	return AUTXO[Address].Balance, AUTXO[Address].TXOutputs2(), nil
 }
 
 // GetBalanceToLight 跨区转账时使用的查询余额的函数，可以返回带交易坐标的Output集合
-func (w Wallet) GetBalanceToLight() (int, map[string][]core.TXOutputsTran, error) {
-	// 方法：查询这个公钥地址所有的UTXO的转入转出，并进行合并
-	// return core.BlockChain{}.FindUTXO2(PublicKey) // some bugs
-
-	var Changes int = 0 // 钱包余额
-
-	AUTXO := make(map[string][]core.TXOutputsTran, 10) // 当前用户可用的UTXO集合
-
+func (w Wallet) GetBalanceToLight() (core.Amount, map[string][]core.TXOutputsTran, error) {
 	// 将Publickey转为Address
 	Address := crypto.PubkeyToAddress(w.PublicKey) // 钱包公钥对应的地址
-	// 模拟获取目前阶段的blockchain
-	err, blockchain := core.GetBlockChain()
+	AUTXO, err := FindAddressUTXOs([]common.Address{Address})
 	if err != nil {
-		fmt.Println("! 模拟获取区块链出现错误")
 		return 0, nil, err
 	}
-	// 寻找目前所有可用的UTXO
-	UTXOSet := blockchain.FindUTXOutputsForTran()
-	// 遍历所有的可用UTXO，寻找地址一样的未使用交易输出
-	for key, value := range UTXOSet {
-		for _, out := range value {
-			if out.Out.Address == Address {
-				fmt.Println("> 找到一个可用的未使用交易输出")
-				Changes += out.Out.Value // 累加余额
-
-				// 角色可用的UTXO集合
-				AUTXO[key] = append(AUTXO[key], out)
-				//AUTXO[key].Outputs = append(AUTXO[key].Outputs, out)
-			}
-		}
-	}
 	// 返回
-	return Changes, AUTXO, nil
+	return AUTXO[Address].Balance, AUTXO[Address].TXOutputsTran(), nil
 }
 
 // NewWallet creates and returns a Wallet
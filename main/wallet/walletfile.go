@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 钱包文件
+// 钱包文件只保存子钱包的私钥，不保存账户密码和总钱包的私钥
+// 子钱包私钥用账户密码经过scrypt得到的密钥以AES-GCM加密，账户名作为附加数据，文件被复制到其他账户名下时无法解密
+
+// walletFileVersion 钱包文件格式版本，旧版本的钱包文件是gob编码的整个Wallets，没有版本号
+const walletFileVersion = 1
+
+// scrypt参数，每次新建找零子钱包都会重新加密保存，N取1<<15使一次保存在100毫秒左右
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// privateKeyLen 子钱包私钥的长度
+const privateKeyLen = 32
+
+var (
+	ErrWalletPassword = errors.New("账户密码错误或钱包文件已损坏")
+	ErrNoPassword     = errors.New("没有账户密码，无法加密钱包文件")
+)
+
+// walletFileContent 钱包文件的内容
+type walletFileContent struct {
+	Version    int
+	Salt       []byte // scrypt的盐，每次保存都重新生成
+	Nonce      []byte // AES-GCM的nonce
+	Ciphertext []byte // 按地址排序后依次拼接的子钱包私钥，每个32字节
+}
+
+// newWalletCipher 由账户密码与盐得到AES-GCM
+func newWalletCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWallets 把子钱包的私钥加密为钱包文件的内容
+func encryptWallets(account, password string, wallets map[common.Address]*Wallet) ([]byte, error) {
+	if password == "" {
+		return nil, ErrNoPassword
+	}
+
+	addresses := make([]common.Address, 0, len(wallets))
+	for address := range wallets {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+	var plaintext []byte
+	for _, address := range addresses {
+		key := wallets[address].PrivateKey
+		plaintext = append(plaintext, crypto.FromECDSA(&key)...)
+	}
+
+	content := walletFileContent{Version: walletFileVersion, Salt: make([]byte, 32)}
+	_, err := io.ReadFull(rand.Reader, content.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newWalletCipher(password, content.Salt)
+	if err != nil {
+		return nil, err
+	}
+	content.Nonce = make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, content.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	content.Ciphertext = aead.Seal(nil, content.Nonce, plaintext, []byte(account))
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(content)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptWallets 解密钱包文件中的子钱包私钥
+// 旧版本的钱包文件没有加密，返回其中的子钱包并且legacy为true，调用者需要重新保存为加密的钱包文件
+func decryptWallets(data []byte, account, password string) (wallets map[common.Address]*Wallet, legacy bool, err error) {
+	var content walletFileContent
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&content)
+	if err != nil {
+		// 旧版本的钱包文件与walletFileContent没有相同的字段，无法按新格式解码
+		var old Wallets
+		registerCurves()
+		if gob.NewDecoder(bytes.NewReader(data)).Decode(&old) != nil {
+			return nil, false, fmt.Errorf("! 钱包文件无法解码: %v", err)
+		}
+		return old.Wallets, true, nil
+	}
+	if content.Version != walletFileVersion {
+		return nil, false, fmt.Errorf("! 不支持的钱包文件版本 %d", content.Version)
+	}
+
+	aead, err := newWalletCipher(password, content.Salt)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(content.Nonce) != aead.NonceSize() {
+		return nil, false, ErrWalletPassword
+	}
+	plaintext, err := aead.Open(nil, content.Nonce, content.Ciphertext, []byte(account))
+	if err != nil || len(plaintext)%privateKeyLen != 0 {
+		return nil, false, ErrWalletPassword
+	}
+
+	wallets = make(map[common.Address]*Wallet)
+	for i := 0; i < len(plaintext); i += privateKeyLen {
+		key, err := crypto.ToECDSA(plaintext[i : i+privateKeyLen])
+		if err != nil {
+			return nil, false, err
+		}
+		w := NewWallet2(*key, key.PublicKey)
+		wallets[w.GetAddress()] = w
+	}
+	return wallets, false, nil
+}
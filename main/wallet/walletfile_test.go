@@ -0,0 +1,153 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestWallets(t *testing.T) *Wallets {
+	ws := NewWallets("alice", "secret-password")
+	master, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Privatekey = *master
+	ws.Publickey = master.PublicKey
+	ws.CreateWallet(3)
+	return ws
+}
+
+func TestWalletFileRoundTrip(t *testing.T) {
+	ws := newTestWallets(t)
+	data, err := encryptWallets(ws.Account, ws.Password, ws.Wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 文件中不能出现明文的密码、总钱包私钥或子钱包私钥
+	secrets := map[string][]byte{
+		"password":   []byte(ws.Password),
+		"master key": crypto.FromECDSA(&ws.Privatekey),
+	}
+	for address, w := range ws.Wallets {
+		key := w.PrivateKey
+		secrets["sub-wallet "+address.Hex()] = crypto.FromECDSA(&key)
+	}
+	for name, secret := range secrets {
+		if bytes.Contains(data, secret) {
+			t.Fatalf("钱包文件中包含明文的 %s", name)
+		}
+	}
+
+	got, legacy, err := decryptWallets(data, ws.Account, ws.Password)
+	if err != nil || legacy {
+		t.Fatalf("decryptWallets = %v, legacy %v", err, legacy)
+	}
+	if len(got) != len(ws.Wallets) {
+		t.Fatalf("解密得到 %d 个子钱包，期望 %d", len(got), len(ws.Wallets))
+	}
+	for address, w := range ws.Wallets {
+		g, ok := got[address]
+		if !ok || !g.PrivateKey.Equal(&w.PrivateKey) {
+			t.Fatalf("子钱包 %x 的私钥不一致", address)
+		}
+	}
+}
+
+func TestWalletFileRejects(t *testing.T) {
+	ws := newTestWallets(t)
+	data, err := encryptWallets(ws.Account, ws.Password, ws.Wallets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-2] ^= 1
+
+	tests := []struct {
+		name     string
+		data     []byte
+		account  string
+		password string
+	}{
+		{"wrong password", data, ws.Account, "wrong"},
+		// 账户名是附加数据，复制到其他账户下的钱包文件无法解密
+		{"other account", data, "bob", ws.Password},
+		{"tampered", tampered, ws.Account, ws.Password},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decryptWallets(tt.data, tt.account, tt.password)
+			if !errors.Is(err, ErrWalletPassword) {
+				t.Fatalf("decryptWallets = %v，期望 %v", err, ErrWalletPassword)
+			}
+		})
+	}
+
+	if _, err := encryptWallets(ws.Account, "", ws.Wallets); !errors.Is(err, ErrNoPassword) {
+		t.Fatalf("没有密码时 encryptWallets = %v，期望 %v", err, ErrNoPassword)
+	}
+}
+
+// TestLoadLegacyWalletFile 旧版本的明文钱包文件读取后被改写为加密的钱包文件
+func TestLoadLegacyWalletFile(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	old := newTestWallets(t)
+	var buf bytes.Buffer
+	registerCurves()
+	err = gob.NewEncoder(&buf).Encode(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile("wallet_alice.dat", buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := NewWallets("alice", old.Password)
+	err = ws.LoadFromFile("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ws.Wallets) != len(old.Wallets) {
+		t.Fatalf("读取到 %d 个子钱包，期望 %d", len(ws.Wallets), len(old.Wallets))
+	}
+
+	data, err := os.ReadFile("wallet_alice.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(old.Password)) || bytes.Contains(data, crypto.FromECDSA(&old.Privatekey)) {
+		t.Fatal("旧钱包文件没有被改写，仍然包含明文的密码或总钱包私钥")
+	}
+
+	// 新建的找零子钱包保存后可以用密码重新读取
+	change, err := ws.NewChangeWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := &Wallets{Account: "alice", Password: old.Password}
+	err = reloaded.LoadFromFile("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reloaded.Wallets[change]; !ok || len(reloaded.Wallets) != len(old.Wallets)+1 {
+		t.Fatalf("重新读取到 %d 个子钱包，找零子钱包 %x 缺失", len(reloaded.Wallets), change)
+	}
+	if err := (&Wallets{Password: "wrong"}).LoadFromFile("alice"); !errors.Is(err, ErrWalletPassword) {
+		t.Fatalf("密码错误时 LoadFromFile = %v，期望 %v", err, ErrWalletPassword)
+	}
+}
@@ -1,7 +1,6 @@
 package wallet
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/gob"
@@ -12,6 +11,7 @@ import (
 	"transfer/core"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 const walletFile = "wallet_%s.dat"
@@ -87,6 +87,23 @@ func (ws *Wallets) CreateWallet(amount int) (a []common.Address) {
 	return a
 }
 
+// NewChangeWallet 新建一个子钱包用于接收找零，并在返回前保存到钱包文件
+// 保存失败时不会使用这个子钱包，避免找零转到一个私钥已经丢失的地址
+func (ws *Wallets) NewChangeWallet() (common.Address, error) {
+	address := ws.CreateWallet(1)[0]
+	err := ws.saveToFile(ws.Account)
+	if err != nil {
+		delete(ws.Wallets, address)
+		return common.Address{}, fmt.Errorf("! 保存找零子钱包失败: %v", err)
+	}
+	return address, nil
+}
+
+// ChangePolicy 找零转到新建的子钱包，子钱包由NewChangeWallet创建并保存
+func (ws *Wallets) ChangePolicy() core.ChangePolicy {
+	return core.NewWalletChange{NewWallet: ws.NewChangeWallet}
+}
+
 // GetAddresses returns an array of addresses stored in the wallet file
 func (ws *Wallets) GetAddresses() []common.Address {
 	var addresses []common.Address
@@ -98,12 +115,24 @@ func (ws *Wallets) GetAddresses() []common.Address {
 	return addresses
 }
 
+// Keyring 返回所有子钱包的私钥，用于构造与签名使用多个子钱包的out的交易
+func (ws Wallets) Keyring() core.Keyring {
+	keyring := make(core.Keyring, len(ws.Wallets))
+	for address, w := range ws.Wallets {
+		privateKey := w.PrivateKey
+		keyring[address] = &privateKey
+	}
+	return keyring
+}
+
 // GetWallet returns a Wallet by its address
 func (ws Wallets) GetWallet(address common.Address) Wallet {
 	return *ws.Wallets[address]
 }
 
 // LoadFromFile loads wallets from the file
+// 文件中的子钱包合并到当前的子钱包中，已有的子钱包不会被删除
+// 钱包文件用账户密码解密，旧版本的明文钱包文件读取后会立即重新保存为加密的钱包文件
 func (ws *Wallets) LoadFromFile(nodeID string) error {
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
@@ -115,36 +144,55 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 		log.Panic(err)
 	}
 
-	var wallets Wallets
-	gob.Register(elliptic.P256())
-	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
-	err = decoder.Decode(&wallets)
+	wallets, legacy, err := decryptWallets(fileContent, nodeID, ws.Password)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	ws.Wallets = wallets.Wallets
+	if ws.Wallets == nil {
+		ws.Wallets = make(map[common.Address]*Wallet)
+	}
+	for address, w := range wallets {
+		ws.Wallets[address] = w
+	}
 
+	// 旧版本的钱包文件中有明文的密码与私钥，改写为只包含加密子钱包私钥的文件
+	if legacy {
+		return ws.saveToFile(nodeID)
+	}
 	return nil
 }
 
 // SaveToFile saves wallets to a file
 func (ws Wallets) SaveToFile(nodeID string) {
-	var content bytes.Buffer
-	walletFile := fmt.Sprintf(walletFile, nodeID)
+	err := ws.saveToFile(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-	gob.Register(elliptic.P256())
+// saveToFile 保存钱包文件，先写入临时文件再重命名，写入中断时原来的钱包文件不会损坏
+// 只保存用账户密码加密的子钱包私钥，不保存密码与总钱包的私钥
+func (ws Wallets) saveToFile(nodeID string) error {
+	walletFile := fmt.Sprintf(walletFile, nodeID)
 
-	encoder := gob.NewEncoder(&content)
-	err := encoder.Encode(ws)
+	content, err := encryptWallets(nodeID, ws.Password, ws.Wallets)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	tmpFile := walletFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0600)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+	return os.Rename(tmpFile, walletFile)
+}
+
+// registerCurves 旧版本的钱包文件是gob编码的Wallets，私钥中的Curve是接口，解码前需要注册具体的曲线类型
+func registerCurves() {
+	gob.Register(elliptic.P256())
+	gob.Register(crypto.S256())
 }
 
 // LoginWallets 多钱包登录函数
@@ -174,6 +222,11 @@ func LoginWallets() (bool, string, *Wallets, error) {
 		fmt.Println("登录验证失败")
 		return false, "", nil, err
 	}
+	// 加载保存在钱包文件中的子钱包，例如接收找零的子钱包
+	err = w.LoadFromFile(account)
+	if err != nil && !os.IsNotExist(err) {
+		return false, "", nil, err
+	}
 	// 返回
 	return true, account, w, nil
 }
@@ -200,39 +253,47 @@ func WalletsVerify(a, p string) (bool, *Wallets) {
 // WalletsBalance 正常交易
 type WalletsBalance struct {
 	Address common.Address            // 钱包地址
-	Balance int                       // 余额
+	Balance core.Amount               // 可以花费的余额
 	Txouts  map[string]core.TXOutputs // 每个钱包可用的UTXO集合
+	Locked  core.Amount               // 时间锁还没有到期的余额，不能用于转账
+	Pending core.Amount               // 还没有结算的跨区转账占位交易的待定余额
 }
 
 // WalletsBalance2 正常交易
 type WalletsBalance2 struct {
 	Address common.Address             // 钱包地址
-	Balance int                        // 余额
+	Balance core.Amount                // 可以花费的余额
 	Txouts  map[string]core.TXOutputs2 // 每个钱包可用的UTXO集合
+	Locked  core.Amount                // 时间锁还没有到期的余额，不能用于转账
+	Pending core.Amount                // 还没有结算的跨区转账占位交易的待定余额
 }
 
 // WalletsBalanceToLight 跨链交易ToLight
 type WalletsBalanceToLight struct {
 	Address common.Address                  // 钱包地址
-	Balance int                             // 余额
+	Balance core.Amount                     // 可以花费的余额
 	Txouts  map[string][]core.TXOutputsTran // 每个钱包可用的UTXO集合
+	Locked  core.Amount                     // 时间锁还没有到期的余额，不能用于转账
+	Pending core.Amount                     // 还没有结算的跨区转账占位交易的待定余额
 }
 
 // GetWalletsBalance 正常交易获取余额余额
+// 所有子钱包地址通过地址索引一次查询
 func (ws Wallets) GetWalletsBalance() ([]WalletsBalance, error) {
+	AUTXO, err := FindAddressUTXOs(ws.GetAddresses())
+	if err != nil {
+		fmt.Println("! 获取多钱包余额时出现错误")
+		return nil, err
+	}
 	// 新建返回值
-	wb := make([]WalletsBalance, 1)
-	// 分开调用GetBalance方法
-	for _, v := range ws.Wallets {
-		balance, txouts, err := v.GetBalance()
-		if err != nil {
-			fmt.Println("! 获取多钱包余额时出现错误")
-			return nil, err
-		}
+	wb := make([]WalletsBalance, 0, len(AUTXO))
+	for address, au := range AUTXO {
 		wb = append(wb, WalletsBalance{
-			Address: v.GetAddress(),
-			Balance: balance,
-			Txouts:  txouts,
+			Address: address,
+			Balance: au.Balance,
+			Locked:  au.Locked,
+			Pending: au.Pending,
+			Txouts:  au.TXOutputs(),
 		})
 	}
 	// 返回
@@ -241,19 +302,20 @@ func (ws Wallets) GetWalletsBalance() ([]WalletsBalance, error) {
 
 // GetWalletsBalance2 正常交易获取余额余额
 func (ws Wallets) GetWalletsBalance2() ([]WalletsBalance2, error) {
+	AUTXO, err := FindAddressUTXOs(ws.GetAddresses())
+	if err != nil {
+		fmt.Println("! 获取多钱包余额时出现错误")
+		return nil, err
+	}
 	// 新建返回值
-	wb := make([]WalletsBalance2, 1)
-	// 分开调用GetBalance方法
-	for _, v := range ws.Wallets {
-		balance, txouts, err := v.GetBalance2()
-		if err != nil {
-			fmt.Println("! 获取多钱包余额时出现错误")
-			return nil, err
-		}
+	wb := make([]WalletsBalance2, 0, len(AUTXO))
+	for address, au := range AUTXO {
 		wb = append(wb, WalletsBalance2{
-			Address: v.GetAddress(),
-			Balance: balance,
-			Txouts:  txouts,
+			Address: address,
+			Balance: au.Balance,
+			Locked:  au.Locked,
+			Pending: au.Pending,
+			Txouts:  au.TXOutputs2(),
 		})
 	}
 	// 返回
@@ -262,19 +324,20 @@ func (ws Wallets) GetWalletsBalance2() ([]WalletsBalance2, error) {
 
 // GetWalletsBalanceToLight 跨链交易ToLight获取余额余额
 func (ws Wallets) GetWalletsBalanceToLight() ([]WalletsBalanceToLight, error) {
+	AUTXO, err := FindAddressUTXOs(ws.GetAddresses())
+	if err != nil {
+		fmt.Println("! 获取多钱包余额时出现错误")
+		return nil, err
+	}
 	// 新建返回值
-	wb := make([]WalletsBalanceToLight, 1)
-	// 分开调用GetBalance方法
-	for _, v := range ws.Wallets {
-		balance, txouts, err := v.GetBalanceToLight()
-		if err != nil {
-			fmt.Println("! 获取多钱包余额时出现错误")
-			return nil, err
-		}
+	wb := make([]WalletsBalanceToLight, 0, len(AUTXO))
+	for address, au := range AUTXO {
 		wb = append(wb, WalletsBalanceToLight{
-			Address: v.GetAddress(),
-			Balance: balance,
-			Txouts:  txouts,
+			Address: address,
+			Balance: au.Balance,
+			Locked:  au.Locked,
+			Pending: au.Pending,
+			Txouts:  au.TXOutputsTran(),
 		})
 	}
 	// 返回